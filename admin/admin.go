@@ -0,0 +1,245 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/funny/link"
+)
+
+// Admin is an http.Handler to look into a running server. Mount it on an
+// existing mux, for example:
+//
+//	mux.Handle("/admin/", http.StripPrefix("/admin", admin.New(server.Manager())))
+//
+// Routes:
+//
+//	GET    /sessions            all live sessions
+//	GET    /sessions/{id}       one session
+//	POST   /sessions/{id}/kick  close a session
+//	GET    /channels            registered channels
+//	GET    /channels/{name}     members of a channel
+//	GET    /config              the live config, needs Config
+//	PATCH  /config              change fields of the live config
+type Admin struct {
+	manager *link.Manager
+	mux     *http.ServeMux
+
+	mutex    sync.RWMutex
+	channels map[string]*link.Channel
+
+	// OnKick is called before a session is closed through the endpoint.
+	OnKick func(r *http.Request, session *link.Session)
+
+	// Config of the server, usually Server.Config, the config endpoints
+	// are not found without.
+	Config *link.LiveConfig
+}
+
+func New(manager *link.Manager) *Admin {
+	admin := &Admin{
+		manager:  manager,
+		mux:      http.NewServeMux(),
+		channels: make(map[string]*link.Channel),
+	}
+	admin.mux.HandleFunc("GET /sessions", admin.listSessions)
+	admin.mux.HandleFunc("GET /sessions/{id}", admin.getSession)
+	admin.mux.HandleFunc("POST /sessions/{id}/kick", admin.kickSession)
+	admin.mux.HandleFunc("GET /channels", admin.listChannels)
+	admin.mux.HandleFunc("GET /channels/{name}", admin.getChannel)
+	admin.mux.HandleFunc("GET /config", admin.getConfig)
+	admin.mux.HandleFunc("PATCH /config", admin.patchConfig)
+	return admin
+}
+
+func (admin *Admin) AddChannel(name string, channel *link.Channel) {
+	admin.mutex.Lock()
+	defer admin.mutex.Unlock()
+	admin.channels[name] = channel
+}
+
+func (admin *Admin) RemoveChannel(name string) {
+	admin.mutex.Lock()
+	defer admin.mutex.Unlock()
+	delete(admin.channels, name)
+}
+
+func (admin *Admin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	admin.mux.ServeHTTP(w, r)
+}
+
+type SessionInfo struct {
+	ID           uint64            `json:"id"`
+	RemoteAddr   string            `json:"remote_addr,omitempty"`
+	SendQueueLen int               `json:"send_queue_len"`
+	Closed       bool              `json:"closed"`
+	State        string            `json:"state,omitempty"`
+	StateKeys    []string          `json:"state_keys,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"`
+}
+
+func sessionInfo(session *link.Session) *SessionInfo {
+	info := &SessionInfo{
+		ID:           session.ID(),
+		SendQueueLen: session.SendQueueLen(),
+		Closed:       session.IsClosed(),
+		Labels:       session.Labels(),
+	}
+	if addr := session.RemoteAddr(); addr != nil {
+		info.RemoteAddr = addr.String()
+	}
+	if session.State != nil {
+		info.State = fmt.Sprintf("%T", session.State)
+		info.StateKeys = stateKeys(session.State)
+	}
+	return info
+}
+
+// stateKeys lists the keys of a map state, or the field names of a struct
+// state, values are not exposed.
+func stateKeys(state interface{}) []string {
+	v := reflect.ValueOf(state)
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	var keys []string
+	switch v.Kind() {
+	case reflect.Map:
+		for _, k := range v.MapKeys() {
+			keys = append(keys, fmt.Sprint(k.Interface()))
+		}
+		sort.Strings(keys)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if f := v.Type().Field(i); f.IsExported() {
+				keys = append(keys, f.Name)
+			}
+		}
+	}
+	return keys
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func (admin *Admin) session(w http.ResponseWriter, r *http.Request) *link.Session {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "bad session id", http.StatusBadRequest)
+		return nil
+	}
+	session := admin.manager.GetSession(id)
+	if session == nil {
+		http.NotFound(w, r)
+	}
+	return session
+}
+
+func (admin *Admin) listSessions(w http.ResponseWriter, r *http.Request) {
+	infos := make([]*SessionInfo, 0, admin.manager.Len())
+	admin.manager.Fetch(func(session *link.Session) {
+		infos = append(infos, sessionInfo(session))
+	})
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+	writeJSON(w, infos)
+}
+
+func (admin *Admin) getSession(w http.ResponseWriter, r *http.Request) {
+	if session := admin.session(w, r); session != nil {
+		writeJSON(w, sessionInfo(session))
+	}
+}
+
+func (admin *Admin) kickSession(w http.ResponseWriter, r *http.Request) {
+	session := admin.session(w, r)
+	if session == nil {
+		return
+	}
+	if admin.OnKick != nil {
+		admin.OnKick(r, session)
+	}
+	session.Close()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type ChannelInfo struct {
+	Name    string   `json:"name"`
+	Len     int      `json:"len"`
+	Members []uint64 `json:"members,omitempty"`
+}
+
+func (admin *Admin) listChannels(w http.ResponseWriter, r *http.Request) {
+	admin.mutex.RLock()
+	infos := make([]*ChannelInfo, 0, len(admin.channels))
+	for name, channel := range admin.channels {
+		infos = append(infos, &ChannelInfo{Name: name, Len: channel.Len()})
+	}
+	admin.mutex.RUnlock()
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	writeJSON(w, infos)
+}
+
+func (admin *Admin) getChannel(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	admin.mutex.RLock()
+	channel, exists := admin.channels[name]
+	admin.mutex.RUnlock()
+	if !exists {
+		http.NotFound(w, r)
+		return
+	}
+	info := &ChannelInfo{Name: name}
+	channel.Fetch(func(session *link.Session) {
+		info.Members = append(info.Members, session.ID())
+	})
+	info.Len = len(info.Members)
+	sort.Slice(info.Members, func(i, j int) bool { return info.Members[i] < info.Members[j] })
+	writeJSON(w, info)
+}
+
+func (admin *Admin) getConfig(w http.ResponseWriter, r *http.Request) {
+	if admin.Config == nil {
+		http.NotFound(w, r)
+		return
+	}
+	writeJSON(w, admin.Config.Load())
+}
+
+// patchConfig decodes the body over the current config, fields which are
+// not in the body keep their value.
+// maxConfigBody limits the body of PATCH /config.
+const maxConfigBody = 64 * 1024
+
+func (admin *Admin) patchConfig(w http.ResponseWriter, r *http.Request) {
+	if admin.Config == nil {
+		http.NotFound(w, r)
+		return
+	}
+	// the body is read and checked before the update, which holds the lock
+	// of the config.
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxConfigBody))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	check := *admin.Config.Load()
+	if err := json.Unmarshal(data, &check); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	admin.Config.Update(func(config *link.Config) {
+		json.Unmarshal(data, config)
+	})
+	writeJSON(w, admin.Config.Load())
+}
@@ -0,0 +1,188 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/codec"
+	"github.com/funny/link/dump"
+	"github.com/funny/utest"
+)
+
+func getJSON(t *testing.T, url string, v interface{}) int {
+	rsp, err := http.Get(url)
+	utest.IsNilNow(t, err)
+	defer rsp.Body.Close()
+	if rsp.StatusCode == http.StatusOK {
+		utest.IsNilNow(t, json.NewDecoder(rsp.Body).Decode(v))
+	}
+	return rsp.StatusCode
+}
+
+func Test_Admin(t *testing.T) {
+	joined := make(chan *link.Session, 1)
+	channel := link.NewChannel()
+	server, err := link.Listen("tcp", "127.0.0.1:0", codec.Json(), 0, link.HandlerFunc(func(session *link.Session) {
+		session.State = map[string]interface{}{"user": "u1"}
+		channel.Join(session)
+		joined <- session
+		for {
+			if _, err := session.Receive(); err != nil {
+				return
+			}
+		}
+	}))
+	utest.IsNilNow(t, err)
+	go server.Serve()
+	defer server.Stop()
+
+	client, err := link.Dial("tcp", server.Listener().Addr().String(), codec.Json(), 0)
+	utest.IsNilNow(t, err)
+	defer client.Close()
+	session := <-joined
+
+	admin := New(server.Manager())
+	admin.AddChannel("lobby", channel)
+	var kicked *link.Session
+	admin.OnKick = func(r *http.Request, s *link.Session) { kicked = s }
+	mux := http.NewServeMux()
+	mux.Handle("/admin/", http.StripPrefix("/admin", admin))
+	hs := httptest.NewServer(mux)
+	defer hs.Close()
+
+	var sessions []SessionInfo
+	utest.EqualNow(t, getJSON(t, hs.URL+"/admin/sessions", &sessions), http.StatusOK)
+	utest.EqualNow(t, len(sessions), 1)
+	utest.EqualNow(t, sessions[0].ID, session.ID())
+	utest.Assert(t, sessions[0].RemoteAddr != "")
+	utest.EqualNow(t, sessions[0].StateKeys, []string{"user"})
+
+	var channels []ChannelInfo
+	utest.EqualNow(t, getJSON(t, hs.URL+"/admin/channels", &channels), http.StatusOK)
+	utest.EqualNow(t, channels[0].Name, "lobby")
+	utest.EqualNow(t, channels[0].Len, 1)
+	var lobby ChannelInfo
+	utest.EqualNow(t, getJSON(t, hs.URL+"/admin/channels/lobby", &lobby), http.StatusOK)
+	utest.EqualNow(t, lobby.Members, []uint64{session.ID()})
+
+	var info SessionInfo
+	utest.EqualNow(t, getJSON(t, hs.URL+"/admin/sessions/12345678", &info), http.StatusNotFound)
+
+	var config link.Config
+	utest.EqualNow(t, getJSON(t, hs.URL+"/admin/config", &config), http.StatusNotFound)
+	admin.Config = link.NewLiveConfig(link.Config{RecvRate: 100, MaxPacket: 1024})
+	req, err := http.NewRequest("PATCH", hs.URL+"/admin/config", strings.NewReader(`{"max_packet": 4096}`))
+	utest.IsNilNow(t, err)
+	rsp, err := http.DefaultClient.Do(req)
+	utest.IsNilNow(t, err)
+	rsp.Body.Close()
+	utest.EqualNow(t, rsp.StatusCode, http.StatusOK)
+	utest.EqualNow(t, getJSON(t, hs.URL+"/admin/config", &config), http.StatusOK)
+	utest.EqualNow(t, config, link.Config{RecvRate: 100, MaxPacket: 4096})
+
+	// a bad body changes nothing and tells no watcher.
+	var notified int
+	admin.Config.OnChange(func(*link.Config) { notified++ })
+	req, err = http.NewRequest("PATCH", hs.URL+"/admin/config", strings.NewReader(`{"max_packet": "x"}`))
+	utest.IsNilNow(t, err)
+	rsp, err = http.DefaultClient.Do(req)
+	utest.IsNilNow(t, err)
+	rsp.Body.Close()
+	utest.EqualNow(t, rsp.StatusCode, http.StatusBadRequest)
+	utest.EqualNow(t, notified, 1)
+	utest.EqualNow(t, *admin.Config.Load(), link.Config{RecvRate: 100, MaxPacket: 4096})
+
+	rsp, err = http.Post(hs.URL+fmt.Sprintf("/admin/sessions/%d/kick", session.ID()), "", nil)
+	utest.IsNilNow(t, err)
+	rsp.Body.Close()
+	utest.EqualNow(t, rsp.StatusCode, http.StatusNoContent)
+	utest.EqualNow(t, kicked, session)
+	utest.Assert(t, session.IsClosed())
+	for server.Manager().Len() != 0 {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func Test_Console(t *testing.T) {
+	dumper := dump.NewDumper(io.Discard)
+	server, err := link.Listen("tcp", "127.0.0.1:0", dumper.Protocol(codec.Json()), 0, link.HandlerFunc(func(session *link.Session) {
+		for {
+			if _, err := session.Receive(); err != nil {
+				return
+			}
+		}
+	}))
+	utest.IsNilNow(t, err)
+	go server.Serve()
+	defer server.Stop()
+	client, err := link.Dial("tcp", server.Listener().Addr().String(), codec.Json(), 0)
+	utest.IsNilNow(t, err)
+	defer client.Close()
+	for server.Manager().Len() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	var session *link.Session
+	server.Manager().Fetch(func(s *link.Session) { session = s })
+
+	admin := New(server.Manager())
+	admin.AddChannel("lobby", link.NewChannel())
+	console := admin.Console()
+	console.Dumper = dumper
+	console.Handle("echo", "the arguments", func(args []string) (string, error) {
+		return strings.Join(args, " "), nil
+	})
+	_, err = console.Listen("tcp", ":0")
+	utest.Assert(t, errors.Is(err, NotLocalError))
+	utest.Assert(t, errors.Is(err, link.PolicyError))
+	consoleServer, err := console.Listen("tcp", "127.0.0.1:0")
+	utest.IsNilNow(t, err)
+	go consoleServer.Serve()
+	defer consoleServer.Stop()
+
+	operator, err := link.Dial("tcp", consoleServer.Listener().Addr().String(), LineProtocol(), 0)
+	utest.IsNilNow(t, err)
+	defer operator.Close()
+	// output lines come as a message each.
+	run := func(line string, n int) []string {
+		utest.IsNilNow(t, operator.Send(line))
+		var lines []string
+		for i := 0; i < n; i++ {
+			msg, err := operator.Receive()
+			utest.IsNilNow(t, err)
+			lines = append(lines, msg.(string))
+		}
+		return lines
+	}
+	msg, err := operator.Receive()
+	utest.IsNilNow(t, err)
+	utest.Assert(t, strings.Contains(msg.(string), "help"))
+
+	id := strconv.FormatUint(session.ID(), 10)
+	lines := run("sessions", 2)
+	utest.EqualNow(t, lines[1], "1 sessions")
+	utest.Assert(t, strings.HasPrefix(lines[0], id+"\t"))
+	lines = run("channels", 2)
+	utest.EqualNow(t, lines, []string{"lobby\t0", "1 channels"})
+	utest.EqualNow(t, run("echo a b", 1), []string{"a b"})
+	utest.EqualNow(t, run("nope", 1), []string{"unknown command nope, type help"})
+	utest.EqualNow(t, run("kick x", 1), []string{"error: Admin Bad Argument: session id x"})
+	utest.EqualNow(t, run("dump on "+id, 1), []string{"dump on " + id})
+	utest.Assert(t, strings.HasPrefix(run("stats", 1)[0], "sessions=1 "))
+	utest.EqualNow(t, run("config", 1), []string{"error: Admin Not Configured: config"})
+	admin.Config = link.NewLiveConfig(link.Config{RecvRate: 100})
+	utest.EqualNow(t, run("config idle_timeout 30s", 1), []string{"idle_timeout=30s recv_rate=100 recv_burst=0 max_packet=0"})
+	utest.EqualNow(t, run("config max_packet x", 1), []string{"error: Admin Bad Argument: number x"})
+	utest.EqualNow(t, admin.Config.Load().IdleTimeout, 30*time.Second)
+
+	utest.EqualNow(t, run("kick "+id, 1), []string{"kicked " + id})
+	utest.Assert(t, session.IsClosed())
+}
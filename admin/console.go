@@ -0,0 +1,353 @@
+package admin
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/dump"
+)
+
+var (
+	NotLocalError      = link.NewError(link.PolicyError, "Admin Console Not Local")
+	LineTooLongError   = link.NewError(link.ProtocolError, "Admin Console Line Too Long")
+	UsageError         = link.NewError(link.PolicyError, "Admin Command Usage")
+	BadArgumentError   = link.NewError(link.PolicyError, "Admin Bad Argument")
+	NoSessionError     = link.NewError(link.PolicyError, "Admin No Such Session")
+	NotConfiguredError = link.NewError(link.PolicyError, "Admin Not Configured")
+)
+
+// maxLine is the longest command line the console reads.
+const maxLine = 4096
+
+// Command runs a console command and returns its output.
+type Command func(args []string) (string, error)
+
+// Console is a line based service to operate a running server, for telnet,
+// nc or socat. It shares the manager and channels of its Admin. Commands:
+//
+//	help                  the commands
+//	sessions              all live sessions
+//	session <id>          one session
+//	kick <id>             close a session
+//	channels              registered channels
+//	stats                 counters of the process
+//	dump on|off [<id>]    dump the frames of all sessions or one, needs Dumper
+//	config [<key> <value>] show or change the live config, needs Admin.Config
+//	quit                  close the console
+type Console struct {
+	admin    *Admin
+	mutex    sync.RWMutex
+	commands map[string]consoleCommand
+
+	// Dumper of the sessions, dump fails without.
+	Dumper *dump.Dumper
+
+	// OnKick is called before a session is closed through kick.
+	OnKick func(session *link.Session)
+}
+
+type consoleCommand struct {
+	help string
+	run  Command
+}
+
+// Console returns the console of admin.
+func (admin *Admin) Console() *Console {
+	c := &Console{admin: admin, commands: make(map[string]consoleCommand)}
+	c.Handle("help", "the commands", c.help)
+	c.Handle("sessions", "all live sessions", c.sessions)
+	c.Handle("session", "<id>, one session", c.session)
+	c.Handle("kick", "<id>, close a session", c.kick)
+	c.Handle("channels", "registered channels", c.channels)
+	c.Handle("stats", "counters of the process", c.stats)
+	c.Handle("dump", "on|off [<id>], dump the frames of all sessions or one", c.dump)
+	c.Handle("config", "[<key> <value>], show or change the live config", c.config)
+	return c
+}
+
+// Handle adds a command or replaces one.
+func (c *Console) Handle(name, help string, run Command) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.commands[name] = consoleCommand{help, run}
+}
+
+// Listen serves the console on a loopback or unix address, the console has
+// no authentication, whoever reaches it can kick sessions.
+func (c *Console) Listen(network, address string) (*link.Server, error) {
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+	if !isLocal(listener.Addr()) {
+		listener.Close()
+		return nil, NotLocalError
+	}
+	return link.NewServer(listener, LineProtocol(), 16, c), nil
+}
+
+func isLocal(addr net.Addr) bool {
+	switch addr := addr.(type) {
+	case *net.UnixAddr:
+		return true
+	case *net.TCPAddr:
+		return addr.IP.IsLoopback()
+	}
+	return false
+}
+
+func (c *Console) HandleSession(session *link.Session) {
+	defer session.Close()
+	session.Send("link admin console, type help")
+	for {
+		msg, err := session.Receive()
+		if err != nil {
+			return
+		}
+		args := strings.Fields(msg.(string))
+		if len(args) == 0 {
+			continue
+		}
+		if args[0] == "quit" || args[0] == "exit" {
+			return
+		}
+		if err := session.Send(c.Run(args)); err != nil {
+			return
+		}
+	}
+}
+
+// Run runs a command line split into fields and returns its output.
+func (c *Console) Run(args []string) string {
+	c.mutex.RLock()
+	cmd, exists := c.commands[args[0]]
+	c.mutex.RUnlock()
+	if !exists {
+		return "unknown command " + args[0] + ", type help"
+	}
+	out, err := cmd.run(args[1:])
+	if err != nil {
+		return "error: " + err.Error()
+	}
+	return out
+}
+
+func (c *Console) help(args []string) (string, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	names := make([]string, 0, len(c.commands))
+	for name := range c.commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&b, "%-10s %s\n", name, c.commands[name].help)
+	}
+	b.WriteString("quit       close the console")
+	return b.String(), nil
+}
+
+func (c *Console) getSession(args []string) (*link.Session, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("%w: session <id>", UsageError)
+	}
+	id, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: session id %s", BadArgumentError, args[0])
+	}
+	session := c.admin.manager.GetSession(id)
+	if session == nil {
+		return nil, fmt.Errorf("%w: %s", NoSessionError, args[0])
+	}
+	return session, nil
+}
+
+func formatSession(b *strings.Builder, info *SessionInfo) {
+	fmt.Fprintf(b, "%d\t%s\tqueue=%d", info.ID, info.RemoteAddr, info.SendQueueLen)
+	if info.State != "" {
+		fmt.Fprintf(b, "\tstate=%s%v", info.State, info.StateKeys)
+	}
+}
+
+func (c *Console) sessions(args []string) (string, error) {
+	var infos []*SessionInfo
+	c.admin.manager.Fetch(func(session *link.Session) {
+		infos = append(infos, sessionInfo(session))
+	})
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+	var b strings.Builder
+	for _, info := range infos {
+		formatSession(&b, info)
+		b.WriteByte('\n')
+	}
+	fmt.Fprintf(&b, "%d sessions", len(infos))
+	return b.String(), nil
+}
+
+func (c *Console) session(args []string) (string, error) {
+	session, err := c.getSession(args)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	formatSession(&b, sessionInfo(session))
+	return b.String(), nil
+}
+
+func (c *Console) kick(args []string) (string, error) {
+	session, err := c.getSession(args)
+	if err != nil {
+		return "", err
+	}
+	if c.OnKick != nil {
+		c.OnKick(session)
+	}
+	session.Close()
+	return "kicked " + args[0], nil
+}
+
+func (c *Console) channels(args []string) (string, error) {
+	c.admin.mutex.RLock()
+	names := make([]string, 0, len(c.admin.channels))
+	for name := range c.admin.channels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s\t%d\n", name, c.admin.channels[name].Len())
+	}
+	c.admin.mutex.RUnlock()
+	fmt.Fprintf(&b, "%d channels", len(names))
+	return b.String(), nil
+}
+
+func (c *Console) stats(args []string) (string, error) {
+	queued := 0
+	c.admin.manager.Fetch(func(session *link.Session) {
+		queued += session.SendQueueLen()
+	})
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	return fmt.Sprintf("sessions=%d queued=%d goroutines=%d heap=%d",
+		c.admin.manager.Len(), queued, runtime.NumGoroutine(), mem.HeapAlloc), nil
+}
+
+func (c *Console) dump(args []string) (string, error) {
+	if c.Dumper == nil {
+		return "", fmt.Errorf("%w: dumper", NotConfiguredError)
+	}
+	if len(args) == 0 || len(args) > 2 || (args[0] != "on" && args[0] != "off") {
+		return "", fmt.Errorf("%w: dump on|off [<id>]", UsageError)
+	}
+	on := args[0] == "on"
+	if len(args) == 1 {
+		c.Dumper.EnableAll(on)
+		return "dump " + args[0], nil
+	}
+	session, err := c.getSession(args[1:])
+	if err != nil {
+		return "", err
+	}
+	if on {
+		err = c.Dumper.Enable(session)
+	} else {
+		err = c.Dumper.Disable(session)
+	}
+	if err != nil {
+		return "", err
+	}
+	return "dump " + args[0] + " " + args[1], nil
+}
+
+func formatConfig(config *link.Config) string {
+	return fmt.Sprintf("idle_timeout=%s recv_rate=%d recv_burst=%d max_packet=%d",
+		config.IdleTimeout, config.RecvRate, config.RecvBurst, config.MaxPacket)
+}
+
+func (c *Console) config(args []string) (string, error) {
+	live := c.admin.Config
+	if live == nil {
+		return "", fmt.Errorf("%w: config", NotConfiguredError)
+	}
+	if len(args) == 0 {
+		return formatConfig(live.Load()), nil
+	}
+	if len(args) != 2 {
+		return "", fmt.Errorf("%w: config [<key> <value>]", UsageError)
+	}
+	var set func(config *link.Config)
+	if args[0] == "idle_timeout" {
+		d, err := time.ParseDuration(args[1])
+		if err != nil {
+			return "", fmt.Errorf("%w: duration %s", BadArgumentError, args[1])
+		}
+		set = func(config *link.Config) { config.IdleTimeout = d }
+	} else {
+		n, err := strconv.Atoi(args[1])
+		if err != nil {
+			return "", fmt.Errorf("%w: number %s", BadArgumentError, args[1])
+		}
+		switch args[0] {
+		case "recv_rate":
+			set = func(config *link.Config) { config.RecvRate = n }
+		case "recv_burst":
+			set = func(config *link.Config) { config.RecvBurst = n }
+		case "max_packet":
+			set = func(config *link.Config) { config.MaxPacket = n }
+		default:
+			return "", fmt.Errorf("%w: key %s", BadArgumentError, args[0])
+		}
+	}
+	live.Update(set)
+	return formatConfig(live.Load()), nil
+}
+
+// LineProtocol reads and writes lines of text as strings, a line sent can
+// hold line breaks. Lines end with \n, an \r before it is dropped.
+func LineProtocol() link.Protocol {
+	return link.ProtocolFunc(func(rw io.ReadWriter) (link.Codec, error) {
+		c := &lineCodec{r: bufio.NewReaderSize(rw, maxLine), w: rw}
+		c.closer, _ = rw.(io.Closer)
+		return c, nil
+	})
+}
+
+type lineCodec struct {
+	r      *bufio.Reader
+	w      io.Writer
+	closer io.Closer
+}
+
+func (c *lineCodec) Receive() (interface{}, error) {
+	line, err := c.r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return nil, LineTooLongError
+	}
+	if err != nil {
+		return nil, err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(string(line), "\n"), "\r"), nil
+}
+
+func (c *lineCodec) Send(msg interface{}) error {
+	_, err := io.WriteString(c.w, fmt.Sprint(msg)+"\n")
+	return err
+}
+
+func (c *lineCodec) Close() error {
+	if c.closer != nil {
+		return c.closer.Close()
+	}
+	return nil
+}
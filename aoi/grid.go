@@ -0,0 +1,220 @@
+package aoi
+
+import (
+	"math"
+	"sync"
+
+	"github.com/funny/link"
+)
+
+type cell struct {
+	x, y int
+}
+
+type entity struct {
+	id      uint64
+	x, y    float64
+	cell    cell
+	session *link.Session
+}
+
+// Grid is a cell based interest management helper. Entities see each other
+// when their cells are within viewRange cells on both axes.
+type Grid struct {
+	mutex     sync.RWMutex
+	cellSize  float64
+	viewRange int
+	cells     map[cell]map[uint64]*entity
+	entities  map[uint64]*entity
+}
+
+func NewGrid(cellSize float64, viewRange int) *Grid {
+	return &Grid{
+		cellSize:  cellSize,
+		viewRange: viewRange,
+		cells:     make(map[cell]map[uint64]*entity),
+		entities:  make(map[uint64]*entity),
+	}
+}
+
+func (grid *Grid) cellOf(x, y float64) cell {
+	return cell{
+		int(math.Floor(x / grid.cellSize)),
+		int(math.Floor(y / grid.cellSize)),
+	}
+}
+
+func (grid *Grid) addToCell(e *entity) {
+	entities, exists := grid.cells[e.cell]
+	if !exists {
+		entities = make(map[uint64]*entity)
+		grid.cells[e.cell] = entities
+	}
+	entities[e.id] = e
+}
+
+func (grid *Grid) delFromCell(e *entity) {
+	entities := grid.cells[e.cell]
+	delete(entities, e.id)
+	if len(entities) == 0 {
+		delete(grid.cells, e.cell)
+	}
+}
+
+func (grid *Grid) visit(c cell, callback func(*entity)) {
+	for x := c.x - grid.viewRange; x <= c.x+grid.viewRange; x++ {
+		for y := c.y - grid.viewRange; y <= c.y+grid.viewRange; y++ {
+			for _, e := range grid.cells[cell{x, y}] {
+				callback(e)
+			}
+		}
+	}
+}
+
+func (grid *Grid) neighbors(c cell, self uint64) map[uint64]*entity {
+	result := make(map[uint64]*entity)
+	grid.visit(c, func(e *entity) {
+		if e.id != self {
+			result[e.id] = e
+		}
+	})
+	return result
+}
+
+func ids(entities map[uint64]*entity) []uint64 {
+	result := make([]uint64, 0, len(entities))
+	for id := range entities {
+		result = append(result, id)
+	}
+	return result
+}
+
+// Enter adds an entity and returns the entities it can see. The session may
+// be nil for entities without a client, and is removed from the grid when
+// it closes.
+func (grid *Grid) Enter(id uint64, session *link.Session, x, y float64) []uint64 {
+	grid.mutex.Lock()
+	defer grid.mutex.Unlock()
+
+	old, exists := grid.entities[id]
+	if exists {
+		grid.delFromCell(old)
+		// entering again with another session must not leave the old one
+		// able to remove the entity when it closes.
+		if old.session != nil && old.session != session {
+			old.session.RemoveCloseCallback(grid, id)
+		}
+	}
+	e := &entity{id, x, y, grid.cellOf(x, y), session}
+	grid.entities[id] = e
+	grid.addToCell(e)
+
+	if session != nil && (!exists || old.session != session) {
+		session.AddCloseCallback(grid, id, func() {
+			grid.Leave(id)
+		})
+	}
+	return ids(grid.neighbors(e.cell, id))
+}
+
+// Move updates the position of an entity and returns the entities which
+// came into and went out of its view.
+func (grid *Grid) Move(id uint64, x, y float64) (appeared, disappeared []uint64) {
+	grid.mutex.Lock()
+	defer grid.mutex.Unlock()
+
+	e, exists := grid.entities[id]
+	if !exists {
+		return nil, nil
+	}
+	e.x, e.y = x, y
+	c := grid.cellOf(x, y)
+	if c == e.cell {
+		return nil, nil
+	}
+
+	before := grid.neighbors(e.cell, id)
+	grid.delFromCell(e)
+	e.cell = c
+	grid.addToCell(e)
+	after := grid.neighbors(e.cell, id)
+
+	for id := range after {
+		if _, exists := before[id]; !exists {
+			appeared = append(appeared, id)
+		}
+	}
+	for id := range before {
+		if _, exists := after[id]; !exists {
+			disappeared = append(disappeared, id)
+		}
+	}
+	return
+}
+
+func (grid *Grid) Leave(id uint64) []uint64 {
+	grid.mutex.Lock()
+	defer grid.mutex.Unlock()
+
+	e, exists := grid.entities[id]
+	if !exists {
+		return nil
+	}
+	delete(grid.entities, id)
+	grid.delFromCell(e)
+	if e.session != nil {
+		e.session.RemoveCloseCallback(grid, id)
+	}
+	return ids(grid.neighbors(e.cell, id))
+}
+
+func (grid *Grid) Position(id uint64) (x, y float64, ok bool) {
+	grid.mutex.RLock()
+	defer grid.mutex.RUnlock()
+	if e, exists := grid.entities[id]; exists {
+		return e.x, e.y, true
+	}
+	return 0, 0, false
+}
+
+func (grid *Grid) Neighbors(id uint64) []uint64 {
+	grid.mutex.RLock()
+	defer grid.mutex.RUnlock()
+	e, exists := grid.entities[id]
+	if !exists {
+		return nil
+	}
+	return ids(grid.neighbors(e.cell, id))
+}
+
+// Recipients returns the sessions of the entities which can see id.
+func (grid *Grid) Recipients(id uint64) []*link.Session {
+	grid.mutex.RLock()
+	defer grid.mutex.RUnlock()
+	e, exists := grid.entities[id]
+	if !exists {
+		return nil
+	}
+	var sessions []*link.Session
+	grid.visit(e.cell, func(n *entity) {
+		if n.id != id && n.session != nil {
+			sessions = append(sessions, n.session)
+		}
+	})
+	return sessions
+}
+
+func (grid *Grid) Broadcast(id uint64, msg interface{}) (failed int) {
+	for _, session := range grid.Recipients(id) {
+		if session.Send(msg) != nil {
+			failed++
+		}
+	}
+	return
+}
+
+func (grid *Grid) Len() int {
+	grid.mutex.RLock()
+	defer grid.mutex.RUnlock()
+	return len(grid.entities)
+}
@@ -0,0 +1,57 @@
+package aoi
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/codec"
+	"github.com/funny/utest"
+)
+
+func sorted(ids []uint64) []uint64 {
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func Test_Grid(t *testing.T) {
+	grid := NewGrid(10, 1)
+
+	utest.EqualNow(t, len(grid.Enter(1, nil, 5, 5)), 0)
+	utest.EqualNow(t, grid.Enter(2, nil, 15, 5), []uint64{1})
+	utest.EqualNow(t, len(grid.Enter(3, nil, 55, 55)), 0)
+
+	utest.EqualNow(t, sorted(grid.Neighbors(1)), []uint64{2})
+
+	appeared, disappeared := grid.Move(3, 25, 5)
+	utest.EqualNow(t, appeared, []uint64{2})
+	utest.EqualNow(t, len(disappeared), 0)
+
+	appeared, disappeared = grid.Move(1, -20, 5)
+	utest.EqualNow(t, len(appeared), 0)
+	utest.EqualNow(t, disappeared, []uint64{2})
+
+	utest.EqualNow(t, grid.Leave(2), []uint64{3})
+	utest.EqualNow(t, grid.Len(), 2)
+}
+
+func Test_GridReenter(t *testing.T) {
+	grid := NewGrid(10, 1)
+	old, _, err := link.Pipe(codec.Json(), 0)
+	utest.IsNilNow(t, err)
+	grid.Enter(1, old, 5, 5)
+	session, _, err := link.Pipe(codec.Json(), 0)
+	utest.IsNilNow(t, err)
+	grid.Enter(1, session, 5, 5)
+	grid.Enter(1, session, 6, 6)
+
+	// the session the entity left behind doesn't take it out of the grid.
+	old.Close()
+	time.Sleep(10 * time.Millisecond)
+	utest.EqualNow(t, grid.Len(), 1)
+	session.Close()
+	for grid.Len() != 0 {
+		time.Sleep(time.Millisecond)
+	}
+}
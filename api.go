@@ -5,6 +5,8 @@ import (
 	"net"
 	"strings"
 	"time"
+
+	"github.com/funny/link/clock"
 )
 
 type Protocol interface {
@@ -27,6 +29,27 @@ type ClearSendChan interface {
 	ClearSendChan(<-chan interface{})
 }
 
+// BatchCodec is implemented by codecs which can decode the frames already
+// read ahead in one pass. ReceiveBatch appends one message, waiting for it
+// like Receive, then up to max-1 more as long as no further read is needed.
+// Messages decoded before an error are returned along with it.
+type BatchCodec interface {
+	ReceiveBatch(msgs []interface{}, max int) ([]interface{}, error)
+}
+
+// ReceiveBatch uses the BatchCodec of codec, or receives a single message
+// when there is none.
+func ReceiveBatch(codec Codec, msgs []interface{}, max int) ([]interface{}, error) {
+	if batch, ok := codec.(BatchCodec); ok {
+		return batch.ReceiveBatch(msgs, max)
+	}
+	msg, err := codec.Receive()
+	if err != nil {
+		return msgs, err
+	}
+	return append(msgs, msg), nil
+}
+
 func Listen(network, address string, protocol Protocol, sendChanSize int, handler Handler) (*Server, error) {
 	listener, err := net.Listen(network, address)
 	if err != nil {
@@ -44,7 +67,9 @@ func Dial(network, address string, protocol Protocol, sendChanSize int) (*Sessio
 	if err != nil {
 		return nil, err
 	}
-	return NewSession(codec, sendChanSize), nil
+	session := NewSession(codec, sendChanSize)
+	session.remoteAddr = conn.RemoteAddr()
+	return session, nil
 }
 
 func DialTimeout(network, address string, timeout time.Duration, protocol Protocol, sendChanSize int) (*Session, error) {
@@ -56,7 +81,9 @@ func DialTimeout(network, address string, timeout time.Duration, protocol Protoc
 	if err != nil {
 		return nil, err
 	}
-	return NewSession(codec, sendChanSize), nil
+	session := NewSession(codec, sendChanSize)
+	session.remoteAddr = conn.RemoteAddr()
+	return session, nil
 }
 
 func Accept(listener net.Listener) (net.Conn, error) {
@@ -73,7 +100,7 @@ func Accept(listener net.Listener) (net.Conn, error) {
 				if max := 1 * time.Second; tempDelay > max {
 					tempDelay = max
 				}
-				time.Sleep(tempDelay)
+				<-clock.Default().NewTimer(tempDelay).C()
 				continue
 			}
 			if strings.Contains(err.Error(), "use of closed network connection") {
@@ -0,0 +1,172 @@
+package audit
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/funny/link"
+)
+
+type EventType string
+
+const (
+	Connect      EventType = "connect"
+	Auth         EventType = "auth"
+	AuthFailed   EventType = "auth_failed"
+	Kick         EventType = "kick"
+	Close        EventType = "close"
+	CodecUpgrade EventType = "codec_upgrade"
+)
+
+type Event struct {
+	Time       time.Time         `json:"time"`
+	Type       EventType         `json:"type"`
+	SessionID  uint64            `json:"session_id,omitempty"`
+	RemoteAddr string            `json:"remote_addr,omitempty"`
+	Actor      string            `json:"actor,omitempty"`
+	Reason     string            `json:"reason,omitempty"`
+	Fields     map[string]string `json:"fields,omitempty"`
+}
+
+type Sink interface {
+	Audit(event *Event)
+}
+
+type SinkFunc func(event *Event)
+
+func (f SinkFunc) Audit(event *Event) {
+	f(event)
+}
+
+type jsonSink struct {
+	mutex   sync.Mutex
+	encoder *json.Encoder
+}
+
+// JSONSink writes one JSON object per line to w.
+func JSONSink(w io.Writer) Sink {
+	return &jsonSink{encoder: json.NewEncoder(w)}
+}
+
+func (s *jsonSink) Audit(event *Event) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.encoder.Encode(event)
+}
+
+// Auditor turns session lifecycle and administrative actions into events.
+type Auditor struct {
+	sink  Sink
+	mutex sync.Mutex
+	// reasons has an entry for every tracked session, empty until
+	// SetCloseReason.
+	reasons map[uint64]string
+}
+
+func New(sink Sink) *Auditor {
+	return &Auditor{
+		sink:    sink,
+		reasons: make(map[uint64]string),
+	}
+}
+
+func (a *Auditor) Emit(event *Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	a.sink.Audit(event)
+}
+
+func (a *Auditor) event(typ EventType, session *link.Session) *Event {
+	event := &Event{Type: typ, SessionID: session.ID()}
+	if addr := session.RemoteAddr(); addr != nil {
+		event.RemoteAddr = addr.String()
+	}
+	return event
+}
+
+type handler struct {
+	a    *Auditor
+	base link.Handler
+}
+
+// Handler emits a connect event for every session passed to base and a
+// close event, with the reason given to SetCloseReason, when it closes.
+func (a *Auditor) Handler(base link.Handler) link.Handler {
+	return &handler{a, base}
+}
+
+func (h *handler) HandleSession(session *link.Session) {
+	h.a.Track(session)
+	h.base.HandleSession(session)
+}
+
+func (a *Auditor) Track(session *link.Session) {
+	a.Emit(a.event(Connect, session))
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if session.AddCloseCallback(a, nil, func() {
+		a.mutex.Lock()
+		reason := a.reasons[session.ID()]
+		delete(a.reasons, session.ID())
+		a.mutex.Unlock()
+		if reason == "" {
+			reason = "closed"
+		}
+		event := a.event(Close, session)
+		event.Reason = reason
+		a.Emit(event)
+	}) {
+		a.reasons[session.ID()] = ""
+	}
+}
+
+// SetCloseReason records why session is going to be closed. The first
+// reason wins, sessions which are not tracked or closed already are ignored.
+func (a *Auditor) SetCloseReason(session *link.Session, reason string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if session.IsClosed() {
+		return
+	}
+	if current, exists := a.reasons[session.ID()]; exists && current == "" {
+		a.reasons[session.ID()] = reason
+	}
+}
+
+func (a *Auditor) Auth(session *link.Session, user string, ok bool, reason string) {
+	event := a.event(Auth, session)
+	if !ok {
+		event.Type = AuthFailed
+	}
+	event.Actor, event.Reason = user, reason
+	a.Emit(event)
+}
+
+// Kick records the action and closes session.
+func (a *Auditor) Kick(session *link.Session, actor, reason string) {
+	event := a.event(Kick, session)
+	event.Actor, event.Reason = actor, reason
+	a.Emit(event)
+	a.SetCloseReason(session, "kicked: "+reason)
+	session.Close()
+}
+
+func (a *Auditor) CodecUpgrade(session *link.Session, from, to string) {
+	event := a.event(CodecUpgrade, session)
+	event.Fields = map[string]string{"from": from, "to": to}
+	a.Emit(event)
+}
+
+// AdminKick returns a callback for admin.Admin.OnKick.
+func (a *Auditor) AdminKick() func(r *http.Request, session *link.Session) {
+	return func(r *http.Request, session *link.Session) {
+		event := a.event(Kick, session)
+		event.Actor, event.Reason = r.RemoteAddr, "admin"
+		a.Emit(event)
+		a.SetCloseReason(session, "kicked: admin")
+	}
+}
@@ -0,0 +1,83 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/codec"
+	"github.com/funny/utest"
+)
+
+type syncBuffer struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) events(t *testing.T) []Event {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	var events []Event
+	scanner := bufio.NewScanner(bytes.NewReader(b.buf.Bytes()))
+	for scanner.Scan() {
+		var event Event
+		utest.IsNilNow(t, json.Unmarshal(scanner.Bytes(), &event))
+		events = append(events, event)
+	}
+	return events
+}
+
+func Test_Audit(t *testing.T) {
+	var buf syncBuffer
+	auditor := New(JSONSink(&buf))
+
+	client, server, err := link.Pipe(codec.Json(), 0)
+	utest.IsNilNow(t, err)
+	defer client.Close()
+	go auditor.Handler(link.HandlerFunc(func(session *link.Session) {
+		auditor.Auth(session, "alice", true, "")
+		auditor.CodecUpgrade(session, "json", "fixlen+json")
+		auditor.Kick(session, "operator", "spam")
+	})).HandleSession(server)
+
+	var events []Event
+	for i := 0; i < 100 && len(events) < 5; i++ {
+		time.Sleep(time.Millisecond)
+		events = buf.events(t)
+	}
+	utest.EqualNow(t, len(events), 5)
+	types := []EventType{Connect, Auth, CodecUpgrade, Kick, Close}
+	for i, event := range events {
+		utest.EqualNow(t, event.Type, types[i])
+		utest.EqualNow(t, event.SessionID, server.ID())
+	}
+	utest.EqualNow(t, events[1].Actor, "alice")
+	utest.EqualNow(t, events[2].Fields["to"], "fixlen+json")
+	utest.EqualNow(t, events[3].Actor, "operator")
+	utest.EqualNow(t, events[4].Reason, "kicked: spam")
+}
+
+func Test_CloseReasonUntracked(t *testing.T) {
+	auditor := New(SinkFunc(func(event *Event) {}))
+
+	client, server, err := link.Pipe(codec.Json(), 0)
+	utest.IsNilNow(t, err)
+	defer client.Close()
+	auditor.SetCloseReason(server, "kicked: spam")
+	utest.EqualNow(t, len(auditor.reasons), 0)
+
+	server.Close()
+	auditor.Track(server)
+	auditor.SetCloseReason(server, "kicked: spam")
+	utest.EqualNow(t, len(auditor.reasons), 0)
+}
@@ -0,0 +1,54 @@
+package link
+
+import (
+	"bytes"
+	"sync"
+)
+
+// Encoded is a message that has already been marshaled to wire bytes.
+// Codecs write it to the transport as is.
+type Encoded []byte
+
+type Broadcaster struct {
+	mutex  sync.Mutex
+	buffer broadcastBuffer
+	codec  Codec
+}
+
+type broadcastBuffer struct {
+	bytes.Buffer
+}
+
+func (b *broadcastBuffer) Close() error {
+	return nil
+}
+
+// NewBroadcaster creates a Broadcaster which marshals messages with protocol.
+// All target sessions must use the same stateless protocol.
+func NewBroadcaster(protocol Protocol) (*Broadcaster, error) {
+	b := &Broadcaster{}
+	codec, err := protocol.NewCodec(&b.buffer)
+	if err != nil {
+		return nil, err
+	}
+	b.codec = codec
+	return b, nil
+}
+
+func (b *Broadcaster) Encode(msg interface{}) (Encoded, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.buffer.Reset()
+	if err := b.codec.Send(msg); err != nil {
+		return nil, err
+	}
+	return Encoded(append([]byte(nil), b.buffer.Bytes()...)), nil
+}
+
+func (b *Broadcaster) Broadcast(channel *Channel, msg interface{}, except ...*Session) (int, error) {
+	encoded, err := b.Encode(msg)
+	if err != nil {
+		return 0, err
+	}
+	return channel.Broadcast(encoded, except...), nil
+}
@@ -0,0 +1,262 @@
+package bus
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
+
+	"github.com/funny/link"
+	"github.com/funny/link/internal/redial"
+	"github.com/funny/link/pubsub"
+)
+
+var (
+	ClosedError       = link.NewError(link.TransportError, "Bus Closed")
+	DisconnectedError = link.NewError(link.TransportError, "Bus Disconnected")
+)
+
+// Transport carries published messages between the nodes, such as Redis
+// pub/sub or NATS. Its methods may be called concurrently, Receive is called
+// by one goroutine only.
+type Transport interface {
+	Publish(topic string, data []byte) error
+	Subscribe(topic string) error
+	Unsubscribe(topic string) error
+	// Receive returns the next message of a subscribed topic, with the topic
+	// it was published to.
+	Receive() (topic string, data []byte, err error)
+	Close() error
+}
+
+// envelope header: publishing node and its sequence number.
+const headSize = 16
+
+// the number of message ids kept to drop duplicates.
+const recentSize = 64
+
+type msgID struct {
+	node, seq uint64
+}
+
+// Bus is a PubSub whose topics span all the nodes connected to the same
+// transport. Messages are encoded once with the protocol of the sessions and
+// sent to the nodes as wire bytes, so all sessions must use the same
+// stateless protocol. A node subscribes to a topic on the transport as long
+// as one of its sessions does. Broken transports are redialed with backoff
+// and the topics subscribed again, messages published meanwhile are lost.
+type Bus struct {
+	dial        func() (Transport, error)
+	broadcaster *link.Broadcaster
+	local       *pubsub.PubSub
+	node        uint64
+	seq         atomic.Uint64
+	recent      [recentSize]msgID
+	recentSet   map[msgID]struct{}
+	recentNext  int
+
+	mutex     sync.Mutex
+	transport Transport
+	refs      map[string]int
+	closed    bool
+	closeChan chan struct{}
+}
+
+// New dials the transport and starts receiving, dial is called again when
+// the transport breaks.
+func New(dial func() (Transport, error), protocol link.Protocol) (*Bus, error) {
+	broadcaster, err := link.NewBroadcaster(protocol)
+	if err != nil {
+		return nil, err
+	}
+	transport, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	var node [8]byte
+	rand.Read(node[:])
+	b := &Bus{
+		dial:        dial,
+		broadcaster: broadcaster,
+		local:       pubsub.New(),
+		node:        binary.LittleEndian.Uint64(node[:]),
+		recentSet:   make(map[msgID]struct{}, recentSize),
+		transport:   transport,
+		refs:        make(map[string]int),
+		closeChan:   make(chan struct{}),
+	}
+	go b.loop(transport)
+	return b, nil
+}
+
+func (b *Bus) Subscribe(session *link.Session, topic string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.closed || session.IsClosed() {
+		return
+	}
+	for _, t := range b.local.Topics(session) {
+		if t == topic {
+			return
+		}
+	}
+	b.local.Subscribe(session, topic)
+	session.AddCloseCallback(b, topic, func() {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+		b.release(topic)
+	})
+	b.refs[topic]++
+	if b.refs[topic] == 1 && b.transport != nil {
+		if err := b.transport.Subscribe(topic); err != nil {
+			link.GetLogger().Warn("bus: subscribe failed", "topic", topic, "error", err)
+		}
+	}
+}
+
+func (b *Bus) Unsubscribe(session *link.Session, topic string) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if !b.local.Unsubscribe(session, topic) {
+		return false
+	}
+	session.RemoveCloseCallback(b, topic)
+	b.release(topic)
+	return true
+}
+
+func (b *Bus) release(topic string) {
+	if b.refs[topic]--; b.refs[topic] > 0 {
+		return
+	}
+	delete(b.refs, topic)
+	if b.transport != nil {
+		b.transport.Unsubscribe(topic)
+	}
+}
+
+// Subscribers returns the sessions of this node which get the messages of
+// topic.
+func (b *Bus) Subscribers(topic string) []*link.Session {
+	return b.local.Subscribers(topic)
+}
+
+// Publish sends msg to the subscribers of topic on this node, it returns
+// how many of those sends failed, and to the other nodes. The error is about
+// the other nodes.
+func (b *Bus) Publish(topic string, msg interface{}) (failed int, err error) {
+	encoded, err := b.broadcaster.Encode(msg)
+	if err != nil {
+		return 0, err
+	}
+	failed = b.local.Publish(topic, encoded)
+
+	b.mutex.Lock()
+	transport, closed := b.transport, b.closed
+	b.mutex.Unlock()
+	if closed {
+		return failed, ClosedError
+	}
+	if transport == nil {
+		return failed, DisconnectedError
+	}
+	data := make([]byte, headSize+len(encoded))
+	binary.LittleEndian.PutUint64(data, b.node)
+	binary.LittleEndian.PutUint64(data[8:], b.seq.Add(1))
+	copy(data[headSize:], encoded)
+	return failed, transport.Publish(topic, data)
+}
+
+func (b *Bus) loop(transport Transport) {
+	var backoff redial.Backoff
+	for {
+		if transport != nil {
+			backoff.Reset()
+			err := b.receive(transport)
+			transport.Close()
+			b.mutex.Lock()
+			b.transport = nil
+			closed := b.closed
+			b.mutex.Unlock()
+			if closed {
+				return
+			}
+			link.GetLogger().Warn("bus: transport broken", "error", err)
+		}
+
+		if !backoff.Wait(b.closeChan) {
+			return
+		}
+
+		var err error
+		if transport, err = b.dial(); err != nil {
+			link.GetLogger().Warn("bus: dial failed", "error", err)
+			continue
+		}
+		if !b.reconnected(transport) {
+			transport.Close()
+			return
+		}
+	}
+}
+
+// reconnected subscribes the topics again on transport and puts it in place.
+func (b *Bus) reconnected(transport Transport) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.closed {
+		return false
+	}
+	for topic := range b.refs {
+		if err := transport.Subscribe(topic); err != nil {
+			link.GetLogger().Warn("bus: subscribe failed", "topic", topic, "error", err)
+		}
+	}
+	b.transport = transport
+	return true
+}
+
+func (b *Bus) receive(transport Transport) error {
+	for {
+		topic, data, err := transport.Receive()
+		if err != nil {
+			return err
+		}
+		if len(data) < headSize {
+			continue
+		}
+		id := msgID{binary.LittleEndian.Uint64(data), binary.LittleEndian.Uint64(data[8:])}
+		// our own messages were delivered in Publish. A message which
+		// matches several subscriptions of the node comes once for each.
+		if id.node == b.node || !b.first(id) {
+			continue
+		}
+		b.local.Publish(topic, link.Encoded(data[headSize:]))
+	}
+}
+
+// first reports whether id was not among the recent messages.
+func (b *Bus) first(id msgID) bool {
+	if _, exists := b.recentSet[id]; exists {
+		return false
+	}
+	delete(b.recentSet, b.recent[b.recentNext])
+	b.recent[b.recentNext] = id
+	b.recentSet[id] = struct{}{}
+	b.recentNext = (b.recentNext + 1) % recentSize
+	return true
+}
+
+func (b *Bus) Close() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.closed {
+		return ClosedError
+	}
+	b.closed = true
+	close(b.closeChan)
+	if b.transport != nil {
+		return b.transport.Close()
+	}
+	return nil
+}
@@ -0,0 +1,272 @@
+package bus
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/codec"
+	"github.com/funny/link/internal/resp"
+	"github.com/funny/link/pubsub"
+	"github.com/funny/utest"
+)
+
+type Event struct {
+	Text string
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	for i := 0; !cond(); i++ {
+		utest.Assert(t, i < 1000)
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// fakeServer keeps the subscriptions of its connections, deliver writes a
+// published message to the ones that match.
+type fakeServer struct {
+	listener net.Listener
+	mutex    sync.Mutex
+	conns    map[net.Conn]map[string]string // subscription id -> topic
+	deliver  func(conn net.Conn, id, pattern, topic string, data []byte)
+}
+
+func newFakeServer(t *testing.T, serve func(s *fakeServer, conn net.Conn)) *fakeServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	utest.IsNilNow(t, err)
+	s := &fakeServer{listener: listener, conns: make(map[net.Conn]map[string]string)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			s.mutex.Lock()
+			s.conns[conn] = make(map[string]string)
+			s.mutex.Unlock()
+			go func() {
+				serve(s, conn)
+				s.mutex.Lock()
+				delete(s.conns, conn)
+				s.mutex.Unlock()
+				conn.Close()
+			}()
+		}
+	}()
+	return s
+}
+
+func (s *fakeServer) subscribe(conn net.Conn, id, topic string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.conns[conn][id] = topic
+}
+
+func (s *fakeServer) unsubscribe(conn net.Conn, id string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.conns[conn], id)
+}
+
+func (s *fakeServer) publish(topic string, data []byte, match func(pattern, topic string) bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for conn, subs := range s.conns {
+		for id, pattern := range subs {
+			if match(pattern, topic) {
+				s.deliver(conn, id, pattern, topic, data)
+			}
+		}
+	}
+}
+
+func (s *fakeServer) subscriptions() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	n := 0
+	for _, subs := range s.conns {
+		n += len(subs)
+	}
+	return n
+}
+
+// kill breaks all connections.
+func (s *fakeServer) kill() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+		delete(s.conns, conn)
+	}
+}
+
+func (s *fakeServer) Close() {
+	s.listener.Close()
+	s.kill()
+}
+
+func newFakeRedis(t *testing.T) *fakeServer {
+	s := newFakeServer(t, func(s *fakeServer, conn net.Conn) {
+		r := bufio.NewReader(conn)
+		for {
+			v, err := resp.ReadValue(r)
+			if err != nil {
+				return
+			}
+			args := v.([]interface{})
+			command, arg := args[0].(string), args[1].(string)
+			switch command {
+			case "SUBSCRIBE", "PSUBSCRIBE":
+				s.subscribe(conn, command+" "+arg, arg)
+				resp.WriteCommand(conn, strings.ToLower(command), arg)
+			case "UNSUBSCRIBE", "PUNSUBSCRIBE":
+				s.unsubscribe(conn, strings.Replace(command, "UN", "", 1)+" "+arg)
+				resp.WriteCommand(conn, strings.ToLower(command), arg)
+			case "PUBLISH":
+				s.publish(arg, []byte(args[2].(string)), func(pattern, topic string) bool {
+					matched, _ := path.Match(pattern, topic)
+					return matched
+				})
+				io.WriteString(conn, ":1\r\n")
+			case "AUTH":
+				io.WriteString(conn, "+OK\r\n")
+			}
+		}
+	})
+	s.deliver = func(conn net.Conn, id, pattern, topic string, data []byte) {
+		if strings.HasPrefix(id, "P") {
+			resp.WriteCommand(conn, "pmessage", pattern, topic, string(data))
+		} else {
+			resp.WriteCommand(conn, "message", topic, string(data))
+		}
+	}
+	return s
+}
+
+func newFakeNATS(t *testing.T) *fakeServer {
+	s := newFakeServer(t, func(s *fakeServer, conn net.Conn) {
+		io.WriteString(conn, "INFO {}\r\nPING\r\n")
+		r := bufio.NewReader(conn)
+		for {
+			line, err := readLine(r)
+			if err != nil {
+				return
+			}
+			fields := strings.Fields(line)
+			switch fields[0] {
+			case "SUB":
+				s.subscribe(conn, fields[2], fields[1])
+			case "UNSUB":
+				s.unsubscribe(conn, fields[1])
+			case "PUB":
+				size, _ := strconv.Atoi(fields[2])
+				data := make([]byte, size+2)
+				if _, err := io.ReadFull(r, data); err != nil {
+					return
+				}
+				s.publish(fields[1], data[:size], func(pattern, topic string) bool {
+					return pattern == topic || pubsub.Match(pattern, topic)
+				})
+			}
+		}
+	})
+	s.deliver = func(conn net.Conn, id, pattern, topic string, data []byte) {
+		fmt.Fprintf(conn, "MSG %s %s %d\r\n%s\r\n", topic, id, len(data), data)
+	}
+	return s
+}
+
+func testBus(t *testing.T, server *fakeServer, dial func() (Transport, error)) {
+	defer server.Close()
+	json := codec.Json()
+	json.Register(Event{})
+
+	bus1, err := New(dial, json)
+	utest.IsNilNow(t, err)
+	defer bus1.Close()
+	bus2, err := New(dial, json)
+	utest.IsNilNow(t, err)
+	defer bus2.Close()
+
+	peer1, session1, err := link.Pipe(json, 0)
+	utest.IsNilNow(t, err)
+	peer2, session2, err := link.Pipe(json, 0)
+	utest.IsNilNow(t, err)
+	bus1.Subscribe(session1, "room.1")
+	bus2.Subscribe(session2, "room.1")
+	bus2.Subscribe(session2, "room.*")
+	waitFor(t, func() bool { return server.subscriptions() == 3 })
+
+	receive := func(peer *link.Session, text string) {
+		msg, err := peer.Receive()
+		utest.IsNilNow(t, err)
+		utest.EqualNow(t, msg.(*Event).Text, text)
+	}
+
+	// session2 matches twice on its node and gets it once.
+	failed, err := bus1.Publish("room.1", &Event{"hello"})
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, failed, 0)
+	receive(peer1, "hello")
+	receive(peer2, "hello")
+	_, err = bus2.Publish("room.2", &Event{"room 2"})
+	utest.IsNilNow(t, err)
+	receive(peer2, "room 2")
+
+	// the subscriptions are back after the transports broke.
+	server.kill()
+	waitFor(t, func() bool { return server.subscriptions() == 3 })
+	_, err = bus1.Publish("room.1", &Event{"again"})
+	utest.IsNilNow(t, err)
+	receive(peer1, "again")
+	receive(peer2, "again")
+
+	utest.Assert(t, bus2.Unsubscribe(session2, "room.*"))
+	waitFor(t, func() bool { return server.subscriptions() == 2 })
+	session2.Close()
+	waitFor(t, func() bool { return server.subscriptions() == 1 })
+}
+
+func Test_Redis(t *testing.T) {
+	server := newFakeRedis(t)
+	testBus(t, server, func() (Transport, error) {
+		return DialRedis(server.listener.Addr().String(), "secret")
+	})
+}
+
+func Test_NATS(t *testing.T) {
+	server := newFakeNATS(t)
+	testBus(t, server, func() (Transport, error) {
+		return DialNATS(server.listener.Addr().String(), map[string]string{"auth_token": "secret"})
+	})
+}
+
+func Test_NATSBadGreeting(t *testing.T) {
+	server := newFakeServer(t, func(s *fakeServer, conn net.Conn) {
+		io.WriteString(conn, "+OK\r\n")
+	})
+	defer server.Close()
+	_, err := DialNATS(server.listener.Addr().String(), nil)
+	utest.Assert(t, errors.Is(err, BadNATSReplyError))
+	utest.EqualNow(t, link.Classify(err), link.ProtocolError)
+}
+
+func Test_RedisPattern(t *testing.T) {
+	glob, pattern := redisPattern("room.*.chat")
+	utest.Assert(t, pattern)
+	utest.EqualNow(t, glob, "room.*.chat")
+	glob, pattern = redisPattern("a[1].>")
+	utest.Assert(t, pattern)
+	utest.EqualNow(t, glob, `a\[1\].*`)
+	_, pattern = redisPattern("room.1")
+	utest.Assert(t, !pattern)
+}
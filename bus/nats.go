@@ -0,0 +1,152 @@
+package bus
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/funny/link"
+)
+
+var (
+	BadNATSReplyError = link.NewError(link.ProtocolError, "Bad NATS Reply")
+	NATSServerError   = link.NewError(link.TransportError, "NATS Server Error")
+)
+
+// NATS is a Transport over the NATS client protocol. NATS subjects are dot
+// separated with the same "*" and ">" wildcards as pubsub topics, so topics
+// are used as they are.
+type NATS struct {
+	conn   net.Conn
+	r      *bufio.Reader
+	mutex  sync.Mutex
+	sids   map[string]int
+	topics map[int]string
+	next   int
+}
+
+// DialNATS connects to the NATS server at address, options are the fields of
+// the CONNECT message, such as "user" and "pass" or "auth_token".
+func DialNATS(address string, options map[string]string) (*NATS, error) {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	n := &NATS{
+		conn:   conn,
+		r:      bufio.NewReader(conn),
+		sids:   make(map[string]int),
+		topics: make(map[int]string),
+	}
+	line, err := readLine(n.r)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		conn.Close()
+		return nil, fmt.Errorf("%w: greeting %q", BadNATSReplyError, line)
+	}
+	fields := []string{`"verbose":false`, `"pedantic":false`}
+	for k, v := range options {
+		fields = append(fields, strconv.Quote(k)+":"+strconv.Quote(v))
+	}
+	if err := n.write("CONNECT {" + strings.Join(fields, ",") + "}\r\n"); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return n, nil
+}
+
+func (n *NATS) write(s string) error {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	_, err := io.WriteString(n.conn, s)
+	return err
+}
+
+func (n *NATS) Publish(topic string, data []byte) error {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if _, err := fmt.Fprintf(n.conn, "PUB %s %d\r\n%s\r\n", topic, len(data), data); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (n *NATS) Subscribe(topic string) error {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if _, exists := n.sids[topic]; exists {
+		return nil
+	}
+	n.next++
+	n.sids[topic] = n.next
+	n.topics[n.next] = topic
+	_, err := fmt.Fprintf(n.conn, "SUB %s %d\r\n", topic, n.next)
+	return err
+}
+
+func (n *NATS) Unsubscribe(topic string) error {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	sid, exists := n.sids[topic]
+	if !exists {
+		return nil
+	}
+	delete(n.sids, topic)
+	delete(n.topics, sid)
+	_, err := fmt.Fprintf(n.conn, "UNSUB %d\r\n", sid)
+	return err
+}
+
+func (n *NATS) Receive() (string, []byte, error) {
+	for {
+		line, err := readLine(n.r)
+		if err != nil {
+			return "", nil, err
+		}
+		switch op, args, _ := strings.Cut(line, " "); strings.ToUpper(op) {
+		case "MSG":
+			// MSG <subject> <sid> [reply-to] <size>
+			fields := strings.Fields(args)
+			if len(fields) < 3 {
+				return "", nil, fmt.Errorf("%w: message %q", BadNATSReplyError, line)
+			}
+			size, err := strconv.Atoi(fields[len(fields)-1])
+			if err != nil || size < 0 {
+				return "", nil, fmt.Errorf("%w: message %q", BadNATSReplyError, line)
+			}
+			data := make([]byte, size+2)
+			if _, err := io.ReadFull(n.r, data); err != nil {
+				return "", nil, err
+			}
+			return fields[0], data[:size], nil
+		case "PING":
+			if err := n.write("PONG\r\n"); err != nil {
+				return "", nil, err
+			}
+		case "-ERR":
+			return "", nil, fmt.Errorf("%w: %s", NATSServerError, args)
+		}
+	}
+}
+
+func (n *NATS) Close() error {
+	return n.conn.Close()
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return "", fmt.Errorf("%w: line %q", BadNATSReplyError, line)
+	}
+	return line[:len(line)-2], nil
+}
@@ -0,0 +1,139 @@
+package bus
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/funny/link/internal/resp"
+)
+
+// Redis is a Transport over Redis pub/sub. A subscribed Redis connection
+// can't publish, so it uses two. Topics with wildcards are subscribed with
+// PSUBSCRIBE, the glob of a pattern may match more than the pattern, the
+// extra messages are filtered by the bus.
+type Redis struct {
+	pubMutex sync.Mutex
+	pub      net.Conn
+	pubR     *bufio.Reader
+
+	subMutex sync.Mutex
+	sub      net.Conn
+	subR     *bufio.Reader
+}
+
+// DialRedis connects to the Redis server at address, with AUTH when password
+// is not empty.
+func DialRedis(address, password string) (*Redis, error) {
+	pub, err := net.Dial("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	sub, err := net.Dial("tcp", address)
+	if err != nil {
+		pub.Close()
+		return nil, err
+	}
+	r := &Redis{
+		pub:  pub,
+		pubR: bufio.NewReader(pub),
+		sub:  sub,
+		subR: bufio.NewReader(sub),
+	}
+	if password != "" {
+		for _, c := range []struct {
+			conn net.Conn
+			r    *bufio.Reader
+		}{{pub, r.pubR}, {sub, r.subR}} {
+			if err := resp.WriteCommand(c.conn, "AUTH", password); err != nil {
+				r.Close()
+				return nil, err
+			}
+			if _, err := resp.ReadValue(c.r); err != nil {
+				r.Close()
+				return nil, err
+			}
+		}
+	}
+	return r, nil
+}
+
+func (r *Redis) Publish(topic string, data []byte) error {
+	r.pubMutex.Lock()
+	defer r.pubMutex.Unlock()
+	if err := resp.WriteCommand(r.pub, "PUBLISH", topic, string(data)); err != nil {
+		return err
+	}
+	_, err := resp.ReadValue(r.pubR)
+	return err
+}
+
+// redisPattern turns a topic pattern into a glob, "*" and ">" become "*",
+// the glob characters of the other segments are escaped.
+func redisPattern(topic string) (string, bool) {
+	segments := strings.Split(topic, ".")
+	pattern := false
+	for i, segment := range segments {
+		if segment == "*" || segment == ">" {
+			segments[i], pattern = "*", true
+			continue
+		}
+		var b strings.Builder
+		for _, c := range segment {
+			if strings.ContainsRune(`*?[]\`, c) {
+				b.WriteByte('\\')
+			}
+			b.WriteRune(c)
+		}
+		segments[i] = b.String()
+	}
+	return strings.Join(segments, "."), pattern
+}
+
+func (r *Redis) subscribe(command, topic string) error {
+	if glob, pattern := redisPattern(topic); pattern {
+		command, topic = "P"+command, glob
+	}
+	r.subMutex.Lock()
+	defer r.subMutex.Unlock()
+	// the replies come to Receive.
+	return resp.WriteCommand(r.sub, command, topic)
+}
+
+func (r *Redis) Subscribe(topic string) error {
+	return r.subscribe("SUBSCRIBE", topic)
+}
+
+func (r *Redis) Unsubscribe(topic string) error {
+	return r.subscribe("UNSUBSCRIBE", topic)
+}
+
+func (r *Redis) Receive() (string, []byte, error) {
+	for {
+		v, err := resp.ReadValue(r.subR)
+		if err != nil {
+			return "", nil, err
+		}
+		items, ok := v.([]interface{})
+		if !ok || len(items) < 3 {
+			continue
+		}
+		kind, _ := items[0].(string)
+		switch {
+		case kind == "message" && len(items) == 3:
+			topic, _ := items[1].(string)
+			data, _ := items[2].(string)
+			return topic, []byte(data), nil
+		case kind == "pmessage" && len(items) == 4:
+			topic, _ := items[2].(string)
+			data, _ := items[3].(string)
+			return topic, []byte(data), nil
+		}
+	}
+}
+
+func (r *Redis) Close() error {
+	r.sub.Close()
+	return r.pub.Close()
+}
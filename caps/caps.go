@@ -0,0 +1,145 @@
+package caps
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/funny/link"
+)
+
+var (
+	NegotiateError = link.NewError(link.ProtocolError, "Caps Negotiation Failed")
+	MissingError   = link.NewError(link.ProtocolError, "Caps Required By Peer Missing")
+)
+
+// Flags is a set of optional features of a connection.
+type Flags uint32
+
+const (
+	Compression Flags = 1 << iota
+	Batching
+	Acks
+	BigFrames
+)
+
+// User is the first flag free for applications, the ones below are kept for
+// features of link.
+const User Flags = 1 << 16
+
+var names = []string{"compression", "batching", "acks", "big-frames"}
+
+func (f Flags) Has(flags Flags) bool {
+	return f&flags == flags
+}
+
+func (f Flags) String() string {
+	var parts []string
+	for i, name := range names {
+		if f&(1<<i) != 0 {
+			parts = append(parts, name)
+		}
+	}
+	if rest := f &^ (1<<len(names) - 1); rest != 0 {
+		parts = append(parts, "0x"+strconv.FormatUint(uint64(rest), 16))
+	}
+	return strings.Join(parts, "|")
+}
+
+const hello = "LNKC"
+
+// Negotiator is a protocol which tells the peer the features it supports
+// before the session starts. Both ends send a hello with their Flags, the
+// features on in both are handed to Protocol, which builds the stack with
+// them, e.g. adds compression or larger frames. A peer which lacks one of
+// Required fails the session.
+type Negotiator struct {
+	Flags    Flags
+	Required Flags
+	Protocol func(agreed Flags) link.Protocol
+	Timeout  time.Duration // of the exchange on a net.Conn, default 10s
+}
+
+func New(flags Flags, protocol func(agreed Flags) link.Protocol) *Negotiator {
+	return &Negotiator{Flags: flags, Protocol: protocol, Timeout: 10 * time.Second}
+}
+
+// NewCodec exchanges the hellos on rw before the protocol gets it. It blocks
+// until the peer answers, so link.Pipe can't use it.
+func (n *Negotiator) NewCodec(rw io.ReadWriter) (link.Codec, error) {
+	if conn, ok := rw.(net.Conn); ok && n.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(n.Timeout))
+		defer conn.SetDeadline(time.Time{})
+	}
+	agreed, err := n.exchange(rw)
+	if err != nil {
+		return nil, err
+	}
+	codec, err := n.Protocol(agreed).NewCodec(rw)
+	if err != nil {
+		return nil, err
+	}
+	return &Codec{codec, agreed}, nil
+}
+
+// exchange sends the magic, the flags and the required flags, a peer only
+// checks the required ones of the other side against its own flags.
+func (n *Negotiator) exchange(rw io.ReadWriter) (Flags, error) {
+	var buf [len(hello) + 8]byte
+	copy(buf[:], hello)
+	binary.LittleEndian.PutUint32(buf[len(hello):], uint32(n.Flags|n.Required))
+	binary.LittleEndian.PutUint32(buf[len(hello)+4:], uint32(n.Required))
+	if _, err := rw.Write(buf[:]); err != nil {
+		return 0, err
+	}
+	if flusher, ok := rw.(interface{ Flush() error }); ok {
+		if err := flusher.Flush(); err != nil {
+			return 0, err
+		}
+	}
+	if _, err := io.ReadFull(rw, buf[:]); err != nil {
+		return 0, err
+	}
+	if string(buf[:len(hello)]) != hello {
+		return 0, NegotiateError
+	}
+	peer := Flags(binary.LittleEndian.Uint32(buf[len(hello):]))
+	peerRequired := Flags(binary.LittleEndian.Uint32(buf[len(hello)+4:]))
+	if !peer.Has(n.Required) || !(n.Flags | n.Required).Has(peerRequired) {
+		return 0, MissingError
+	}
+	return (n.Flags | n.Required) & peer, nil
+}
+
+// Codec is the codec of Negotiator sessions.
+type Codec struct {
+	link.Codec
+	flags Flags
+}
+
+// Flags returns the features both ends support.
+func (c *Codec) Flags() Flags {
+	return c.flags
+}
+
+func (c *Codec) ReceiveBatch(msgs []interface{}, max int) ([]interface{}, error) {
+	return link.ReceiveBatch(c.Codec, msgs, max)
+}
+
+func (c *Codec) ClearSendChan(ch <-chan interface{}) {
+	if clear, ok := c.Codec.(link.ClearSendChan); ok {
+		clear.ClearSendChan(ch)
+	}
+}
+
+// Of returns the features agreed for session, zero when the outermost codec
+// of session isn't a Codec.
+func Of(session *link.Session) Flags {
+	if c, ok := session.Codec().(*Codec); ok {
+		return c.flags
+	}
+	return 0
+}
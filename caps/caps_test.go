@@ -0,0 +1,91 @@
+package caps
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/funny/link"
+	"github.com/funny/link/codec"
+	"github.com/funny/utest"
+)
+
+type Move struct {
+	X, Y int
+}
+
+func negotiator(flags, required Flags, agreed *Flags) *Negotiator {
+	n := New(flags, func(f Flags) link.Protocol {
+		*agreed = f
+		json := codec.Json()
+		json.Register(Move{})
+		maxPacket := 64 * 1024
+		if f.Has(BigFrames) {
+			maxPacket = 16 * 1024 * 1024
+		}
+		return codec.FixLen(json, 4, binary.LittleEndian, maxPacket, maxPacket)
+	})
+	n.Required = required
+	return n
+}
+
+func connect(p1, p2 link.Protocol) (link.Codec, link.Codec, error, error) {
+	conn1, conn2 := link.PipeConn()
+	type result struct {
+		codec link.Codec
+		err   error
+	}
+	done := make(chan result)
+	go func() {
+		c, err := p2.NewCodec(conn2)
+		done <- result{c, err}
+	}()
+	c1, err1 := p1.NewCodec(conn1)
+	if err1 != nil {
+		conn1.Close()
+	}
+	r := <-done
+	return c1, r.codec, err1, r.err
+}
+
+func Test_Negotiate(t *testing.T) {
+	var f1, f2 Flags
+	c1, c2, err1, err2 := connect(
+		negotiator(Compression|Batching|BigFrames|User, 0, &f1),
+		negotiator(Batching|Acks|BigFrames, 0, &f2))
+	utest.IsNilNow(t, err1)
+	utest.IsNilNow(t, err2)
+	utest.EqualNow(t, f1, Batching|BigFrames)
+	utest.EqualNow(t, f2, Batching|BigFrames)
+	utest.EqualNow(t, f1.String(), "batching|big-frames")
+
+	a, b := link.NewSession(c1, 0), link.NewSession(c2, 0)
+	defer a.Close()
+	defer b.Close()
+	utest.EqualNow(t, Of(a), Batching|BigFrames)
+	utest.IsNilNow(t, a.Send(&Move{1, 2}))
+	msg, err := b.Receive()
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, *msg.(*Move), Move{1, 2})
+}
+
+func Test_Required(t *testing.T) {
+	var f1, f2 Flags
+	_, _, err1, err2 := connect(negotiator(Batching, Acks, &f1), negotiator(Batching, 0, &f2))
+	utest.Assert(t, errors.Is(err1, MissingError))
+	utest.Assert(t, errors.Is(err2, MissingError))
+
+	// a required flag counts as supported.
+	c1, c2, err1, err2 := connect(negotiator(0, Acks, &f1), negotiator(Acks, 0, &f2))
+	utest.IsNilNow(t, err1)
+	utest.IsNilNow(t, err2)
+	c1.Close()
+	c2.Close()
+	utest.EqualNow(t, f1, Acks)
+	utest.EqualNow(t, f2, Acks)
+}
+
+func Test_String(t *testing.T) {
+	utest.EqualNow(t, (Compression | Acks | User<<1).String(), "compression|acks|0x20000")
+	utest.EqualNow(t, Flags(0).String(), "")
+}
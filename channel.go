@@ -6,9 +6,23 @@ import (
 
 type KEY interface{}
 
+type ChannelEventType int
+
+const (
+	ChannelJoin ChannelEventType = iota
+	ChannelLeave
+)
+
+type ChannelEvent struct {
+	Type    ChannelEventType
+	Key     KEY
+	Session *Session
+}
+
 type Channel struct {
-	mutex    sync.RWMutex
-	sessions map[KEY]*Session
+	mutex        sync.RWMutex
+	sessions     map[KEY]*Session
+	eventHandler func(ChannelEvent)
 
 	// channel state
 	State interface{}
@@ -20,6 +34,41 @@ func NewChannel() *Channel {
 	}
 }
 
+// SetEventHandler registers a callback for membership changes. The callback
+// runs while the channel is locked, so events are seen in the same order as
+// the changes, but it must not call back into the channel.
+func (channel *Channel) SetEventHandler(handler func(ChannelEvent)) {
+	channel.mutex.Lock()
+	defer channel.mutex.Unlock()
+	channel.eventHandler = handler
+}
+
+func (channel *Channel) emit(eventType ChannelEventType, key KEY, session *Session) {
+	if channel.eventHandler != nil {
+		channel.eventHandler(ChannelEvent{eventType, key, session})
+	}
+}
+
+func (channel *Channel) Snapshot() map[KEY]*Session {
+	channel.mutex.RLock()
+	defer channel.mutex.RUnlock()
+	snapshot := make(map[KEY]*Session, len(channel.sessions))
+	for key, session := range channel.sessions {
+		snapshot[key] = session
+	}
+	return snapshot
+}
+
+func (channel *Channel) Keys() []KEY {
+	channel.mutex.RLock()
+	defer channel.mutex.RUnlock()
+	keys := make([]KEY, 0, len(channel.sessions))
+	for key := range channel.sessions {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
 func (channel *Channel) Len() int {
 	channel.mutex.RLock()
 	defer channel.mutex.RUnlock()
@@ -51,11 +100,53 @@ func (channel *Channel) Put(key KEY, session *Session) {
 		channel.Remove(key)
 	})
 	channel.sessions[key] = session
+	channel.emit(ChannelJoin, key, session)
 }
 
 func (channel *Channel) remove(key KEY, session *Session) {
 	session.RemoveCloseCallback(channel, key)
 	delete(channel.sessions, key)
+	channel.emit(ChannelLeave, key, session)
+}
+
+func (channel *Channel) Join(session *Session) {
+	channel.Put(session.ID(), session)
+}
+
+func (channel *Channel) Leave(session *Session) bool {
+	return channel.Remove(session.ID())
+}
+
+func isExcepted(session *Session, except []*Session) bool {
+	for _, s := range except {
+		if s == session {
+			return true
+		}
+	}
+	return false
+}
+
+func (channel *Channel) Broadcast(msg interface{}, except ...*Session) (failed int) {
+	_, failed = channel.broadcast(msg, except)
+	return
+}
+
+// broadcast returns the number of sessions sent to and how many of them
+// failed, excepted sessions count in neither.
+func (channel *Channel) broadcast(msg interface{}, except []*Session) (sent, failed int) {
+	channel.mutex.RLock()
+	defer channel.mutex.RUnlock()
+	for _, session := range channel.sessions {
+		if len(except) > 0 && isExcepted(session, except) {
+			continue
+		}
+		if session.Send(msg) != nil {
+			failed++
+		} else {
+			sent++
+		}
+	}
+	return
 }
 
 func (channel *Channel) Remove(key KEY) bool {
@@ -72,8 +163,7 @@ func (channel *Channel) FetchAndRemove(callback func(*Session)) {
 	channel.mutex.Lock()
 	defer channel.mutex.Unlock()
 	for key, session := range channel.sessions {
-		session.RemoveCloseCallback(channel, key)
-		delete(channel.sessions, key)
+		channel.remove(key, session)
 		callback(session)
 	}
 }
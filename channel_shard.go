@@ -0,0 +1,137 @@
+package link
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+)
+
+type ShardedChannel struct {
+	shards     []*Channel
+	broadcasts uint64
+	sent       uint64
+	failed     uint64
+}
+
+type ShardedChannelStats struct {
+	Members    int
+	ShardLens  []int
+	Broadcasts uint64
+	Sent       uint64
+	Failed     uint64
+}
+
+// NewShardedChannel makes a channel of shardNum shards, at least one.
+func NewShardedChannel(shardNum int) *ShardedChannel {
+	if shardNum < 1 {
+		shardNum = 1
+	}
+	channel := &ShardedChannel{
+		shards: make([]*Channel, shardNum),
+	}
+	for i := range channel.shards {
+		channel.shards[i] = NewChannel()
+	}
+	return channel
+}
+
+func hashKey(key KEY) uint64 {
+	switch k := key.(type) {
+	case uint64:
+		return k
+	case int64:
+		return uint64(k)
+	case int:
+		return uint64(k)
+	case uint32:
+		return uint64(k)
+	case int32:
+		return uint64(k)
+	case string:
+		h := fnv.New64a()
+		h.Write([]byte(k))
+		return h.Sum64()
+	}
+	h := fnv.New64a()
+	fmt.Fprint(h, key)
+	return h.Sum64()
+}
+
+func (channel *ShardedChannel) Shard(key KEY) *Channel {
+	return channel.shards[hashKey(key)%uint64(len(channel.shards))]
+}
+
+func (channel *ShardedChannel) Put(key KEY, session *Session) {
+	channel.Shard(key).Put(key, session)
+}
+
+func (channel *ShardedChannel) Get(key KEY) *Session {
+	return channel.Shard(key).Get(key)
+}
+
+func (channel *ShardedChannel) Remove(key KEY) bool {
+	return channel.Shard(key).Remove(key)
+}
+
+func (channel *ShardedChannel) Join(session *Session) {
+	channel.Put(session.ID(), session)
+}
+
+func (channel *ShardedChannel) Leave(session *Session) bool {
+	return channel.Remove(session.ID())
+}
+
+func (channel *ShardedChannel) Len() int {
+	n := 0
+	for _, shard := range channel.shards {
+		n += shard.Len()
+	}
+	return n
+}
+
+func (channel *ShardedChannel) Fetch(callback func(*Session)) {
+	for _, shard := range channel.shards {
+		shard.Fetch(callback)
+	}
+}
+
+func (channel *ShardedChannel) Broadcast(msg interface{}, except ...*Session) (failed int) {
+	var wait sync.WaitGroup
+	var sent, fails int64
+	for _, shard := range channel.shards {
+		wait.Add(1)
+		go func(shard *Channel) {
+			defer wait.Done()
+			s, f := shard.broadcast(msg, except)
+			atomic.AddInt64(&sent, int64(s))
+			atomic.AddInt64(&fails, int64(f))
+		}(shard)
+	}
+	wait.Wait()
+
+	atomic.AddUint64(&channel.broadcasts, 1)
+	atomic.AddUint64(&channel.sent, uint64(sent))
+	atomic.AddUint64(&channel.failed, uint64(fails))
+	return int(fails)
+}
+
+func (channel *ShardedChannel) Stats() ShardedChannelStats {
+	stats := ShardedChannelStats{
+		ShardLens:  make([]int, len(channel.shards)),
+		Broadcasts: atomic.LoadUint64(&channel.broadcasts),
+		Sent:       atomic.LoadUint64(&channel.sent),
+		Failed:     atomic.LoadUint64(&channel.failed),
+	}
+	for i, shard := range channel.shards {
+		stats.ShardLens[i] = shard.Len()
+		stats.Members += stats.ShardLens[i]
+	}
+	return stats
+}
+
+func (channel *ShardedChannel) Close() {
+	for _, shard := range channel.shards {
+		shard.Close()
+	}
+}
@@ -0,0 +1,146 @@
+package chaos
+
+import (
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/clock"
+)
+
+var ResetError = link.NewError(link.TransportError, "Chaos Connection Reset")
+
+type Config struct {
+	Latency        time.Duration // added before every write
+	Jitter         time.Duration // random extra latency up to this much
+	BytesPerSecond int           // write throttle, 0 is unlimited
+	MaxWriteChunk  int           // split writes into random chunks up to this size, 0 keeps them whole
+	ResetRate      float64       // probability of a reset per read or write
+	CorruptRate    float64       // probability of flipping one bit of a write
+	Seed           int64         // same seed and same calls give the same faults
+	Clock          clock.Clock   // of the latency, nil is clock.Default()
+}
+
+// Conn injects faults into the writes, and resets into the reads, of the
+// wrapped connection. Faults are decided by a seeded random source, so a
+// test run can be repeated exactly.
+type Conn struct {
+	net.Conn
+	config Config
+
+	mutex sync.Mutex
+	rand  *rand.Rand
+}
+
+func Wrap(conn net.Conn, config Config) *Conn {
+	return &Conn{
+		Conn:   conn,
+		config: config,
+		rand:   rand.New(rand.NewSource(config.Seed)),
+	}
+}
+
+func (c *Conn) chance(rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.rand.Float64() < rate
+}
+
+func (c *Conn) intn(n int) int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.rand.Intn(n)
+}
+
+func (c *Conn) reset() error {
+	c.Conn.Close()
+	return ResetError
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	if c.chance(c.config.ResetRate) {
+		return 0, c.reset()
+	}
+	return c.Conn.Read(p)
+}
+
+func (c *Conn) delay(n int) {
+	d := c.config.Latency
+	if c.config.Jitter > 0 {
+		d += time.Duration(c.intn(int(c.config.Jitter)))
+	}
+	if c.config.BytesPerSecond > 0 {
+		d += time.Duration(n) * time.Second / time.Duration(c.config.BytesPerSecond)
+	}
+	if d > 0 {
+		<-clock.Or(c.config.Clock).NewTimer(d).C()
+	}
+}
+
+func (c *Conn) Write(p []byte) (int, error) {
+	if c.chance(c.config.ResetRate) {
+		return 0, c.reset()
+	}
+	data := p
+	if c.chance(c.config.CorruptRate) && len(p) > 0 {
+		data = append([]byte(nil), p...)
+		i := c.intn(len(data) * 8)
+		data[i/8] ^= 1 << uint(i%8)
+	}
+
+	written := 0
+	for written < len(data) {
+		chunk := len(data) - written
+		if c.config.MaxWriteChunk > 0 && chunk > 1 {
+			if max := c.intn(c.config.MaxWriteChunk) + 1; chunk > max {
+				chunk = max
+			}
+		}
+		c.delay(chunk)
+		n, err := c.Conn.Write(data[written : written+chunk])
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+type Listener struct {
+	net.Listener
+	config Config
+	mutex  sync.Mutex
+	count  int64
+}
+
+// NewListener wraps every accepted connection. Connection n uses the seed
+// Seed+n, so runs stay repeatable with many connections.
+func NewListener(l net.Listener, config Config) *Listener {
+	return &Listener{Listener: l, config: config}
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	l.mutex.Lock()
+	config := l.config
+	config.Seed += l.count
+	l.count++
+	l.mutex.Unlock()
+	return Wrap(conn, config), nil
+}
+
+func Dial(network, address string, config Config) (*Conn, error) {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return Wrap(conn, config), nil
+}
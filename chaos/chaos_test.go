@@ -0,0 +1,60 @@
+package chaos
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/utest"
+)
+
+type recordConn struct {
+	net.Conn
+	writes [][]byte
+}
+
+func (c *recordConn) Write(p []byte) (int, error) {
+	c.writes = append(c.writes, append([]byte(nil), p...))
+	return len(p), nil
+}
+
+func faults(config Config, data []byte) [][]byte {
+	rc := &recordConn{}
+	conn := Wrap(rc, config)
+	for i := 0; i < 10; i++ {
+		conn.Write(data)
+	}
+	return rc.writes
+}
+
+func Test_Deterministic(t *testing.T) {
+	config := Config{MaxWriteChunk: 7, CorruptRate: 0.3, Seed: 42}
+	data := bytes.Repeat([]byte{0xAA}, 64)
+	a := faults(config, data)
+	b := faults(config, data)
+	utest.EqualNow(t, a, b)
+	utest.Assert(t, len(a) > 10)
+	utest.Assert(t, !bytes.Equal(bytes.Join(a, nil), bytes.Repeat(data, 10)))
+}
+
+func Test_Chaos(t *testing.T) {
+	c1, c2 := link.PipeConn()
+	conn := Wrap(c1, Config{Latency: 10 * time.Millisecond, MaxWriteChunk: 3})
+	data := []byte("hello chaos")
+	start := time.Now()
+	go conn.Write(data)
+	buf := make([]byte, len(data))
+	_, err := io.ReadFull(c2, buf)
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, buf, data)
+	utest.Assert(t, time.Since(start) >= 20*time.Millisecond)
+
+	conn = Wrap(c1, Config{ResetRate: 1})
+	_, err = conn.Write(data)
+	utest.EqualNow(t, err, ResetError)
+	_, err = c2.Read(buf)
+	utest.NotNilNow(t, err)
+}
@@ -0,0 +1,256 @@
+package clock
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Clock is the source of time for timeouts, backoffs and debouncing, so
+// tests can swap in a Fake and advance time instantly. Types which wait take
+// it from a Clock field, a nil field is the Default clock.
+type Clock interface {
+	Now() time.Time
+	Since(t time.Time) time.Duration
+	NewTimer(d time.Duration) Timer
+	AfterFunc(d time.Duration, f func()) Timer
+	NewTicker(d time.Duration) Ticker
+}
+
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Real is the system clock.
+var Real Clock = realClock{}
+
+type holder struct {
+	Clock
+}
+
+var defaultClock atomic.Value
+
+func init() {
+	defaultClock.Store(holder{Real})
+}
+
+// SetDefault sets the clock of every Clock field left nil and of the code
+// which has no field, such as link.WithTTL and the redial backoffs, nil is
+// Real.
+func SetDefault(c Clock) {
+	if c == nil {
+		c = Real
+	}
+	defaultClock.Store(holder{c})
+}
+
+func Default() Clock {
+	return defaultClock.Load().(holder).Clock
+}
+
+// Or returns c, or the Default clock when c is nil.
+func Or(c Clock) Clock {
+	if c == nil {
+		return Default()
+	}
+	return c
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                  { return time.Now() }
+func (realClock) Since(t time.Time) time.Duration { return time.Since(t) }
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	t := time.NewTimer(d)
+	return &realTimer{t, t.C}
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return &realTimer{time.AfterFunc(d, f), nil}
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+type realTimer struct {
+	t *time.Timer
+	c <-chan time.Time
+}
+
+func (t *realTimer) C() <-chan time.Time        { return t.c }
+func (t *realTimer) Stop() bool                 { return t.t.Stop() }
+func (t *realTimer) Reset(d time.Duration) bool { return t.t.Reset(d) }
+
+type realTicker struct {
+	t *time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time { return t.t.C }
+func (t realTicker) Stop()               { t.t.Stop() }
+
+// Fake is a manual clock. Timers fire when Advance moves the time past
+// their deadline, callbacks of AfterFunc run in the advancing goroutine.
+type Fake struct {
+	mutex   sync.Mutex
+	now     time.Time
+	waiters []*fakeTimer
+	changed *sync.Cond
+}
+
+func NewFake(now time.Time) *Fake {
+	f := &Fake{now: now}
+	f.changed = sync.NewCond(&f.mutex)
+	return f
+}
+
+func (f *Fake) Now() time.Time {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.now
+}
+
+func (f *Fake) Since(t time.Time) time.Duration {
+	return f.Now().Sub(t)
+}
+
+// Advance moves the time forward and fires the timers which are due, in
+// deadline order.
+func (f *Fake) Advance(d time.Duration) {
+	f.mutex.Lock()
+	target := f.now.Add(d)
+	for {
+		sort.Slice(f.waiters, func(i, j int) bool { return f.waiters[i].when.Before(f.waiters[j].when) })
+		if len(f.waiters) == 0 || f.waiters[0].when.After(target) {
+			break
+		}
+		t := f.waiters[0]
+		f.waiters = f.waiters[1:]
+		if t.when.After(f.now) {
+			f.now = t.when
+		}
+		f.changed.Broadcast()
+		f.mutex.Unlock()
+		t.fire(t.when)
+		f.mutex.Lock()
+	}
+	f.now = target
+	f.changed.Broadcast()
+	f.mutex.Unlock()
+}
+
+// Waiters returns the number of pending timers and tickers.
+func (f *Fake) Waiters() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return len(f.waiters)
+}
+
+// BlockUntil waits until at least n timers are pending, to synchronize
+// with a goroutine which is about to wait on the clock.
+func (f *Fake) BlockUntil(n int) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for len(f.waiters) < n {
+		f.changed.Wait()
+	}
+}
+
+func (f *Fake) add(t *fakeTimer) {
+	f.waiters = append(f.waiters, t)
+	f.changed.Broadcast()
+}
+
+func (f *Fake) remove(t *fakeTimer) bool {
+	for i, w := range f.waiters {
+		if w == t {
+			f.waiters = append(f.waiters[:i], f.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (f *Fake) newTimer(d time.Duration, fn func(), period time.Duration) *fakeTimer {
+	t := &fakeTimer{clock: f, fn: fn, period: period}
+	if fn == nil {
+		t.c = make(chan time.Time, 1)
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	t.when = f.now.Add(d)
+	f.add(t)
+	return t
+}
+
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	return f.newTimer(d, nil, 0)
+}
+
+func (f *Fake) AfterFunc(d time.Duration, fn func()) Timer {
+	return f.newTimer(d, fn, 0)
+}
+
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	return fakeTicker{f.newTimer(d, nil, d)}
+}
+
+type fakeTicker struct {
+	t *fakeTimer
+}
+
+func (t fakeTicker) C() <-chan time.Time { return t.t.c }
+func (t fakeTicker) Stop()               { t.t.Stop() }
+
+type fakeTimer struct {
+	clock  *Fake
+	when   time.Time
+	period time.Duration
+	fn     func()
+	c      chan time.Time
+}
+
+func (t *fakeTimer) fire(now time.Time) {
+	if t.period > 0 {
+		t.clock.mutex.Lock()
+		t.when = now.Add(t.period)
+		t.clock.add(t)
+		t.clock.mutex.Unlock()
+	}
+	if t.fn != nil {
+		t.fn()
+		return
+	}
+	select {
+	case t.c <- now:
+	default:
+	}
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mutex.Lock()
+	defer t.clock.mutex.Unlock()
+	return t.clock.remove(t)
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.mutex.Lock()
+	defer t.clock.mutex.Unlock()
+	active := t.clock.remove(t)
+	t.when = t.clock.now.Add(d)
+	t.clock.add(t)
+	return active
+}
@@ -0,0 +1,43 @@
+package clock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/funny/utest"
+)
+
+func Test_Fake(t *testing.T) {
+	start := time.Unix(1000, 0)
+	f := NewFake(start)
+
+	var fired []int
+	f.AfterFunc(2*time.Second, func() { fired = append(fired, 2) })
+	f.AfterFunc(time.Second, func() { fired = append(fired, 1) })
+	stopped := f.AfterFunc(time.Second, func() { fired = append(fired, 0) })
+	utest.Assert(t, stopped.Stop())
+	timer := f.NewTimer(3 * time.Second)
+	ticker := f.NewTicker(time.Second)
+
+	f.Advance(1500 * time.Millisecond)
+	utest.EqualNow(t, fired, []int{1})
+	utest.EqualNow(t, f.Since(start), 1500*time.Millisecond)
+	<-ticker.C()
+
+	f.Advance(2 * time.Second)
+	utest.EqualNow(t, fired, []int{1, 2})
+	utest.EqualNow(t, (<-timer.C()).Sub(start), 3*time.Second)
+	<-ticker.C()
+
+	ticker.Stop()
+	utest.EqualNow(t, f.Waiters(), 0)
+
+	done := make(chan struct{})
+	go func() {
+		<-f.NewTimer(time.Minute).C()
+		close(done)
+	}()
+	f.BlockUntil(1)
+	f.Advance(time.Minute)
+	<-done
+}
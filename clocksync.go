@@ -0,0 +1,33 @@
+package link
+
+import "time"
+
+// SetClock records the estimated offset of the clock of the peer and the
+// round trip time, as measured by the timesync package.
+func (session *Session) SetClock(offset, rtt time.Duration) {
+	session.clockOffset.Store(int64(offset))
+	session.rtt.Store(int64(rtt))
+}
+
+// ClockOffset is how far the clock of the peer is ahead of ours, zero until
+// it was measured.
+func (session *Session) ClockOffset() time.Duration {
+	return time.Duration(session.clockOffset.Load())
+}
+
+// SetRTT records a round trip time to the peer, as measured by control pings
+// or metrics.Latency.
+func (session *Session) SetRTT(rtt time.Duration) {
+	session.rtt.Store(int64(rtt))
+}
+
+// RTT is the last round trip time measured to the peer, by timesync, control
+// pings or metrics.Latency, zero until it was measured.
+func (session *Session) RTT() time.Duration {
+	return time.Duration(session.rtt.Load())
+}
+
+// PeerTime converts a local time to the clock of the peer.
+func (session *Session) PeerTime(t time.Time) time.Time {
+	return t.Add(session.ClockOffset())
+}
@@ -0,0 +1,110 @@
+package codec
+
+import (
+	"bufio"
+	"io"
+
+	"github.com/funny/link"
+)
+
+// AdaptiveBufio is Bufio with a read buffer sized per session: it starts at
+// minRead, doubles up to maxRead while reads fill it completely and halves
+// again after a run of reads which used less than a quarter of it. Most
+// connections only send small messages, they keep a small buffer.
+func AdaptiveBufio(base link.Protocol, minRead, maxRead, writeBuf int) link.Protocol {
+	if minRead < 16 {
+		minRead = 16
+	}
+	if maxRead < minRead {
+		maxRead = minRead
+	}
+	return &adaptiveProtocol{base, minRead, maxRead, writeBuf}
+}
+
+type adaptiveProtocol struct {
+	base     link.Protocol
+	minRead  int
+	maxRead  int
+	writeBuf int
+}
+
+func (p *adaptiveProtocol) NewCodec(rw io.ReadWriter) (cc link.Codec, err error) {
+	codec := new(bufioCodec)
+	if p.writeBuf > 0 {
+		codec.stream.w = bufio.NewWriterSize(rw, p.writeBuf)
+		codec.stream.Writer = codec.stream.w
+	} else {
+		codec.stream.Writer = rw
+	}
+	codec.stream.Reader = &adaptiveReader{rd: rw, size: p.minRead, min: p.minRead, max: p.maxRead}
+	codec.stream.c, _ = rw.(io.Closer)
+
+	codec.base, err = p.base.NewCodec(&codec.stream)
+	if err != nil {
+		return
+	}
+	cc = codec
+	return
+}
+
+// shrink after this many small reads in a row.
+const adaptiveShrinkAfter = 16
+
+type adaptiveReader struct {
+	rd    io.Reader
+	buf   []byte
+	r, w  int
+	size  int // of the next buffer
+	min   int
+	max   int
+	small int
+}
+
+func (b *adaptiveReader) Read(p []byte) (int, error) {
+	if b.r == b.w {
+		if len(p) >= b.size {
+			n, err := b.rd.Read(p)
+			b.observe(n)
+			return n, err
+		}
+		if len(b.buf) != b.size {
+			b.buf = make([]byte, b.size)
+		}
+		n, err := b.rd.Read(b.buf)
+		b.observe(n)
+		b.r, b.w = 0, n
+		if n == 0 {
+			return 0, err
+		}
+	}
+	n := copy(p, b.buf[b.r:b.w])
+	b.r += n
+	return n, nil
+}
+
+func (b *adaptiveReader) Buffered() int {
+	return b.w - b.r
+}
+
+func (b *adaptiveReader) Peek(n int) ([]byte, error) {
+	if n > b.w-b.r {
+		return nil, bufio.ErrBufferFull
+	}
+	return b.buf[b.r : b.r+n], nil
+}
+
+func (b *adaptiveReader) observe(n int) {
+	switch {
+	case n >= b.size && b.size < b.max:
+		b.size = min(b.size*2, b.max)
+		b.small = 0
+	case n < b.size/4 && b.size > b.min:
+		b.small++
+		if b.small >= adaptiveShrinkAfter {
+			b.size = max(b.size/2, b.min)
+			b.small = 0
+		}
+	default:
+		b.small = 0
+	}
+}
@@ -0,0 +1,48 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+func Test_AdaptiveBufio(t *testing.T) {
+	JsonTest(t, AdaptiveBufio(FixLen(JsonTestProtocol(), 2, binary.LittleEndian, 64*1024, 64*1024), 16, 1024, 1024))
+}
+
+// chunkReader returns at most chunk bytes per read, like a socket.
+type chunkReader struct {
+	data  *bytes.Reader
+	chunk int
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if len(p) > r.chunk {
+		p = p[:r.chunk]
+	}
+	return r.data.Read(p)
+}
+
+func Test_AdaptiveReader(t *testing.T) {
+	source := &chunkReader{bytes.NewReader(make([]byte, 1<<20)), 1 << 20}
+	reader := &adaptiveReader{rd: source, size: 64, min: 64, max: 4096}
+	p := make([]byte, 8)
+
+	// bursts grow the buffer to the max.
+	for i := 0; i < 1000; i++ {
+		io.ReadFull(reader, p)
+	}
+	if reader.size != 4096 {
+		t.Fatalf("buffer not grown: %d", reader.size)
+	}
+
+	// small messages shrink it back.
+	source.chunk = 10
+	for i := 0; i < 10000; i++ {
+		io.ReadFull(reader, p)
+	}
+	if reader.size != 64 {
+		t.Fatalf("buffer not shrunk: %d", reader.size)
+	}
+}
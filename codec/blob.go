@@ -0,0 +1,285 @@
+package codec
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/funny/link"
+	"github.com/funny/link/internal/buffered"
+)
+
+var ErrUnknownMark = link.NewError(link.ProtocolError, "Unknown Blob Mark")
+
+const (
+	markMessage byte = iota
+	markBlob
+)
+
+// FileRegion sends Length bytes of File from Offset after the Header
+// message. When the session runs on a TCP connection the data goes out with
+// sendfile(2), without being copied through user space. The file offset is
+// moved, so the file must not be shared by concurrent sends.
+type FileRegion struct {
+	Header interface{}
+	File   *os.File
+	Offset int64
+	Length int64
+}
+
+// BlobMessage is a Header message followed by binary Data. Received blobs,
+// FileRegions too, are BlobMessages unless the protocol streams or spills
+// them.
+type BlobMessage struct {
+	Header interface{}
+	Data   []byte
+}
+
+// BlobStream is a Header message followed by Size bytes which are read from
+// the connection as the handler goes, nothing is buffered. The session
+// receives nothing else until the stream is read to its end or closed.
+// Received blobs are BlobStreams when BlobProtocol.Stream is set, and they
+// can be sent like BlobMessages.
+type BlobStream struct {
+	Header interface{}
+	Size   int64
+	mutex  sync.Mutex
+	r      io.LimitedReader
+	closed bool
+	done   chan struct{}
+}
+
+func NewBlobStream(header interface{}, r io.Reader, size int64) *BlobStream {
+	return &BlobStream{
+		Header: header,
+		Size:   size,
+		r:      io.LimitedReader{R: r, N: size},
+		done:   make(chan struct{}),
+	}
+}
+
+func (s *BlobStream) Read(p []byte) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return 0, io.ErrClosedPipe
+	}
+	n, err := s.r.Read(p)
+	if err == io.EOF {
+		if s.r.N > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		s.close()
+	}
+	return n, err
+}
+
+// Close skips the rest of the stream.
+func (s *BlobStream) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.close()
+	return nil
+}
+
+func (s *BlobStream) close() {
+	if !s.closed {
+		s.closed = true
+		close(s.done)
+	}
+}
+
+// SpilledBlob is a received blob larger than BlobProtocol.SpillSize, its
+// data was written to a temporary file instead of memory. Close removes the
+// file. It can be sent on like a FileRegion.
+type SpilledBlob struct {
+	Header interface{}
+	Size   int64
+	file   *os.File
+}
+
+func (b *SpilledBlob) ReadAt(p []byte, off int64) (int, error) {
+	return b.file.ReadAt(p, off)
+}
+
+func (b *SpilledBlob) Close() error {
+	err := b.file.Close()
+	if err2 := os.Remove(b.file.Name()); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// BlobProtocol lets messages carry raw binary data after them. Each frame
+// starts with a mark byte, blobs then have an 8 bytes length, the header
+// message of base and the data. It has to be the outermost protocol, on the
+// connection itself, for FileRegion to use sendfile.
+type BlobProtocol struct {
+	base    link.Protocol
+	MaxSize int64 // of received blobs, default 64MB
+	Stream  bool  // receive BlobStreams instead of BlobMessages
+
+	// SpillSize is the largest blob received into memory, larger ones are
+	// SpilledBlobs in SpillDir, or the default temporary directory. Zero
+	// means no limit.
+	SpillSize int64
+	SpillDir  string
+}
+
+func Blob(base link.Protocol) *BlobProtocol {
+	return &BlobProtocol{base: base, MaxSize: 64 * 1024 * 1024}
+}
+
+func (p *BlobProtocol) NewCodec(rw io.ReadWriter) (cc link.Codec, err error) {
+	codec := &blobCodec{p: p, rw: rw, closed: make(chan struct{})}
+	codec.baseRW.RW = rw
+	codec.base, err = p.base.NewCodec(&codec.baseRW)
+	if err != nil {
+		return
+	}
+	cc = codec
+	return
+}
+
+type blobCodec struct {
+	p      *BlobProtocol
+	base   link.Codec
+	baseRW buffered.Writer
+	rw     io.ReadWriter
+	head   [9]byte
+	stream *BlobStream
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (c *blobCodec) Receive() (interface{}, error) {
+	if s := c.stream; s != nil {
+		c.stream = nil
+		select {
+		case <-s.done:
+		case <-c.closed:
+			return nil, io.ErrClosedPipe
+		}
+		if _, err := io.CopyN(io.Discard, c.rw, s.r.N); err != nil {
+			return nil, err
+		}
+	}
+	mark := c.head[:1]
+	if _, err := io.ReadFull(c.rw, mark); err != nil {
+		return nil, err
+	}
+	switch mark[0] {
+	case markMessage:
+		return c.base.Receive()
+	case markBlob:
+	default:
+		return nil, ErrUnknownMark
+	}
+	n := c.head[1:9]
+	if _, err := io.ReadFull(c.rw, n); err != nil {
+		return nil, err
+	}
+	size := int64(binary.LittleEndian.Uint64(n))
+	if size < 0 || size > c.p.MaxSize {
+		return nil, ErrTooLargePacket
+	}
+	header, err := c.base.Receive()
+	if err != nil {
+		return nil, err
+	}
+	if c.p.Stream {
+		c.stream = NewBlobStream(header, c.rw, size)
+		return c.stream, nil
+	}
+	if c.p.SpillSize > 0 && size > c.p.SpillSize {
+		return c.spill(header, size)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(c.rw, data); err != nil {
+		return nil, err
+	}
+	return &BlobMessage{header, data}, nil
+}
+
+func (c *blobCodec) spill(header interface{}, size int64) (*SpilledBlob, error) {
+	file, err := os.CreateTemp(c.p.SpillDir, "link-blob-*")
+	if err != nil {
+		return nil, err
+	}
+	blob := &SpilledBlob{header, size, file}
+	if _, err := io.CopyN(file, c.rw, size); err != nil {
+		blob.Close()
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return blob, nil
+}
+
+func (c *blobCodec) Send(msg interface{}) error {
+	var header interface{}
+	var size int64
+	switch m := msg.(type) {
+	case link.Encoded:
+		_, err := c.rw.Write(m)
+		return err
+	case *FileRegion:
+		header, size = m.Header, m.Length
+	case *BlobMessage:
+		header, size = m.Header, int64(len(m.Data))
+	case *BlobStream:
+		header, size = m.Header, m.Size
+	case *SpilledBlob:
+		header, size = m.Header, m.Size
+		msg = &FileRegion{m.Header, m.file, 0, m.Size}
+	default:
+		c.baseRW.Buf.Reset()
+		c.baseRW.Buf.WriteByte(markMessage)
+		if err := c.base.Send(msg); err != nil {
+			return err
+		}
+		_, err := c.rw.Write(c.baseRW.Buf.Bytes())
+		return err
+	}
+	c.head[0] = markBlob
+	binary.LittleEndian.PutUint64(c.head[1:9], uint64(size))
+	c.baseRW.Buf.Reset()
+	c.baseRW.Buf.Write(c.head[:])
+	if err := c.base.Send(header); err != nil {
+		return err
+	}
+	if _, err := c.rw.Write(c.baseRW.Buf.Bytes()); err != nil {
+		return err
+	}
+	switch m := msg.(type) {
+	case *BlobMessage:
+		_, err := c.rw.Write(m.Data)
+		return err
+	case *BlobStream:
+		// the peer expects Size bytes, a short stream breaks the connection.
+		_, err := io.CopyN(c.rw, m, m.Size)
+		m.Close()
+		return err
+	}
+	return c.sendFile(msg.(*FileRegion))
+}
+
+func (c *blobCodec) sendFile(region *FileRegion) error {
+	if _, err := region.File.Seek(region.Offset, io.SeekStart); err != nil {
+		return err
+	}
+	// io.Copy ends up in TCPConn.ReadFrom, which uses sendfile for a limited
+	// *os.File.
+	n, err := io.Copy(c.rw, io.LimitReader(region.File, region.Length))
+	if err == nil && n < region.Length {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func (c *blobCodec) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return c.base.Close()
+}
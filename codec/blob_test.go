@@ -0,0 +1,173 @@
+package codec
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_FileRegion(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 10000)
+	path := filepath.Join(t.TempDir(), "asset")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	protocol := Blob(Framed(JsonTestProtocol()))
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		codec, _ := protocol.NewCodec(conn)
+		codec.Send(&MyMessage1{"before", 1})
+		codec.Send(&FileRegion{&MyMessage2{2, "asset"}, file, 5, 50000})
+		codec.Send(&MyMessage1{"after", 3})
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	codec, _ := protocol.NewCodec(conn)
+	defer codec.Close()
+	msg, err := codec.Receive()
+	if err != nil || msg.(*MyMessage1).Field1 != "before" {
+		t.Fatal(msg, err)
+	}
+	msg, err = codec.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob := msg.(*BlobMessage)
+	if *blob.Header.(*MyMessage2) != (MyMessage2{2, "asset"}) {
+		t.Fatalf("header not match: %v", blob.Header)
+	}
+	if !bytes.Equal(blob.Data, data[5:50005]) {
+		t.Fatalf("data not match, %d bytes", len(blob.Data))
+	}
+	msg, err = codec.Receive()
+	if err != nil || msg.(*MyMessage1).Field1 != "after" {
+		t.Fatal(msg, err)
+	}
+}
+
+func Test_BlobStream(t *testing.T) {
+	var stream bytes.Buffer
+	protocol := Blob(Framed(JsonTestProtocol()))
+	protocol.Stream = true
+	codec, _ := protocol.NewCodec(&stream)
+	data := bytes.Repeat([]byte("x"), 1000)
+	codec.Send(&BlobMessage{&MyMessage1{"first", 1}, data})
+	codec.Send(NewBlobStream(&MyMessage1{"second", 2}, bytes.NewReader(data), 500))
+	codec.Send(&MyMessage1{"after", 3})
+
+	msg, err := codec.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := msg.(*BlobStream)
+	if first.Size != 1000 || first.Header.(*MyMessage1).Field1 != "first" {
+		t.Fatalf("stream not match: %v", first)
+	}
+	b := make([]byte, 10)
+	if _, err := io.ReadFull(first, b); err != nil {
+		t.Fatal(err)
+	}
+	// the rest is skipped after Close.
+	first.Close()
+
+	msg, err = codec.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := msg.(*BlobStream)
+	got, err := io.ReadAll(second)
+	if err != nil || !bytes.Equal(got, data[:500]) {
+		t.Fatalf("stream data not match: %d bytes, %v", len(got), err)
+	}
+
+	msg, err = codec.Receive()
+	if err != nil || msg.(*MyMessage1).Field1 != "after" {
+		t.Fatal(msg, err)
+	}
+}
+
+func Test_SpilledBlob(t *testing.T) {
+	var stream bytes.Buffer
+	protocol := Blob(Framed(JsonTestProtocol()))
+	protocol.SpillSize = 100
+	protocol.SpillDir = t.TempDir()
+	codec, _ := protocol.NewCodec(&stream)
+	data := bytes.Repeat([]byte("0123456789"), 100)
+	codec.Send(&BlobMessage{&MyMessage1{"small", 1}, data[:100]})
+	codec.Send(&BlobMessage{&MyMessage1{"large", 2}, data})
+
+	msg, err := codec.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(msg.(*BlobMessage).Data, data[:100]) {
+		t.Fatal("small blob not in memory")
+	}
+	msg, err = codec.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob := msg.(*SpilledBlob)
+	got := make([]byte, blob.Size)
+	if _, err := blob.ReadAt(got, 0); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("spilled data not match: %v", err)
+	}
+
+	// sent on, the file goes out as a file region.
+	if err := codec.Send(blob); err != nil {
+		t.Fatal(err)
+	}
+	blob.Close()
+	if files, _ := os.ReadDir(protocol.SpillDir); len(files) != 0 {
+		t.Fatalf("temporary files left: %v", files)
+	}
+	msg, err = codec.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg.(*SpilledBlob).Close()
+}
+
+func Test_BlobSendFailure(t *testing.T) {
+	var stream bytes.Buffer
+	codec, err := Blob(JsonTestProtocol()).NewCodec(&stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := codec.Send(&struct{ C chan int }{}); err == nil {
+		t.Fatal("expected an encode error")
+	}
+	if stream.Len() != 0 {
+		t.Fatalf("failed send left %d bytes", stream.Len())
+	}
+	if err := codec.Send(&MyMessage1{"abc", 123}); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := codec.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *(msg.(*MyMessage1)) != (MyMessage1{"abc", 123}) {
+		t.Fatalf("message not match: %v", msg)
+	}
+}
@@ -54,6 +54,21 @@ type bufioStream struct {
 	w *bufio.Writer
 }
 
+// Buffered and Peek let FixLen see whole frames in the read buffer.
+func (s *bufioStream) Buffered() int {
+	if r, ok := s.Reader.(bufferedReader); ok {
+		return r.Buffered()
+	}
+	return 0
+}
+
+func (s *bufioStream) Peek(n int) ([]byte, error) {
+	if r, ok := s.Reader.(bufferedReader); ok {
+		return r.Peek(n)
+	}
+	return nil, bufio.ErrBufferFull
+}
+
 func (s *bufioStream) Flush() error {
 	if s.w != nil {
 		return s.w.Flush()
@@ -84,6 +99,10 @@ func (c *bufioCodec) Receive() (interface{}, error) {
 	return c.base.Receive()
 }
 
+func (c *bufioCodec) ReceiveBatch(msgs []interface{}, max int) ([]interface{}, error) {
+	return link.ReceiveBatch(c.base, msgs, max)
+}
+
 func (c *bufioCodec) Close() error {
 	err1 := c.base.Close()
 	err2 := c.stream.close()
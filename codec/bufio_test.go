@@ -1,10 +1,44 @@
 package codec
 
 import (
+	"bytes"
 	"encoding/binary"
+	"io"
 	"testing"
+
+	"github.com/funny/link"
 )
 
 func Test_Bufio(t *testing.T) {
 	JsonTest(t, Bufio(FixLen(JsonTestProtocol(), 2, binary.LittleEndian, 64*1024, 64*1024), 1024, 1024))
 }
+
+func Test_ReceiveBatch(t *testing.T) {
+	var stream bytes.Buffer
+	codec, _ := Framed(JsonTestProtocol(), WithBufio(1024)).NewCodec(&stream)
+	for i := 0; i < 5; i++ {
+		if err := codec.Send(&MyMessage1{Field2: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var received []interface{}
+	for _, want := range []int{3, 2} {
+		msgs, err := link.ReceiveBatch(codec, nil, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(msgs) != want {
+			t.Fatalf("batch of %d messages, want %d", len(msgs), want)
+		}
+		received = append(received, msgs...)
+	}
+	for i, msg := range received {
+		if msg.(*MyMessage1).Field2 != i {
+			t.Fatalf("message %d out of order: %v", i, msg)
+		}
+	}
+	if _, err := link.ReceiveBatch(codec, nil, 3); err != io.EOF {
+		t.Fatalf("error %v, want EOF", err)
+	}
+}
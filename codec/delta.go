@@ -0,0 +1,189 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"reflect"
+
+	"github.com/funny/link"
+)
+
+var ErrBadDelta = link.NewError(link.ProtocolError, "Bad Delta Frame")
+
+const (
+	deltaFull byte = iota
+	deltaXor
+)
+
+const (
+	maxDeltaSlots = 255
+	noDeltaSlot   = 0xFF
+)
+
+type DeltaProtocol struct {
+	base link.Protocol
+}
+
+// Delta encodes a message of base against the previous message of the same
+// Go type sent on the session: the XOR of both, with the runs of zero bytes
+// left out, goes out when it is shorter, so repetitive tick updates shrink
+// to the bytes which changed. It expects one frame per read, so it sits
+// inside a framing protocol such as FixLen. The frames depend on the ones
+// before, link.Encoded broadcasts can't go through it.
+func Delta(base link.Protocol) *DeltaProtocol {
+	return &DeltaProtocol{base}
+}
+
+func (p *DeltaProtocol) NewCodec(rw io.ReadWriter) (link.Codec, error) {
+	c := &deltaCodec{rw: rw, slots: make(map[reflect.Type]byte)}
+	var err error
+	if c.base, err = p.base.NewCodec(&c.plain); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+type deltaCodec struct {
+	base  link.Codec
+	rw    io.ReadWriter
+	plain plainRW
+
+	slots    map[reflect.Type]byte
+	sendPrev [][]byte
+	recvPrev [maxDeltaSlots][]byte
+	frame    []byte
+}
+
+// plainRW hands the base codec the plaintext of one frame.
+type plainRW struct {
+	recv bytes.Reader
+	send bytes.Buffer
+}
+
+func (rw *plainRW) Read(p []byte) (int, error)  { return rw.recv.Read(p) }
+func (rw *plainRW) Write(p []byte) (int, error) { return rw.send.Write(p) }
+
+func (c *deltaCodec) Send(msg interface{}) error {
+	c.plain.send.Reset()
+	if err := c.base.Send(msg); err != nil {
+		return err
+	}
+	data := c.plain.send.Bytes()
+
+	t := reflect.TypeOf(msg)
+	slot, exists := c.slots[t]
+	if !exists && len(c.sendPrev) < maxDeltaSlots {
+		slot = byte(len(c.sendPrev))
+		c.slots[t] = slot
+		c.sendPrev = append(c.sendPrev, nil)
+		exists = true
+	}
+	if !exists {
+		slot = noDeltaSlot
+	}
+
+	frame := append(c.frame[:0], slot, deltaFull)
+	if exists && c.sendPrev[slot] != nil {
+		frame[1] = deltaXor
+		frame = appendXor(frame, c.sendPrev[slot], data)
+		if len(frame) >= 2+len(data) {
+			frame = append(frame[:1], deltaFull)
+		}
+	}
+	if frame[1] == deltaFull {
+		frame = append(frame, data...)
+	}
+	c.frame = frame
+	if exists {
+		c.sendPrev[slot] = append(c.sendPrev[slot][:0], data...)
+	}
+	_, err := c.rw.Write(frame)
+	return err
+}
+
+// appendXor appends the XOR of prev and data as pairs of a zero run and a
+// literal, the length of data first, data beyond prev is XORed with zeros.
+func appendXor(frame, prev, data []byte) []byte {
+	frame = binary.AppendUvarint(frame, uint64(len(data)))
+	for i := 0; i < len(data); {
+		zeros := i
+		for i < len(data) && i < len(prev) && data[i] == prev[i] {
+			i++
+		}
+		literal := i
+		for i < len(data) && (i >= len(prev) || data[i] != prev[i]) {
+			i++
+		}
+		frame = binary.AppendUvarint(frame, uint64(literal-zeros))
+		frame = binary.AppendUvarint(frame, uint64(i-literal))
+		for j := literal; j < i; j++ {
+			b := data[j]
+			if j < len(prev) {
+				b ^= prev[j]
+			}
+			frame = append(frame, b)
+		}
+	}
+	return frame
+}
+
+func applyXor(prev, delta []byte) ([]byte, error) {
+	r := bytes.NewReader(delta)
+	size, err := binary.ReadUvarint(r)
+	if err != nil || size > uint64(len(delta)+len(prev)) {
+		return nil, ErrBadDelta
+	}
+	data := make([]byte, 0, size)
+	for uint64(len(data)) < size {
+		zeros, err1 := binary.ReadUvarint(r)
+		n, err2 := binary.ReadUvarint(r)
+		if err1 != nil || err2 != nil || uint64(len(data))+zeros+n > size || uint64(len(data))+zeros > uint64(len(prev)) {
+			return nil, ErrBadDelta
+		}
+		data = append(data, prev[len(data):len(data)+int(zeros)]...)
+		for ; n > 0; n-- {
+			b, err := r.ReadByte()
+			if err != nil {
+				return nil, ErrBadDelta
+			}
+			if len(data) < len(prev) {
+				b ^= prev[len(data)]
+			}
+			data = append(data, b)
+		}
+	}
+	return data, nil
+}
+
+func (c *deltaCodec) Receive() (interface{}, error) {
+	frame, err := io.ReadAll(c.rw)
+	if err != nil {
+		return nil, err
+	}
+	if len(frame) < 2 {
+		return nil, ErrBadDelta
+	}
+	slot, data := frame[0], frame[2:]
+	switch frame[1] {
+	case deltaFull:
+	case deltaXor:
+		if slot == noDeltaSlot || c.recvPrev[slot] == nil {
+			return nil, ErrBadDelta
+		}
+		if data, err = applyXor(c.recvPrev[slot], data); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, ErrBadDelta
+	}
+	if slot != noDeltaSlot {
+		c.recvPrev[slot] = append(c.recvPrev[slot][:0], data...)
+	}
+	c.plain.recv.Reset(data)
+	return c.base.Receive()
+}
+
+func (c *deltaCodec) Close() error {
+	return c.base.Close()
+}
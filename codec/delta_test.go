@@ -0,0 +1,68 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func Test_Delta(t *testing.T) {
+	JsonTest(t, FixLen(Delta(JsonTestProtocol()), 2, binary.LittleEndian, 1024, 1024))
+}
+
+func Test_DeltaShrinks(t *testing.T) {
+	protocol := FixLen(Delta(JsonTestProtocol()), 2, binary.LittleEndian, 1024, 1024)
+	var stream bytes.Buffer
+	sender, _ := protocol.NewCodec(&stream)
+	receiver, _ := protocol.NewCodec(&stream)
+
+	var sizes []int
+	msgs := []interface{}{
+		&MyMessage1{"position update of a player", 1000},
+		&MyMessage1{"position update of a player", 1001},
+		&MyMessage2{7, "other type"},
+		&MyMessage1{"position update of a player", 1002},
+		&MyMessage1{"short", 3},
+		&MyMessage1{"a much longer text than the one before", 3},
+	}
+	for _, msg := range msgs {
+		before := stream.Len()
+		if err := sender.Send(msg); err != nil {
+			t.Fatal(err)
+		}
+		sizes = append(sizes, stream.Len()-before)
+	}
+	for i, want := range msgs {
+		msg, err := receiver.Receive()
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch want := want.(type) {
+		case *MyMessage1:
+			if *msg.(*MyMessage1) != *want {
+				t.Fatalf("message %d not match: %v", i, msg)
+			}
+		case *MyMessage2:
+			if *msg.(*MyMessage2) != *want {
+				t.Fatalf("message %d not match: %v", i, msg)
+			}
+		}
+	}
+	// the repeated updates only carry the changed digit.
+	if sizes[1] >= sizes[0]/4 || sizes[3] >= sizes[0]/4 {
+		t.Fatalf("sizes not shrunk: %v", sizes)
+	}
+}
+
+func Test_BadDelta(t *testing.T) {
+	if _, err := applyXor([]byte("abc"), []byte{200, 1}); err != ErrBadDelta {
+		t.Fatalf("error not match: %v", err)
+	}
+	if _, err := applyXor([]byte("abc"), []byte{4, 5, 0}); err != ErrBadDelta {
+		t.Fatalf("error not match: %v", err)
+	}
+	data, err := applyXor([]byte("abc"), appendXor(nil, []byte("abc"), []byte("abd!")))
+	if err != nil || string(data) != "abd!" {
+		t.Fatalf("data not match: %q %v", data, err)
+	}
+}
@@ -0,0 +1,160 @@
+package codec
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"github.com/funny/link"
+)
+
+var ErrNoProtocol = link.NewError(link.ProtocolError, "No Protocol Matches Connection")
+
+// Matcher looks at the first bytes of a connection. It returns decided false
+// when it needs more bytes to tell, at the end of the stream it has to
+// decide with what it got.
+type Matcher func(head []byte, eof bool) (matched, decided bool)
+
+// Prefix matches connections starting with one of prefixes.
+func Prefix(prefixes ...string) Matcher {
+	return func(head []byte, eof bool) (bool, bool) {
+		undecided := false
+		for _, prefix := range prefixes {
+			if len(head) >= len(prefix) {
+				if string(head[:len(prefix)]) == prefix {
+					return true, true
+				}
+			} else if prefix[:len(head)] == string(head) {
+				undecided = true
+			}
+		}
+		return false, !undecided || eof
+	}
+}
+
+// HTTP matches HTTP/1 requests by their method.
+func HTTP() Matcher {
+	return Prefix("GET ", "POST ", "PUT ", "HEAD ", "DELETE ", "OPTIONS ", "PATCH ", "CONNECT ", "TRACE ")
+}
+
+// JSONText matches connections whose first byte after white space opens a
+// JSON object or array, like newline delimited JSON.
+func JSONText() Matcher {
+	return func(head []byte, eof bool) (bool, bool) {
+		head = bytes.TrimLeft(head, " \t\r\n")
+		if len(head) == 0 {
+			return false, eof
+		}
+		return head[0] == '{' || head[0] == '[', true
+	}
+}
+
+// Detector is a protocol which picks one of its registered protocols by the
+// first bytes the client sends, so clients of different protocols can share
+// a port. The routes are tried in the order they were registered, Default,
+// when set, takes the connections none of them match. The sniffed bytes are
+// read again by the picked protocol. Protocols in which the server speaks
+// first can't be detected, their clients send nothing until they hear from
+// the server.
+type Detector struct {
+	routes []detectRoute
+
+	Default link.Protocol
+	MaxPeek int           // bytes read before giving up, default 64
+	Timeout time.Duration // for the first bytes on a net.Conn, default 10s
+}
+
+type detectRoute struct {
+	match    Matcher
+	protocol link.Protocol
+}
+
+func Detect() *Detector {
+	return &Detector{MaxPeek: 64, Timeout: 10 * time.Second}
+}
+
+// Register adds a protocol for the connections match takes.
+func (d *Detector) Register(match Matcher, protocol link.Protocol) *Detector {
+	d.routes = append(d.routes, detectRoute{match, protocol})
+	return d
+}
+
+func (d *Detector) NewCodec(rw io.ReadWriter) (link.Codec, error) {
+	conn, isConn := rw.(net.Conn)
+	if isConn && d.Timeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(d.Timeout))
+	}
+	head, protocol, err := d.sniff(rw)
+	if isConn && d.Timeout > 0 {
+		conn.SetReadDeadline(time.Time{})
+	}
+	if err != nil {
+		return nil, err
+	}
+	replay := io.MultiReader(bytes.NewReader(head), rw)
+	if isConn {
+		return protocol.NewCodec(&sniffedConn{conn, replay})
+	}
+	closer, _ := rw.(io.Closer)
+	return protocol.NewCodec(&sniffedStream{replay, rw, closer})
+}
+
+func (d *Detector) sniff(rw io.Reader) ([]byte, link.Protocol, error) {
+	head := make([]byte, 0, d.MaxPeek)
+	eof := false
+	for {
+		if protocol, decided := d.pick(head, eof || len(head) == cap(head)); decided {
+			if protocol == nil {
+				return head, nil, ErrNoProtocol
+			}
+			return head, protocol, nil
+		}
+		n, err := rw.Read(head[len(head):cap(head)])
+		head = head[:len(head)+n]
+		if errors.Is(err, io.EOF) {
+			eof = true
+		} else if err != nil {
+			return nil, nil, err
+		}
+	}
+}
+
+// pick returns the protocol of the first route which matches, once all the
+// routes before it are decided.
+func (d *Detector) pick(head []byte, eof bool) (link.Protocol, bool) {
+	for _, route := range d.routes {
+		matched, decided := route.match(head, eof)
+		if !decided && !eof {
+			return nil, false
+		}
+		if matched {
+			return route.protocol, true
+		}
+	}
+	return d.Default, true
+}
+
+type sniffedStream struct {
+	io.Reader
+	io.Writer
+	c io.Closer
+}
+
+func (s *sniffedStream) Close() error {
+	if s.c != nil {
+		return s.c.Close()
+	}
+	return nil
+}
+
+// sniffedConn keeps the deadlines and addresses of the connection.
+type sniffedConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *sniffedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
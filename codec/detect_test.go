@@ -0,0 +1,105 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/funny/link"
+)
+
+func detectTestProtocol(text, framed *int) *Detector {
+	count := func(n *int, p link.Protocol) link.Protocol {
+		return link.ProtocolFunc(func(rw io.ReadWriter) (link.Codec, error) {
+			*n++
+			return p.NewCodec(rw)
+		})
+	}
+	d := Detect()
+	d.Register(HTTP(), link.ProtocolFunc(func(rw io.ReadWriter) (link.Codec, error) {
+		return nil, errors.New("http")
+	}))
+	d.Register(JSONText(), count(text, JsonTestProtocol()))
+	d.Default = count(framed, FixLen(JsonTestProtocol(), 2, binary.LittleEndian, 1024, 1024))
+	return d
+}
+
+// sniff sends msg with protocol and receives it through the detector.
+func sniff(t *testing.T, d *Detector, protocol link.Protocol) (interface{}, error) {
+	var stream bytes.Buffer
+	sender, _ := protocol.NewCodec(&stream)
+	if err := sender.Send(&MyMessage1{"abc", 1}); err != nil {
+		t.Fatal(err)
+	}
+	// one byte per read, the detector has to wait for enough of them.
+	codec, err := d.NewCodec(struct {
+		io.Reader
+		io.Writer
+	}{&oneByteReader{&stream}, io.Discard})
+	if err != nil {
+		return nil, err
+	}
+	return codec.Receive()
+}
+
+type oneByteReader struct {
+	r io.Reader
+}
+
+func (r *oneByteReader) Read(p []byte) (int, error) {
+	return r.r.Read(p[:1])
+}
+
+func Test_Detect(t *testing.T) {
+	var text, framed int
+	d := detectTestProtocol(&text, &framed)
+
+	msg, err := sniff(t, d, JsonTestProtocol())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *msg.(*MyMessage1) != (MyMessage1{"abc", 1}) || text != 1 {
+		t.Fatal("text not detected")
+	}
+	msg, err = sniff(t, d, FixLen(JsonTestProtocol(), 2, binary.LittleEndian, 1024, 1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *msg.(*MyMessage1) != (MyMessage1{"abc", 1}) || framed != 1 {
+		t.Fatal("framed not detected")
+	}
+
+	codec, err := d.NewCodec(bytes.NewBufferString("GET / HTTP/1.1\r\n\r\n"))
+	if err == nil || err.Error() != "http" || codec != nil {
+		t.Fatal("http not detected")
+	}
+
+	d.Default = nil
+	if _, err := d.NewCodec(bytes.NewBufferString("\x00\x01")); !errors.Is(err, ErrNoProtocol) {
+		t.Fatal(err)
+	}
+}
+
+func Test_Prefix(t *testing.T) {
+	match := Prefix("GET ", "GO")
+	for _, c := range []struct {
+		head             string
+		eof              bool
+		matched, decided bool
+	}{
+		{"", false, false, false},
+		{"G", false, false, false},
+		{"GO", false, true, true},
+		{"GE", false, false, false},
+		{"GE", true, false, true},
+		{"GET /", false, true, true},
+		{"X", false, false, true},
+	} {
+		matched, decided := match([]byte(c.head), c.eof)
+		if matched != c.matched || decided != c.decided {
+			t.Fatal(c.head, matched, decided)
+		}
+	}
+}
@@ -3,20 +3,21 @@ package codec
 import (
 	"bytes"
 	"encoding/binary"
-	"errors"
 	"io"
 	"math"
+	"sync/atomic"
 
 	"github.com/funny/link"
 )
 
-var ErrTooLargePacket = errors.New("Too Large Packet")
+var ErrTooLargePacket = link.NewError(link.ProtocolError, "Too Large Packet")
 
 type FixLenProtocol struct {
 	base        link.Protocol
 	n           int
 	maxRecv     int
 	maxSend     int
+	liveRecv    atomic.Int64 // set by Follow, 0 is maxRecv
 	headDecoder func([]byte) int
 	headEncoder func([]byte, int)
 }
@@ -81,6 +82,25 @@ func FixLen(base link.Protocol, n int, byteOrder binary.ByteOrder, maxRecv, maxS
 	return proto
 }
 
+// Follow makes MaxPacket of live the receive limit, a zero MaxPacket is
+// the limit given to FixLen. Changes apply to existing codecs too.
+func (p *FixLenProtocol) Follow(live *link.LiveConfig) {
+	live.OnChange(func(config *link.Config) {
+		n := config.MaxPacket
+		if n > 0 && p.n < 8 {
+			n = min(n, 1<<(8*p.n)-1)
+		}
+		p.liveRecv.Store(int64(n))
+	})
+}
+
+func (p *FixLenProtocol) recvLimit() int {
+	if n := p.liveRecv.Load(); n > 0 {
+		return int(n)
+	}
+	return p.maxRecv
+}
+
 func (p *FixLenProtocol) NewCodec(rw io.ReadWriter) (cc link.Codec, err error) {
 	codec := &fixlenCodec{
 		rw:             rw,
@@ -113,6 +133,9 @@ type fixlenCodec struct {
 	base    link.Codec
 	head    [8]byte
 	headBuf []byte
+	// sendPad holds the place of the head of a frame being sent, headBuf is
+	// for Receive, the two run at once on a session.
+	sendPad [8]byte
 	bodyBuf []byte
 	rw      io.ReadWriter
 	*FixLenProtocol
@@ -124,7 +147,7 @@ func (c *fixlenCodec) Receive() (interface{}, error) {
 		return nil, err
 	}
 	size := c.headDecoder(c.headBuf)
-	if size > c.maxRecv {
+	if size < 0 || size > c.recvLimit() {
 		return nil, ErrTooLargePacket
 	}
 	if cap(c.bodyBuf) < size {
@@ -139,14 +162,57 @@ func (c *fixlenCodec) Receive() (interface{}, error) {
 	return msg, err
 }
 
+type bufferedReader interface {
+	Buffered() int
+	Peek(n int) ([]byte, error)
+}
+
+// ReceiveBatch goes on with the frames which are complete in the read buffer
+// of a Bufio stream, so bursts are decoded without a read per message.
+func (c *fixlenCodec) ReceiveBatch(msgs []interface{}, max int) ([]interface{}, error) {
+	msg, err := c.Receive()
+	if err != nil {
+		return msgs, err
+	}
+	msgs = append(msgs, msg)
+	r, ok := c.rw.(bufferedReader)
+	if !ok {
+		return msgs, nil
+	}
+	for n := 1; n < max && r.Buffered() >= c.n; n++ {
+		head, err := r.Peek(c.n)
+		if err != nil {
+			break
+		}
+		// a bad size is left to Receive to report.
+		size := c.headDecoder(head)
+		if size >= 0 && size <= c.recvLimit() && r.Buffered() < c.n+size {
+			break
+		}
+		msg, err := c.Receive()
+		if err != nil {
+			return msgs, err
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
+
 func (c *fixlenCodec) Send(msg interface{}) error {
+	if encoded, ok := msg.(link.Encoded); ok {
+		_, err := c.rw.Write(encoded)
+		return err
+	}
 	c.sendBuf.Reset()
-	c.sendBuf.Write(c.headBuf)
+	c.sendBuf.Write(c.sendPad[:c.n])
 	err := c.base.Send(msg)
 	if err != nil {
 		return err
 	}
 	buff := c.sendBuf.Bytes()
+	if len(buff)-c.n > c.maxSend {
+		return ErrTooLargePacket
+	}
 	c.headEncoder(buff, len(buff)-c.n)
 	_, err = c.rw.Write(buff)
 	return err
@@ -1,8 +1,12 @@
 package codec
 
 import (
+	"bytes"
 	"encoding/binary"
+	"fmt"
 	"testing"
+
+	"github.com/funny/link"
 )
 
 func Test_FixLen(t *testing.T) {
@@ -10,3 +14,94 @@ func Test_FixLen(t *testing.T) {
 	protocol := FixLen(base, 2, binary.LittleEndian, 1024, 1024)
 	JsonTest(t, protocol)
 }
+
+func Test_FixLenEncoded(t *testing.T) {
+	protocol := Bufio(FixLen(JsonTestProtocol(), 2, binary.LittleEndian, 1024, 1024), 1024, 1024)
+	broadcaster, err := link.NewBroadcaster(protocol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := broadcaster.Encode(&MyMessage1{"abc", 123})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stream bytes.Buffer
+	codec, _ := protocol.NewCodec(&stream)
+	if err := codec.Send(encoded); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := codec.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *(msg.(*MyMessage1)) != (MyMessage1{"abc", 123}) {
+		t.Fatalf("message not match: %v", msg)
+	}
+}
+
+func Test_FixLenFollow(t *testing.T) {
+	protocol := FixLen(JsonTestProtocol(), 2, binary.LittleEndian, 1024, 1024)
+	live := link.NewLiveConfig(link.Config{MaxPacket: 8})
+	protocol.Follow(live)
+
+	var stream bytes.Buffer
+	codec, _ := protocol.NewCodec(&stream)
+	if err := codec.Send(&MyMessage1{"abc", 123}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := codec.Receive(); err != ErrTooLargePacket {
+		t.Fatalf("expected too large packet, got %v", err)
+	}
+
+	live.Update(func(config *link.Config) { config.MaxPacket = 0 })
+	stream.Reset()
+	if err := codec.Send(&MyMessage1{"abc", 123}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := codec.Receive(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Test_FixLenDuplex sends and receives on one codec at once, run it with
+// -race.
+func Test_FixLenDuplex(t *testing.T) {
+	protocol := FixLen(JsonTestProtocol(), 2, binary.LittleEndian, 1024, 1024)
+	conn1, conn2 := link.PipeConn()
+	codec1, _ := protocol.NewCodec(conn1)
+	codec2, _ := protocol.NewCodec(conn2)
+
+	const n = 100
+	errs := make(chan error, 4)
+	for _, c := range []link.Codec{codec1, codec2} {
+		go func(c link.Codec) {
+			for i := 0; i < n; i++ {
+				if err := c.Send(&MyMessage1{"abc", i}); err != nil {
+					errs <- err
+					return
+				}
+			}
+			errs <- nil
+		}(c)
+		go func(c link.Codec) {
+			for i := 0; i < n; i++ {
+				msg, err := c.Receive()
+				if err != nil {
+					errs <- err
+					return
+				}
+				if *(msg.(*MyMessage1)) != (MyMessage1{"abc", i}) {
+					errs <- fmt.Errorf("message not match: %v", msg)
+					return
+				}
+			}
+			errs <- nil
+		}(c)
+	}
+	for i := 0; i < 4; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
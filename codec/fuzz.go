@@ -0,0 +1,38 @@
+package codec
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/funny/link"
+)
+
+// FuzzDecode feeds data to a codec of protocol and receives until the input
+// is used up or a message fails to decode. It returns the number of decoded
+// messages. Decode errors are expected on random input, only panics are bugs,
+// so FuzzDecode is meant to be called from fuzz targets.
+func FuzzDecode(protocol link.Protocol, data []byte) (int, error) {
+	codec, err := protocol.NewCodec(&fuzzReadWriter{bytes.NewReader(data)})
+	if err != nil {
+		return 0, err
+	}
+	defer codec.Close()
+	// every message consumes at least one byte, more means the codec is stuck.
+	for n := 0; n <= len(data); n++ {
+		if _, err := codec.Receive(); err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return n, err
+		}
+	}
+	return len(data) + 1, io.ErrNoProgress
+}
+
+type fuzzReadWriter struct {
+	*bytes.Reader
+}
+
+func (rw *fuzzReadWriter) Write(p []byte) (int, error) {
+	return len(p), nil
+}
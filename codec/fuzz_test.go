@@ -0,0 +1,57 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/funny/link"
+)
+
+func fuzzSeed(protocol link.Protocol) []byte {
+	var stream bytes.Buffer
+	codec, _ := protocol.NewCodec(&stream)
+	codec.Send(&MyMessage1{"abc", 123})
+	codec.Send(&MyMessage2{456, "def"})
+	return stream.Bytes()
+}
+
+func FuzzJson(f *testing.F) {
+	f.Add(fuzzSeed(JsonTestProtocol()))
+	f.Add([]byte(`{"Head":"unknown","Body":null}`))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		FuzzDecode(JsonTestProtocol(), data)
+	})
+}
+
+func FuzzFixLen(f *testing.F) {
+	for _, n := range []int{1, 2, 4, 8} {
+		f.Add(n, fuzzSeed(FixLen(JsonTestProtocol(), n, binary.LittleEndian, 1024, 1024)))
+	}
+	f.Add(8, []byte("\xff\xff\xff\xff\xff\xff\xff\xff"))
+	f.Fuzz(func(t *testing.T, n int, data []byte) {
+		switch n {
+		case 1, 2, 4, 8:
+		default:
+			t.Skip()
+		}
+		FuzzDecode(FixLen(JsonTestProtocol(), n, binary.LittleEndian, 1024, 1024), data)
+	})
+}
+
+func FuzzPacket(f *testing.F) {
+	f.Add([]byte(`{"Head":"msg2","Body":{"Field1":1,"Field2":"x"}}`))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		FuzzDecode(Packet(JsonTestProtocol(), 1024, 1024), data)
+	})
+}
+
+func FuzzStruct(f *testing.F) {
+	var stream bytes.Buffer
+	codec, _ := structTestProtocol().NewCodec(&stream)
+	codec.Send(&structMsg{S: "abc", Points: []structPoint{{1, 2}}, Tree: structTree{"a", []structTree{{"b", nil}}}})
+	f.Add(stream.Bytes())
+	f.Fuzz(func(t *testing.T, data []byte) {
+		FuzzDecode(structTestProtocol(), data)
+	})
+}
@@ -8,6 +8,8 @@ import (
 	"github.com/funny/link"
 )
 
+var ErrJsonNoBody = link.NewError(link.ProtocolError, "Json Message Has No Body")
+
 type JsonProtocol struct {
 	types map[string]reflect.Type
 	names map[reflect.Type]string
@@ -42,6 +44,7 @@ func (j *JsonProtocol) RegisterName(name string, t interface{}) {
 func (j *JsonProtocol) NewCodec(rw io.ReadWriter) (link.Codec, error) {
 	codec := &jsonCodec{
 		p:       j,
+		writer:  rw,
 		encoder: json.NewEncoder(rw),
 		decoder: json.NewDecoder(rw),
 	}
@@ -62,6 +65,7 @@ type jsonOut struct {
 type jsonCodec struct {
 	p       *JsonProtocol
 	closer  io.Closer
+	writer  io.Writer
 	encoder *json.Encoder
 	decoder *json.Decoder
 }
@@ -72,6 +76,9 @@ func (c *jsonCodec) Receive() (interface{}, error) {
 	if err != nil {
 		return nil, err
 	}
+	if in.Body == nil {
+		return nil, ErrJsonNoBody
+	}
 	var body interface{}
 	if in.Head != "" {
 		if t, exists := c.p.types[in.Head]; exists {
@@ -86,6 +93,10 @@ func (c *jsonCodec) Receive() (interface{}, error) {
 }
 
 func (c *jsonCodec) Send(msg interface{}) error {
+	if encoded, ok := msg.(link.Encoded); ok {
+		_, err := c.writer.Write(encoded)
+		return err
+	}
 	var out jsonOut
 	t := reflect.TypeOf(msg)
 	if t.Kind() == reflect.Ptr {
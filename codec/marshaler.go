@@ -0,0 +1,126 @@
+package codec
+
+import (
+	"encoding"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"reflect"
+
+	"github.com/funny/link"
+)
+
+// BinaryProtocol sends types implementing encoding.BinaryMarshaler, and
+// whose pointer implements encoding.BinaryUnmarshaler. A message is a 2 bytes
+// type ID, a uvarint length and the marshaled bytes.
+type BinaryProtocol struct {
+	types   map[uint16]reflect.Type
+	ids     map[reflect.Type]uint16
+	MaxSize int // limits the marshaled size, default 1MB
+}
+
+func Binary() *BinaryProtocol {
+	return &BinaryProtocol{
+		types:   make(map[uint16]reflect.Type),
+		ids:     make(map[reflect.Type]uint16),
+		MaxSize: 1024 * 1024,
+	}
+}
+
+var (
+	marshalerType   = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+	unmarshalerType = reflect.TypeOf((*encoding.BinaryUnmarshaler)(nil)).Elem()
+)
+
+// Register assigns id to the type of msg. It panics when the id is used or
+// the type can't be marshaled both ways.
+func (p *BinaryProtocol) Register(id uint16, msg interface{}) {
+	t := reflect.TypeOf(msg)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if !reflect.PointerTo(t).Implements(marshalerType) || !reflect.PointerTo(t).Implements(unmarshalerType) {
+		panic(fmt.Sprintf("codec: %v is not a BinaryMarshaler and BinaryUnmarshaler", t))
+	}
+	if _, exists := p.types[id]; exists {
+		panic(fmt.Sprintf("codec: binary ID %d registered twice", id))
+	}
+	p.types[id] = t
+	p.ids[t] = id
+}
+
+func (p *BinaryProtocol) NewCodec(rw io.ReadWriter) (link.Codec, error) {
+	codec := &binaryCodec{p: p, rw: rw}
+	codec.dec = structDecoder{rw: rw, maxSize: p.MaxSize}
+	codec.closer, _ = rw.(io.Closer)
+	return codec, nil
+}
+
+type binaryCodec struct {
+	p      *BinaryProtocol
+	rw     io.ReadWriter
+	closer io.Closer
+	dec    structDecoder
+	buf    []byte
+}
+
+func (c *binaryCodec) Receive() (interface{}, error) {
+	id, err := c.dec.fixed(2)
+	if err != nil {
+		return nil, err
+	}
+	t, exists := c.p.types[uint16(id)]
+	if !exists {
+		return nil, ErrUnknownType
+	}
+	data, err := c.dec.bytes()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	msg := reflect.New(t).Interface()
+	if err := msg.(encoding.BinaryUnmarshaler).UnmarshalBinary(data); err != nil {
+		return nil, link.WrapError(link.ProtocolError, err)
+	}
+	return msg, nil
+}
+
+func (c *binaryCodec) Send(msg interface{}) error {
+	if encoded, ok := msg.(link.Encoded); ok {
+		_, err := c.rw.Write(encoded)
+		return err
+	}
+	t := reflect.TypeOf(msg)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	id, exists := c.p.ids[t]
+	if !exists {
+		return ErrNotRegistered
+	}
+	marshaler, ok := msg.(encoding.BinaryMarshaler)
+	if !ok {
+		// registered value type with pointer receivers.
+		v := reflect.New(t)
+		v.Elem().Set(reflect.ValueOf(msg))
+		marshaler = v.Interface().(encoding.BinaryMarshaler)
+	}
+	data, err := marshaler.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	c.buf = appendFixed(c.buf[:0], uint64(id), 2)
+	c.buf = binary.AppendUvarint(c.buf, uint64(len(data)))
+	c.buf = append(c.buf, data...)
+	_, err = c.rw.Write(c.buf)
+	return err
+}
+
+func (c *binaryCodec) Close() error {
+	if c.closer != nil {
+		return c.closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package codec
+
+import (
+	"bytes"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+)
+
+type binaryPoint struct {
+	X, Y byte
+}
+
+func (p binaryPoint) MarshalBinary() ([]byte, error) {
+	return []byte{p.X, p.Y}, nil
+}
+
+func (p *binaryPoint) UnmarshalBinary(b []byte) error {
+	if len(b) != 2 {
+		return errors.New("bad point")
+	}
+	p.X, p.Y = b[0], b[1]
+	return nil
+}
+
+func Test_Binary(t *testing.T) {
+	protocol := Binary()
+	protocol.Register(1, time.Time{})
+	protocol.Register(2, binaryPoint{})
+	protocol.Register(3, &url.URL{})
+
+	now := time.Unix(1700000000, 123).UTC()
+	u, _ := url.Parse("https://example.com/a?b=c")
+
+	var stream bytes.Buffer
+	codec, _ := protocol.NewCodec(&stream)
+	for _, msg := range []interface{}{now, &binaryPoint{1, 2}, u} {
+		if err := codec.Send(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := codec.Send(&MyMessage1{}); err != ErrNotRegistered {
+		t.Fatalf("expect ErrNotRegistered, got %v", err)
+	}
+
+	msg, err := codec.Receive()
+	if err != nil || !msg.(*time.Time).Equal(now) {
+		t.Fatalf("time not match: %v %v", msg, err)
+	}
+	msg, err = codec.Receive()
+	if err != nil || *msg.(*binaryPoint) != (binaryPoint{1, 2}) {
+		t.Fatalf("point not match: %v %v", msg, err)
+	}
+	msg, err = codec.Receive()
+	if err != nil || msg.(*url.URL).String() != u.String() {
+		t.Fatalf("url not match: %v %v", msg, err)
+	}
+}
@@ -0,0 +1,66 @@
+package codec
+
+import (
+	"encoding/binary"
+
+	"github.com/funny/link"
+)
+
+type options struct {
+	headSize  int
+	byteOrder binary.ByteOrder
+	maxRecv   int
+	maxSend   int
+	readBuf   int
+	writeBuf  int
+}
+
+type Option func(*options)
+
+// WithHead sets the size of the length head, 1, 2, 4 or 8 bytes.
+func WithHead(n int, byteOrder binary.ByteOrder) Option {
+	return func(o *options) {
+		o.headSize, o.byteOrder = n, byteOrder
+	}
+}
+
+// WithMaxPacket limits the packets of both directions.
+func WithMaxPacket(n int) Option {
+	return func(o *options) {
+		o.maxRecv, o.maxSend = n, n
+	}
+}
+
+func WithMaxRecv(n int) Option {
+	return func(o *options) { o.maxRecv = n }
+}
+
+func WithMaxSend(n int) Option {
+	return func(o *options) { o.maxSend = n }
+}
+
+// WithBufio puts buffered reader and writer of size under the framing.
+func WithBufio(size int) Option {
+	return func(o *options) {
+		o.readBuf, o.writeBuf = size, size
+	}
+}
+
+// Framed is FixLen, optionally wrapped with Bufio, configured by options.
+// Default is a 4 bytes little endian head, 1MB packets and no buffering.
+func Framed(base link.Protocol, opts ...Option) link.Protocol {
+	o := options{
+		headSize:  4,
+		byteOrder: binary.LittleEndian,
+		maxRecv:   1 << 20,
+		maxSend:   1 << 20,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	var protocol link.Protocol = FixLen(base, o.headSize, o.byteOrder, o.maxRecv, o.maxSend)
+	if o.readBuf > 0 || o.writeBuf > 0 {
+		protocol = Bufio(protocol, o.readBuf, o.writeBuf)
+	}
+	return protocol
+}
@@ -0,0 +1,18 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func Test_Framed(t *testing.T) {
+	JsonTest(t, Framed(JsonTestProtocol()))
+	JsonTest(t, Framed(JsonTestProtocol(), WithHead(2, binary.BigEndian), WithBufio(1024)))
+
+	var stream bytes.Buffer
+	codec, _ := Framed(JsonTestProtocol(), WithMaxPacket(16)).NewCodec(&stream)
+	if err := codec.Send(&MyMessage1{"abc", 123}); err != ErrTooLargePacket {
+		t.Fatalf("expect ErrTooLargePacket, got %v", err)
+	}
+}
@@ -0,0 +1,79 @@
+package codec
+
+import (
+	"io"
+
+	"github.com/funny/link"
+)
+
+type PacketProtocol struct {
+	base    link.Protocol
+	maxRecv int
+	maxSend int
+}
+
+func Packet(base link.Protocol, maxRecv, maxSend int) *PacketProtocol {
+	return &PacketProtocol{
+		base:    base,
+		maxRecv: maxRecv,
+		maxSend: maxSend,
+	}
+}
+
+func (p *PacketProtocol) NewCodec(rw io.ReadWriter) (cc link.Codec, err error) {
+	codec := &packetCodec{
+		rw:             rw,
+		PacketProtocol: p,
+		recvPacket:     make([]byte, p.maxRecv+1),
+	}
+	codec.base, err = p.base.NewCodec(&codec.fixlenReadWriter)
+	if err != nil {
+		return
+	}
+	cc = codec
+	return
+}
+
+type packetCodec struct {
+	base       link.Codec
+	recvPacket []byte
+	rw         io.ReadWriter
+	*PacketProtocol
+	fixlenReadWriter
+}
+
+func (c *packetCodec) Receive() (interface{}, error) {
+	n, err := c.rw.Read(c.recvPacket)
+	if err != nil {
+		return nil, err
+	}
+	if n > c.maxRecv {
+		return nil, ErrTooLargePacket
+	}
+	c.recvBuf.Reset(c.recvPacket[:n])
+	return c.base.Receive()
+}
+
+func (c *packetCodec) Send(msg interface{}) error {
+	if encoded, ok := msg.(link.Encoded); ok {
+		_, err := c.rw.Write(encoded)
+		return err
+	}
+	c.sendBuf.Reset()
+	err := c.base.Send(msg)
+	if err != nil {
+		return err
+	}
+	if c.sendBuf.Len() > c.maxSend {
+		return ErrTooLargePacket
+	}
+	_, err = c.rw.Write(c.sendBuf.Bytes())
+	return err
+}
+
+func (c *packetCodec) Close() error {
+	if closer, ok := c.rw.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+package codec
+
+import (
+	"testing"
+)
+
+func Test_Packet(t *testing.T) {
+	JsonTest(t, Packet(JsonTestProtocol(), 1024, 1024))
+}
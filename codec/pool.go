@@ -0,0 +1,150 @@
+package codec
+
+import (
+	"bufio"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/clock"
+)
+
+// ReaderPool keeps released bufio.Readers for reuse. Unlike a sync.Pool it
+// is bounded, at most maxIdle readers are kept, and readers idle for longer
+// than idleTimeout are dropped, so memory goes back down after a spike of
+// connections.
+type ReaderPool struct {
+	size        int
+	maxIdle     int
+	idleTimeout time.Duration
+	Clock       clock.Clock
+
+	mutex sync.Mutex
+	free  []idleReader // oldest first
+}
+
+type idleReader struct {
+	r     *bufio.Reader
+	since time.Time
+}
+
+func NewReaderPool(size, maxIdle int, idleTimeout time.Duration) *ReaderPool {
+	return &ReaderPool{size: size, maxIdle: maxIdle, idleTimeout: idleTimeout}
+}
+
+func (p *ReaderPool) evict(now time.Time) {
+	if p.idleTimeout <= 0 {
+		return
+	}
+	n := 0
+	for n < len(p.free) && now.Sub(p.free[n].since) > p.idleTimeout {
+		p.free[n] = idleReader{}
+		n++
+	}
+	p.free = p.free[n:]
+}
+
+func (p *ReaderPool) Get(rd io.Reader) *bufio.Reader {
+	p.mutex.Lock()
+	p.evict(clock.Or(p.Clock).Now())
+	if n := len(p.free); n > 0 {
+		r := p.free[n-1].r
+		p.free[n-1] = idleReader{}
+		p.free = p.free[:n-1]
+		p.mutex.Unlock()
+		r.Reset(rd)
+		return r
+	}
+	p.mutex.Unlock()
+	return bufio.NewReaderSize(rd, p.size)
+}
+
+func (p *ReaderPool) Put(r *bufio.Reader) {
+	r.Reset(nil)
+	now := clock.Or(p.Clock).Now()
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.evict(now)
+	if len(p.free) >= p.maxIdle {
+		return
+	}
+	p.free = append(p.free, idleReader{r, now})
+}
+
+// Len returns the number of idle readers.
+func (p *ReaderPool) Len() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.evict(clock.Or(p.Clock).Now())
+	return len(p.free)
+}
+
+// PooledBufio is Bufio taking the read buffers from pool. A buffer goes back
+// to the pool when Receive fails, which it does once the session is closed.
+func PooledBufio(base link.Protocol, pool *ReaderPool, writeBuf int) link.Protocol {
+	return &pooledProtocol{base, pool, writeBuf}
+}
+
+type pooledProtocol struct {
+	base     link.Protocol
+	pool     *ReaderPool
+	writeBuf int
+}
+
+func (p *pooledProtocol) NewCodec(rw io.ReadWriter) (cc link.Codec, err error) {
+	codec := &pooledCodec{pool: p.pool}
+	if p.writeBuf > 0 {
+		codec.stream.w = bufio.NewWriterSize(rw, p.writeBuf)
+		codec.stream.Writer = codec.stream.w
+	} else {
+		codec.stream.Writer = rw
+	}
+	codec.reader = p.pool.Get(rw)
+	codec.stream.Reader = codec.reader
+	codec.stream.c, _ = rw.(io.Closer)
+
+	codec.base, err = p.base.NewCodec(&codec.stream)
+	if err != nil {
+		p.pool.Put(codec.reader)
+		return
+	}
+	cc = codec
+	return
+}
+
+type pooledCodec struct {
+	bufioCodec
+	pool   *ReaderPool
+	reader *bufio.Reader
+}
+
+type errReader struct {
+	err error
+}
+
+func (r errReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
+
+func (c *pooledCodec) Receive() (interface{}, error) {
+	msg, err := c.base.Receive()
+	c.release(err)
+	return msg, err
+}
+
+func (c *pooledCodec) ReceiveBatch(msgs []interface{}, max int) ([]interface{}, error) {
+	msgs, err := link.ReceiveBatch(c.base, msgs, max)
+	c.release(err)
+	return msgs, err
+}
+
+// only the receiving goroutine touches the reader, so it is safe to give it
+// back here and not in Close.
+func (c *pooledCodec) release(err error) {
+	if err != nil && c.reader != nil {
+		c.stream.Reader = errReader{err}
+		c.pool.Put(c.reader)
+		c.reader = nil
+	}
+}
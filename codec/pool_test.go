@@ -0,0 +1,63 @@
+package codec
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/clock"
+)
+
+func Test_ReaderPool(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1000, 0))
+	pool := NewReaderPool(1024, 2, time.Minute)
+	pool.Clock = clk
+
+	r1, r2, r3 := pool.Get(nil), pool.Get(nil), pool.Get(nil)
+	pool.Put(r1)
+	clk.Advance(30 * time.Second)
+	pool.Put(r2)
+	pool.Put(r3)
+	if pool.Len() != 2 {
+		t.Fatalf("pool not bounded: %d", pool.Len())
+	}
+	if pool.Get(nil) != r2 {
+		t.Fatal("expect the most recent reader")
+	}
+	pool.Put(r2)
+
+	clk.Advance(45 * time.Second)
+	if pool.Len() != 1 {
+		t.Fatalf("idle reader not evicted: %d", pool.Len())
+	}
+	clk.Advance(time.Hour)
+	if pool.Len() != 0 {
+		t.Fatalf("idle reader not evicted: %d", pool.Len())
+	}
+}
+
+func Test_PooledBufio(t *testing.T) {
+	pool := NewReaderPool(1024, 10, time.Minute)
+	protocol := PooledBufio(FixLen(JsonTestProtocol(), 2, binary.LittleEndian, 1024, 1024), pool, 1024)
+	JsonTest(t, protocol)
+
+	client, server, err := link.Pipe(protocol, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go client.Send(&MyMessage1{"abc", 1})
+	if _, err := server.Receive(); err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+	if _, err := server.Receive(); err == nil {
+		t.Fatal("expect error")
+	}
+	if _, err := server.Receive(); err == nil {
+		t.Fatal("expect error")
+	}
+	if pool.Len() != 1 {
+		t.Fatalf("reader not returned: %d", pool.Len())
+	}
+}
@@ -0,0 +1,109 @@
+package codec
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/funny/link"
+)
+
+var (
+	ErrSeqGap       = link.NewError(link.ProtocolError, "Sequence Gap")
+	ErrSeqReordered = link.NewError(link.ProtocolError, "Sequence Reordered")
+)
+
+// SeqError is returned by Receive when a frame doesn't carry the next
+// sequence number, it matches ErrSeqGap when frames were lost and
+// ErrSeqReordered when a frame came late or twice.
+type SeqError struct {
+	Expected uint32
+	Got      uint32
+}
+
+func (e *SeqError) Error() string {
+	return fmt.Sprintf("%v: expected %d, got %d", e.Unwrap(), e.Expected, e.Got)
+}
+
+func (e *SeqError) Unwrap() error {
+	if int32(e.Got-e.Expected) > 0 {
+		return ErrSeqGap
+	}
+	return ErrSeqReordered
+}
+
+type SeqProtocol struct {
+	base        link.Protocol
+	onViolation func(err *SeqError) error
+}
+
+// Seq stamps every frame of base with a 4 byte sequence number of the
+// session and checks the ones received, for links over relays and custom
+// tunnels. It goes around a framing protocol such as FixLen, which keeps
+// link.Encoded broadcasts stamped too, and reads the stream itself, so only
+// Bufio may sit above it. A frame out of sequence goes to onViolation, the
+// error it returns fails Receive, with nil the frame is received and the
+// sequence goes on after it. A nil onViolation fails on every violation.
+func Seq(base link.Protocol, onViolation func(err *SeqError) error) *SeqProtocol {
+	return &SeqProtocol{base, onViolation}
+}
+
+func (p *SeqProtocol) NewCodec(rw io.ReadWriter) (link.Codec, error) {
+	base, err := p.base.NewCodec(rw)
+	if err != nil {
+		return nil, err
+	}
+	return &seqCodec{base: base, rw: rw, SeqProtocol: p}, nil
+}
+
+type seqCodec struct {
+	base     link.Codec
+	rw       io.ReadWriter
+	sendSeq  uint32
+	recvSeq  uint32
+	sendHead [4]byte
+	recvHead [4]byte
+	*SeqProtocol
+}
+
+func (c *seqCodec) Receive() (interface{}, error) {
+	if _, err := io.ReadFull(c.rw, c.recvHead[:]); err != nil {
+		return nil, err
+	}
+	msg, err := c.base.Receive()
+	if err != nil {
+		return nil, err
+	}
+	got := binary.LittleEndian.Uint32(c.recvHead[:])
+	if got != c.recvSeq {
+		err := &SeqError{Expected: c.recvSeq, Got: got}
+		if c.onViolation == nil {
+			return nil, err
+		}
+		if err := c.onViolation(err); err != nil {
+			return nil, err
+		}
+		if int32(got-c.recvSeq) < 0 {
+			return msg, nil
+		}
+	}
+	c.recvSeq = got + 1
+	return msg, nil
+}
+
+func (c *seqCodec) Send(msg interface{}) error {
+	binary.LittleEndian.PutUint32(c.sendHead[:], c.sendSeq)
+	if _, err := c.rw.Write(c.sendHead[:]); err != nil {
+		return err
+	}
+	c.sendSeq++
+	// encoded by a codec of this protocol, with a stamp of its own.
+	if encoded, ok := msg.(link.Encoded); ok && len(encoded) >= 4 {
+		msg = encoded[4:]
+	}
+	return c.base.Send(msg)
+}
+
+func (c *seqCodec) Close() error {
+	return c.base.Close()
+}
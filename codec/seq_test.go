@@ -0,0 +1,70 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/funny/link"
+)
+
+func Test_Seq(t *testing.T) {
+	JsonTest(t, Seq(FixLen(JsonTestProtocol(), 2, binary.LittleEndian, 1024, 1024), nil))
+}
+
+func Test_SeqGap(t *testing.T) {
+	var violations []*SeqError
+	protocol := Seq(FixLen(JsonTestProtocol(), 2, binary.LittleEndian, 1024, 1024), func(err *SeqError) error {
+		violations = append(violations, err)
+		return nil
+	})
+	broadcaster, err := link.NewBroadcaster(protocol)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// four frames, one of them a broadcast, the third gets lost.
+	var stream bytes.Buffer
+	sender, _ := protocol.NewCodec(&stream)
+	var frames [][]byte
+	for i := 0; i < 4; i++ {
+		var msg interface{} = &MyMessage1{"abc", i}
+		if i == 1 {
+			msg, _ = broadcaster.Encode(msg)
+		}
+		if err := sender.Send(msg); err != nil {
+			t.Fatal(err)
+		}
+		frames = append(frames, append([]byte(nil), stream.Bytes()...))
+		stream.Reset()
+	}
+	wire := bytes.Join([][]byte{frames[0], frames[1], frames[3], frames[1]}, nil)
+
+	receiver, _ := protocol.NewCodec(bytes.NewBuffer(wire))
+	for _, want := range []int{0, 1, 3, 1} {
+		msg, err := receiver.Receive()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.(*MyMessage1).Field2 != want {
+			t.Fatalf("message not match: %v", msg)
+		}
+	}
+	if len(violations) != 2 || !errors.Is(violations[0], ErrSeqGap) || !errors.Is(violations[1], ErrSeqReordered) {
+		t.Fatalf("violations not match: %v", violations)
+	}
+	if *violations[0] != (SeqError{2, 3}) || *violations[1] != (SeqError{4, 1}) {
+		t.Fatalf("violations not match: %v", violations)
+	}
+
+	// without a handler Receive fails.
+	strict := Seq(FixLen(JsonTestProtocol(), 2, binary.LittleEndian, 1024, 1024), nil)
+	receiver, _ = strict.NewCodec(bytes.NewBuffer(bytes.Join([][]byte{frames[0], frames[2]}, nil)))
+	if _, err := receiver.Receive(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := receiver.Receive(); !errors.Is(err, ErrSeqGap) || !errors.Is(err, link.ProtocolError) {
+		t.Fatalf("error not match: %v", err)
+	}
+}
@@ -0,0 +1,348 @@
+package codec
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+
+	"github.com/funny/link"
+)
+
+var ErrUnknownType = link.NewError(link.ProtocolError, "Unknown Message Type")
+var ErrNotRegistered = link.NewError(link.ProtocolError, "Message Type Not Registered")
+
+// StructProtocol encodes registered structs field by field in declaration
+// order, without any generated code. A message is a 2 bytes type ID followed
+// by the fields: fixed size little endian numbers, bool as one byte, strings
+// and slices prefixed by a uvarint length. Fields tagged `link:"-"` and
+// unexported fields are skipped.
+type StructProtocol struct {
+	types   map[uint16]*structType
+	ids     map[reflect.Type]*structType
+	MaxSize int // limits the lengths of strings and slices, default 1MB
+}
+
+type structType struct {
+	id  uint16
+	t   reflect.Type
+	fns *fieldCodec
+}
+
+type fieldCodec struct {
+	enc func(b []byte, v reflect.Value) []byte
+	dec func(d *structDecoder, v reflect.Value) error
+}
+
+func Struct() *StructProtocol {
+	return &StructProtocol{
+		types:   make(map[uint16]*structType),
+		ids:     make(map[reflect.Type]*structType),
+		MaxSize: 1024 * 1024,
+	}
+}
+
+// Register derives the layout of the struct type of msg. It panics when the
+// ID is used or a field type is not supported.
+func (p *StructProtocol) Register(id uint16, msg interface{}) {
+	t := reflect.TypeOf(msg)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("codec: %v is not a struct", t))
+	}
+	if _, exists := p.types[id]; exists {
+		panic(fmt.Sprintf("codec: struct ID %d registered twice", id))
+	}
+	fns, err := compileField(t, make(map[reflect.Type]*fieldCodec))
+	if err != nil {
+		panic(err)
+	}
+	st := &structType{id, t, fns}
+	p.types[id] = st
+	p.ids[t] = st
+}
+
+func compileField(t reflect.Type, seen map[reflect.Type]*fieldCodec) (*fieldCodec, error) {
+	if fns, exists := seen[t]; exists {
+		return fns, nil
+	}
+	fns := &fieldCodec{}
+	switch t.Kind() {
+	case reflect.Bool:
+		fns.enc = func(b []byte, v reflect.Value) []byte {
+			if v.Bool() {
+				return append(b, 1)
+			}
+			return append(b, 0)
+		}
+		fns.dec = func(d *structDecoder, v reflect.Value) error {
+			n, err := d.fixed(1)
+			v.SetBool(n != 0)
+			return err
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		size := int(t.Size())
+		fns.enc = func(b []byte, v reflect.Value) []byte {
+			return appendFixed(b, uint64(v.Int()), size)
+		}
+		fns.dec = func(d *structDecoder, v reflect.Value) error {
+			n, err := d.fixed(size)
+			// sign extend
+			shift := 64 - 8*size
+			v.SetInt(int64(n<<shift) >> shift)
+			return err
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		size := int(t.Size())
+		fns.enc = func(b []byte, v reflect.Value) []byte {
+			return appendFixed(b, v.Uint(), size)
+		}
+		fns.dec = func(d *structDecoder, v reflect.Value) error {
+			n, err := d.fixed(size)
+			v.SetUint(n)
+			return err
+		}
+	case reflect.Float32:
+		fns.enc = func(b []byte, v reflect.Value) []byte {
+			return appendFixed(b, uint64(math.Float32bits(float32(v.Float()))), 4)
+		}
+		fns.dec = func(d *structDecoder, v reflect.Value) error {
+			n, err := d.fixed(4)
+			v.SetFloat(float64(math.Float32frombits(uint32(n))))
+			return err
+		}
+	case reflect.Float64:
+		fns.enc = func(b []byte, v reflect.Value) []byte {
+			return appendFixed(b, math.Float64bits(v.Float()), 8)
+		}
+		fns.dec = func(d *structDecoder, v reflect.Value) error {
+			n, err := d.fixed(8)
+			v.SetFloat(math.Float64frombits(n))
+			return err
+		}
+	case reflect.String:
+		fns.enc = func(b []byte, v reflect.Value) []byte {
+			b = binary.AppendUvarint(b, uint64(v.Len()))
+			return append(b, v.String()...)
+		}
+		fns.dec = func(d *structDecoder, v reflect.Value) error {
+			s, err := d.bytes()
+			v.SetString(string(s))
+			return err
+		}
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			fns.enc = func(b []byte, v reflect.Value) []byte {
+				b = binary.AppendUvarint(b, uint64(v.Len()))
+				return append(b, v.Bytes()...)
+			}
+			fns.dec = func(d *structDecoder, v reflect.Value) error {
+				s, err := d.bytes()
+				if err == nil && len(s) > 0 {
+					v.SetBytes(s)
+				}
+				return err
+			}
+			break
+		}
+		elem, err := compileField(t.Elem(), seen)
+		if err != nil {
+			return nil, err
+		}
+		fns.enc = func(b []byte, v reflect.Value) []byte {
+			b = binary.AppendUvarint(b, uint64(v.Len()))
+			for i := 0; i < v.Len(); i++ {
+				b = elem.enc(b, v.Index(i))
+			}
+			return b
+		}
+		fns.dec = func(d *structDecoder, v reflect.Value) error {
+			n, err := d.length()
+			if err != nil || n == 0 {
+				return err
+			}
+			// grow as elements arrive, n comes from the peer.
+			s := reflect.MakeSlice(t, 0, min(n, 64))
+			e := reflect.New(t.Elem()).Elem()
+			for i := 0; i < n; i++ {
+				e.SetZero()
+				if err := elem.dec(d, e); err != nil {
+					return err
+				}
+				s = reflect.Append(s, e)
+			}
+			v.Set(s)
+			return nil
+		}
+	case reflect.Array:
+		elem, err := compileField(t.Elem(), seen)
+		if err != nil {
+			return nil, err
+		}
+		fns.enc = func(b []byte, v reflect.Value) []byte {
+			for i := 0; i < v.Len(); i++ {
+				b = elem.enc(b, v.Index(i))
+			}
+			return b
+		}
+		fns.dec = func(d *structDecoder, v reflect.Value) error {
+			for i := 0; i < v.Len(); i++ {
+				if err := elem.dec(d, v.Index(i)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	case reflect.Struct:
+		// registered before the fields, so recursive types terminate.
+		seen[t] = fns
+		var fields []int
+		var codecs []*fieldCodec
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() || f.Tag.Get("link") == "-" {
+				continue
+			}
+			fc, err := compileField(f.Type, seen)
+			if err != nil {
+				return nil, fmt.Errorf("codec: %v.%s: %v", t, f.Name, err)
+			}
+			fields = append(fields, i)
+			codecs = append(codecs, fc)
+		}
+		fns.enc = func(b []byte, v reflect.Value) []byte {
+			for i, field := range fields {
+				b = codecs[i].enc(b, v.Field(field))
+			}
+			return b
+		}
+		fns.dec = func(d *structDecoder, v reflect.Value) error {
+			for i, field := range fields {
+				if err := codecs[i].dec(d, v.Field(field)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	default:
+		return nil, fmt.Errorf("unsupported type %v", t)
+	}
+	seen[t] = fns
+	return fns, nil
+}
+
+func appendFixed(b []byte, n uint64, size int) []byte {
+	for i := 0; i < size; i++ {
+		b = append(b, byte(n>>(8*i)))
+	}
+	return b
+}
+
+type structDecoder struct {
+	rw      io.Reader
+	maxSize int
+	buf     [8]byte
+}
+
+func (d *structDecoder) ReadByte() (byte, error) {
+	if _, err := io.ReadFull(d.rw, d.buf[:1]); err != nil {
+		return 0, err
+	}
+	return d.buf[0], nil
+}
+
+func (d *structDecoder) fixed(size int) (uint64, error) {
+	b := d.buf[:size]
+	if _, err := io.ReadFull(d.rw, b); err != nil {
+		return 0, err
+	}
+	var n uint64
+	for i := size - 1; i >= 0; i-- {
+		n = n<<8 | uint64(b[i])
+	}
+	return n, nil
+}
+
+func (d *structDecoder) length() (int, error) {
+	n, err := binary.ReadUvarint(d)
+	if err != nil {
+		return 0, err
+	}
+	if n > uint64(d.maxSize) {
+		return 0, ErrTooLargePacket
+	}
+	return int(n), nil
+}
+
+func (d *structDecoder) bytes() ([]byte, error) {
+	n, err := d.length()
+	if err != nil || n == 0 {
+		return nil, err
+	}
+	b := make([]byte, n)
+	_, err = io.ReadFull(d.rw, b)
+	return b, err
+}
+
+func (p *StructProtocol) NewCodec(rw io.ReadWriter) (link.Codec, error) {
+	codec := &structCodec{p: p, rw: rw}
+	codec.dec = structDecoder{rw: rw, maxSize: p.MaxSize}
+	codec.closer, _ = rw.(io.Closer)
+	return codec, nil
+}
+
+type structCodec struct {
+	p      *StructProtocol
+	rw     io.ReadWriter
+	closer io.Closer
+	dec    structDecoder
+	buf    []byte
+}
+
+func (c *structCodec) Receive() (interface{}, error) {
+	id, err := c.dec.fixed(2)
+	if err != nil {
+		return nil, err
+	}
+	st, exists := c.p.types[uint16(id)]
+	if !exists {
+		return nil, ErrUnknownType
+	}
+	msg := reflect.New(st.t)
+	if err := st.fns.dec(&c.dec, msg.Elem()); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return msg.Interface(), nil
+}
+
+func (c *structCodec) Send(msg interface{}) error {
+	if encoded, ok := msg.(link.Encoded); ok {
+		_, err := c.rw.Write(encoded)
+		return err
+	}
+	v := reflect.ValueOf(msg)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	st, exists := c.p.ids[v.Type()]
+	if !exists {
+		return ErrNotRegistered
+	}
+	c.buf = appendFixed(c.buf[:0], uint64(st.id), 2)
+	c.buf = st.fns.enc(c.buf, v)
+	_, err := c.rw.Write(c.buf)
+	return err
+}
+
+func (c *structCodec) Close() error {
+	if c.closer != nil {
+		return c.closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+type structPoint struct {
+	X, Y int16
+}
+
+type structTree struct {
+	Name     string
+	Children []structTree
+}
+
+type structMsg struct {
+	B      bool
+	I      int
+	I8     int8
+	U32    uint32
+	F      float64
+	S      string
+	Data   []byte
+	Points []structPoint
+	Fixed  [3]uint8
+	Tree   structTree
+	Skip   string `link:"-"`
+	hidden int
+}
+
+func structTestProtocol() *StructProtocol {
+	protocol := Struct()
+	protocol.Register(1, structMsg{})
+	protocol.Register(2, &structPoint{})
+	return protocol
+}
+
+func Test_Struct(t *testing.T) {
+	msg := &structMsg{
+		B: true, I: -123456789, I8: -5, U32: 1 << 31, F: 3.25,
+		S: "hello", Data: []byte{1, 2, 3},
+		Points: []structPoint{{1, -1}, {-300, 300}},
+		Fixed:  [3]uint8{7, 8, 9},
+		Tree:   structTree{"root", []structTree{{"leaf", nil}}},
+		Skip:   "not sent",
+	}
+	var stream bytes.Buffer
+	codec, _ := FixLen(structTestProtocol(), 2, binary.LittleEndian, 1024, 1024).NewCodec(&stream)
+	if err := codec.Send(msg); err != nil {
+		t.Fatal(err)
+	}
+	if err := codec.Send(structPoint{3, 4}); err != nil {
+		t.Fatal(err)
+	}
+	if err := codec.Send(&MyMessage1{}); err != ErrNotRegistered {
+		t.Fatalf("expect ErrNotRegistered, got %v", err)
+	}
+
+	got, err := codec.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg.Skip = ""
+	if !reflect.DeepEqual(got, msg) {
+		t.Fatalf("message not match: %#v", got)
+	}
+	got, err = codec.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got.(*structPoint) != (structPoint{3, 4}) {
+		t.Fatalf("message not match: %#v", got)
+	}
+
+	stream.Reset()
+	stream.Write([]byte{3, 0})
+	codec, _ = structTestProtocol().NewCodec(&stream)
+	if _, err := codec.Receive(); err != ErrUnknownType {
+		t.Fatalf("expect ErrUnknownType, got %v", err)
+	}
+}
@@ -0,0 +1,143 @@
+package link
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/funny/link/internal/bucket"
+)
+
+// Config holds the knobs of a server which can be changed while it runs,
+// see LiveConfig. Zero values turn a knob off.
+type Config struct {
+	// IdleTimeout closes sessions which receive nothing for this long.
+	IdleTimeout time.Duration `json:"idle_timeout"`
+
+	// RecvRate is the messages per second a session may send, a faster
+	// peer is read slower. RecvBurst defaults to RecvRate.
+	RecvRate  int `json:"recv_rate"`
+	RecvBurst int `json:"recv_burst"`
+
+	// MaxPacket limits inbound packets of protocols which follow the
+	// config, like codec.FixLenProtocol.Follow.
+	MaxPacket int `json:"max_packet"`
+}
+
+// LiveConfig is a Config which is swapped atomically, readers always see a
+// complete config and never wait for a writer.
+type LiveConfig struct {
+	config   atomic.Pointer[Config]
+	mutex    sync.Mutex
+	watchers []func(*Config)
+}
+
+func NewLiveConfig(config Config) *LiveConfig {
+	live := &LiveConfig{}
+	live.config.Store(&config)
+	return live
+}
+
+// Load returns the current config, it must not be changed.
+func (live *LiveConfig) Load() *Config {
+	return live.config.Load()
+}
+
+// Store replaces the config and calls the watchers.
+func (live *LiveConfig) Store(config Config) {
+	live.Update(func(c *Config) { *c = config })
+}
+
+// Update changes a copy of the current config with fn and stores it, updates
+// don't overwrite each other.
+func (live *LiveConfig) Update(fn func(*Config)) {
+	live.mutex.Lock()
+	defer live.mutex.Unlock()
+	config := *live.config.Load()
+	fn(&config)
+	live.config.Store(&config)
+	for _, watcher := range live.watchers {
+		watcher(&config)
+	}
+}
+
+// OnChange calls fn with the current config and after every change.
+func (live *LiveConfig) OnChange(fn func(*Config)) {
+	live.mutex.Lock()
+	defer live.mutex.Unlock()
+	live.watchers = append(live.watchers, fn)
+	fn(live.config.Load())
+}
+
+type deadlineReader interface {
+	SetReadDeadline(time.Time) error
+}
+
+// Configured applies IdleTimeout and RecvRate of live to the codecs of base,
+// a change applies from the next message received. The idle timeout needs
+// a connection with read deadlines, like net.Conn.
+func Configured(base Protocol, live *LiveConfig) Protocol {
+	return ProtocolFunc(func(rw io.ReadWriter) (Codec, error) {
+		codec, err := base.NewCodec(rw)
+		if err != nil {
+			return nil, err
+		}
+		c := &configuredCodec{Codec: codec, live: live}
+		c.conn, _ = rw.(deadlineReader)
+		return c, nil
+	})
+}
+
+type configuredCodec struct {
+	Codec
+	live *LiveConfig
+	conn deadlineReader
+
+	recv bucket.Bucket // of RecvRate
+}
+
+func (c *configuredCodec) ClearSendChan(ch <-chan interface{}) {
+	if clear, ok := c.Codec.(ClearSendChan); ok {
+		clear.ClearSendChan(ch)
+	}
+}
+
+func (c *configuredCodec) Receive() (interface{}, error) {
+	c.prepare()
+	return c.Codec.Receive()
+}
+
+// ReceiveBatch takes one message at a time while RecvRate is set, so each
+// of them waits for its token.
+func (c *configuredCodec) ReceiveBatch(msgs []interface{}, max int) ([]interface{}, error) {
+	if config := c.prepare(); config.RecvRate > 0 {
+		max = 1
+	}
+	return ReceiveBatch(c.Codec, msgs, max)
+}
+
+// prepare waits for the rate limit and sets the idle deadline of the next
+// read.
+func (c *configuredCodec) prepare() *Config {
+	config := c.live.Load()
+	c.wait(config)
+	if c.conn != nil {
+		var deadline time.Time
+		if config.IdleTimeout > 0 {
+			deadline = time.Now().Add(config.IdleTimeout)
+		}
+		c.conn.SetReadDeadline(deadline)
+	}
+	return config
+}
+
+// wait sleeps until the session may receive another message.
+func (c *configuredCodec) wait(config *Config) {
+	burst := config.RecvBurst
+	if burst <= 0 {
+		burst = config.RecvRate
+	}
+	c.recv.SetRate(float64(config.RecvRate), float64(burst))
+	c.recv.Wait(1)
+}
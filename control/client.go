@@ -0,0 +1,103 @@
+package control
+
+import (
+	"sync"
+
+	"github.com/funny/link"
+)
+
+// Broadcast sends msg, like a Maintenance notice, to every session of manager.
+func Broadcast(manager *link.Manager, msg interface{}) {
+	// Send blocks on sessions without a send queue, Fetch holds a lock.
+	var sessions []*link.Session
+	manager.Fetch(func(session *link.Session) {
+		sessions = append(sessions, session)
+	})
+	for _, session := range sessions {
+		session.Send(msg)
+	}
+}
+
+// Client is the client side of the control messages. It hides them from the
+// application: a Redirect makes it dial the new address and continue there,
+// Kick and Maintenance are passed to the hooks.
+type Client struct {
+	dial func(address string) (*link.Session, error)
+
+	OnKick        func(kick *Kick)
+	OnMaintenance func(notice *Maintenance)
+	OnRedirect    func(redirect *Redirect) bool // false refuses to follow
+
+	mutex   sync.Mutex
+	session *link.Session
+}
+
+// NewClient dials address with dial, which must return a session of
+// ControlProtocol.
+func NewClient(address string, dial func(address string) (*link.Session, error)) (*Client, error) {
+	session, err := dial(address)
+	if err != nil {
+		return nil, err
+	}
+	Bind(session)
+	return &Client{dial: dial, session: session}, nil
+}
+
+// Session returns the current session, it changes after a redirect.
+func (client *Client) Session() *link.Session {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	return client.session
+}
+
+func (client *Client) Send(msg interface{}) error {
+	return client.Session().Send(msg)
+}
+
+// Receive returns the next application message.
+func (client *Client) Receive() (interface{}, error) {
+	for {
+		session := client.Session()
+		msg, err := session.Receive()
+		if err != nil {
+			return nil, err
+		}
+		switch m := msg.(type) {
+		case *Kick:
+			if client.OnKick != nil {
+				client.OnKick(m)
+			}
+		case *Maintenance:
+			if client.OnMaintenance != nil {
+				client.OnMaintenance(m)
+			}
+		case *Redirect:
+			if client.OnRedirect != nil && !client.OnRedirect(m) {
+				continue
+			}
+			if err := client.redirect(session, m.Address); err != nil {
+				return nil, err
+			}
+		default:
+			return msg, nil
+		}
+	}
+}
+
+func (client *Client) redirect(old *link.Session, address string) error {
+	session, err := client.dial(address)
+	if err != nil {
+		link.GetLogger().Warn("link: redirect failed", "address", address, "error", err)
+		return err
+	}
+	Bind(session)
+	client.mutex.Lock()
+	client.session = session
+	client.mutex.Unlock()
+	old.Close()
+	return nil
+}
+
+func (client *Client) Close() error {
+	return client.Session().Close()
+}
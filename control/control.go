@@ -0,0 +1,321 @@
+package control
+
+import (
+	"encoding/binary"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/clock"
+	"github.com/funny/link/internal/buffered"
+)
+
+var NotControlError = link.NewError(link.PolicyError, "Session Codec Has No Control Messages")
+var UnknownControlError = link.NewError(link.ProtocolError, "Unknown Control Message")
+var StringTooLongError = link.NewError(link.ProtocolError, "Control String Too Long")
+
+const (
+	markApp byte = iota
+	markControl
+)
+
+const (
+	kindPing byte = iota + 1
+	kindPong
+	kindKick
+	kindRedirect
+	kindMaintenance
+)
+
+const maxString = 1<<16 - 1
+
+// Ping and Pong are answered and consumed by the codec, they never reach
+// the application. See Keepalive.
+type Ping struct {
+	Time time.Time
+}
+
+type Pong struct {
+	Time time.Time // of the ping
+}
+
+// Kick tells the peer that the server is closing the session.
+type Kick struct {
+	Reason string
+}
+
+// Redirect asks the peer to reconnect to Address.
+type Redirect struct {
+	Address string
+	Reason  string
+}
+
+// Maintenance announces that the server goes down for maintenance at At,
+// for about Duration.
+type Maintenance struct {
+	At       time.Time
+	Duration time.Duration
+	Message  string
+}
+
+// ControlProtocol adds the control messages to base. Each frame starts with
+// one byte telling control and application messages apart. It is meant to be
+// the outermost protocol of a stream connection, so Bind can find it.
+type ControlProtocol struct {
+	base  link.Protocol
+	Clock clock.Clock // of keepalive and RTT, nil is clock.Default()
+}
+
+func Protocol(base link.Protocol) *ControlProtocol {
+	return &ControlProtocol{base: base}
+}
+
+func (p *ControlProtocol) NewCodec(rw io.ReadWriter) (cc link.Codec, err error) {
+	codec := &controlCodec{rw: rw, clock: clock.Or(p.Clock)}
+	codec.baseRW.RW = rw
+	codec.base, err = p.base.NewCodec(&codec.baseRW)
+	if err != nil {
+		return
+	}
+	codec.touch()
+	cc = codec
+	return
+}
+
+type controlCodec struct {
+	base     link.Codec
+	baseRW   buffered.Writer
+	rw       io.ReadWriter
+	clock    clock.Clock
+	session  atomic.Pointer[link.Session]
+	lastRecv atomic.Int64
+	recvBuf  [8]byte
+	sendBuf  []byte
+}
+
+func (c *controlCodec) touch() {
+	c.lastRecv.Store(c.clock.Now().UnixNano())
+}
+
+func (c *controlCodec) Receive() (interface{}, error) {
+	for {
+		mark := c.recvBuf[:1]
+		if _, err := io.ReadFull(c.rw, mark); err != nil {
+			return nil, err
+		}
+		c.touch()
+		if mark[0] == markApp {
+			return c.base.Receive()
+		}
+		if mark[0] != markControl {
+			return nil, UnknownControlError
+		}
+		msg, err := c.receiveControl()
+		if err != nil {
+			return nil, err
+		}
+		switch m := msg.(type) {
+		case *Ping:
+			if session := c.session.Load(); session != nil {
+				session.Send(&Pong{m.Time})
+			}
+		case *Pong:
+			// pongs answer the pings of Keepalive, which bound the session.
+			if session := c.session.Load(); session != nil {
+				session.SetRTT(c.clock.Since(m.Time))
+			}
+		default:
+			return msg, nil
+		}
+	}
+}
+
+func (c *controlCodec) receiveControl() (interface{}, error) {
+	b := c.recvBuf[:1]
+	if _, err := io.ReadFull(c.rw, b); err != nil {
+		return nil, err
+	}
+	switch kind := b[0]; kind {
+	case kindPing, kindPong:
+		b = c.recvBuf[:8]
+		if _, err := io.ReadFull(c.rw, b); err != nil {
+			return nil, err
+		}
+		t := time.Unix(0, int64(binary.LittleEndian.Uint64(b)))
+		if kind == kindPing {
+			return &Ping{t}, nil
+		}
+		return &Pong{t}, nil
+	case kindKick:
+		reason, err := c.readString()
+		return &Kick{reason}, err
+	case kindRedirect:
+		address, err := c.readString()
+		if err != nil {
+			return nil, err
+		}
+		reason, err := c.readString()
+		return &Redirect{address, reason}, err
+	case kindMaintenance:
+		b = c.recvBuf[:8]
+		if _, err := io.ReadFull(c.rw, b); err != nil {
+			return nil, err
+		}
+		at := time.Unix(0, int64(binary.LittleEndian.Uint64(b)))
+		if _, err := io.ReadFull(c.rw, b); err != nil {
+			return nil, err
+		}
+		duration := time.Duration(binary.LittleEndian.Uint64(b))
+		message, err := c.readString()
+		return &Maintenance{at, duration, message}, err
+	}
+	return nil, UnknownControlError
+}
+
+func (c *controlCodec) readString() (string, error) {
+	n := c.recvBuf[:2]
+	if _, err := io.ReadFull(c.rw, n); err != nil {
+		return "", err
+	}
+	b := make([]byte, binary.LittleEndian.Uint16(n))
+	if _, err := io.ReadFull(c.rw, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func appendString(b []byte, s string) ([]byte, error) {
+	if len(s) > maxString {
+		return nil, StringTooLongError
+	}
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...), nil
+}
+
+func (c *controlCodec) Send(msg interface{}) error {
+	if encoded, ok := msg.(link.Encoded); ok {
+		_, err := c.rw.Write(encoded)
+		return err
+	}
+	b := append(c.sendBuf[:0], markControl)
+	var err error
+	switch m := msg.(type) {
+	case *Ping:
+		b = append(b, kindPing)
+		b = binary.LittleEndian.AppendUint64(b, uint64(m.Time.UnixNano()))
+	case *Pong:
+		b = append(b, kindPong)
+		b = binary.LittleEndian.AppendUint64(b, uint64(m.Time.UnixNano()))
+	case *Kick:
+		b, err = appendString(append(b, kindKick), m.Reason)
+	case *Redirect:
+		b, err = appendString(append(b, kindRedirect), m.Address)
+		if err == nil {
+			b, err = appendString(b, m.Reason)
+		}
+	case *Maintenance:
+		b = append(b, kindMaintenance)
+		b = binary.LittleEndian.AppendUint64(b, uint64(m.At.UnixNano()))
+		b = binary.LittleEndian.AppendUint64(b, uint64(m.Duration))
+		b, err = appendString(b, m.Message)
+	default:
+		c.baseRW.Buf.Reset()
+		c.baseRW.Buf.WriteByte(markApp)
+		if err := c.base.Send(msg); err != nil {
+			return err
+		}
+		_, err := c.rw.Write(c.baseRW.Buf.Bytes())
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	c.sendBuf = b
+	_, err = c.rw.Write(b)
+	return err
+}
+
+func (c *controlCodec) Close() error {
+	return c.base.Close()
+}
+
+func codecOf(session *link.Session) (*controlCodec, error) {
+	c, ok := session.Codec().(*controlCodec)
+	if !ok {
+		return nil, NotControlError
+	}
+	return c, nil
+}
+
+// Bind lets the codec of session answer pings. Keepalive and Handler bind
+// the session as well.
+func Bind(session *link.Session) error {
+	c, err := codecOf(session)
+	if err != nil {
+		return err
+	}
+	c.session.Store(session)
+	return nil
+}
+
+// RTT returns the round trip time of session, pongs go to Session.RTT.
+func RTT(session *link.Session) time.Duration {
+	return session.RTT()
+}
+
+type KeepaliveConfig struct {
+	Interval time.Duration // between pings
+	Timeout  time.Duration // without any frame from the peer before closing
+}
+
+// Keepalive pings the peer every Interval and closes session when nothing
+// was received for Timeout.
+func Keepalive(session *link.Session, config KeepaliveConfig) error {
+	c, err := codecOf(session)
+	if err != nil {
+		return err
+	}
+	c.session.Store(session)
+	done := make(chan struct{})
+	session.AddCloseCallback(c, nil, func() { close(done) })
+	if session.IsClosed() {
+		return link.SessionClosedError
+	}
+	ticker := c.clock.NewTicker(config.Interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C():
+			case <-done:
+				return
+			}
+			last := time.Unix(0, c.lastRecv.Load())
+			if c.clock.Since(last) >= config.Timeout {
+				link.GetLogger().Info("link: keepalive timeout", "session", session.ID())
+				session.Close()
+				return
+			}
+			session.Send(&Ping{c.clock.Now()})
+		}
+	}()
+	return nil
+}
+
+type handler struct {
+	base   link.Handler
+	config KeepaliveConfig
+}
+
+// Handler runs Keepalive on every session before passing it to base.
+func Handler(base link.Handler, config KeepaliveConfig) link.Handler {
+	return &handler{base, config}
+}
+
+func (h *handler) HandleSession(session *link.Session) {
+	if err := Keepalive(session, h.config); err != nil {
+		link.GetLogger().Warn("link: keepalive not started", "session", session.ID(), "error", err)
+	}
+	h.base.HandleSession(session)
+}
@@ -0,0 +1,144 @@
+package control
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/clock"
+	"github.com/funny/link/codec"
+	"github.com/funny/utest"
+)
+
+type Chat struct {
+	Text string
+}
+
+func testProtocol(clk clock.Clock) link.Protocol {
+	json := codec.Json()
+	json.Register(Chat{})
+	protocol := Protocol(codec.FixLen(json, 2, binary.LittleEndian, 1024, 1024))
+	protocol.Clock = clk
+	return protocol
+}
+
+func Test_Messages(t *testing.T) {
+	client, server, err := link.Pipe(testProtocol(nil), 0)
+	utest.IsNilNow(t, err)
+	defer client.Close()
+
+	go func() {
+		server.Send(&Chat{"hi"})
+		server.Send(&Redirect{"10.0.0.2:8000", "rebalance"})
+		server.Send(&Kick{"bye"})
+	}()
+	msg, err := client.Receive()
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, msg.(*Chat).Text, "hi")
+	msg, err = client.Receive()
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, *msg.(*Redirect), Redirect{"10.0.0.2:8000", "rebalance"})
+	msg, err = client.Receive()
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, msg.(*Kick).Reason, "bye")
+}
+
+func Test_Keepalive(t *testing.T) {
+	client, server, err := link.Pipe(testProtocol(nil), 16)
+	utest.IsNilNow(t, err)
+	defer client.Close()
+	utest.IsNilNow(t, Bind(client))
+	utest.IsNilNow(t, Keepalive(server, KeepaliveConfig{Interval: 10 * time.Millisecond, Timeout: time.Second}))
+	go client.Receive()
+	go server.Receive()
+	for i := 0; RTT(server) == 0; i++ {
+		utest.Assert(t, i < 1000)
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func Test_KeepaliveTimeout(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1000, 0))
+	client, server, err := link.Pipe(testProtocol(clk), 16)
+	utest.IsNilNow(t, err)
+	defer client.Close()
+	utest.IsNilNow(t, Keepalive(server, KeepaliveConfig{Interval: time.Second, Timeout: 3 * time.Second}))
+
+	// the client never answers.
+	for i := 0; i < 3; i++ {
+		utest.Assert(t, !server.IsClosed())
+		clk.BlockUntil(1)
+		clk.Advance(time.Second)
+	}
+	for i := 0; !server.IsClosed(); i++ {
+		utest.Assert(t, i < 1000)
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func Test_Client(t *testing.T) {
+	echo := link.HandlerFunc(func(session *link.Session) {
+		for {
+			msg, err := session.Receive()
+			if err != nil {
+				return
+			}
+			session.Send(msg)
+		}
+	})
+	newer, err := link.Listen("tcp", "127.0.0.1:0", testProtocol(nil), 0, echo)
+	utest.IsNilNow(t, err)
+	go newer.Serve()
+	defer newer.Stop()
+
+	older, err := link.Listen("tcp", "127.0.0.1:0", testProtocol(nil), 0, link.HandlerFunc(func(session *link.Session) {
+		session.Send(&Maintenance{time.Unix(2000, 0), time.Hour, "upgrade"})
+		session.Send(&Redirect{newer.Listener().Addr().String(), "draining"})
+		session.Receive()
+	}))
+	utest.IsNilNow(t, err)
+	go older.Serve()
+	defer older.Stop()
+
+	dial := func(address string) (*link.Session, error) {
+		return link.Dial("tcp", address, testProtocol(nil), 0)
+	}
+	client, err := NewClient(older.Listener().Addr().String(), dial)
+	utest.IsNilNow(t, err)
+	defer client.Close()
+	var notices []*Maintenance
+	client.OnMaintenance = func(notice *Maintenance) {
+		notices = append(notices, notice)
+	}
+	first := client.Session()
+
+	// the echo comes from the new server.
+	go func() {
+		for client.Session() == first {
+			time.Sleep(time.Millisecond)
+		}
+		client.Send(&Chat{"hello"})
+	}()
+	msg, err := client.Receive()
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, msg.(*Chat).Text, "hello")
+	utest.Assert(t, first.IsClosed())
+	utest.EqualNow(t, len(notices), 1)
+	utest.EqualNow(t, *notices[0], Maintenance{time.Unix(2000, 0), time.Hour, "upgrade"})
+}
+
+func Test_SendFailureLeavesNoMark(t *testing.T) {
+	json := codec.Json()
+	json.Register(Chat{})
+	var stream bytes.Buffer
+	c, err := Protocol(json).NewCodec(&stream)
+	utest.IsNilNow(t, err)
+	utest.NotNilNow(t, c.Send(&struct{ C chan int }{}))
+	utest.EqualNow(t, stream.Len(), 0)
+	utest.IsNilNow(t, c.Send(&Chat{"hi"}))
+	msg, err := c.Receive()
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, msg.(*Chat).Text, "hi")
+}
@@ -0,0 +1,121 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/clock"
+)
+
+// ConsulResolver watches the healthy instances of a service with blocking
+// queries of the Consul HTTP API, so changes arrive at once.
+type ConsulResolver struct {
+	Address string // of the agent, default http://127.0.0.1:8500
+	Service string
+	Wait    time.Duration // of a blocking query, default 5m
+	Retry   time.Duration // after a failed query, default 1s
+	Client  *http.Client
+	Clock   clock.Clock // of Retry, nil is clock.Default()
+}
+
+func Consul(service string) *ConsulResolver {
+	return &ConsulResolver{
+		Address: "http://127.0.0.1:8500",
+		Service: service,
+		Wait:    5 * time.Minute,
+		Retry:   time.Second,
+		Client:  http.DefaultClient,
+	}
+}
+
+type consulEntry struct {
+	Node struct {
+		Address string
+	}
+	Service struct {
+		Address string
+		Port    int
+	}
+}
+
+func (r *ConsulResolver) Watch(ctx context.Context) <-chan []string {
+	ch := make(chan []string)
+	go func() {
+		defer close(ch)
+		var index uint64
+		var last []string
+		for first := true; ; {
+			addresses, next, err := r.query(ctx, index)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				link.GetLogger().Warn("discovery: consul query failed", "service", r.Service, "error", err)
+				if !sleep(ctx, r.Clock, r.Retry) {
+					return
+				}
+				continue
+			}
+			// the index may go backwards, e.g. when consul restarts.
+			if next < index {
+				next = 0
+			}
+			index = next
+			if first || !slices.Equal(addresses, last) {
+				if !send(ctx, ch, addresses) {
+					return
+				}
+				first, last = false, addresses
+			}
+			// without an index the query doesn't block.
+			if index == 0 && !sleep(ctx, r.Clock, r.Retry) {
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+func (r *ConsulResolver) query(ctx context.Context, index uint64) ([]string, uint64, error) {
+	query := url.Values{"passing": {"1"}}
+	if index > 0 {
+		query.Set("index", strconv.FormatUint(index, 10))
+		query.Set("wait", fmt.Sprintf("%ds", int(r.Wait.Seconds())))
+	}
+	u := r.Address + "/v1/health/service/" + url.PathEscape(r.Service) + "?" + query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	resp, err := r.Client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("discovery: consul status %s", resp.Status)
+	}
+	var entries []consulEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, 0, err
+	}
+	next, _ := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+	addresses := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		host := entry.Service.Address
+		if host == "" {
+			host = entry.Node.Address
+		}
+		addresses = append(addresses, net.JoinHostPort(host, strconv.Itoa(entry.Service.Port)))
+	}
+	slices.Sort(addresses)
+	return addresses, next, nil
+}
@@ -0,0 +1,133 @@
+package discovery
+
+import (
+	"context"
+	"net"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/clock"
+)
+
+// Resolver finds the addresses of the instances of a service.
+type Resolver interface {
+	// Watch sends the complete list of addresses as soon as it is known and
+	// again on every change. The channel is closed after ctx is done.
+	Watch(ctx context.Context) <-chan []string
+}
+
+type static []string
+
+// Static resolves to a fixed list of addresses.
+func Static(addresses ...string) Resolver {
+	return static(slices.Clone(addresses))
+}
+
+func (s static) Watch(ctx context.Context) <-chan []string {
+	ch := make(chan []string, 1)
+	ch <- slices.Clone(s)
+	go func() {
+		<-ctx.Done()
+		close(ch)
+	}()
+	return ch
+}
+
+// send returns false when ctx is done.
+func send(ctx context.Context, ch chan<- []string, addresses []string) bool {
+	select {
+	case ch <- addresses:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// sleep returns false when ctx is done first, a nil clk is the system
+// clock.
+func sleep(ctx context.Context, clk clock.Clock, d time.Duration) bool {
+	timer := clock.Or(clk).NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C():
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// poll watches by calling lookup every interval, for sources without change
+// notifications. Failed lookups keep the last list.
+func poll(ctx context.Context, clk clock.Clock, interval time.Duration, name string, lookup func(context.Context) ([]string, error)) <-chan []string {
+	ch := make(chan []string)
+	go func() {
+		defer close(ch)
+		var last []string
+		for first := true; ; first = false {
+			addresses, err := lookup(ctx)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				link.GetLogger().Warn("discovery: lookup failed", "service", name, "error", err)
+			} else {
+				slices.Sort(addresses)
+				if first || !slices.Equal(addresses, last) {
+					if !send(ctx, ch, addresses) {
+						return
+					}
+					last = addresses
+				}
+			}
+			if !sleep(ctx, clk, interval) {
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// DNSResolver looks up the SRV records of a service. DNS has no change
+// notifications, the records are looked up every Interval.
+type DNSResolver struct {
+	Service  string
+	Proto    string
+	Name     string
+	Interval time.Duration // default 30s
+	Resolver *net.Resolver // default net.DefaultResolver
+	Clock    clock.Clock   // of Interval, nil is clock.Default()
+}
+
+// DNS resolves _service._proto.name, see net.LookupSRV.
+func DNS(service, proto, name string) *DNSResolver {
+	return &DNSResolver{
+		Service:  service,
+		Proto:    proto,
+		Name:     name,
+		Interval: 30 * time.Second,
+		Resolver: net.DefaultResolver,
+	}
+}
+
+func (r *DNSResolver) Watch(ctx context.Context) <-chan []string {
+	return poll(ctx, r.Clock, r.Interval, r.Name, func(ctx context.Context) ([]string, error) {
+		_, records, err := r.Resolver.LookupSRV(ctx, r.Service, r.Proto, r.Name)
+		if err != nil {
+			return nil, err
+		}
+		addresses := make([]string, len(records))
+		for i, srv := range records {
+			addresses[i] = net.JoinHostPort(trimDot(srv.Target), strconv.Itoa(int(srv.Port)))
+		}
+		return addresses, nil
+	})
+}
+
+func trimDot(host string) string {
+	if len(host) > 0 && host[len(host)-1] == '.' {
+		return host[:len(host)-1]
+	}
+	return host
+}
@@ -0,0 +1,102 @@
+package discovery
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/funny/utest"
+)
+
+func next(t *testing.T, ch <-chan []string) []string {
+	select {
+	case addresses := <-ch:
+		return addresses
+	case <-time.After(5 * time.Second):
+		t.Fatal("no addresses")
+		return nil
+	}
+}
+
+func Test_Static(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := Static("a:1", "b:2").Watch(ctx)
+	utest.EqualNow(t, next(t, ch), []string{"a:1", "b:2"})
+	cancel()
+	_, ok := <-ch
+	utest.Assert(t, !ok)
+}
+
+func Test_Consul(t *testing.T) {
+	changed := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		utest.EqualNow(t, r.URL.Path, "/v1/health/service/game")
+		entries := `[{"Node":{"Address":"10.0.0.1"},"Service":{"Address":"","Port":8000}}]`
+		index := "5"
+		if r.URL.Query().Get("index") == "5" {
+			select {
+			case <-changed:
+			case <-r.Context().Done():
+				return
+			}
+			entries = `[{"Node":{"Address":"10.0.0.1"},"Service":{"Address":"","Port":8000}},
+				{"Node":{"Address":"10.0.0.9"},"Service":{"Address":"10.0.0.2","Port":8000}}]`
+			index = "6"
+		}
+		w.Header().Set("X-Consul-Index", index)
+		io.WriteString(w, entries)
+	}))
+	defer server.Close()
+
+	resolver := Consul("game")
+	resolver.Address = server.URL
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := resolver.Watch(ctx)
+	utest.EqualNow(t, next(t, ch), []string{"10.0.0.1:8000"})
+	close(changed)
+	utest.EqualNow(t, next(t, ch), []string{"10.0.0.1:8000", "10.0.0.2:8000"})
+}
+
+func Test_Etcd(t *testing.T) {
+	b64 := func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+	events := make(chan string)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&req)
+		switch r.URL.Path {
+		case "/v3/kv/range":
+			utest.EqualNow(t, req["key"], b64("/game/"))
+			utest.EqualNow(t, req["range_end"], b64("/game0"))
+			fmt.Fprintf(w, `{"header":{"revision":"7"},"kvs":[{"key":"%s","value":"%s"}]}`, b64("/game/1"), b64("10.0.0.1:8000"))
+		case "/v3/watch":
+			create := req["create_request"].(map[string]interface{})
+			utest.EqualNow(t, create["start_revision"], "8")
+			io.WriteString(w, `{"result":{"header":{"revision":"7"},"created":true}}`+"\n")
+			w.(http.Flusher).Flush()
+			for event := range events {
+				io.WriteString(w, `{"result":{"events":[`+event+`]}}`+"\n")
+				w.(http.Flusher).Flush()
+			}
+		}
+	}))
+	defer server.Close()
+	defer close(events)
+
+	resolver := Etcd("/game/")
+	resolver.Endpoint = server.URL
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := resolver.Watch(ctx)
+	utest.EqualNow(t, next(t, ch), []string{"10.0.0.1:8000"})
+	events <- fmt.Sprintf(`{"kv":{"key":"%s","value":"%s"}}`, b64("/game/2"), b64("10.0.0.2:8000"))
+	utest.EqualNow(t, next(t, ch), []string{"10.0.0.1:8000", "10.0.0.2:8000"})
+	events <- fmt.Sprintf(`{"type":"DELETE","kv":{"key":"%s"}}`, b64("/game/1"))
+	utest.EqualNow(t, next(t, ch), []string{"10.0.0.2:8000"})
+}
@@ -0,0 +1,191 @@
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/clock"
+)
+
+// EtcdResolver watches the keys under Prefix with the JSON gateway of the
+// etcd v3 API, the values of the keys are the addresses. Instances usually
+// put their key with a lease, so it goes away with them.
+type EtcdResolver struct {
+	Endpoint string // default http://127.0.0.1:2379
+	Prefix   string
+	Retry    time.Duration // after a failed request, default 1s
+	Client   *http.Client
+	Clock    clock.Clock // of Retry, nil is clock.Default()
+}
+
+func Etcd(prefix string) *EtcdResolver {
+	return &EtcdResolver{
+		Endpoint: "http://127.0.0.1:2379",
+		Prefix:   prefix,
+		Retry:    time.Second,
+		Client:   http.DefaultClient,
+	}
+}
+
+type etcdKV struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+}
+
+type etcdHeader struct {
+	Revision string `json:"revision"`
+}
+
+func (h etcdHeader) revision() int64 {
+	n, _ := strconv.ParseInt(h.Revision, 10, 64)
+	return n
+}
+
+// rangeEnd is the end of the keys with prefix, see the etcd range request.
+func rangeEnd(prefix string) []byte {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return []byte{0}
+}
+
+func (r *EtcdResolver) post(ctx context.Context, path string, body interface{}) (*http.Response, error) {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.Endpoint+path, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := r.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("discovery: etcd status %s", resp.Status)
+	}
+	return resp, nil
+}
+
+func (r *EtcdResolver) list(ctx context.Context) (map[string]string, int64, error) {
+	resp, err := r.post(ctx, "/v3/kv/range", map[string]interface{}{
+		"key":       []byte(r.Prefix),
+		"range_end": rangeEnd(r.Prefix),
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	var result struct {
+		Header etcdHeader `json:"header"`
+		KVs    []etcdKV   `json:"kvs"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, 0, err
+	}
+	kvs := make(map[string]string, len(result.KVs))
+	for _, kv := range result.KVs {
+		kvs[string(kv.Key)] = string(kv.Value)
+	}
+	return kvs, result.Header.revision(), nil
+}
+
+// watch applies the events after revision to kvs and calls changed, until
+// the stream breaks.
+func (r *EtcdResolver) watch(ctx context.Context, kvs map[string]string, revision int64, changed func() bool) error {
+	resp, err := r.post(ctx, "/v3/watch", map[string]interface{}{
+		"create_request": map[string]interface{}{
+			"key":            []byte(r.Prefix),
+			"range_end":      rangeEnd(r.Prefix),
+			"start_revision": strconv.FormatInt(revision+1, 10),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var msg struct {
+			Result struct {
+				Events []struct {
+					Type string `json:"type"`
+					KV   etcdKV `json:"kv"`
+				} `json:"events"`
+				Canceled bool `json:"canceled"`
+			} `json:"result"`
+		}
+		if err := decoder.Decode(&msg); err != nil {
+			return err
+		}
+		if msg.Result.Canceled {
+			return fmt.Errorf("discovery: etcd watch canceled")
+		}
+		if len(msg.Result.Events) == 0 {
+			continue
+		}
+		for _, event := range msg.Result.Events {
+			if event.Type == "DELETE" {
+				delete(kvs, string(event.KV.Key))
+			} else {
+				kvs[string(event.KV.Key)] = string(event.KV.Value)
+			}
+		}
+		if !changed() {
+			return ctx.Err()
+		}
+	}
+}
+
+func (r *EtcdResolver) Watch(ctx context.Context) <-chan []string {
+	ch := make(chan []string)
+	go func() {
+		defer close(ch)
+		var last []string
+		first := true
+		update := func(kvs map[string]string) bool {
+			addresses := make([]string, 0, len(kvs))
+			for _, address := range kvs {
+				addresses = append(addresses, address)
+			}
+			slices.Sort(addresses)
+			addresses = slices.Compact(addresses)
+			if !first && slices.Equal(addresses, last) {
+				return true
+			}
+			first, last = false, addresses
+			return send(ctx, ch, addresses)
+		}
+		for {
+			kvs, revision, err := r.list(ctx)
+			if err == nil {
+				if !update(kvs) {
+					return
+				}
+				err = r.watch(ctx, kvs, revision, func() bool { return update(kvs) })
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			link.GetLogger().Warn("discovery: etcd watch failed", "prefix", r.Prefix, "error", err)
+			if !sleep(ctx, r.Clock, r.Retry) {
+				return
+			}
+		}
+	}()
+	return ch
+}
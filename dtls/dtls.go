@@ -0,0 +1,110 @@
+package dtls
+
+import (
+	"io"
+	"net"
+	"sync"
+
+	"github.com/funny/link"
+	"github.com/funny/link/udp"
+	piondtls "github.com/pion/dtls/v2"
+)
+
+type Listener struct {
+	base      net.Listener
+	config    *piondtls.Config
+	connChan  chan net.Conn
+	closeOnce sync.Once
+	closeChan chan struct{}
+}
+
+func NewListener(base net.Listener, config *piondtls.Config) *Listener {
+	listener := &Listener{
+		base:      base,
+		config:    config,
+		connChan:  make(chan net.Conn),
+		closeChan: make(chan struct{}),
+	}
+	go listener.acceptLoop()
+	return listener
+}
+
+func (listener *Listener) acceptLoop() {
+	defer listener.Close()
+	for {
+		conn, err := link.Accept(listener.base)
+		if err != nil {
+			return
+		}
+		go listener.handshake(conn)
+	}
+}
+
+func (listener *Listener) handshake(conn net.Conn) {
+	dconn, err := piondtls.Server(conn, listener.config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	select {
+	case listener.connChan <- dconn:
+	case <-listener.closeChan:
+		dconn.Close()
+	}
+}
+
+func (listener *Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-listener.connChan:
+		return conn, nil
+	case <-listener.closeChan:
+		return nil, io.EOF
+	}
+}
+
+func (listener *Listener) Close() error {
+	var err error
+	listener.closeOnce.Do(func() {
+		close(listener.closeChan)
+		err = listener.base.Close()
+	})
+	return err
+}
+
+func (listener *Listener) Addr() net.Addr {
+	return listener.base.Addr()
+}
+
+func Listen(network, address string, udpConfig udp.Config, config *piondtls.Config, protocol link.Protocol, sendChanSize int, handler link.Handler) (*link.Server, error) {
+	base, err := udp.ListenPacket(network, address, udpConfig)
+	if err != nil {
+		return nil, err
+	}
+	return link.NewServer(NewListener(base, config), protocol, sendChanSize, handler), nil
+}
+
+func DialConn(network, address string, config *piondtls.Config) (net.Conn, error) {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	dconn, err := piondtls.Client(conn, config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return dconn, nil
+}
+
+func Dial(network, address string, config *piondtls.Config, protocol link.Protocol, sendChanSize int) (*link.Session, error) {
+	conn, err := DialConn(network, address, config)
+	if err != nil {
+		return nil, err
+	}
+	codec, err := protocol.NewCodec(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return link.NewSession(codec, sendChanSize), nil
+}
@@ -0,0 +1,58 @@
+package dtls
+
+import (
+	"testing"
+
+	"github.com/funny/link"
+	"github.com/funny/link/codec"
+	"github.com/funny/link/udp"
+	"github.com/funny/utest"
+	piondtls "github.com/pion/dtls/v2"
+)
+
+type EchoMsg struct {
+	Text string
+}
+
+func testConfig() *piondtls.Config {
+	return &piondtls.Config{
+		PSK: func(hint []byte) ([]byte, error) {
+			return []byte{0xAB, 0xC1, 0x23}, nil
+		},
+		PSKIdentityHint: []byte("link"),
+		CipherSuites:    []piondtls.CipherSuiteID{piondtls.TLS_PSK_WITH_AES_128_GCM_SHA256},
+	}
+}
+
+func Test_DTLS(t *testing.T) {
+	json := codec.Json()
+	json.Register(EchoMsg{})
+	protocol := codec.Packet(json, 1024, 1024)
+
+	server, err := Listen("udp", "127.0.0.1:0", udp.DefaultConfig, testConfig(), protocol, 0, link.HandlerFunc(func(session *link.Session) {
+		defer session.Close()
+		for {
+			msg, err := session.Receive()
+			if err != nil {
+				return
+			}
+			if session.Send(msg) != nil {
+				return
+			}
+		}
+	}))
+	utest.IsNilNow(t, err)
+	go server.Serve()
+	defer server.Stop()
+
+	session, err := Dial("udp", server.Listener().Addr().String(), testConfig(), protocol, 0)
+	utest.IsNilNow(t, err)
+	defer session.Close()
+
+	for i := 0; i < 100; i++ {
+		utest.IsNilNow(t, session.Send(&EchoMsg{"secret"}))
+		msg, err := session.Receive()
+		utest.IsNilNow(t, err)
+		utest.EqualNow(t, msg.(*EchoMsg).Text, "secret")
+	}
+}
@@ -0,0 +1,302 @@
+package dump
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/funny/link"
+)
+
+var NotDumpableError = link.NewError(link.PolicyError, "Session Codec Not Dumpable")
+var BadFormatError = link.NewError(link.ProtocolError, "Bad Dump Format")
+
+const magic = "LINKDUMP\x01"
+
+type Direction byte
+
+const (
+	Recv Direction = iota + 1
+	Send
+)
+
+// Record is one frame in a dump: the bytes read during one Receive or
+// written during one Send of a codec.
+type Record struct {
+	Time      time.Time
+	SessionID uint64
+	Direction Direction
+	Data      []byte
+}
+
+// recordHead is time, session ID, direction and data length.
+const recordHead = 8 + 8 + 1 + 4
+
+// Dumper writes records to w in a simple binary format, read them back with
+// NewReader. All sessions share one Dumper.
+type Dumper struct {
+	mutex    sync.Mutex
+	w        *bufio.Writer
+	err      error
+	all      bool
+	header   bool
+	recvOnly bool
+}
+
+func NewDumper(w io.Writer) *Dumper {
+	return &Dumper{w: bufio.NewWriter(w)}
+}
+
+// EnableAll turns dumping on or off for codecs which were not toggled with
+// Enable or Disable.
+func (d *Dumper) EnableAll(on bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.all = on
+}
+
+func (d *Dumper) write(r *Record) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.err != nil {
+		return
+	}
+	if !d.header {
+		d.header = true
+		if _, d.err = d.w.WriteString(magic); d.err != nil {
+			return
+		}
+	}
+	var head [recordHead]byte
+	binary.LittleEndian.PutUint64(head[0:], uint64(r.Time.UnixNano()))
+	binary.LittleEndian.PutUint64(head[8:], r.SessionID)
+	head[16] = byte(r.Direction)
+	binary.LittleEndian.PutUint32(head[17:], uint32(len(r.Data)))
+	if _, d.err = d.w.Write(head[:]); d.err == nil {
+		_, d.err = d.w.Write(r.Data)
+	}
+}
+
+func (d *Dumper) Flush() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.err != nil {
+		return d.err
+	}
+	return d.w.Flush()
+}
+
+func (d *Dumper) codecOf(session *link.Session) (*dumpCodec, error) {
+	c, ok := session.Codec().(*dumpCodec)
+	if !ok || c.d != d {
+		return nil, NotDumpableError
+	}
+	atomic.StoreUint64(&c.sessionID, session.ID())
+	return c, nil
+}
+
+// Bind tags the records of the session codec with the session ID. Enable
+// and Handler do it as well; records of unbound codecs carry ID 0.
+func (d *Dumper) Bind(session *link.Session) error {
+	_, err := d.codecOf(session)
+	return err
+}
+
+type handler struct {
+	d    *Dumper
+	base link.Handler
+}
+
+// Handler binds every session passed to base.
+func (d *Dumper) Handler(base link.Handler) link.Handler {
+	return &handler{d, base}
+}
+
+func (h *handler) HandleSession(session *link.Session) {
+	h.d.Bind(session)
+	h.base.HandleSession(session)
+}
+
+// Enable starts dumping the frames of session. The session codec must be
+// created by Protocol of this Dumper.
+func (d *Dumper) Enable(session *link.Session) error {
+	c, err := d.codecOf(session)
+	if err == nil {
+		atomic.StoreInt32(&c.state, stateOn)
+	}
+	return err
+}
+
+func (d *Dumper) Disable(session *link.Session) error {
+	c, err := d.codecOf(session)
+	if err == nil {
+		atomic.StoreInt32(&c.state, stateOff)
+	}
+	return err
+}
+
+type protocol struct {
+	d    *Dumper
+	base link.Protocol
+}
+
+// Protocol wraps base so that its frames can be dumped. Codecs reading
+// ahead, such as Bufio, put the bytes in the record of the read that got
+// them from the connection.
+func (d *Dumper) Protocol(base link.Protocol) link.Protocol {
+	return &protocol{d, base}
+}
+
+func (p *protocol) NewCodec(rw io.ReadWriter) (link.Codec, error) {
+	c := &dumpCodec{d: p.d}
+	c.rw.ReadWriter = rw
+	c.rw.c = c
+	base, err := p.base.NewCodec(&c.rw)
+	if err != nil {
+		return nil, err
+	}
+	c.base = base
+	return c, nil
+}
+
+const (
+	stateDefault int32 = iota
+	stateOn
+	stateOff
+)
+
+type dumpCodec struct {
+	base      link.Codec
+	d         *Dumper
+	rw        teeReadWriter
+	sessionID uint64
+	state     int32
+	recvBuf   bytes.Buffer
+	sendBuf   bytes.Buffer
+}
+
+func (c *dumpCodec) enabled() bool {
+	switch atomic.LoadInt32(&c.state) {
+	case stateOn:
+		return true
+	case stateOff:
+		return false
+	}
+	c.d.mutex.Lock()
+	defer c.d.mutex.Unlock()
+	return c.d.all
+}
+
+type teeReadWriter struct {
+	io.ReadWriter
+	c      *dumpCodec
+	recvOn bool
+	sendOn bool
+}
+
+func (rw *teeReadWriter) Read(p []byte) (int, error) {
+	n, err := rw.ReadWriter.Read(p)
+	if rw.recvOn {
+		rw.c.recvBuf.Write(p[:n])
+	}
+	return n, err
+}
+
+func (rw *teeReadWriter) Write(p []byte) (int, error) {
+	n, err := rw.ReadWriter.Write(p)
+	if rw.sendOn {
+		rw.c.sendBuf.Write(p[:n])
+	}
+	return n, err
+}
+
+func (rw *teeReadWriter) Close() error {
+	if closer, ok := rw.ReadWriter.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (c *dumpCodec) emit(dir Direction, buf *bytes.Buffer) {
+	data := make([]byte, buf.Len())
+	copy(data, buf.Bytes())
+	buf.Reset()
+	c.d.write(&Record{time.Now(), atomic.LoadUint64(&c.sessionID), dir, data})
+}
+
+func (c *dumpCodec) Receive() (interface{}, error) {
+	c.rw.recvOn = c.enabled()
+	msg, err := c.base.Receive()
+	if c.rw.recvOn && c.recvBuf.Len() > 0 {
+		c.emit(Recv, &c.recvBuf)
+	}
+	return msg, err
+}
+
+func (c *dumpCodec) Send(msg interface{}) error {
+	c.rw.sendOn = !c.d.recvOnly && c.enabled()
+	err := c.base.Send(msg)
+	if c.rw.sendOn && c.sendBuf.Len() > 0 {
+		c.emit(Send, &c.sendBuf)
+	}
+	return err
+}
+
+func (c *dumpCodec) Close() error {
+	return c.base.Close()
+}
+
+func (c *dumpCodec) ClearSendChan(ch <-chan interface{}) {
+	if clear, ok := c.base.(link.ClearSendChan); ok {
+		clear.ClearSendChan(ch)
+	}
+}
+
+type Reader struct {
+	r      *bufio.Reader
+	header bool
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// Next returns the next record, io.EOF at the end of the dump.
+func (r *Reader) Next() (*Record, error) {
+	if !r.header {
+		var head [len(magic)]byte
+		if _, err := io.ReadFull(r.r, head[:]); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				return nil, BadFormatError
+			}
+			return nil, err
+		}
+		if string(head[:]) != magic {
+			return nil, BadFormatError
+		}
+		r.header = true
+	}
+	var head [recordHead]byte
+	if _, err := io.ReadFull(r.r, head[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, BadFormatError
+		}
+		return nil, err
+	}
+	record := &Record{
+		Time:      time.Unix(0, int64(binary.LittleEndian.Uint64(head[0:]))),
+		SessionID: binary.LittleEndian.Uint64(head[8:]),
+		Direction: Direction(head[16]),
+	}
+	// don't trust the length for the allocation, a broken dump would need GBs.
+	var data bytes.Buffer
+	if _, err := io.CopyN(&data, r.r, int64(binary.LittleEndian.Uint32(head[17:]))); err != nil {
+		return nil, BadFormatError
+	}
+	record.Data = data.Bytes()
+	return record, nil
+}
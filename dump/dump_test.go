@@ -0,0 +1,113 @@
+package dump
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"github.com/funny/link"
+	"github.com/funny/link/codec"
+	"github.com/funny/utest"
+)
+
+type Msg struct {
+	Text string
+}
+
+func Test_Dump(t *testing.T) {
+	var buf bytes.Buffer
+	d := NewDumper(&buf)
+	json := codec.Json()
+	json.Register(Msg{})
+	protocol := d.Protocol(codec.FixLen(json, 2, binary.LittleEndian, 1024, 1024))
+
+	client, server, err := link.Pipe(protocol, 0)
+	utest.IsNilNow(t, err)
+	defer client.Close()
+
+	// not enabled yet, nothing is dumped.
+	utest.IsNilNow(t, client.Send(&Msg{"skip"}))
+	_, err = server.Receive()
+	utest.IsNilNow(t, err)
+
+	utest.IsNilNow(t, d.Enable(client))
+	utest.IsNilNow(t, client.Send(&Msg{"hello"}))
+	_, err = server.Receive()
+	utest.IsNilNow(t, err)
+
+	utest.IsNilNow(t, d.Enable(server))
+	utest.IsNilNow(t, server.Send(&Msg{"world"}))
+	_, err = client.Receive()
+	utest.IsNilNow(t, err)
+
+	utest.IsNilNow(t, d.Disable(client))
+	utest.IsNilNow(t, d.Disable(server))
+	utest.IsNilNow(t, client.Send(&Msg{"skip"}))
+	_, err = server.Receive()
+	utest.IsNilNow(t, err)
+	utest.IsNilNow(t, d.Flush())
+
+	r := NewReader(&buf)
+	var records []*Record
+	for {
+		record, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		utest.IsNilNow(t, err)
+		records = append(records, record)
+	}
+	utest.EqualNow(t, len(records), 3)
+	utest.EqualNow(t, records[0].Direction, Send)
+	utest.EqualNow(t, records[0].SessionID, client.ID())
+	utest.Assert(t, bytes.Contains(records[0].Data, []byte("hello")))
+	utest.EqualNow(t, records[1].Direction, Send)
+	utest.EqualNow(t, records[1].SessionID, server.ID())
+	utest.EqualNow(t, records[2].Direction, Recv)
+	utest.EqualNow(t, records[2].SessionID, client.ID())
+	utest.EqualNow(t, records[2].Data, records[1].Data)
+
+	other := link.NewSession(nil, 0)
+	utest.EqualNow(t, d.Enable(other), NotDumpableError)
+	utest.EqualNow(t, link.Classify(NotDumpableError), link.PolicyError)
+}
+
+func Test_Replay(t *testing.T) {
+	json := codec.Json()
+	json.Register(Msg{})
+	base := codec.FixLen(json, 2, binary.LittleEndian, 1024, 1024)
+	echo := link.HandlerFunc(func(session *link.Session) {
+		for {
+			msg, err := session.Receive()
+			if err != nil {
+				return
+			}
+			session.Send(&Msg{"re: " + msg.(*Msg).Text})
+		}
+	})
+
+	var buf bytes.Buffer
+	recorder := NewRecorder(&buf)
+	client, server, err := link.Pipe(recorder.Protocol(base), 0)
+	utest.IsNilNow(t, err)
+	go recorder.Handler(echo).HandleSession(server)
+	for _, text := range []string{"a", "b", "c"} {
+		utest.IsNilNow(t, client.Send(&Msg{text}))
+		_, err := client.Receive()
+		utest.IsNilNow(t, err)
+	}
+	client.Close()
+	utest.IsNilNow(t, recorder.Flush())
+
+	records, err := Load(bytes.NewReader(buf.Bytes()), server.ID())
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, len(records), 3)
+
+	out1, err := Replay(records, base, echo)
+	utest.IsNilNow(t, err)
+	out2, err := Replay(records, base, echo)
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, out1, out2)
+	utest.Assert(t, bytes.Contains(out1, []byte("re: c")))
+}
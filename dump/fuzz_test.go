@@ -0,0 +1,19 @@
+package dump
+
+import (
+	"bytes"
+	"testing"
+)
+
+func FuzzReader(f *testing.F) {
+	f.Add([]byte(magic))
+	f.Add([]byte(magic + "\x00\x00\x00\x00\x00\x00\x00\x00\x01\x00\x00\x00\x00\x00\x00\x00\x00\x03\x00\x00\x00abc"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		r := NewReader(bytes.NewReader(data))
+		for {
+			if _, err := r.Next(); err != nil {
+				return
+			}
+		}
+	})
+}
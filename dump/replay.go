@@ -0,0 +1,75 @@
+package dump
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/funny/link"
+)
+
+// NewRecorder returns a Dumper which only keeps the inbound frames, and is
+// enabled for all sessions, the input needed by Replay.
+func NewRecorder(w io.Writer) *Dumper {
+	d := NewDumper(w)
+	d.all = true
+	d.recvOnly = true
+	return d
+}
+
+// Load reads the inbound records of one session from a dump. When
+// sessionID is 0 the first session found in the dump is used.
+func Load(r io.Reader, sessionID uint64) ([]*Record, error) {
+	reader := NewReader(r)
+	var records []*Record
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if record.Direction != Recv {
+			continue
+		}
+		if sessionID == 0 {
+			sessionID = record.SessionID
+		}
+		if record.SessionID == sessionID {
+			records = append(records, record)
+		}
+	}
+}
+
+type replayConn struct {
+	r   io.Reader
+	out bytes.Buffer
+}
+
+func (c *replayConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+func (c *replayConn) Write(p []byte) (int, error) {
+	return c.out.Write(p)
+}
+
+// Replay runs handler on a session whose connection yields the recorded
+// frames in order and then io.EOF. It returns after the handler returned,
+// together with everything the handler wrote back, so the output can be
+// compared against a known good run.
+func Replay(records []*Record, protocol link.Protocol, handler link.Handler) ([]byte, error) {
+	readers := make([]io.Reader, len(records))
+	for i, record := range records {
+		readers[i] = bytes.NewReader(record.Data)
+	}
+	conn := &replayConn{r: io.MultiReader(readers...)}
+	codec, err := protocol.NewCodec(conn)
+	if err != nil {
+		return nil, err
+	}
+	session := link.NewSession(codec, 0)
+	handler.HandleSession(session)
+	session.Close()
+	return conn.out.Bytes(), nil
+}
@@ -0,0 +1,81 @@
+package link
+
+import (
+	"errors"
+	"io"
+	"net"
+	"syscall"
+)
+
+// Error classes. Every error of this package and its sub packages belongs to
+// at most one class, test it with errors.Is or get it with Classify:
+//
+//	TransportError: the connection is broken, reconnect.
+//	ProtocolError:  the peer sent something we can't decode, log it and drop the peer.
+//	HandlerError:   the application handler failed.
+//	PolicyError:    the request was rejected on purpose (overload, breaker, limit).
+var (
+	TransportError = errors.New("Transport Error")
+	ProtocolError  = errors.New("Protocol Error")
+	HandlerError   = errors.New("Handler Error")
+	PolicyError    = errors.New("Policy Error")
+)
+
+// Error is an error with a class. The sentinels of this package are *Error,
+// so they compare with == as before and also match their class with errors.Is.
+type Error struct {
+	Class error
+	Msg   string
+	Err   error
+}
+
+// NewError creates a sentinel error of class.
+func NewError(class error, msg string) error {
+	return &Error{Class: class, Msg: msg}
+}
+
+// WrapError puts err into class, errors.As still finds err.
+func WrapError(class error, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Class: class, Err: err}
+}
+
+func (e *Error) Error() string {
+	switch {
+	case e.Msg == "":
+		return e.Err.Error()
+	case e.Err == nil:
+		return e.Msg
+	}
+	return e.Msg + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() []error {
+	if e.Err == nil {
+		return []error{e.Class}
+	}
+	return []error{e.Class, e.Err}
+}
+
+// Classify returns the class of err, nil when it doesn't know. Errors of the
+// io, net and syscall packages are transport errors.
+func Classify(err error) error {
+	if err == nil {
+		return nil
+	}
+	for _, class := range []error{TransportError, ProtocolError, HandlerError, PolicyError} {
+		if errors.Is(err, class) {
+			return class
+		}
+	}
+	var netErr net.Error
+	var errno syscall.Errno
+	switch {
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.ErrClosedPipe),
+		errors.Is(err, net.ErrClosed), errors.As(err, &netErr), errors.As(err, &errno):
+		return TransportError
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package link
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/funny/link/clock"
+)
+
+type fanoutTask struct {
+	session *Session
+	msg     interface{}
+}
+
+// defaultFanoutTimeout bounds the sends of a Fanout made without a timeout.
+const defaultFanoutTimeout = 5 * time.Second
+
+// Fanout delivers messages to sessions through a fixed set of workers.
+// Messages of one session are always handled by the same worker so they keep
+// their order. A member whose queue is full misses the message, and a member
+// which can't finish a send within timeout is closed. Until then a slow
+// member holds up the other members of its worker, sessions with a send
+// queue never block it.
+type Fanout struct {
+	queues    []chan fanoutTask
+	timeout   time.Duration
+	dropped   uint64
+	timedOut  uint64
+	mutex     sync.RWMutex
+	closed    bool
+	closeWait sync.WaitGroup
+}
+
+// NewFanout starts workers workers with a queue of queueSize each, there is
+// at least one worker and a timeout of 0 is 5 seconds.
+func NewFanout(workers, queueSize int, timeout time.Duration) *Fanout {
+	if workers < 1 {
+		workers = 1
+	}
+	if timeout <= 0 {
+		timeout = defaultFanoutTimeout
+	}
+	fanout := &Fanout{
+		queues:  make([]chan fanoutTask, workers),
+		timeout: timeout,
+	}
+	for i := 0; i < workers; i++ {
+		fanout.queues[i] = make(chan fanoutTask, queueSize)
+		fanout.closeWait.Add(1)
+		go fanout.worker(fanout.queues[i])
+	}
+	return fanout
+}
+
+func (fanout *Fanout) worker(queue chan fanoutTask) {
+	defer fanout.closeWait.Done()
+	for task := range queue {
+		if task.session.IsClosed() {
+			continue
+		}
+		session := task.session
+		timer := clock.Default().AfterFunc(fanout.timeout, func() {
+			atomic.AddUint64(&fanout.timedOut, 1)
+			GetLogger().Warn("link: fanout send timed out, closing session", "session", session.ID())
+			session.Close()
+		})
+		session.Send(task.msg)
+		timer.Stop()
+	}
+}
+
+// Send queues msg for session, false when the queue is full or the fanout
+// is closed.
+func (fanout *Fanout) Send(session *Session, msg interface{}) bool {
+	fanout.mutex.RLock()
+	defer fanout.mutex.RUnlock()
+	if fanout.closed {
+		return false
+	}
+	queue := fanout.queues[session.ID()%uint64(len(fanout.queues))]
+	select {
+	case queue <- fanoutTask{session, msg}:
+		return true
+	default:
+		atomic.AddUint64(&fanout.dropped, 1)
+		GetLogger().Debug("link: fanout queue full, message dropped", "session", session.ID())
+		return false
+	}
+}
+
+func (fanout *Fanout) Broadcast(channel *Channel, msg interface{}) (dropped int) {
+	channel.Fetch(func(session *Session) {
+		if !fanout.Send(session, msg) {
+			dropped++
+		}
+	})
+	return
+}
+
+func (fanout *Fanout) Dropped() uint64 {
+	return atomic.LoadUint64(&fanout.dropped)
+}
+
+func (fanout *Fanout) TimedOut() uint64 {
+	return atomic.LoadUint64(&fanout.timedOut)
+}
+
+func (fanout *Fanout) Close() {
+	fanout.mutex.Lock()
+	if !fanout.closed {
+		fanout.closed = true
+		for _, queue := range fanout.queues {
+			close(queue)
+		}
+	}
+	fanout.mutex.Unlock()
+	fanout.closeWait.Wait()
+}
@@ -0,0 +1,188 @@
+package gateway
+
+import (
+	"encoding/binary"
+	"io"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/clock"
+)
+
+// AffinityLabel is the session label under which a Backend with
+// ForwardedKeys puts the key of a client, see Config.ForwardKey.
+const AffinityLabel = "affinity"
+
+// defaultPinIdle is Config.PinIdle when it isn't set.
+const defaultPinIdle = 10 * time.Minute
+
+// pin places the clients of a key ahead of the ring. idle is when the last
+// client of the key left, zero while it has clients.
+type pin struct {
+	address string
+	sticky  bool
+	idle    time.Time
+}
+
+// Pin sends the clients of key to the backend at address from now on, ahead
+// of the ring, for moving a user by hand. Connected clients of key on other
+// backends are closed so they reconnect to it.
+func (g *Gateway) Pin(key, address string) {
+	g.pinMutex.Lock()
+	g.pins[key] = &pin{address: address}
+	g.pinMutex.Unlock()
+
+	g.connMutex.Lock()
+	defer g.connMutex.Unlock()
+	for conn, c := range g.conns {
+		if c.key == key && c.backend != "" && c.backend != address {
+			conn.Close()
+		}
+	}
+}
+
+// Unpin lets the ring place the clients of key again, connected clients
+// stay where they are.
+func (g *Gateway) Unpin(key string) {
+	g.pinMutex.Lock()
+	defer g.pinMutex.Unlock()
+	delete(g.pins, key)
+}
+
+// Affinity returns the backend key is pinned to, empty when it isn't.
+func (g *Gateway) Affinity(key string) string {
+	g.pinMutex.Lock()
+	defer g.pinMutex.Unlock()
+	if p := g.pins[key]; p != nil {
+		return p.address
+	}
+	return ""
+}
+
+// connected counts a client of key on the backend at address. With Sticky
+// it pins key to address unless it is pinned already, so a client which fell
+// back to another backend while its own one restarts goes back to it next
+// time.
+func (g *Gateway) connected(key, address string) {
+	g.pinMutex.Lock()
+	defer g.pinMutex.Unlock()
+	g.keyed[key]++
+	p := g.pins[key]
+	if p == nil && g.config.Sticky {
+		p = &pin{address: address, sticky: true}
+		g.pins[key] = p
+	}
+	if p != nil {
+		p.idle = time.Time{}
+	}
+}
+
+// disconnected starts the idle time of the pin of key when its last client
+// left.
+func (g *Gateway) disconnected(key string) {
+	g.pinMutex.Lock()
+	defer g.pinMutex.Unlock()
+	if g.keyed[key]--; g.keyed[key] > 0 {
+		return
+	}
+	delete(g.keyed, key)
+	if p := g.pins[key]; p != nil {
+		p.idle = clock.Or(g.config.Clock).Now()
+	}
+}
+
+// expireLoop drops the sticky pins which had no clients for PinIdle, so
+// the pins don't grow with every key ever seen.
+func (g *Gateway) expireLoop() {
+	ticker := clock.Or(g.config.Clock).NewTicker(g.config.PinIdle / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C():
+			g.pinMutex.Lock()
+			for key, p := range g.pins {
+				if p.sticky && !p.idle.IsZero() && now.Sub(p.idle) >= g.config.PinIdle {
+					delete(g.pins, key)
+				}
+			}
+			g.pinMutex.Unlock()
+		case <-g.closeChan:
+			return
+		}
+	}
+}
+
+// unpinRemoved drops the pins to backends which left, their keys go back to
+// the ring.
+func (g *Gateway) unpinRemoved(removed map[string]*backend) {
+	g.pinMutex.Lock()
+	defer g.pinMutex.Unlock()
+	for key, p := range g.pins {
+		if _, exists := removed[p.address]; exists {
+			delete(g.pins, key)
+		}
+	}
+}
+
+// owner returns the backend key belongs to, its pin or its ring node.
+func (g *Gateway) owner(key string, ring *Ring) string {
+	if address := g.Affinity(key); address != "" {
+		return address
+	}
+	return ring.Get(key)
+}
+
+// writeKey sends the key of a client ahead of its bytes, a 2 bytes big
+// endian length and the key.
+func writeKey(w io.Writer, key string) error {
+	if len(key) > 0xFFFF {
+		key = key[:0xFFFF]
+	}
+	buf := make([]byte, 2+len(key))
+	binary.BigEndian.PutUint16(buf, uint16(len(key)))
+	copy(buf[2:], key)
+	_, err := w.Write(buf)
+	return err
+}
+
+func readKey(r io.Reader) (string, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return "", err
+	}
+	key := make([]byte, binary.BigEndian.Uint16(head[:]))
+	if _, err := io.ReadFull(r, key); err != nil {
+		return "", err
+	}
+	return string(key), nil
+}
+
+// keyedProtocol reads the key a gateway sends before the client bytes.
+func keyedProtocol(base link.Protocol) link.Protocol {
+	return link.ProtocolFunc(func(rw io.ReadWriter) (link.Codec, error) {
+		key, err := readKey(rw)
+		if err != nil {
+			return nil, err
+		}
+		codec, err := base.NewCodec(rw)
+		if err != nil {
+			return nil, err
+		}
+		return &keyedCodec{codec, key}, nil
+	})
+}
+
+type keyedCodec struct {
+	link.Codec
+	key string
+}
+
+func (c *keyedCodec) ReceiveBatch(msgs []interface{}, max int) ([]interface{}, error) {
+	return link.ReceiveBatch(c.Codec, msgs, max)
+}
+
+func (c *keyedCodec) ClearSendChan(ch <-chan interface{}) {
+	if clear, ok := c.Codec.(link.ClearSendChan); ok {
+		clear.ClearSendChan(ch)
+	}
+}
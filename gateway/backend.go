@@ -0,0 +1,130 @@
+package gateway
+
+import (
+	"net"
+	"sync"
+
+	"github.com/funny/link"
+	"github.com/funny/link/mux"
+)
+
+// Backend accepts the connections of gateways and serves every client
+// stream on them as a session of handler. The sessions of all gateways share
+// one Manager. When a gateway connection breaks its sessions are closed, as
+// the clients are gone.
+type Backend struct {
+	listener     net.Listener
+	protocol     link.Protocol
+	handler      link.Handler
+	sendChanSize int
+	config       mux.Config
+	manager      *link.Manager
+
+	mutex  sync.Mutex
+	closed bool
+	muxes  map[*mux.Mux]struct{}
+
+	// ForwardedKeys reads the key gateways with Config.ForwardKey send
+	// before the client bytes, it becomes the AffinityLabel of the session.
+	// Set it before Serve.
+	ForwardedKeys bool
+}
+
+func NewBackend(listener net.Listener, protocol link.Protocol, sendChanSize int, handler link.Handler) *Backend {
+	return &Backend{
+		listener:     listener,
+		protocol:     protocol,
+		handler:      handler,
+		sendChanSize: sendChanSize,
+		config:       mux.DefaultConfig,
+		manager:      link.NewManager(),
+		muxes:        make(map[*mux.Mux]struct{}),
+	}
+}
+
+func (b *Backend) Manager() *link.Manager {
+	return b.manager
+}
+
+func (b *Backend) Listener() net.Listener {
+	return b.listener
+}
+
+func (b *Backend) Serve() error {
+	for {
+		conn, err := link.Accept(b.listener)
+		if err != nil {
+			return err
+		}
+		m := mux.Server(conn, b.config)
+		b.mutex.Lock()
+		if b.closed {
+			b.mutex.Unlock()
+			m.Close()
+			continue
+		}
+		b.muxes[m] = struct{}{}
+		b.mutex.Unlock()
+
+		go b.serveGateway(m)
+	}
+}
+
+func (b *Backend) serveGateway(m *mux.Mux) {
+	var mutex sync.Mutex
+	sessions := make(map[*link.Session]struct{})
+	handler := link.HandlerFunc(func(session *link.Session) {
+		mutex.Lock()
+		sessions[session] = struct{}{}
+		mutex.Unlock()
+		if codec, ok := session.Codec().(*keyedCodec); ok && codec.key != "" {
+			session.SetLabel(AffinityLabel, codec.key)
+		}
+		session.AddCloseCallback(b, m, func() {
+			mutex.Lock()
+			delete(sessions, session)
+			mutex.Unlock()
+		})
+		b.handler.HandleSession(session)
+	})
+	protocol := b.protocol
+	if b.ForwardedKeys {
+		protocol = keyedProtocol(protocol)
+	}
+	server := link.NewServerWith(m, protocol, handler,
+		link.WithSendChanSize(b.sendChanSize), link.WithManager(b.manager))
+	server.Serve()
+	m.Close()
+	b.mutex.Lock()
+	delete(b.muxes, m)
+	b.mutex.Unlock()
+
+	// the clients of the gateway are gone, even if a handler didn't notice.
+	mutex.Lock()
+	orphans := make([]*link.Session, 0, len(sessions))
+	for session := range sessions {
+		orphans = append(orphans, session)
+	}
+	mutex.Unlock()
+	for _, session := range orphans {
+		session.Close()
+	}
+}
+
+// NumGateways returns the number of connected gateways.
+func (b *Backend) NumGateways() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return len(b.muxes)
+}
+
+func (b *Backend) Stop() {
+	b.listener.Close()
+	b.mutex.Lock()
+	b.closed = true
+	for m := range b.muxes {
+		m.Close()
+	}
+	b.mutex.Unlock()
+	b.manager.Dispose()
+}
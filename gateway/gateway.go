@@ -0,0 +1,433 @@
+package gateway
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/clock"
+	"github.com/funny/link/discovery"
+	"github.com/funny/link/internal/redial"
+	"github.com/funny/link/mux"
+)
+
+var NoBackendError = link.NewError(link.TransportError, "No Backend")
+
+type Config struct {
+	Backends []string
+	Mux      mux.Config
+
+	// Resolver, when set, provides the backends instead of Backends. New
+	// backends are dialed as they appear, the clients of removed backends are
+	// closed as if the backend went down.
+	Resolver discovery.Resolver
+
+	// Dial connects to a backend, by default over TCP.
+	Dial func(address string) (net.Conn, error)
+
+	// Key, when set, returns the session key of a client, such as its user
+	// ID, and the client goes to the backend of the key on a consistent hash
+	// ring, so its state stays on one backend. Key may Peek at r, bytes it
+	// reads are not forwarded. Clients are spread round-robin otherwise.
+	Key func(conn net.Conn, r *bufio.Reader) (string, error)
+
+	// Replicas and Hash configure the ring, see NewRing.
+	Replicas int
+	Hash     func(data []byte) uint32
+
+	// OnMove is called when a change of the backends moved the key of a
+	// connected client to another backend. Returning true closes the client,
+	// so it reconnects to the new one, otherwise it stays where it is.
+	OnMove func(key, from, to string) bool
+
+	// Sticky pins a key to the first backend it lands on, so its clients go
+	// back there after the backend restarts instead of staying on the one
+	// they fell back to, and a new backend doesn't take it over. Pins are
+	// dropped when their backend is removed or PinIdle after the last client
+	// of their key left, see also Gateway.Pin.
+	Sticky bool
+
+	// PinIdle is how long a Sticky pin outlives the last client of its key,
+	// default 10 minutes. Pins made with Gateway.Pin don't expire.
+	PinIdle time.Duration
+
+	// ForwardKey sends the key of a client to the backend before its bytes,
+	// the backends need ForwardedKeys.
+	ForwardKey bool
+
+	// Clock runs the redial backoff and the pin expiry, nil is the system
+	// clock.
+	Clock clock.Clock
+}
+
+// Gateway accepts client connections and carries each of them as a stream
+// of a mux connection to one of a few backends, so a backend serves any
+// number of clients over a handful of connections. Client bytes are copied
+// as they are, the gateway does not decode messages. Broken backend
+// connections are redialed with backoff, the clients they carried are
+// closed.
+type Gateway struct {
+	listener     net.Listener
+	config       Config
+	backendMutex sync.RWMutex
+	backends     []*backend
+	ring         *Ring
+	next         atomic.Uint64
+	clients      atomic.Int64
+	stopWatch    context.CancelFunc
+
+	closeOnce sync.Once
+	closeChan chan struct{}
+	connMutex sync.Mutex
+	conns     map[net.Conn]*client
+
+	pinMutex sync.Mutex
+	pins     map[string]*pin
+	keyed    map[string]int
+}
+
+type client struct {
+	key     string
+	backend string
+}
+
+type backend struct {
+	address string
+	mutex   sync.Mutex
+	current *mux.Mux
+	removed chan struct{}
+}
+
+func (b *backend) mux() *mux.Mux {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.current == nil || b.current.IsClosed() {
+		return nil
+	}
+	return b.current
+}
+
+func New(listener net.Listener, config Config) *Gateway {
+	if config.Dial == nil {
+		config.Dial = func(address string) (net.Conn, error) {
+			return net.Dial("tcp", address)
+		}
+	}
+	if config.PinIdle <= 0 {
+		config.PinIdle = defaultPinIdle
+	}
+	g := &Gateway{
+		listener:  listener,
+		config:    config,
+		ring:      NewRing(config.Replicas, config.Hash),
+		closeChan: make(chan struct{}),
+		conns:     make(map[net.Conn]*client),
+		pins:      make(map[string]*pin),
+		keyed:     make(map[string]int),
+	}
+	resolver := config.Resolver
+	if resolver == nil {
+		resolver = discovery.Static(config.Backends...)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	g.stopWatch = cancel
+	watch := resolver.Watch(ctx)
+	// a list which is known at once, like Backends, is in place when New
+	// returns.
+	select {
+	case addresses, ok := <-watch:
+		if ok {
+			g.setBackends(addresses)
+		}
+	default:
+	}
+	go func() {
+		for addresses := range watch {
+			g.setBackends(addresses)
+		}
+	}()
+	if config.Sticky {
+		go g.expireLoop()
+	}
+	return g
+}
+
+func (g *Gateway) setBackends(addresses []string) {
+	g.backendMutex.Lock()
+	defer g.backendMutex.Unlock()
+	select {
+	case <-g.closeChan:
+		return
+	default:
+	}
+	old := make(map[string]*backend, len(g.backends))
+	for _, b := range g.backends {
+		old[b.address] = b
+	}
+	backends := make([]*backend, 0, len(addresses))
+	for _, address := range addresses {
+		if b, exists := old[address]; exists {
+			delete(old, address)
+			backends = append(backends, b)
+			continue
+		}
+		b := &backend{address: address, removed: make(chan struct{})}
+		backends = append(backends, b)
+		go g.dialLoop(b)
+	}
+	for _, b := range old {
+		link.GetLogger().Info("gateway: backend removed", "backend", b.address)
+		close(b.removed)
+		b.mutex.Lock()
+		if b.current != nil {
+			b.current.Close()
+		}
+		b.mutex.Unlock()
+	}
+	g.unpinRemoved(old)
+	g.backends = backends
+	// rings are not changed after this, lookups need no lock.
+	g.ring = NewRing(g.config.Replicas, g.config.Hash)
+	g.ring.Add(addresses...)
+	if g.config.OnMove != nil {
+		g.rebalance()
+	}
+}
+
+func (g *Gateway) rebalance() {
+	g.connMutex.Lock()
+	defer g.connMutex.Unlock()
+	for conn, c := range g.conns {
+		if c.key == "" || c.backend == "" {
+			continue
+		}
+		if to := g.owner(c.key, g.ring); to != c.backend && g.config.OnMove(c.key, c.backend, to) {
+			conn.Close()
+		}
+	}
+}
+
+func (g *Gateway) snapshot() ([]*backend, *Ring) {
+	g.backendMutex.RLock()
+	defer g.backendMutex.RUnlock()
+	return g.backends, g.ring
+}
+
+func (g *Gateway) dialLoop(b *backend) {
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-g.closeChan:
+		case <-b.removed:
+		}
+		close(stop)
+	}()
+	backoff := redial.Backoff{Clock: g.config.Clock}
+	for {
+		conn, err := g.config.Dial(b.address)
+		if err == nil {
+			backoff.Reset()
+			g.serveBackend(b, mux.Client(conn, g.config.Mux))
+		} else {
+			link.GetLogger().Warn("gateway: dial backend failed", "backend", b.address, "error", err)
+		}
+		if !backoff.Wait(stop) {
+			return
+		}
+	}
+}
+
+func (g *Gateway) serveBackend(b *backend, m *mux.Mux) {
+	b.mutex.Lock()
+	select {
+	case <-g.closeChan:
+		b.mutex.Unlock()
+		m.Close()
+		return
+	case <-b.removed:
+		b.mutex.Unlock()
+		m.Close()
+		return
+	default:
+	}
+	b.current = m
+	b.mutex.Unlock()
+
+	link.GetLogger().Info("gateway: backend connected", "backend", b.address)
+	defer m.Close()
+	// backends don't open streams, Accept returns when the connection breaks.
+	for {
+		conn, err := m.Accept()
+		if err != nil {
+			break
+		}
+		conn.Close()
+	}
+	link.GetLogger().Warn("gateway: backend disconnected", "backend", b.address)
+}
+
+// Serve accepts clients until the listener is closed.
+func (g *Gateway) Serve() error {
+	for {
+		conn, err := link.Accept(g.listener)
+		if err != nil {
+			return err
+		}
+		go g.serveClient(conn)
+	}
+}
+
+func (g *Gateway) serveClient(conn net.Conn) {
+	c := &client{}
+	if !g.track(conn, c) {
+		conn.Close()
+		return
+	}
+	defer g.track(conn, nil)
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	if g.config.Key != nil {
+		key, err := g.config.Key(conn, r)
+		if err != nil {
+			link.GetLogger().Warn("gateway: client key failed", "remote", conn.RemoteAddr(), "error", err)
+			return
+		}
+		g.connMutex.Lock()
+		c.key = key
+		g.connMutex.Unlock()
+	}
+	stream, address, err := g.open(c.key)
+	if err != nil {
+		link.GetLogger().Warn("gateway: client rejected", "remote", conn.RemoteAddr(), "error", err)
+		return
+	}
+	defer stream.Close()
+	g.connMutex.Lock()
+	c.backend = address
+	g.connMutex.Unlock()
+	if c.key != "" {
+		g.connected(c.key, address)
+		defer g.disconnected(c.key)
+	}
+	if g.config.ForwardKey {
+		if err := writeKey(stream, c.key); err != nil {
+			return
+		}
+	}
+
+	g.clients.Add(1)
+	defer g.clients.Add(-1)
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(stream, r)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(conn, stream)
+		done <- struct{}{}
+	}()
+	// either side ending closes both, which ends the other copy.
+	<-done
+}
+
+// track adds conn, or removes it when c is nil.
+func (g *Gateway) track(conn net.Conn, c *client) bool {
+	g.connMutex.Lock()
+	defer g.connMutex.Unlock()
+	if c == nil {
+		delete(g.conns, conn)
+		return true
+	}
+	select {
+	case <-g.closeChan:
+		return false
+	default:
+	}
+	g.conns[conn] = c
+	return true
+}
+
+// open starts a stream on the backend of key, its pinned one or its node on
+// the ring, or the ones after it on the ring when it is down. Without a key
+// the backends take turns.
+func (g *Gateway) open(key string) (net.Conn, string, error) {
+	backends, ring := g.snapshot()
+	var order []*backend
+	if key != "" {
+		byAddress := make(map[string]*backend, len(backends))
+		for _, b := range backends {
+			byAddress[b.address] = b
+		}
+		pinned := g.Affinity(key)
+		if b, exists := byAddress[pinned]; exists {
+			order = append(order, b)
+		}
+		for _, address := range ring.GetN(key, len(backends)) {
+			if address != pinned {
+				order = append(order, byAddress[address])
+			}
+		}
+	} else {
+		n := uint64(len(backends))
+		start := g.next.Add(1)
+		for i := uint64(0); i < n; i++ {
+			order = append(order, backends[(start+i)%n])
+		}
+	}
+	for _, b := range order {
+		m := b.mux()
+		if m == nil {
+			continue
+		}
+		if stream, err := m.Open(); err == nil {
+			return stream, b.address, nil
+		}
+	}
+	return nil, "", NoBackendError
+}
+
+// NumClients returns the number of clients carried to a backend.
+func (g *Gateway) NumClients() int {
+	return int(g.clients.Load())
+}
+
+// NumBackends returns the number of connected backends.
+func (g *Gateway) NumBackends() int {
+	n := 0
+	backends, _ := g.snapshot()
+	for _, b := range backends {
+		if b.mux() != nil {
+			n++
+		}
+	}
+	return n
+}
+
+func (g *Gateway) Stop() {
+	g.closeOnce.Do(func() {
+		g.stopWatch()
+		g.backendMutex.Lock()
+		close(g.closeChan)
+		g.backendMutex.Unlock()
+		g.listener.Close()
+		backends, _ := g.snapshot()
+		for _, b := range backends {
+			b.mutex.Lock()
+			if b.current != nil {
+				b.current.Close()
+			}
+			b.mutex.Unlock()
+		}
+		g.connMutex.Lock()
+		for conn := range g.conns {
+			conn.Close()
+		}
+		g.connMutex.Unlock()
+	})
+}
@@ -0,0 +1,355 @@
+package gateway
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/clock"
+	"github.com/funny/link/codec"
+	"github.com/funny/utest"
+)
+
+type Echo struct {
+	Text string
+}
+
+func testProtocol() link.Protocol {
+	json := codec.Json()
+	json.Register(Echo{})
+	return codec.FixLen(json, 2, binary.LittleEndian, 1024, 1024)
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	for i := 0; !cond(); i++ {
+		utest.Assert(t, i < 1000)
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func startBackend(t *testing.T) *Backend {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	utest.IsNilNow(t, err)
+	// the handler doesn't receive, its sessions are closed as orphans.
+	address := listener.Addr().String()
+	backend := NewBackend(listener, testProtocol(), 16, link.HandlerFunc(func(session *link.Session) {
+		session.Send(&Echo{address})
+	}))
+	go backend.Serve()
+	return backend
+}
+
+func Test_Gateway(t *testing.T) {
+	backend1, backend2 := startBackend(t), startBackend(t)
+	defer backend1.Stop()
+	defer backend2.Stop()
+
+	var mutex sync.Mutex
+	var links []net.Conn
+	var down atomic.Bool
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	utest.IsNilNow(t, err)
+	gateway := New(listener, Config{
+		Backends: []string{backend1.Listener().Addr().String(), backend2.Listener().Addr().String()},
+		Dial: func(address string) (net.Conn, error) {
+			if down.Load() {
+				return nil, errors.New("down")
+			}
+			conn, err := net.Dial("tcp", address)
+			if err == nil {
+				mutex.Lock()
+				links = append(links, conn)
+				mutex.Unlock()
+			}
+			return conn, err
+		},
+	})
+	go gateway.Serve()
+	defer gateway.Stop()
+	waitFor(t, func() bool { return gateway.NumBackends() == 2 })
+
+	var clients []*link.Session
+	for i := 0; i < 4; i++ {
+		client, err := link.Dial("tcp", listener.Addr().String(), testProtocol(), 0)
+		utest.IsNilNow(t, err)
+		defer client.Close()
+		msg, err := client.Receive()
+		utest.IsNilNow(t, err)
+		utest.Assert(t, msg.(*Echo).Text != "")
+		clients = append(clients, client)
+	}
+	utest.EqualNow(t, gateway.NumClients(), 4)
+	utest.EqualNow(t, backend1.Manager().Len(), 2)
+	utest.EqualNow(t, backend2.Manager().Len(), 2)
+
+	// breaking the backend connections closes the clients and the orphans.
+	down.Store(true)
+	mutex.Lock()
+	for _, conn := range links {
+		conn.Close()
+	}
+	mutex.Unlock()
+	for _, client := range clients {
+		_, err := client.Receive()
+		utest.NotNilNow(t, err)
+	}
+	waitFor(t, func() bool { return backend1.Manager().Len()+backend2.Manager().Len() == 0 })
+	waitFor(t, func() bool { return gateway.NumClients() == 0 && gateway.NumBackends() == 0 })
+
+	client, err := link.Dial("tcp", listener.Addr().String(), testProtocol(), 0)
+	utest.IsNilNow(t, err)
+	_, err = client.Receive()
+	utest.NotNilNow(t, err)
+
+	// the gateway redials.
+	down.Store(false)
+	waitFor(t, func() bool { return gateway.NumBackends() == 2 })
+	client, err = link.Dial("tcp", listener.Addr().String(), testProtocol(), 0)
+	utest.IsNilNow(t, err)
+	defer client.Close()
+	_, err = client.Receive()
+	utest.IsNilNow(t, err)
+}
+
+type testResolver chan []string
+
+func (r testResolver) Watch(ctx context.Context) <-chan []string {
+	return r
+}
+
+func Test_GatewayResolver(t *testing.T) {
+	backend1, backend2 := startBackend(t), startBackend(t)
+	defer backend1.Stop()
+	defer backend2.Stop()
+
+	resolver := make(testResolver, 1)
+	resolver <- []string{backend1.Listener().Addr().String()}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	utest.IsNilNow(t, err)
+	gateway := New(listener, Config{Resolver: resolver})
+	go gateway.Serve()
+	defer gateway.Stop()
+	waitFor(t, func() bool { return backend1.NumGateways() == 1 })
+
+	client, err := link.Dial("tcp", listener.Addr().String(), testProtocol(), 0)
+	utest.IsNilNow(t, err)
+	defer client.Close()
+	_, err = client.Receive()
+	utest.IsNilNow(t, err)
+
+	// backend1 leaves, its client is closed and backend2 takes new ones.
+	resolver <- []string{backend2.Listener().Addr().String()}
+	_, err = client.Receive()
+	utest.NotNilNow(t, err)
+	waitFor(t, func() bool { return backend1.NumGateways() == 0 && gateway.NumBackends() == 1 })
+	client, err = link.Dial("tcp", listener.Addr().String(), testProtocol(), 0)
+	utest.IsNilNow(t, err)
+	defer client.Close()
+	_, err = client.Receive()
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, backend2.Manager().Len(), 1)
+}
+
+func Test_Ring(t *testing.T) {
+	ring := NewRing(0, nil)
+	utest.EqualNow(t, ring.Get("alice"), "")
+	ring.Add("a", "b", "c")
+	owners := make(map[string]string)
+	count := make(map[string]int)
+	for i := 0; i < 3000; i++ {
+		key := fmt.Sprint("user", i)
+		owners[key] = ring.Get(key)
+		count[owners[key]]++
+	}
+	for _, node := range []string{"a", "b", "c"} {
+		utest.Assert(t, count[node] > 600)
+	}
+	utest.EqualNow(t, len(ring.GetN("alice", 5)), 3)
+
+	// only the keys of the removed node move.
+	ring.Remove("b")
+	for key, owner := range owners {
+		if owner != "b" {
+			utest.EqualNow(t, ring.Get(key), owner)
+		} else {
+			utest.Assert(t, ring.Get(key) != "b")
+		}
+	}
+}
+
+func Test_GatewayKey(t *testing.T) {
+	backends := []*Backend{startBackend(t), startBackend(t), startBackend(t)}
+	var addresses []string
+	for _, backend := range backends {
+		defer backend.Stop()
+		addresses = append(addresses, backend.Listener().Addr().String())
+	}
+
+	var mutex sync.Mutex
+	moves := make(map[string][2]string)
+	resolver := make(testResolver, 1)
+	resolver <- addresses[:2]
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	utest.IsNilNow(t, err)
+	gateway := New(listener, Config{
+		Resolver: resolver,
+		// the first frame is the key.
+		Key: func(conn net.Conn, r *bufio.Reader) (string, error) {
+			head, err := r.Peek(2)
+			if err != nil {
+				return "", err
+			}
+			frame, err := r.Peek(2 + int(binary.LittleEndian.Uint16(head)))
+			return string(frame), err
+		},
+		OnMove: func(key, from, to string) bool {
+			mutex.Lock()
+			moves[key] = [2]string{from, to}
+			mutex.Unlock()
+			return true
+		},
+	})
+	go gateway.Serve()
+	defer gateway.Stop()
+	waitFor(t, func() bool { return gateway.NumBackends() == 2 })
+
+	// every user lands on the same backend each time.
+	owners := make(map[string]string)
+	for i := 0; i < 30; i++ {
+		for j := 0; j < 2; j++ {
+			client, err := link.Dial("tcp", listener.Addr().String(), testProtocol(), 0)
+			utest.IsNilNow(t, err)
+			defer client.Close()
+			user := fmt.Sprint("user", i)
+			utest.IsNilNow(t, client.Send(&Echo{user}))
+			msg, err := client.Receive()
+			utest.IsNilNow(t, err)
+			if j == 0 {
+				owners[user] = msg.(*Echo).Text
+			} else {
+				utest.EqualNow(t, msg.(*Echo).Text, owners[user])
+				client.Close()
+			}
+		}
+	}
+	waitFor(t, func() bool { return gateway.NumClients() == 30 })
+
+	// a new backend takes over some users, their clients are closed.
+	resolver <- addresses
+	waitFor(t, func() bool { return gateway.NumBackends() == 3 })
+	mutex.Lock()
+	n := len(moves)
+	utest.Assert(t, n > 0)
+	for _, move := range moves {
+		utest.Assert(t, move[0] == addresses[0] || move[0] == addresses[1])
+		utest.EqualNow(t, move[1], addresses[2])
+	}
+	mutex.Unlock()
+	waitFor(t, func() bool { return gateway.NumClients() == 30-n })
+}
+
+func Test_GatewayAffinity(t *testing.T) {
+	var backends []*Backend
+	var addresses []string
+	for i := 0; i < 2; i++ {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		utest.IsNilNow(t, err)
+		address := listener.Addr().String()
+		backend := NewBackend(listener, testProtocol(), 16, link.HandlerFunc(func(session *link.Session) {
+			session.Send(&Echo{address})
+			for {
+				if _, err := session.Receive(); err != nil {
+					return
+				}
+			}
+		}))
+		backend.ForwardedKeys = true
+		go backend.Serve()
+		defer backend.Stop()
+		backends = append(backends, backend)
+		addresses = append(addresses, address)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	utest.IsNilNow(t, err)
+	gateway := New(listener, Config{
+		Backends:   addresses,
+		Sticky:     true,
+		ForwardKey: true,
+		// the key is a line ahead of the frames, it is not forwarded.
+		Key: func(conn net.Conn, r *bufio.Reader) (string, error) {
+			line, err := r.ReadString('\n')
+			return strings.TrimSuffix(line, "\n"), err
+		},
+	})
+	go gateway.Serve()
+	defer gateway.Stop()
+	waitFor(t, func() bool { return gateway.NumBackends() == 2 })
+
+	connect := func() (*link.Session, string) {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		utest.IsNilNow(t, err)
+		_, err = conn.Write([]byte("alice\n"))
+		utest.IsNilNow(t, err)
+		codec, err := testProtocol().NewCodec(conn)
+		utest.IsNilNow(t, err)
+		client := link.NewSession(codec, 0)
+		msg, err := client.Receive()
+		utest.IsNilNow(t, err)
+		return client, msg.(*Echo).Text
+	}
+	client, first := connect()
+	defer client.Close()
+	utest.EqualNow(t, gateway.Affinity("alice"), first)
+	owner := backends[0]
+	if first == addresses[1] {
+		owner = backends[1]
+	}
+	waitFor(t, func() bool { return len(owner.Manager().Find(AffinityLabel, "alice")) == 1 })
+
+	// moving alice by hand closes her client, she comes back on the other.
+	other := addresses[0]
+	if first == other {
+		other = addresses[1]
+	}
+	gateway.Pin("alice", other)
+	_, err = client.Receive()
+	utest.NotNilNow(t, err)
+	client, to := connect()
+	defer client.Close()
+	utest.EqualNow(t, to, other)
+
+	gateway.Unpin("alice")
+	utest.EqualNow(t, gateway.Affinity("alice"), "")
+}
+
+func Test_PinIdle(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	utest.IsNilNow(t, err)
+	clk := clock.NewFake(time.Now())
+	gateway := New(listener, Config{Sticky: true, PinIdle: 20 * time.Millisecond, Clock: clk})
+	defer gateway.Stop()
+	clk.BlockUntil(1)
+
+	gateway.connected("bob", "a")
+	gateway.Pin("carol", "b")
+	clk.Advance(50 * time.Millisecond)
+	utest.EqualNow(t, gateway.Affinity("bob"), "a")
+
+	// the pin of a key without clients goes, one made by hand stays.
+	gateway.disconnected("bob")
+	waitFor(t, func() bool {
+		clk.Advance(10 * time.Millisecond)
+		return gateway.Affinity("bob") == ""
+	})
+	utest.EqualNow(t, gateway.Affinity("carol"), "b")
+}
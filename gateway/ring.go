@@ -0,0 +1,101 @@
+package gateway
+
+import (
+	"hash/crc32"
+	"slices"
+	"sort"
+	"strconv"
+)
+
+// Ring is a consistent hash ring. Every node is placed at replicas points,
+// a key belongs to the node of the first point after its hash, so adding or
+// removing a node only moves the keys next to its points. A Ring is not safe
+// for concurrent changes.
+type Ring struct {
+	replicas int
+	hash     func(data []byte) uint32
+	points   []uint32
+	owners   map[uint32]string
+	nodes    []string
+}
+
+// NewRing returns a ring with replicas points per node, 160 when zero, and
+// crc32 as the hash when hash is nil.
+func NewRing(replicas int, hash func(data []byte) uint32) *Ring {
+	if replicas <= 0 {
+		replicas = 160
+	}
+	if hash == nil {
+		hash = crc32.ChecksumIEEE
+	}
+	return &Ring{
+		replicas: replicas,
+		hash:     hash,
+		owners:   make(map[uint32]string),
+	}
+}
+
+func (r *Ring) Add(nodes ...string) {
+	for _, node := range nodes {
+		if slices.Contains(r.nodes, node) {
+			continue
+		}
+		r.nodes = append(r.nodes, node)
+		for i := 0; i < r.replicas; i++ {
+			point := r.hash([]byte(node + "#" + strconv.Itoa(i)))
+			r.owners[point] = node
+			r.points = append(r.points, point)
+		}
+	}
+	slices.Sort(r.points)
+	r.points = slices.Compact(r.points)
+}
+
+func (r *Ring) Remove(node string) {
+	i := slices.Index(r.nodes, node)
+	if i < 0 {
+		return
+	}
+	r.nodes = slices.Delete(r.nodes, i, i+1)
+	points := r.points[:0]
+	for _, point := range r.points {
+		if r.owners[point] == node {
+			delete(r.owners, point)
+			continue
+		}
+		points = append(points, point)
+	}
+	r.points = points
+}
+
+// Nodes returns the nodes in the order they were added.
+func (r *Ring) Nodes() []string {
+	return slices.Clone(r.nodes)
+}
+
+// Get returns the node of key, empty when the ring is empty.
+func (r *Ring) Get(key string) string {
+	if nodes := r.GetN(key, 1); len(nodes) > 0 {
+		return nodes[0]
+	}
+	return ""
+}
+
+// GetN returns up to n distinct nodes for key, in the order of the ring. The
+// ones after the first are where the key goes when the first is down.
+func (r *Ring) GetN(key string, n int) []string {
+	if len(r.points) == 0 || n <= 0 {
+		return nil
+	}
+	n = min(n, len(r.nodes))
+	h := r.hash([]byte(key))
+	start := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	nodes := make([]string, 0, n)
+	for i := 0; i < len(r.points) && len(nodes) < n; i++ {
+		node := r.owners[r.points[(start+i)%len(r.points)]]
+		if !slices.Contains(nodes, node) {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
@@ -0,0 +1,155 @@
+package grpcbridge
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/rpc"
+)
+
+// Client calls unary gRPC methods. As an rpc.Handler it serves the requests
+// of link clients with the gRPC method routed to their type, so a service
+// behind the gateway can be reached without a gRPC client.
+type Client struct {
+	endpoint string
+	http     *http.Client
+	codec    Codec
+	routes   map[reflect.Type]route
+
+	// MaxSize is the largest response read.
+	MaxSize int
+
+	// Header, when set, adds metadata to the calls of a session, such as
+	// the authenticated user.
+	Header func(session *link.Session, header http.Header)
+}
+
+type route struct {
+	method   string
+	response reflect.Type
+}
+
+var _ rpc.Handler = (*Client)(nil)
+
+// NewClient calls the server at endpoint, such as http://host:port. Plain
+// http endpoints speak HTTP/2 without TLS as gRPC servers do, https ones
+// negotiate it.
+func NewClient(endpoint string, codec Codec) *Client {
+	transport := &http.Transport{
+		DialContext:       (&net.Dialer{}).DialContext,
+		ForceAttemptHTTP2: true,
+		Protocols:         new(http.Protocols),
+	}
+	transport.Protocols.SetHTTP2(true)
+	transport.Protocols.SetUnencryptedHTTP2(true)
+	return &Client{
+		endpoint: endpoint,
+		http:     &http.Client{Transport: transport},
+		codec:    codec,
+		routes:   make(map[reflect.Type]route),
+		MaxSize:  DefaultMaxSize,
+	}
+}
+
+// Route sends requests of type Req to method, such as
+// "/package.Service/Method", and answers with *Resp. It panics when Req
+// already has a route.
+func Route[Req, Resp any](client *Client, method string) {
+	t := reflect.TypeOf((*Req)(nil)).Elem()
+	if _, exists := client.routes[t]; exists {
+		panic("grpcbridge: route of " + t.String() + " registered twice")
+	}
+	client.routes[t] = route{method, reflect.TypeOf((*Resp)(nil)).Elem()}
+}
+
+func (client *Client) ServeRPC(ctx context.Context, session *link.Session, req interface{}) (interface{}, error) {
+	t := reflect.TypeOf(req)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	r, exists := client.routes[t]
+	if !exists {
+		return nil, NoMethodError
+	}
+	var header http.Header
+	if client.Header != nil && session != nil {
+		header = make(http.Header)
+		client.Header(session, header)
+	}
+	resp := reflect.New(r.response).Interface()
+	if err := client.Invoke(ctx, r.method, header, req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Invoke calls method with req and decodes the response into resp. Errors
+// of the call are *StatusError.
+func (client *Client) Invoke(ctx context.Context, method string, header http.Header, req, resp interface{}) error {
+	data, err := client.codec.Marshal(req)
+	if err != nil {
+		return err
+	}
+	var body bytes.Buffer
+	writeMessage(&body, data)
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, client.endpoint+method, &body)
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		httpReq.Header[k] = v
+	}
+	httpReq.Header.Set("Content-Type", "application/grpc+"+client.codec.Name())
+	httpReq.Header.Set("TE", "trailers")
+	if deadline, ok := ctx.Deadline(); ok {
+		httpReq.Header.Set("Grpc-Timeout", encodeTimeout(time.Until(deadline)))
+	}
+
+	httpResp, err := client.http.Do(httpReq)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return &StatusError{Unavailable, err.Error()}
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		return &StatusError{Unknown, "http status " + httpResp.Status}
+	}
+	// an error without a response comes in the headers alone.
+	if status := httpResp.Header.Get("Grpc-Status"); status != "" {
+		return statusError(status, httpResp.Header.Get("Grpc-Message"))
+	}
+	data, err = readMessage(httpResp.Body, client.MaxSize)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	got := err == nil
+	// the trailers are in place after the body was read to the end.
+	io.Copy(io.Discard, httpResp.Body)
+	if err := statusError(httpResp.Trailer.Get("Grpc-Status"), httpResp.Trailer.Get("Grpc-Message")); err != nil {
+		return err
+	}
+	if !got {
+		return &StatusError{Internal, "no response message"}
+	}
+	return client.codec.Unmarshal(data, resp)
+}
+
+func statusError(status, message string) error {
+	code, err := strconv.Atoi(status)
+	if err != nil {
+		return &StatusError{Internal, "bad grpc-status " + strconv.Quote(status)}
+	}
+	if code == 0 {
+		return nil
+	}
+	return &StatusError{code, decodeMessage(message)}
+}
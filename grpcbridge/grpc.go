@@ -0,0 +1,169 @@
+package grpcbridge
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/funny/link"
+)
+
+var (
+	NoMethodError      = link.NewError(link.ProtocolError, "gRPC Method Not Found")
+	MessageTooBigError = link.NewError(link.ProtocolError, "gRPC Message Too Big")
+	CompressedError    = link.NewError(link.ProtocolError, "gRPC Compressed Message")
+)
+
+// Codec marshals the messages of the gRPC calls, it is named in the content
+// type as application/grpc+name. Use a protobuf codec to talk to the usual
+// gRPC services, the JSON codec needs services which registered it.
+type Codec interface {
+	Name() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+type jsonCodec struct{}
+
+func JSON() Codec {
+	return jsonCodec{}
+}
+
+func (jsonCodec) Name() string                               { return "json" }
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+// DefaultMaxSize is the largest message read by default, as in grpc-go.
+const DefaultMaxSize = 4 * 1024 * 1024
+
+// Status codes of gRPC, the ones the bridge produces itself.
+const (
+	Canceled          = 1
+	Unknown           = 2
+	DeadlineExceeded  = 4
+	ResourceExhausted = 8
+	Unimplemented     = 12
+	Internal          = 13
+	Unavailable       = 14
+)
+
+// StatusError is a call which ended with a non-zero grpc-status. A handler
+// behind a Server returns one to choose the status.
+type StatusError struct {
+	Code    int
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("grpc: status %d: %s", e.Code, e.Message)
+}
+
+// Is sorts the status codes into the error classes of link.
+func (e *StatusError) Is(target error) bool {
+	switch e.Code {
+	case Unavailable:
+		return target == link.TransportError
+	case ResourceExhausted:
+		return target == link.PolicyError
+	case Unimplemented, Internal:
+		return target == link.ProtocolError
+	}
+	return target == link.HandlerError
+}
+
+// statusOf turns the error of a handler into a status.
+func statusOf(err error) (int, string) {
+	var status *StatusError
+	switch {
+	case errors.As(err, &status):
+		return status.Code, status.Message
+	case errors.Is(err, context.DeadlineExceeded):
+		return DeadlineExceeded, err.Error()
+	case errors.Is(err, context.Canceled):
+		return Canceled, err.Error()
+	case errors.Is(err, link.PolicyError):
+		return ResourceExhausted, err.Error()
+	}
+	return Unknown, err.Error()
+}
+
+// messages are framed with a compressed flag and a big endian length.
+func writeMessage(w io.Writer, data []byte) error {
+	var head [5]byte
+	binary.BigEndian.PutUint32(head[1:], uint32(len(data)))
+	if _, err := w.Write(head[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+func readMessage(r io.Reader, maxSize int) ([]byte, error) {
+	var head [5]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return nil, err
+	}
+	if head[0] != 0 {
+		return nil, CompressedError
+	}
+	n := binary.BigEndian.Uint32(head[1:])
+	if int64(n) > int64(maxSize) {
+		return nil, MessageTooBigError
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// encodeTimeout writes a grpc-timeout in milliseconds, the value has at
+// most 8 digits.
+func encodeTimeout(d time.Duration) string {
+	ms := d.Milliseconds()
+	if ms < 1 {
+		ms = 1
+	}
+	if ms > 99999999 {
+		return strconv.FormatInt(int64(d/time.Hour)+1, 10) + "H"
+	}
+	return strconv.FormatInt(ms, 10) + "m"
+}
+
+func decodeTimeout(s string) (time.Duration, bool) {
+	if len(s) < 2 {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(s[:len(s)-1], 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	unit, ok := map[byte]time.Duration{
+		'H': time.Hour,
+		'M': time.Minute,
+		'S': time.Second,
+		'm': time.Millisecond,
+		'u': time.Microsecond,
+		'n': time.Nanosecond,
+	}[s[len(s)-1]]
+	return time.Duration(n) * unit, ok
+}
+
+// grpc-message is percent encoded.
+func encodeMessage(s string) string {
+	return strings.ReplaceAll(url.PathEscape(s), "%20", " ")
+}
+
+func decodeMessage(s string) string {
+	if m, err := url.PathUnescape(s); err == nil {
+		return m
+	}
+	return s
+}
@@ -0,0 +1,114 @@
+package grpcbridge
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/codec"
+	"github.com/funny/link/rpc"
+	"github.com/funny/utest"
+)
+
+type AddReq struct {
+	A, B int
+}
+
+type AddRsp struct {
+	C    int
+	User string
+}
+
+type SleepReq struct {
+	D time.Duration
+}
+
+type UnknownReq struct{}
+
+func Test_Bridge(t *testing.T) {
+	// a gRPC service made of an rpc handler.
+	server := NewServer(rpc.HandlerFunc(func(ctx context.Context, session *link.Session, req interface{}) (interface{}, error) {
+		switch req := req.(type) {
+		case *AddReq:
+			if req.A < 0 {
+				return nil, &StatusError{3, "negative: a"}
+			}
+			return &AddRsp{req.A + req.B, HeaderFromContext(ctx).Get("X-User")}, nil
+		case *SleepReq:
+			select {
+			case <-time.After(req.D):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			return &AddRsp{}, nil
+		}
+		return nil, errors.New("unexpected")
+	}), JSON())
+	Handle[AddReq](server, "/math.Math/Add")
+	Handle[SleepReq](server, "/math.Math/Sleep")
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	utest.IsNilNow(t, err)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	// link clients reach it through an rpc server.
+	client := NewClient("http://"+listener.Addr().String(), JSON())
+	Route[AddReq, AddRsp](client, "/math.Math/Add")
+	Route[SleepReq, AddRsp](client, "/math.Math/Sleep")
+	Route[UnknownReq, AddRsp](client, "/math.Math/Unknown")
+	client.Header = func(session *link.Session, header http.Header) {
+		header.Set("X-User", "alice")
+	}
+	json := codec.Json()
+	json.Register(AddReq{})
+	json.Register(AddRsp{})
+	json.Register(SleepReq{})
+	json.Register(UnknownReq{})
+	protocol := codec.FixLen(rpc.Protocol(json), 4, binary.LittleEndian, 1024*1024, 1024*1024)
+	clientSession, serverSession, err := link.Pipe(protocol, 0)
+	utest.IsNilNow(t, err)
+	go rpc.NewServer(client).HandleSession(serverSession)
+	rpcClient := rpc.NewClient(clientSession)
+	defer rpcClient.Close()
+
+	rsp, err := rpcClient.Call(context.Background(), &AddReq{1, 2})
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, rsp.(*AddRsp).C, 3)
+	utest.EqualNow(t, rsp.(*AddRsp).User, "alice")
+
+	// status errors come back as they were.
+	var status *StatusError
+	err = client.Invoke(context.Background(), "/math.Math/Add", nil, &AddReq{-1, 0}, &AddRsp{})
+	utest.Assert(t, errors.As(err, &status))
+	utest.EqualNow(t, status.Code, 3)
+	utest.EqualNow(t, status.Message, "negative: a")
+	_, err = rpcClient.Call(context.Background(), &AddReq{-1, 0})
+	utest.Assert(t, strings.Contains(err.Error(), "negative: a"))
+
+	err = client.Invoke(context.Background(), "/math.Math/Unknown", nil, &UnknownReq{}, &AddRsp{})
+	utest.Assert(t, errors.As(err, &status))
+	utest.EqualNow(t, status.Code, Unimplemented)
+	utest.Assert(t, errors.Is(err, link.ProtocolError))
+
+	// the deadline goes along as grpc-timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err = client.Invoke(ctx, "/math.Math/Sleep", nil, &SleepReq{time.Second}, &AddRsp{})
+	utest.NotNilNow(t, err)
+}
+
+func Test_Timeout(t *testing.T) {
+	for _, d := range []time.Duration{time.Millisecond, 1500 * time.Millisecond, 200 * time.Hour} {
+		got, ok := decodeTimeout(encodeTimeout(d))
+		utest.Assert(t, ok)
+		utest.Assert(t, got >= d)
+	}
+	_, ok := decodeTimeout("10x")
+	utest.Assert(t, !ok)
+}
@@ -0,0 +1,123 @@
+package grpcbridge
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/funny/link/rpc"
+)
+
+// Server serves unary gRPC calls with an rpc.Handler, so gRPC services can
+// call the handlers of the link services. The handler gets the request
+// decoded into the type registered for the method and a nil session, its
+// metadata is in the context, see HeaderFromContext.
+type Server struct {
+	handler    rpc.Handler
+	codec      Codec
+	methods    map[string]reflect.Type
+	httpServer *http.Server
+
+	// MaxSize is the largest request read.
+	MaxSize int
+}
+
+var _ http.Handler = (*Server)(nil)
+
+func NewServer(handler rpc.Handler, codec Codec) *Server {
+	server := &Server{
+		handler: handler,
+		codec:   codec,
+		methods: make(map[string]reflect.Type),
+		MaxSize: DefaultMaxSize,
+	}
+	server.httpServer = &http.Server{
+		Handler:   server,
+		Protocols: new(http.Protocols),
+	}
+	server.httpServer.Protocols.SetUnencryptedHTTP2(true)
+	return server
+}
+
+// Handle decodes the requests of method, such as "/package.Service/Method",
+// into *Req. It panics when method is already handled.
+func Handle[Req any](server *Server, method string) {
+	if _, exists := server.methods[method]; exists {
+		panic("grpcbridge: method " + method + " registered twice")
+	}
+	server.methods[method] = reflect.TypeOf((*Req)(nil)).Elem()
+}
+
+type headerContext struct{}
+
+// HeaderFromContext returns the request headers of a gRPC call served by a
+// Server.
+func HeaderFromContext(ctx context.Context) http.Header {
+	header, _ := ctx.Value(headerContext{}).(http.Header)
+	return header
+}
+
+func (server *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "not a grpc request", http.StatusUnsupportedMediaType)
+		return
+	}
+	w.Header().Set("Content-Type", "application/grpc+"+server.codec.Name())
+	w.Header().Add("Trailer", "Grpc-Status")
+	w.Header().Add("Trailer", "Grpc-Message")
+
+	reply, err := server.serve(r)
+	code, message := 0, ""
+	if err != nil {
+		code, message = statusOf(err)
+	} else if data, err := server.codec.Marshal(reply); err != nil {
+		code, message = Internal, err.Error()
+	} else {
+		w.WriteHeader(http.StatusOK)
+		writeMessage(w, data)
+	}
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	if message != "" {
+		w.Header().Set("Grpc-Message", encodeMessage(message))
+	}
+}
+
+func (server *Server) serve(r *http.Request) (interface{}, error) {
+	t, exists := server.methods[r.URL.Path]
+	if !exists {
+		return nil, &StatusError{Unimplemented, "unknown method " + r.URL.Path}
+	}
+	data, err := readMessage(r.Body, server.MaxSize)
+	if err != nil {
+		return nil, &StatusError{Internal, err.Error()}
+	}
+	req := reflect.New(t).Interface()
+	if err := server.codec.Unmarshal(data, req); err != nil {
+		return nil, &StatusError{Internal, err.Error()}
+	}
+
+	ctx := context.WithValue(r.Context(), headerContext{}, r.Header)
+	if timeout, ok := decodeTimeout(r.Header.Get("Grpc-Timeout")); ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return server.handler.ServeRPC(ctx, nil, req)
+}
+
+// Serve accepts gRPC connections on listener, HTTP/2 without TLS, until
+// Stop.
+func (server *Server) Serve(listener net.Listener) error {
+	err := server.httpServer.Serve(listener)
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+func (server *Server) Stop() {
+	server.httpServer.Close()
+}
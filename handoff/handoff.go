@@ -0,0 +1,149 @@
+package handoff
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/funny/link"
+	"github.com/funny/link/outbox"
+	"github.com/funny/link/pubsub"
+)
+
+// State is the part of a session which moves to another backend: who the
+// user is, the labels and topics of the session and how far the user got in
+// the outbox. It is JSON friendly for stores shared between processes.
+type State struct {
+	Identity string            `json:"identity"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	Topics   []string          `json:"topics,omitempty"`
+	Cursor   uint64            `json:"cursor,omitempty"`
+}
+
+// Store holds the states between the backend which drains and the one the
+// client reconnects to, such as RedisStore, or MemoryStore within a process.
+// Stores on top of a database implement the same two calls.
+type Store interface {
+	Put(state *State) error
+	// Take removes and returns the state of identity, nil when there is
+	// none.
+	Take(identity string) (*State, error)
+}
+
+// Handoff moves sessions between backend processes. The draining backend
+// saves the state of its sessions with Drain and closes them, the clients
+// reconnect through the gateway to another backend which calls Restore once
+// it knows the identity of the client, like from gateway.AffinityLabel.
+type Handoff struct {
+	store Store
+
+	// PubSub, when set, carries the topics of the sessions.
+	PubSub *pubsub.PubSub
+
+	// Outbox, when set, carries the outbox cursors and Restore attaches the
+	// session to the outbox.
+	Outbox *outbox.Outbox
+}
+
+func New(store Store) *Handoff {
+	return &Handoff{store: store}
+}
+
+// Export returns the state of the session of identity.
+func (h *Handoff) Export(identity string, session *link.Session) *State {
+	state := &State{Identity: identity}
+	if labels := session.Labels(); len(labels) > 0 {
+		state.Labels = labels
+	}
+	if h.PubSub != nil {
+		state.Topics = h.PubSub.Topics(session)
+		sort.Strings(state.Topics)
+	}
+	if h.Outbox != nil {
+		state.Cursor, _ = h.Outbox.Cursor(identity)
+	}
+	return state
+}
+
+// Save puts the state of the session of identity into the store.
+func (h *Handoff) Save(identity string, session *link.Session) error {
+	return h.store.Put(h.Export(identity, session))
+}
+
+// Drain saves the state of every session of manager which has an identity
+// and closes all of them, identity returns empty for sessions which are not
+// moved. It returns the number of states saved, a failed save doesn't stop
+// the others.
+func (h *Handoff) Drain(manager *link.Manager, identity func(*link.Session) string) (int, error) {
+	var sessions []*link.Session
+	manager.Fetch(func(session *link.Session) {
+		sessions = append(sessions, session)
+	})
+	var saved int
+	var firstErr error
+	for _, session := range sessions {
+		if id := identity(session); id != "" {
+			if err := h.Save(id, session); err != nil {
+				link.GetLogger().Warn("handoff: save failed", "session", session.ID(), "identity", id, "error", err)
+				if firstErr == nil {
+					firstErr = err
+				}
+			} else {
+				saved++
+			}
+		}
+		session.Close()
+	}
+	return saved, firstErr
+}
+
+// Restore applies the saved state of identity to session, false when there
+// is none.
+func (h *Handoff) Restore(identity string, session *link.Session) (bool, error) {
+	state, err := h.store.Take(identity)
+	if err != nil || state == nil {
+		return false, err
+	}
+	return true, h.Apply(state, session)
+}
+
+// Apply gives session the labels, topics and outbox cursor of state.
+func (h *Handoff) Apply(state *State, session *link.Session) error {
+	for label, value := range state.Labels {
+		session.SetLabel(label, value)
+	}
+	if h.PubSub != nil {
+		for _, topic := range state.Topics {
+			h.PubSub.Subscribe(session, topic)
+		}
+	}
+	if h.Outbox != nil {
+		return h.Outbox.AttachFrom(state.Identity, session, state.Cursor)
+	}
+	return nil
+}
+
+// MemoryStore keeps the states in memory, for backends sharing a process
+// and tests.
+type MemoryStore struct {
+	mutex  sync.Mutex
+	states map[string]*State
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{states: make(map[string]*State)}
+}
+
+func (s *MemoryStore) Put(state *State) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.states[state.Identity] = state
+	return nil
+}
+
+func (s *MemoryStore) Take(identity string) (*State, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	state := s.states[identity]
+	delete(s.states, identity)
+	return state, nil
+}
@@ -0,0 +1,154 @@
+package handoff
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/codec"
+	"github.com/funny/link/internal/resp"
+	"github.com/funny/link/outbox"
+	"github.com/funny/link/pubsub"
+	"github.com/funny/utest"
+)
+
+type Notice struct {
+	Text string
+}
+
+func testHandoff(t *testing.T, states Store) {
+	protocol := codec.Json()
+	protocol.Register(outbox.Message{})
+	protocol.Register(outbox.Ack{})
+	boxes := outbox.NewMemoryStore()
+
+	// a backend of the test, its sessions are identified by the user label.
+	type backend struct {
+		manager *link.Manager
+		handoff *Handoff
+		router  *link.Router
+	}
+	newBackend := func() *backend {
+		h := New(states)
+		h.PubSub = pubsub.New()
+		h.Outbox = outbox.New(boxes, json.Marshal)
+		router := link.NewRouter()
+		h.Outbox.Register(router)
+		return &backend{link.NewManager(), h, router}
+	}
+	connect := func(b *backend) (*link.Session, *link.Session) {
+		conn1, conn2 := link.PipeConn()
+		codec1, err := protocol.NewCodec(conn1)
+		utest.IsNilNow(t, err)
+		codec2, err := protocol.NewCodec(conn2)
+		utest.IsNilNow(t, err)
+		session := b.manager.NewSession(codec2, 0)
+		go b.router.HandleSession(session)
+		return link.NewSession(codec1, 0), session
+	}
+	receive := func(peer *link.Session, seq uint64) {
+		msg, err := peer.Receive()
+		utest.IsNilNow(t, err)
+		utest.EqualNow(t, msg.(*outbox.Message).Seq, seq)
+	}
+
+	a, b := newBackend(), newBackend()
+	defer a.manager.Dispose()
+	defer b.manager.Dispose()
+
+	peer, session := connect(a)
+	session.SetLabel("user", "alice")
+	session.SetLabel("zone", "eu")
+	a.handoff.PubSub.Subscribe(session, "room.1")
+	utest.IsNilNow(t, a.handoff.Outbox.Attach("alice", session))
+	utest.IsNilNow(t, a.handoff.Outbox.Send("alice", &Notice{"one"}))
+	utest.IsNilNow(t, a.handoff.Outbox.Send("alice", &Notice{"two"}))
+	receive(peer, 1)
+	receive(peer, 2)
+	utest.IsNilNow(t, peer.Send(&outbox.Ack{Seq: 1}))
+	for cursor, _ := a.handoff.Outbox.Cursor("alice"); cursor != 1; cursor, _ = a.handoff.Outbox.Cursor("alice") {
+		time.Sleep(time.Millisecond)
+	}
+
+	saved, err := a.handoff.Drain(a.manager, func(session *link.Session) string {
+		return session.Label("user")
+	})
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, saved, 1)
+	_, err = peer.Receive()
+	utest.NotNilNow(t, err)
+
+	// the client comes back on the other backend where it left off.
+	peer, session = connect(b)
+	defer peer.Close()
+	restored, err := b.handoff.Restore("alice", session)
+	utest.IsNilNow(t, err)
+	utest.Assert(t, restored)
+	utest.EqualNow(t, session.Labels(), map[string]string{"user": "alice", "zone": "eu"})
+	utest.EqualNow(t, b.handoff.PubSub.Subscribers("room.1"), []*link.Session{session})
+	receive(peer, 2)
+	cursor, online := b.handoff.Outbox.Cursor("alice")
+	utest.Assert(t, online)
+	utest.EqualNow(t, cursor, uint64(1))
+
+	restored, err = b.handoff.Restore("alice", session)
+	utest.IsNilNow(t, err)
+	utest.Assert(t, !restored)
+}
+
+func Test_MemoryStore(t *testing.T) {
+	testHandoff(t, NewMemoryStore())
+}
+
+func Test_RedisStore(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	utest.IsNilNow(t, err)
+	defer listener.Close()
+	// a fake Redis with the two commands of the store.
+	var mutex sync.Mutex
+	values := make(map[string]string)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					v, err := resp.ReadValue(r)
+					if err != nil {
+						return
+					}
+					args := v.([]interface{})
+					mutex.Lock()
+					switch args[0] {
+					case "SET":
+						values[args[1].(string)] = args[2].(string)
+						io.WriteString(conn, "+OK\r\n")
+					case "GETDEL":
+						value, exists := values[args[1].(string)]
+						delete(values, args[1].(string))
+						if exists {
+							fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(value), value)
+						} else {
+							io.WriteString(conn, "$-1\r\n")
+						}
+					}
+					mutex.Unlock()
+				}
+			}()
+		}
+	}()
+
+	store := NewRedisStore(listener.Addr().String(), "")
+	defer store.Close()
+	testHandoff(t, store)
+}
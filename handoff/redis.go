@@ -0,0 +1,62 @@
+package handoff
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/funny/link/internal/resp"
+)
+
+// RedisStore keeps the states as JSON in Redis, so the backend which drains
+// and the one the client reconnects to can be different processes. Take
+// uses GETDEL, which needs Redis 6.2 or later.
+type RedisStore struct {
+	client *resp.Client
+
+	// Prefix of the keys, default "handoff:".
+	Prefix string
+
+	// TTL of a state whose client never comes back, default 5 minutes.
+	TTL time.Duration
+}
+
+// NewRedisStore makes a store on the Redis server at address, with AUTH when
+// password is not empty.
+func NewRedisStore(address, password string) *RedisStore {
+	return &RedisStore{
+		client: resp.NewClient(address, password),
+		Prefix: "handoff:",
+		TTL:    5 * time.Minute,
+	}
+}
+
+func (s *RedisStore) Put(state *State) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	ttl := strconv.FormatInt(s.TTL.Milliseconds(), 10)
+	_, err = s.client.Do("SET", s.Prefix+state.Identity, string(data), "PX", ttl)
+	return err
+}
+
+func (s *RedisStore) Take(identity string) (*State, error) {
+	v, err := s.client.Do("GETDEL", s.Prefix+identity)
+	if err != nil || v == nil {
+		return nil, err
+	}
+	data, ok := v.(string)
+	if !ok {
+		return nil, resp.BadReplyError
+	}
+	state := &State{}
+	if err := json.Unmarshal([]byte(data), state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+func (s *RedisStore) Close() error {
+	return s.client.Close()
+}
@@ -0,0 +1,136 @@
+package httpgw
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/rpc"
+)
+
+// Gateway is an http.Handler which turns POSTed JSON into messages, calls a
+// backend with them over an rpc.Client and answers with the JSON of the
+// response, for dashboards and debugging tools. Mount it on an existing mux
+// like admin.Admin. Routes:
+//
+//	GET  /              the registered message names
+//	POST /{name}        call, the response is the reply
+//	POST /{name}?notify send without waiting for a reply
+//
+// Failed calls are answered with {"error": "..."}, 502 when the handler
+// failed, 429 when the backend rejected the call, 504 after Timeout and 503
+// when the backend is gone.
+type Gateway struct {
+	client *rpc.Client
+	mux    *http.ServeMux
+	types  map[string]reflect.Type
+
+	// Timeout of a call, zero means no limit.
+	Timeout time.Duration
+
+	// OnRequest is called before a message is sent, a returned error
+	// rejects the request with 403, for checks like auth.
+	OnRequest func(r *http.Request, name string, msg interface{}) error
+}
+
+func New(client *rpc.Client) *Gateway {
+	g := &Gateway{
+		client:  client,
+		mux:     http.NewServeMux(),
+		types:   make(map[string]reflect.Type),
+		Timeout: 10 * time.Second,
+	}
+	g.mux.HandleFunc("GET /{$}", g.list)
+	g.mux.HandleFunc("POST /{name}", g.call)
+	return g
+}
+
+// Register maps name to messages of type T. It panics when name is already
+// registered.
+func Register[T any](g *Gateway, name string) {
+	if _, exists := g.types[name]; exists {
+		panic("httpgw: message " + name + " registered twice")
+	}
+	g.types[name] = reflect.TypeOf((*T)(nil)).Elem()
+}
+
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.mux.ServeHTTP(w, r)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func (g *Gateway) list(w http.ResponseWriter, r *http.Request) {
+	names := make([]string, 0, len(g.types))
+	for name := range g.types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	writeJSON(w, http.StatusOK, names)
+}
+
+func (g *Gateway) call(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	t, exists := g.types[name]
+	if !exists {
+		http.NotFound(w, r)
+		return
+	}
+	msg := reflect.New(t).Interface()
+	if err := json.NewDecoder(r.Body).Decode(msg); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if g.OnRequest != nil {
+		if err := g.OnRequest(r, name, msg); err != nil {
+			writeError(w, http.StatusForbidden, err)
+			return
+		}
+	}
+
+	if r.URL.Query().Has("notify") {
+		if err := g.client.Notify(msg); err != nil {
+			writeError(w, statusOf(err), err)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	ctx := r.Context()
+	if g.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, g.Timeout)
+		defer cancel()
+	}
+	reply, err := g.client.Call(ctx, msg)
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, reply)
+}
+
+func statusOf(err error) int {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, link.TransportError):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, link.PolicyError):
+		return http.StatusTooManyRequests
+	}
+	return http.StatusBadGateway
+}
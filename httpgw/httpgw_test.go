@@ -0,0 +1,103 @@
+package httpgw
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/codec"
+	"github.com/funny/link/rpc"
+	"github.com/funny/utest"
+)
+
+type AddReq struct {
+	A, B int
+}
+
+type AddRsp struct {
+	C int
+}
+
+func Test_Gateway(t *testing.T) {
+	base := codec.Json()
+	base.Register(AddReq{})
+	base.Register(AddRsp{})
+	protocol := codec.FixLen(rpc.Protocol(base), 4, binary.LittleEndian, 1024*1024, 1024*1024)
+	clientSession, serverSession, err := link.Pipe(protocol, 0)
+	utest.IsNilNow(t, err)
+	notified := make(chan int, 1)
+	go rpc.NewServer(rpc.HandlerFunc(func(ctx context.Context, session *link.Session, req interface{}) (interface{}, error) {
+		add := req.(*AddReq)
+		switch {
+		case add.A < 0:
+			return nil, errors.New("negative")
+		case add.A > 100:
+			<-ctx.Done()
+			return nil, ctx.Err()
+		case add.B == 0:
+			notified <- add.A
+		}
+		return &AddRsp{add.A + add.B}, nil
+	})).HandleSession(serverSession)
+	client := rpc.NewClient(clientSession)
+
+	gateway := New(client)
+	gateway.Timeout = 20 * time.Millisecond
+	gateway.OnRequest = func(r *http.Request, name string, msg interface{}) error {
+		if r.Header.Get("Authorization") != "secret" {
+			return errors.New("denied")
+		}
+		return nil
+	}
+	Register[AddReq](gateway, "add")
+
+	post := func(path, body string) (int, map[string]interface{}) {
+		r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		r.Header.Set("Authorization", "secret")
+		w := httptest.NewRecorder()
+		gateway.ServeHTTP(w, r)
+		var result map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &result)
+		return w.Code, result
+	}
+
+	code, result := post("/add", `{"A": 1, "B": 2}`)
+	utest.EqualNow(t, code, http.StatusOK)
+	utest.EqualNow(t, result["C"], 3.0)
+
+	code, result = post("/add", `{"A": -1}`)
+	utest.EqualNow(t, code, http.StatusBadGateway)
+	utest.EqualNow(t, result["error"], "negative")
+
+	code, _ = post("/add", `{"A": 101}`)
+	utest.EqualNow(t, code, http.StatusGatewayTimeout)
+
+	code, _ = post("/add?notify", `{"A": 7}`)
+	utest.EqualNow(t, code, http.StatusAccepted)
+	utest.EqualNow(t, <-notified, 7)
+
+	code, _ = post("/add", `{"A":`)
+	utest.EqualNow(t, code, http.StatusBadRequest)
+	code, _ = post("/sub", `{}`)
+	utest.EqualNow(t, code, http.StatusNotFound)
+
+	r := httptest.NewRequest(http.MethodPost, "/add", strings.NewReader(`{}`))
+	w := httptest.NewRecorder()
+	gateway.ServeHTTP(w, r)
+	utest.EqualNow(t, w.Code, http.StatusForbidden)
+
+	w = httptest.NewRecorder()
+	gateway.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	utest.EqualNow(t, strings.TrimSpace(w.Body.String()), `["add"]`)
+
+	client.Close()
+	code, _ = post("/add", `{"A": 1, "B": 2}`)
+	utest.EqualNow(t, code, http.StatusServiceUnavailable)
+}
@@ -0,0 +1,92 @@
+package httppoll
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/funny/link/clock"
+)
+
+type pipeBuffer struct {
+	mutex  sync.Mutex
+	buf    bytes.Buffer
+	closed bool
+	notify chan struct{}
+}
+
+func newPipeBuffer() *pipeBuffer {
+	return &pipeBuffer{
+		notify: make(chan struct{}, 1),
+	}
+}
+
+func (b *pipeBuffer) signal() {
+	select {
+	case b.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (b *pipeBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	if b.closed {
+		b.mutex.Unlock()
+		return 0, io.ErrClosedPipe
+	}
+	n, err := b.buf.Write(p)
+	b.mutex.Unlock()
+	b.signal()
+	return n, err
+}
+
+func (b *pipeBuffer) Read(p []byte) (int, error) {
+	for {
+		b.mutex.Lock()
+		if b.buf.Len() > 0 {
+			n, err := b.buf.Read(p)
+			b.mutex.Unlock()
+			return n, err
+		}
+		if b.closed {
+			b.mutex.Unlock()
+			return 0, io.EOF
+		}
+		b.mutex.Unlock()
+		<-b.notify
+	}
+}
+
+// drain waits at most timeout for buffered data and returns all of it.
+func (b *pipeBuffer) drain(clk clock.Clock, timeout time.Duration) ([]byte, error) {
+	timer := clk.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		b.mutex.Lock()
+		if b.buf.Len() > 0 {
+			data := append([]byte(nil), b.buf.Bytes()...)
+			b.buf.Reset()
+			b.mutex.Unlock()
+			return data, nil
+		}
+		if b.closed {
+			b.mutex.Unlock()
+			return nil, io.EOF
+		}
+		b.mutex.Unlock()
+		select {
+		case <-b.notify:
+		case <-timer.C():
+			return nil, nil
+		}
+	}
+}
+
+func (b *pipeBuffer) Close() error {
+	b.mutex.Lock()
+	b.closed = true
+	b.mutex.Unlock()
+	b.signal()
+	return nil
+}
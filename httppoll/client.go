@@ -0,0 +1,142 @@
+package httppoll
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/funny/link"
+)
+
+var ConnClosedError = link.NewError(link.TransportError, "Conn Closed")
+
+type clientConn struct {
+	client    *http.Client
+	endpoint  string
+	recv      *pipeBuffer
+	closeOnce sync.Once
+	closeChan chan struct{}
+}
+
+func DialConn(endpoint string, client *http.Client) (net.Conn, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	rsp, err := client.Post(endpoint, "application/octet-stream", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return nil, errors.New("httppoll: open failed: " + rsp.Status)
+	}
+	sid, err := io.ReadAll(rsp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	query := u.Query()
+	query.Set(sessionParam, string(sid))
+	u.RawQuery = query.Encode()
+
+	conn := &clientConn{
+		client:    client,
+		endpoint:  u.String(),
+		recv:      newPipeBuffer(),
+		closeChan: make(chan struct{}),
+	}
+	go conn.pollLoop()
+	return conn, nil
+}
+
+func Dial(endpoint string, client *http.Client, protocol link.Protocol, sendChanSize int) (*link.Session, error) {
+	conn, err := DialConn(endpoint, client)
+	if err != nil {
+		return nil, err
+	}
+	codec, err := protocol.NewCodec(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return link.NewSession(codec, sendChanSize), nil
+}
+
+func (conn *clientConn) pollLoop() {
+	defer conn.recv.Close()
+	for {
+		rsp, err := conn.client.Get(conn.endpoint)
+		if err != nil {
+			return
+		}
+		data, err := io.ReadAll(rsp.Body)
+		rsp.Body.Close()
+		if err != nil {
+			return
+		}
+		switch rsp.StatusCode {
+		case http.StatusOK:
+			if _, err := conn.recv.Write(data); err != nil {
+				return
+			}
+		case http.StatusNoContent:
+		default:
+			return
+		}
+		select {
+		case <-conn.closeChan:
+			return
+		default:
+		}
+	}
+}
+
+func (conn *clientConn) Read(p []byte) (int, error) {
+	return conn.recv.Read(p)
+}
+
+func (conn *clientConn) Write(p []byte) (int, error) {
+	select {
+	case <-conn.closeChan:
+		return 0, ConnClosedError
+	default:
+	}
+	rsp, err := conn.client.Post(conn.endpoint, "application/octet-stream", bytes.NewReader(p))
+	if err != nil {
+		return 0, err
+	}
+	rsp.Body.Close()
+	if rsp.StatusCode != http.StatusNoContent {
+		return 0, errors.New("httppoll: send failed: " + rsp.Status)
+	}
+	return len(p), nil
+}
+
+func (conn *clientConn) Close() error {
+	err := ConnClosedError
+	conn.closeOnce.Do(func() {
+		close(conn.closeChan)
+		conn.recv.Close()
+		req, _ := http.NewRequest(http.MethodDelete, conn.endpoint, nil)
+		if rsp, e := conn.client.Do(req); e == nil {
+			rsp.Body.Close()
+		}
+		err = nil
+	})
+	return err
+}
+
+func (conn *clientConn) LocalAddr() net.Addr                { return addr("httppoll") }
+func (conn *clientConn) RemoteAddr() net.Addr               { return addr(conn.endpoint) }
+func (conn *clientConn) SetDeadline(t time.Time) error      { return nil }
+func (conn *clientConn) SetReadDeadline(t time.Time) error  { return nil }
+func (conn *clientConn) SetWriteDeadline(t time.Time) error { return nil }
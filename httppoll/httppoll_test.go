@@ -0,0 +1,69 @@
+package httppoll
+
+import (
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/funny/link"
+	"github.com/funny/link/codec"
+	"github.com/funny/utest"
+)
+
+type EchoMsg struct {
+	Seq int
+}
+
+func Test_HTTPPoll(t *testing.T) {
+	json := codec.Json()
+	json.Register(EchoMsg{})
+	protocol := codec.FixLen(json, 2, binary.LittleEndian, 1024, 1024)
+
+	listener := NewListener(DefaultConfig)
+	server := link.NewServer(listener, protocol, 0, link.HandlerFunc(func(session *link.Session) {
+		defer session.Close()
+		for {
+			msg, err := session.Receive()
+			if err != nil {
+				return
+			}
+			if session.Send(msg) != nil {
+				return
+			}
+		}
+	}))
+	go server.Serve()
+	defer server.Stop()
+
+	httpServer := httptest.NewServer(listener)
+	defer httpServer.Close()
+
+	session, err := Dial(httpServer.URL, nil, protocol, 0)
+	utest.IsNilNow(t, err)
+	defer session.Close()
+
+	for i := 0; i < 20; i++ {
+		utest.IsNilNow(t, session.Send(&EchoMsg{i}))
+		msg, err := session.Receive()
+		utest.IsNilNow(t, err)
+		utest.EqualNow(t, msg.(*EchoMsg).Seq, i)
+	}
+}
+
+func Test_HTTPPollLimits(t *testing.T) {
+	// a zero config takes the defaults instead of panicking the expire loop.
+	listener := NewListener(Config{MaxPostSize: 4})
+	defer listener.Close()
+	httpServer := httptest.NewServer(listener)
+	defer httpServer.Close()
+
+	conn, err := DialConn(httpServer.URL, nil)
+	utest.IsNilNow(t, err)
+	defer conn.Close()
+	rsp, err := http.Post(conn.(*clientConn).endpoint, "application/octet-stream", strings.NewReader("12345"))
+	utest.IsNilNow(t, err)
+	rsp.Body.Close()
+	utest.EqualNow(t, rsp.StatusCode, http.StatusRequestEntityTooLarge)
+}
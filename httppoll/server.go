@@ -0,0 +1,280 @@
+package httppoll
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/funny/link/clock"
+)
+
+const sessionParam = "sid"
+
+// minPollTimeout keeps clients from polling in a busy loop.
+const minPollTimeout = 100 * time.Millisecond
+
+type Config struct {
+	PollTimeout   time.Duration
+	IdleTimeout   time.Duration
+	MaxPostSize   int64
+	AcceptBacklog int
+	Clock         clock.Clock // nil is clock.Default()
+}
+
+var DefaultConfig = Config{
+	PollTimeout:   25 * time.Second,
+	IdleTimeout:   time.Minute,
+	MaxPostSize:   1024 * 1024,
+	AcceptBacklog: 128,
+}
+
+type addr string
+
+func (a addr) Network() string { return "http" }
+func (a addr) String() string  { return string(a) }
+
+type Listener struct {
+	config     Config
+	mutex      sync.Mutex
+	conns      map[string]*serverConn
+	acceptChan chan *serverConn
+	closeOnce  sync.Once
+	closeChan  chan struct{}
+}
+
+// NewListener makes a listener, zero durations and sizes of config take the
+// values of DefaultConfig.
+func NewListener(config Config) *Listener {
+	if config.PollTimeout <= 0 {
+		config.PollTimeout = DefaultConfig.PollTimeout
+	} else if config.PollTimeout < minPollTimeout {
+		config.PollTimeout = minPollTimeout
+	}
+	if config.IdleTimeout <= 0 {
+		config.IdleTimeout = DefaultConfig.IdleTimeout
+	}
+	if config.MaxPostSize <= 0 {
+		config.MaxPostSize = DefaultConfig.MaxPostSize
+	}
+	if config.AcceptBacklog <= 0 {
+		config.AcceptBacklog = DefaultConfig.AcceptBacklog
+	}
+	listener := &Listener{
+		config:     config,
+		conns:      make(map[string]*serverConn),
+		acceptChan: make(chan *serverConn, config.AcceptBacklog),
+		closeChan:  make(chan struct{}),
+	}
+	go listener.expireLoop()
+	return listener
+}
+
+func (listener *Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-listener.acceptChan:
+		return conn, nil
+	case <-listener.closeChan:
+		return nil, io.EOF
+	}
+}
+
+func (listener *Listener) Close() error {
+	listener.closeOnce.Do(func() {
+		close(listener.closeChan)
+
+		listener.mutex.Lock()
+		conns := listener.conns
+		listener.conns = make(map[string]*serverConn)
+		listener.mutex.Unlock()
+
+		for _, conn := range conns {
+			conn.Close()
+		}
+	})
+	return nil
+}
+
+func (listener *Listener) Addr() net.Addr {
+	return addr("httppoll")
+}
+
+func (listener *Listener) expireLoop() {
+	ticker := clock.Or(listener.config.Clock).NewTicker(listener.config.IdleTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C():
+			var expired []*serverConn
+			listener.mutex.Lock()
+			for _, conn := range listener.conns {
+				if now.Sub(conn.lastSeen()) > listener.config.IdleTimeout {
+					expired = append(expired, conn)
+				}
+			}
+			listener.mutex.Unlock()
+			for _, conn := range expired {
+				conn.Close()
+			}
+		case <-listener.closeChan:
+			return
+		}
+	}
+}
+
+func (listener *Listener) getConn(sid string) *serverConn {
+	listener.mutex.Lock()
+	defer listener.mutex.Unlock()
+	conn, _ := listener.conns[sid]
+	return conn
+}
+
+func (listener *Listener) delConn(conn *serverConn) {
+	listener.mutex.Lock()
+	defer listener.mutex.Unlock()
+	if listener.conns[conn.sid] == conn {
+		delete(listener.conns, conn.sid)
+	}
+}
+
+func (listener *Listener) open(w http.ResponseWriter, r *http.Request) {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	conn := newServerConn(listener, hex.EncodeToString(id[:]), r.RemoteAddr)
+
+	listener.mutex.Lock()
+	select {
+	case <-listener.closeChan:
+		listener.mutex.Unlock()
+		http.Error(w, "listener closed", http.StatusServiceUnavailable)
+		return
+	case listener.acceptChan <- conn:
+		listener.conns[conn.sid] = conn
+	default:
+		listener.mutex.Unlock()
+		http.Error(w, "accept backlog full", http.StatusServiceUnavailable)
+		return
+	}
+	listener.mutex.Unlock()
+
+	io.WriteString(w, conn.sid)
+}
+
+func (listener *Listener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	sid := r.URL.Query().Get(sessionParam)
+	if sid == "" {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		listener.open(w, r)
+		return
+	}
+
+	conn := listener.getConn(sid)
+	if conn == nil {
+		http.Error(w, "session not found", http.StatusGone)
+		return
+	}
+	conn.touch()
+
+	switch r.Method {
+	case http.MethodGet:
+		data, err := conn.send.drain(clock.Or(listener.config.Clock), listener.config.PollTimeout)
+		if err != nil {
+			http.Error(w, "session closed", http.StatusGone)
+			return
+		}
+		if len(data) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(data)
+	case http.MethodPost:
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, listener.config.MaxPostSize))
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, err := conn.recv.Write(data); err != nil {
+			http.Error(w, "session closed", http.StatusGone)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		conn.Close()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+type serverConn struct {
+	listener   *Listener
+	sid        string
+	remoteAddr addr
+	recv       *pipeBuffer
+	send       *pipeBuffer
+	closeOnce  sync.Once
+	seenMutex  sync.Mutex
+	seen       time.Time
+}
+
+func newServerConn(listener *Listener, sid, remoteAddr string) *serverConn {
+	return &serverConn{
+		listener:   listener,
+		sid:        sid,
+		remoteAddr: addr(remoteAddr),
+		recv:       newPipeBuffer(),
+		send:       newPipeBuffer(),
+		seen:       clock.Or(listener.config.Clock).Now(),
+	}
+}
+
+func (conn *serverConn) touch() {
+	conn.seenMutex.Lock()
+	conn.seen = clock.Or(conn.listener.config.Clock).Now()
+	conn.seenMutex.Unlock()
+}
+
+func (conn *serverConn) lastSeen() time.Time {
+	conn.seenMutex.Lock()
+	defer conn.seenMutex.Unlock()
+	return conn.seen
+}
+
+func (conn *serverConn) Read(p []byte) (int, error) {
+	return conn.recv.Read(p)
+}
+
+func (conn *serverConn) Write(p []byte) (int, error) {
+	return conn.send.Write(p)
+}
+
+func (conn *serverConn) Close() error {
+	conn.closeOnce.Do(func() {
+		conn.recv.Close()
+		conn.send.Close()
+		conn.listener.delConn(conn)
+	})
+	return nil
+}
+
+func (conn *serverConn) LocalAddr() net.Addr                { return conn.listener.Addr() }
+func (conn *serverConn) RemoteAddr() net.Addr               { return conn.remoteAddr }
+func (conn *serverConn) SetDeadline(t time.Time) error      { return nil }
+func (conn *serverConn) SetReadDeadline(t time.Time) error  { return nil }
+func (conn *serverConn) SetWriteDeadline(t time.Time) error { return nil }
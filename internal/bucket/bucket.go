@@ -0,0 +1,65 @@
+// Package bucket is the token bucket behind the rate limits of link and
+// throttle.
+package bucket
+
+import (
+	"sync"
+	"time"
+
+	"github.com/funny/link/clock"
+)
+
+// Bucket refills at rate tokens per second up to burst, a zero rate is
+// unlimited. Takers which find it short go into debt and wait it off, so a
+// big take is delayed instead of refused. The zero Bucket is unlimited.
+type Bucket struct {
+	mutex  sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+
+	Clock clock.Clock // nil is clock.Default()
+}
+
+// SetRate changes the bucket at runtime, the first take finds it full.
+func (b *Bucket) SetRate(rate, burst float64) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.rate, b.burst = rate, burst
+	b.tokens = min(b.tokens, b.burst)
+}
+
+func (b *Bucket) Rate() (rate, burst float64) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.rate, b.burst
+}
+
+// Reserve takes n tokens and returns how long to wait for them.
+func (b *Bucket) Reserve(n int) time.Duration {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.rate <= 0 {
+		return 0
+	}
+	now := clock.Or(b.Clock).Now()
+	if b.last.IsZero() {
+		b.tokens = b.burst
+	} else {
+		b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// Wait takes n tokens, sleeping when the bucket is short.
+func (b *Bucket) Wait(n int) {
+	if d := b.Reserve(n); d > 0 {
+		<-clock.Or(b.Clock).NewTimer(d).C()
+	}
+}
@@ -0,0 +1,30 @@
+// Package buffered holds the writes of a base codec until its message is
+// complete.
+package buffered
+
+import (
+	"bytes"
+	"io"
+)
+
+// Writer reads from RW and collects writes in Buf, so a message the base
+// codec fails to encode leaves nothing on the stream.
+type Writer struct {
+	RW  io.ReadWriter
+	Buf bytes.Buffer
+}
+
+func (w *Writer) Read(p []byte) (int, error) {
+	return w.RW.Read(p)
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	return w.Buf.Write(p)
+}
+
+func (w *Writer) Close() error {
+	if closer, ok := w.RW.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+// Package redial is the backoff of the loops which keep a connection up.
+package redial
+
+import (
+	"time"
+
+	"github.com/funny/link/clock"
+)
+
+const (
+	minDelay = 5 * time.Millisecond
+	maxDelay = time.Second
+)
+
+// Backoff is the wait between redials, it doubles from 5ms up to 1s and
+// starts over after Reset.
+type Backoff struct {
+	Clock clock.Clock // nil is clock.Default()
+
+	delay time.Duration
+}
+
+// Reset makes the next wait the shortest, after a connection was up.
+func (b *Backoff) Reset() {
+	b.delay = 0
+}
+
+// Wait sleeps the next delay, false when stop is closed first.
+func (b *Backoff) Wait(stop <-chan struct{}) bool {
+	if b.delay == 0 {
+		b.delay = minDelay
+	} else {
+		b.delay = min(b.delay*2, maxDelay)
+	}
+	timer := clock.Or(b.Clock).NewTimer(b.delay)
+	select {
+	case <-timer.C():
+		return true
+	case <-stop:
+		timer.Stop()
+		return false
+	}
+}
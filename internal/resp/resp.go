@@ -0,0 +1,158 @@
+// Package resp speaks the Redis protocol, just enough for the Redis backed
+// parts of link.
+package resp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/funny/link"
+)
+
+var BadReplyError = link.NewError(link.ProtocolError, "Bad Redis Reply")
+
+// Error is an error reply of the server.
+type Error string
+
+func (e Error) Error() string {
+	return "redis: " + string(e)
+}
+
+// Client runs commands one at a time over a connection, which is dialed
+// again on the next command after it broke.
+type Client struct {
+	address  string
+	password string
+	mutex    sync.Mutex
+	conn     net.Conn
+	r        *bufio.Reader
+}
+
+// NewClient makes a client of the server at address, with AUTH when password
+// is not empty. It doesn't connect until the first command.
+func NewClient(address, password string) *Client {
+	return &Client{address: address, password: password}
+}
+
+func (c *Client) dial() error {
+	conn, err := net.Dial("tcp", c.address)
+	if err != nil {
+		return err
+	}
+	r := bufio.NewReader(conn)
+	if c.password != "" {
+		if err := WriteCommand(conn, "AUTH", c.password); err != nil {
+			conn.Close()
+			return err
+		}
+		if _, err := ReadValue(r); err != nil {
+			conn.Close()
+			return err
+		}
+	}
+	c.conn, c.r = conn, r
+	return nil
+}
+
+// Do runs a command and returns its reply, bulk strings are strings and nil
+// bulk strings nil.
+func (c *Client) Do(args ...string) (interface{}, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.conn == nil {
+		if err := c.dial(); err != nil {
+			return nil, err
+		}
+	}
+	if err := WriteCommand(c.conn, args...); err != nil {
+		c.broken()
+		return nil, err
+	}
+	v, err := ReadValue(c.r)
+	if _, ok := err.(Error); err != nil && !ok {
+		c.broken()
+	}
+	return v, err
+}
+
+func (c *Client) broken() {
+	c.conn.Close()
+	c.conn, c.r = nil, nil
+}
+
+func (c *Client) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn, c.r = nil, nil
+	return err
+}
+
+// WriteCommand writes args as an array of bulk strings.
+func WriteCommand(w io.Writer, args ...string) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return "", BadReplyError
+	}
+	return line[:len(line)-2], nil
+}
+
+// ReadValue reads a value, bulk strings are returned as strings.
+func ReadValue(r *bufio.Reader) (interface{}, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, Error(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		return string(b[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = ReadValue(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, BadReplyError
+}
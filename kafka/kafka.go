@@ -0,0 +1,247 @@
+package kafka
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/clock"
+	"github.com/funny/link/pubsub"
+)
+
+type Record struct {
+	Topic     string `json:"topic"`
+	Partition int32  `json:"partition"`
+	Offset    int64  `json:"offset"`
+	Key       []byte `json:"key"`
+	Value     []byte `json:"value"`
+}
+
+type Consumer interface {
+	// Poll returns the next records of the subscribed topics, it may wait a
+	// while and return none.
+	Poll(ctx context.Context) ([]Record, error)
+	// Commit marks the records of the last Poll as processed.
+	Commit(ctx context.Context) error
+	Close() error
+}
+
+type Producer interface {
+	Produce(ctx context.Context, topic string, records []Record) error
+}
+
+// Deliver sends a consumed record to its sessions, see ToPubSub and
+// ToChannel.
+type Deliver func(record Record) error
+
+// Decode turns the value of a record into a message for the sessions.
+type Decode func(record Record) (interface{}, error)
+
+// ToPubSub publishes the records to the topic of their key, or of their
+// Kafka topic when they have no key.
+func ToPubSub(ps *pubsub.PubSub, decode Decode) Deliver {
+	return func(record Record) error {
+		msg, err := decode(record)
+		if err != nil {
+			return err
+		}
+		topic := record.Topic
+		if len(record.Key) > 0 {
+			topic = string(record.Key)
+		}
+		ps.Publish(topic, msg)
+		return nil
+	}
+}
+
+// ToChannel sends the records to the session of their key in channel, the
+// keys of the channel must be strings. Records without a key go to all
+// sessions of the channel.
+func ToChannel(channel *link.Channel, decode Decode) Deliver {
+	return func(record Record) error {
+		msg, err := decode(record)
+		if err != nil {
+			return err
+		}
+		if len(record.Key) == 0 {
+			channel.Broadcast(msg)
+		} else if session := channel.Get(string(record.Key)); session != nil {
+			session.Send(msg)
+		}
+		return nil
+	}
+}
+
+// the most records produced at once.
+const maxBatch = 100
+
+// Bridge connects Kafka with the sessions. Records consumed from Kafka are
+// delivered to sessions, messages selected by the Export middleware are
+// produced to Kafka. Either side may be nil.
+//
+// Records are committed after they were delivered, a crash in between
+// delivers them again. Exported messages are queued and produced in the
+// background, when the queue is full they are dropped, so slow Kafka never
+// holds up the handlers.
+type Bridge struct {
+	consumer Consumer
+	deliver  Deliver
+	producer Producer
+	queue    chan Record
+	dropped  atomic.Uint64
+
+	// Retry is the wait after a failed Poll, failed batches of exported
+	// records are logged and dropped.
+	Retry time.Duration
+
+	// Clock runs the Retry wait, nil is clock.Default().
+	Clock clock.Clock
+
+	ctx      context.Context
+	stop     context.CancelFunc
+	stopOnce sync.Once
+	produced chan struct{}
+}
+
+func New(consumer Consumer, deliver Deliver, producer Producer, queueSize int) *Bridge {
+	b := &Bridge{
+		consumer: consumer,
+		deliver:  deliver,
+		producer: producer,
+		queue:    make(chan Record, queueSize),
+		Retry:    time.Second,
+		produced: make(chan struct{}),
+	}
+	b.ctx, b.stop = context.WithCancel(context.Background())
+	if producer != nil {
+		go b.produceLoop()
+	} else {
+		close(b.produced)
+	}
+	return b
+}
+
+// Serve consumes records until Stop.
+func (b *Bridge) Serve() error {
+	if b.consumer == nil {
+		<-b.ctx.Done()
+		return nil
+	}
+	defer b.consumer.Close()
+	for {
+		records, err := b.consumer.Poll(b.ctx)
+		if err == nil {
+			for _, record := range records {
+				if err := b.deliver(record); err != nil {
+					link.GetLogger().Warn("kafka: deliver failed", "topic", record.Topic, "offset", record.Offset, "error", err)
+				}
+			}
+			if len(records) > 0 {
+				err = b.consumer.Commit(b.ctx)
+			}
+		}
+		if b.ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			link.GetLogger().Warn("kafka: consume failed", "error", err)
+			timer := clock.Or(b.Clock).NewTimer(b.Retry)
+			select {
+			case <-timer.C():
+			case <-b.ctx.Done():
+				timer.Stop()
+				return nil
+			}
+		}
+	}
+}
+
+// Export returns a Router middleware which queues the records that encode
+// returns ok for, before the message is handled as usual.
+func (b *Bridge) Export(encode func(session *link.Session, msg interface{}) (record Record, ok bool)) link.Middleware {
+	return func(next link.MessageHandler) link.MessageHandler {
+		return func(ctx context.Context, session *link.Session, msg interface{}) {
+			if record, ok := encode(session, msg); ok {
+				b.Produce(record)
+			}
+			next(ctx, session, msg)
+		}
+	}
+}
+
+// Produce queues record, it returns false when the record was dropped.
+func (b *Bridge) Produce(record Record) bool {
+	if b.producer == nil || b.ctx.Err() != nil {
+		return false
+	}
+	select {
+	case b.queue <- record:
+		return true
+	default:
+		b.dropped.Add(1)
+		return false
+	}
+}
+
+// Dropped returns the number of records dropped because the queue was full.
+func (b *Bridge) Dropped() uint64 {
+	return b.dropped.Load()
+}
+
+func (b *Bridge) produceLoop() {
+	defer close(b.produced)
+	for {
+		var batch []Record
+		select {
+		case record := <-b.queue:
+			batch = append(batch, record)
+		case <-b.ctx.Done():
+		}
+		// after Stop the queue is flushed once more.
+		stopped := b.ctx.Err() != nil
+	gather:
+		for len(batch) < maxBatch {
+			select {
+			case record := <-b.queue:
+				batch = append(batch, record)
+			default:
+				break gather
+			}
+		}
+		b.produce(batch)
+		if stopped && len(b.queue) == 0 {
+			return
+		}
+	}
+}
+
+// produceTimeout is not tied to Stop, which waits for the batches in flight.
+const produceTimeout = 10 * time.Second
+
+func (b *Bridge) produce(batch []Record) {
+	ctx, cancel := context.WithTimeout(context.Background(), produceTimeout)
+	defer cancel()
+	var topics []string
+	byTopic := make(map[string][]Record)
+	for _, record := range batch {
+		if _, exists := byTopic[record.Topic]; !exists {
+			topics = append(topics, record.Topic)
+		}
+		byTopic[record.Topic] = append(byTopic[record.Topic], record)
+	}
+	for _, topic := range topics {
+		if err := b.producer.Produce(ctx, topic, byTopic[topic]); err != nil {
+			link.GetLogger().Warn("kafka: produce failed", "topic", topic, "records", len(byTopic[topic]), "error", err)
+		}
+	}
+}
+
+// Stop stops consuming and flushes the queued records.
+func (b *Bridge) Stop() {
+	b.stopOnce.Do(func() {
+		b.stop()
+		<-b.produced
+	})
+}
@@ -0,0 +1,140 @@
+package kafka
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/codec"
+	"github.com/funny/link/pubsub"
+	"github.com/funny/utest"
+)
+
+type Chat struct {
+	Text string
+}
+
+// fakeProxy serves the REST Proxy calls of a consumer and a producer, pending
+// records are handed out once and committed by the next offsets call.
+type fakeProxy struct {
+	mutex     sync.Mutex
+	pending   []Record
+	commits   int
+	produced  map[string][]Record
+	instances int
+}
+
+func (p *fakeProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/consumers/chat":
+		p.instances++
+		json.NewEncoder(w).Encode(map[string]string{
+			"instance_id": "c1",
+			"base_uri":    "http://" + r.Host + "/consumers/chat/instances/c1",
+		})
+	case strings.HasSuffix(r.URL.Path, "/subscription"), strings.HasSuffix(r.URL.Path, "/offsets"):
+		if strings.HasSuffix(r.URL.Path, "/offsets") {
+			p.commits++
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case strings.HasSuffix(r.URL.Path, "/records"):
+		records := p.pending
+		p.pending = nil
+		if records == nil {
+			records = []Record{}
+		}
+		json.NewEncoder(w).Encode(records)
+	case r.Method == http.MethodDelete:
+		p.instances--
+		w.WriteHeader(http.StatusNoContent)
+	case strings.HasPrefix(r.URL.Path, "/topics/"):
+		var body struct {
+			Records []Record `json:"records"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		topic := strings.TrimPrefix(r.URL.Path, "/topics/")
+		p.produced[topic] = append(p.produced[topic], body.Records...)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"offsets": []map[string]interface{}{{"partition": 0, "offset": 1}},
+		})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (p *fakeProxy) locked(f func()) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	f()
+}
+
+func Test_Bridge(t *testing.T) {
+	proxy := &fakeProxy{produced: make(map[string][]Record)}
+	server := httptest.NewServer(proxy)
+	defer server.Close()
+
+	json := codec.Json()
+	json.Register(Chat{})
+	ps := pubsub.New()
+	peer, session, err := link.Pipe(json, 0)
+	utest.IsNilNow(t, err)
+	ps.Subscribe(session, "room.1")
+
+	consumer := NewRESTConsumer("chat", "chat")
+	consumer.Endpoint = server.URL
+	producer := NewRESTProducer()
+	producer.Endpoint = server.URL
+	bridge := New(consumer, ToPubSub(ps, func(record Record) (interface{}, error) {
+		return &Chat{string(record.Value)}, nil
+	}), producer, 16)
+	bridge.Retry = time.Millisecond
+	go bridge.Serve()
+
+	// consumed records reach the sessions of their key.
+	proxy.locked(func() {
+		proxy.pending = []Record{
+			{Topic: "chat", Key: []byte("room.2"), Value: []byte("not for us")},
+			{Topic: "chat", Key: []byte("room.1"), Value: []byte("hello")},
+		}
+	})
+	msg, err := peer.Receive()
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, msg.(*Chat).Text, "hello")
+	for committed := false; !committed; time.Sleep(time.Millisecond) {
+		proxy.locked(func() { committed = proxy.commits == 1 })
+	}
+
+	// messages selected by the middleware are produced.
+	router := link.NewRouter()
+	handled := 0
+	link.RegisterHandler(router, func(session *link.Session, msg *Chat) {
+		handled++
+	})
+	router.Use(bridge.Export(func(session *link.Session, msg interface{}) (Record, bool) {
+		chat, ok := msg.(*Chat)
+		if !ok || chat.Text == "secret" {
+			return Record{}, false
+		}
+		return Record{Topic: "analytics", Value: []byte(chat.Text)}, true
+	}))
+	utest.IsNilNow(t, router.Dispatch(session, &Chat{"hi"}))
+	utest.IsNilNow(t, router.Dispatch(session, &Chat{"secret"}))
+	utest.EqualNow(t, handled, 2)
+
+	bridge.Stop()
+	utest.Assert(t, !bridge.Produce(Record{Topic: "analytics"}))
+	proxy.locked(func() {
+		utest.EqualNow(t, len(proxy.produced["analytics"]), 1)
+		utest.EqualNow(t, string(proxy.produced["analytics"][0].Value), "hi")
+	})
+	for closed := false; !closed; time.Sleep(time.Millisecond) {
+		proxy.locked(func() { closed = proxy.instances == 0 })
+	}
+}
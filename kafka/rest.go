@@ -0,0 +1,197 @@
+package kafka
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// the embedded format of the REST Proxy v2 API for raw bytes.
+const binaryV2 = "application/vnd.kafka.binary.v2+json"
+
+// RESTConsumer consumes through a Confluent REST Proxy, so no Kafka client
+// library is needed. The consumer instance is created on the first Poll and again when the
+// proxy forgot it.
+type RESTConsumer struct {
+	Endpoint string // of the proxy, default http://127.0.0.1:8082
+	Group    string
+	Topics   []string
+	Reset    string        // auto.offset.reset of a new group, default latest
+	Timeout  time.Duration // of a Poll, default 1s
+	Client   *http.Client
+
+	mutex    sync.Mutex
+	instance string // base URI of the consumer instance
+}
+
+func NewRESTConsumer(group string, topics ...string) *RESTConsumer {
+	return &RESTConsumer{
+		Endpoint: "http://127.0.0.1:8082",
+		Group:    group,
+		Topics:   topics,
+		Reset:    "latest",
+		Timeout:  time.Second,
+		Client:   http.DefaultClient,
+	}
+}
+
+// RESTProducer produces through a Confluent REST Proxy.
+type RESTProducer struct {
+	Endpoint string // of the proxy, default http://127.0.0.1:8082
+	Client   *http.Client
+}
+
+func NewRESTProducer() *RESTProducer {
+	return &RESTProducer{
+		Endpoint: "http://127.0.0.1:8082",
+		Client:   http.DefaultClient,
+	}
+}
+
+func request(ctx context.Context, client *http.Client, method, u string, body interface{}, result interface{}) (int, error) {
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reader = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, reader)
+	if err != nil {
+		return 0, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", binaryV2)
+	}
+	req.Header.Set("Accept", binaryV2)
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		var e struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(resp.Body).Decode(&e)
+		return resp.StatusCode, fmt.Errorf("kafka: rest proxy status %s %s", resp.Status, e.Message)
+	}
+	if result != nil && resp.StatusCode != http.StatusNoContent {
+		return resp.StatusCode, json.NewDecoder(resp.Body).Decode(result)
+	}
+	return resp.StatusCode, nil
+}
+
+func (c *RESTConsumer) create(ctx context.Context) (string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.instance != "" {
+		return c.instance, nil
+	}
+	var created struct {
+		BaseURI string `json:"base_uri"`
+	}
+	_, err := request(ctx, c.Client, http.MethodPost, c.Endpoint+"/consumers/"+url.PathEscape(c.Group), map[string]string{
+		"format":             "binary",
+		"auto.offset.reset":  c.Reset,
+		"auto.commit.enable": "false",
+	}, &created)
+	if err != nil {
+		return "", err
+	}
+	_, err = request(ctx, c.Client, http.MethodPost, created.BaseURI+"/subscription", map[string]interface{}{
+		"topics": c.Topics,
+	}, nil)
+	if err != nil {
+		request(ctx, c.Client, http.MethodDelete, created.BaseURI, nil, nil)
+		return "", err
+	}
+	c.instance = created.BaseURI
+	return c.instance, nil
+}
+
+// forget drops the instance after the proxy lost it, e.g. when it expired.
+func (c *RESTConsumer) forget(status int) {
+	if status == http.StatusNotFound {
+		c.mutex.Lock()
+		c.instance = ""
+		c.mutex.Unlock()
+	}
+}
+
+func (c *RESTConsumer) Poll(ctx context.Context) ([]Record, error) {
+	instance, err := c.create(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var records []Record
+	u := instance + "/records?timeout=" + strconv.FormatInt(c.Timeout.Milliseconds(), 10)
+	status, err := request(ctx, c.Client, http.MethodGet, u, nil, &records)
+	c.forget(status)
+	return records, err
+}
+
+func (c *RESTConsumer) Commit(ctx context.Context) error {
+	c.mutex.Lock()
+	instance := c.instance
+	c.mutex.Unlock()
+	if instance == "" {
+		return nil
+	}
+	// without a body the proxy commits all records fetched.
+	status, err := request(ctx, c.Client, http.MethodPost, instance+"/offsets", nil, nil)
+	c.forget(status)
+	return err
+}
+
+// Close deletes the consumer instance, so its partitions are given to the
+// other members of the group at once.
+func (c *RESTConsumer) Close() error {
+	c.mutex.Lock()
+	instance := c.instance
+	c.instance = ""
+	c.mutex.Unlock()
+	if instance == "" {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := request(ctx, c.Client, http.MethodDelete, instance, nil, nil)
+	return err
+}
+
+func (p *RESTProducer) Produce(ctx context.Context, topic string, records []Record) error {
+	type record struct {
+		Key   []byte `json:"key,omitempty"`
+		Value []byte `json:"value"`
+	}
+	body := struct {
+		Records []record `json:"records"`
+	}{make([]record, len(records))}
+	for i, r := range records {
+		body.Records[i] = record{r.Key, r.Value}
+	}
+	var result struct {
+		Offsets []struct {
+			ErrorCode *int   `json:"error_code"`
+			Error     string `json:"error"`
+		} `json:"offsets"`
+	}
+	if _, err := request(ctx, p.Client, http.MethodPost, p.Endpoint+"/topics/"+url.PathEscape(topic), body, &result); err != nil {
+		return err
+	}
+	for _, offset := range result.Offsets {
+		if offset.ErrorCode != nil {
+			return fmt.Errorf("kafka: produce to %s failed: %s", topic, offset.Error)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package kcp
+
+import (
+	"errors"
+	"io"
+	"net"
+
+	"github.com/funny/link"
+	"github.com/xtaci/kcp-go/v5"
+)
+
+type Config struct {
+	Block        kcp.BlockCrypt
+	DataShards   int
+	ParityShards int
+
+	NoDelay      int
+	Interval     int
+	Resend       int
+	NoCongestion int
+	SendWindow   int
+	RecvWindow   int
+	MTU          int
+	AckNoDelay   bool
+	StreamMode   bool
+}
+
+var DefaultConfig = Config{
+	NoDelay:      1,
+	Interval:     10,
+	Resend:       2,
+	NoCongestion: 1,
+	SendWindow:   128,
+	RecvWindow:   128,
+	MTU:          1400,
+	AckNoDelay:   true,
+	StreamMode:   true,
+}
+
+func (config *Config) apply(conn *kcp.UDPSession) {
+	conn.SetNoDelay(config.NoDelay, config.Interval, config.Resend, config.NoCongestion)
+	if config.SendWindow > 0 || config.RecvWindow > 0 {
+		conn.SetWindowSize(config.SendWindow, config.RecvWindow)
+	}
+	if config.MTU > 0 {
+		conn.SetMtu(config.MTU)
+	}
+	conn.SetACKNoDelay(config.AckNoDelay)
+	conn.SetStreamMode(config.StreamMode)
+}
+
+type Listener struct {
+	*kcp.Listener
+	config Config
+}
+
+func NewListener(address string, config Config) (*Listener, error) {
+	listener, err := kcp.ListenWithOptions(address, config.Block, config.DataShards, config.ParityShards)
+	if err != nil {
+		return nil, err
+	}
+	return &Listener{listener, config}, nil
+}
+
+func (listener *Listener) Accept() (net.Conn, error) {
+	conn, err := listener.Listener.AcceptKCP()
+	if err != nil {
+		if errors.Is(err, io.ErrClosedPipe) {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	listener.config.apply(conn)
+	return conn, nil
+}
+
+func Listen(address string, config Config, protocol link.Protocol, sendChanSize int, handler link.Handler) (*link.Server, error) {
+	listener, err := NewListener(address, config)
+	if err != nil {
+		return nil, err
+	}
+	return link.NewServer(listener, protocol, sendChanSize, handler), nil
+}
+
+func DialConn(address string, config Config) (net.Conn, error) {
+	conn, err := kcp.DialWithOptions(address, config.Block, config.DataShards, config.ParityShards)
+	if err != nil {
+		return nil, err
+	}
+	config.apply(conn)
+	return conn, nil
+}
+
+func Dial(address string, config Config, protocol link.Protocol, sendChanSize int) (*link.Session, error) {
+	conn, err := DialConn(address, config)
+	if err != nil {
+		return nil, err
+	}
+	codec, err := protocol.NewCodec(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return link.NewSession(codec, sendChanSize), nil
+}
@@ -0,0 +1,48 @@
+package kcp
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/funny/link"
+	"github.com/funny/link/codec"
+	"github.com/funny/utest"
+)
+
+type EchoMsg struct {
+	Text string
+}
+
+func Test_KCP(t *testing.T) {
+	json := codec.Json()
+	json.Register(EchoMsg{})
+	protocol := codec.FixLen(json, 2, binary.LittleEndian, 1024, 1024)
+
+	server, err := Listen("127.0.0.1:0", DefaultConfig, protocol, 0, link.HandlerFunc(func(session *link.Session) {
+		defer session.Close()
+		for {
+			msg, err := session.Receive()
+			if err != nil {
+				return
+			}
+			if session.Send(msg) != nil {
+				return
+			}
+		}
+	}))
+	utest.IsNilNow(t, err)
+	go server.Serve()
+	defer server.Stop()
+
+	session, err := Dial(server.Listener().Addr().String(), DefaultConfig, protocol, 0)
+	utest.IsNilNow(t, err)
+	defer session.Close()
+
+	for i := 0; i < 100; i++ {
+		err = session.Send(&EchoMsg{"hello"})
+		utest.IsNilNow(t, err)
+		msg, err := session.Receive()
+		utest.IsNilNow(t, err)
+		utest.EqualNow(t, msg.(*EchoMsg).Text, "hello")
+	}
+}
@@ -0,0 +1,162 @@
+package link
+
+import "sync"
+
+// labelIndex finds the sessions of a manager by label value.
+type labelIndex struct {
+	mutex  sync.RWMutex
+	labels map[string]map[string]map[uint64]*Session
+}
+
+func (index *labelIndex) add(session *Session, label, value string) {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+	if index.labels == nil {
+		index.labels = make(map[string]map[string]map[uint64]*Session)
+	}
+	values := index.labels[label]
+	if values == nil {
+		values = make(map[string]map[uint64]*Session)
+		index.labels[label] = values
+	}
+	sessions := values[value]
+	if sessions == nil {
+		sessions = make(map[uint64]*Session)
+		values[value] = sessions
+	}
+	sessions[session.id] = session
+}
+
+func (index *labelIndex) remove(session *Session, label, value string) {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+	values := index.labels[label]
+	sessions := values[value]
+	delete(sessions, session.id)
+	if len(sessions) == 0 {
+		delete(values, value)
+		if len(values) == 0 {
+			delete(index.labels, label)
+		}
+	}
+}
+
+func (index *labelIndex) find(label string, match func(value string) bool) []*Session {
+	index.mutex.RLock()
+	defer index.mutex.RUnlock()
+	var found []*Session
+	for value, sessions := range index.labels[label] {
+		if !match(value) {
+			continue
+		}
+		for _, session := range sessions {
+			found = append(found, session)
+		}
+	}
+	return found
+}
+
+// SetLabel labels the session, like "user" or "zone", so it can be found
+// with Manager.Find. A session has one value per label, an empty value
+// removes the label. Closed sessions can't be labeled.
+func (session *Session) SetLabel(label, value string) {
+	session.labelMutex.Lock()
+	defer session.labelMutex.Unlock()
+	if session.IsClosed() {
+		return
+	}
+	old, exists := session.labels[label]
+	if exists && old == value {
+		return
+	}
+	if exists {
+		delete(session.labels, label)
+		if session.manager != nil {
+			session.manager.index.remove(session, label, old)
+		}
+	}
+	if value == "" {
+		return
+	}
+	if session.labels == nil {
+		session.labels = make(map[string]string)
+	}
+	session.labels[label] = value
+	if session.manager != nil {
+		session.manager.index.add(session, label, value)
+	}
+}
+
+// Label returns the value of a label, empty when the session doesn't have it.
+func (session *Session) Label(label string) string {
+	session.labelMutex.Lock()
+	defer session.labelMutex.Unlock()
+	return session.labels[label]
+}
+
+// Labels returns a copy of the labels of the session.
+func (session *Session) Labels() map[string]string {
+	session.labelMutex.Lock()
+	defer session.labelMutex.Unlock()
+	labels := make(map[string]string, len(session.labels))
+	for label, value := range session.labels {
+		labels[label] = value
+	}
+	return labels
+}
+
+// unindex takes a closed session out of the label index of its manager.
+func (session *Session) unindex() {
+	session.labelMutex.Lock()
+	defer session.labelMutex.Unlock()
+	for label, value := range session.labels {
+		session.manager.index.remove(session, label, value)
+	}
+}
+
+// Find returns the sessions with value for label, without scanning all of
+// the sessions.
+func (manager *Manager) Find(label, value string) []*Session {
+	return manager.index.find(label, func(v string) bool { return v == value })
+}
+
+// FindFunc returns the sessions with a value for label which match accepts,
+// match is called once per distinct value and must not block.
+func (manager *Manager) FindFunc(label string, match func(value string) bool) []*Session {
+	return manager.index.find(label, match)
+}
+
+// Select returns the sessions which have all of labels, a session must
+// match every label and value.
+func (manager *Manager) Select(labels map[string]string) []*Session {
+	var found []*Session
+	first := true
+	for label, value := range labels {
+		if first {
+			found = manager.Find(label, value)
+			first = false
+			continue
+		}
+		n := 0
+		for _, session := range found {
+			if session.Label(label) == value {
+				found[n] = session
+				n++
+			}
+		}
+		found = found[:n]
+	}
+	return found
+}
+
+func (server *Server) Find(label, value string) []*Session {
+	return server.manager.Find(label, value)
+}
+
+func (server *Server) FindFunc(label string, match func(value string) bool) []*Session {
+	return server.manager.FindFunc(label, match)
+}
+
+func (server *Server) Select(labels map[string]string) []*Session {
+	return server.manager.Select(labels)
+}
@@ -0,0 +1,120 @@
+package linktest
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/funny/link"
+)
+
+// Golden is one conformance case: Message must encode to exactly Frame and
+// Frame must decode back to Message.
+type Golden struct {
+	Name    string
+	Message interface{}
+	Frame   []byte
+}
+
+const goldenExt = ".frame"
+
+// LoadGolden reads the golden frame of every message from dir, the frame of
+// messages[name] is stored in dir/name.frame as raw bytes so that other
+// languages can use the same files.
+func LoadGolden(dir string, messages map[string]interface{}) ([]Golden, error) {
+	names := make([]string, 0, len(messages))
+	for name := range messages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	cases := make([]Golden, 0, len(names))
+	for _, name := range names {
+		frame, err := os.ReadFile(filepath.Join(dir, name+goldenExt))
+		if err != nil {
+			return nil, err
+		}
+		cases = append(cases, Golden{name, messages[name], frame})
+	}
+	return cases, nil
+}
+
+// WriteGolden encodes messages with protocol and (re)writes the golden files.
+func WriteGolden(dir string, protocol link.Protocol, messages map[string]interface{}) error {
+	for name, msg := range messages {
+		frame, err := Encode(protocol, msg)
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name+goldenExt), frame, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Encode returns the bytes protocol writes for msg.
+func Encode(protocol link.Protocol, msg interface{}) ([]byte, error) {
+	var stream bytes.Buffer
+	codec, err := protocol.NewCodec(&stream)
+	if err != nil {
+		return nil, err
+	}
+	if err := codec.Send(msg); err != nil {
+		return nil, err
+	}
+	if flusher, ok := codec.(interface{ Flush() error }); ok {
+		if err := flusher.Flush(); err != nil {
+			return nil, err
+		}
+	}
+	return stream.Bytes(), nil
+}
+
+// Decode reads one message of protocol from frame, frame must hold nothing
+// else.
+func Decode(protocol link.Protocol, frame []byte) (interface{}, error) {
+	reader := bytes.NewReader(frame)
+	codec, err := protocol.NewCodec(&frameReader{reader})
+	if err != nil {
+		return nil, err
+	}
+	msg, err := codec.Receive()
+	if err != nil {
+		return nil, err
+	}
+	if reader.Len() != 0 {
+		return nil, fmt.Errorf("%d trailing bytes", reader.Len())
+	}
+	return msg, nil
+}
+
+type frameReader struct {
+	*bytes.Reader
+}
+
+func (r *frameReader) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+// Conformance checks protocol against the golden cases in both directions.
+func Conformance(t testing.TB, protocol link.Protocol, cases []Golden) {
+	t.Helper()
+	for _, c := range cases {
+		frame, err := Encode(protocol, c.Message)
+		if err != nil {
+			t.Errorf("%s: encode: %v", c.Name, err)
+		} else if !bytes.Equal(frame, c.Frame) {
+			t.Errorf("%s: encode: got %q, want %q", c.Name, frame, c.Frame)
+		}
+		msg, err := Decode(protocol, c.Frame)
+		if err != nil {
+			t.Errorf("%s: decode: %v", c.Name, err)
+		} else if !reflect.DeepEqual(msg, c.Message) {
+			t.Errorf("%s: decode: got %#v, want %#v", c.Name, msg, c.Message)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package linktest
+
+import (
+	"encoding/binary"
+	"flag"
+	"testing"
+
+	"github.com/funny/link"
+	"github.com/funny/link/codec"
+)
+
+var update = flag.Bool("update", false, "rewrite golden frames")
+
+type Login struct {
+	User  string
+	Token []byte
+}
+
+type Chat struct {
+	Room int
+	Text string
+}
+
+func goldenProtocol() link.Protocol {
+	json := codec.Json()
+	json.RegisterName("login", Login{})
+	json.RegisterName("chat", Chat{})
+	return codec.FixLen(json, 2, binary.BigEndian, 1024, 1024)
+}
+
+var goldenMessages = map[string]interface{}{
+	"login":      &Login{"alice", []byte{1, 2, 3}},
+	"chat":       &Chat{7, "hello"},
+	"chat_empty": &Chat{},
+}
+
+func Test_Conformance(t *testing.T) {
+	if *update {
+		if err := WriteGolden("testdata", goldenProtocol(), goldenMessages); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cases, err := LoadGolden("testdata", goldenMessages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	Conformance(t, goldenProtocol(), cases)
+}
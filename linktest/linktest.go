@@ -0,0 +1,258 @@
+package linktest
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/clock"
+)
+
+var ClosedError = link.NewError(link.TransportError, "Mock Codec Closed")
+
+// chanCodec passes messages as values, no encoding involved.
+type chanCodec struct {
+	in        <-chan interface{}
+	out       chan<- interface{}
+	closeOnce sync.Once
+	closeChan chan struct{}
+	peer      *chanCodec
+}
+
+func (c *chanCodec) Receive() (interface{}, error) {
+	select {
+	case msg, ok := <-c.in:
+		if !ok {
+			return nil, io.EOF
+		}
+		return msg, nil
+	case <-c.closeChan:
+		return nil, io.EOF
+	case <-c.peer.closeChan:
+		return nil, io.EOF
+	}
+}
+
+func (c *chanCodec) Send(msg interface{}) error {
+	select {
+	case <-c.closeChan:
+		return ClosedError
+	case <-c.peer.closeChan:
+		return ClosedError
+	default:
+	}
+	select {
+	case c.out <- msg:
+		return nil
+	case <-c.closeChan:
+		return ClosedError
+	case <-c.peer.closeChan:
+		return ClosedError
+	}
+}
+
+func (c *chanCodec) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closeChan)
+	})
+	return nil
+}
+
+// Pair returns two connected sessions which exchange messages as values.
+func Pair(sendChanSize int) (*link.Session, *link.Session) {
+	ab := make(chan interface{}, 1024)
+	ba := make(chan interface{}, 1024)
+	a := &chanCodec{in: ba, out: ab, closeChan: make(chan struct{})}
+	b := &chanCodec{in: ab, out: ba, closeChan: make(chan struct{}), peer: a}
+	a.peer = b
+	return link.NewSession(a, sendChanSize), link.NewSession(b, sendChanSize)
+}
+
+// MockSession is a session whose peer is the test: messages sent by the
+// handler are captured and Push feeds messages to Receive.
+type MockSession struct {
+	*link.Session
+	input  chan interface{}
+	mutex  sync.Mutex
+	sent   []interface{}
+	notify chan struct{}
+
+	Clock clock.Clock // of WaitSent, nil is clock.Default()
+}
+
+type mockCodec struct {
+	mock      *MockSession
+	closeOnce sync.Once
+	closeChan chan struct{}
+}
+
+func NewMockSession() *MockSession {
+	mock := &MockSession{
+		input:  make(chan interface{}, 1024),
+		notify: make(chan struct{}, 1),
+	}
+	mock.Session = link.NewSession(&mockCodec{mock: mock, closeChan: make(chan struct{})}, 0)
+	return mock
+}
+
+func (c *mockCodec) Receive() (interface{}, error) {
+	select {
+	case msg, ok := <-c.mock.input:
+		if !ok {
+			return nil, io.EOF
+		}
+		return msg, nil
+	case <-c.closeChan:
+		return nil, io.EOF
+	}
+}
+
+func (c *mockCodec) Send(msg interface{}) error {
+	c.mock.mutex.Lock()
+	c.mock.sent = append(c.mock.sent, msg)
+	c.mock.mutex.Unlock()
+	select {
+	case c.mock.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (c *mockCodec) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closeChan)
+	})
+	return nil
+}
+
+// Push queues msg to be returned by Receive.
+func (mock *MockSession) Push(msg interface{}) {
+	mock.input <- msg
+}
+
+// EndInput makes Receive return io.EOF after the pushed messages.
+func (mock *MockSession) EndInput() {
+	close(mock.input)
+}
+
+func (mock *MockSession) Sent() []interface{} {
+	mock.mutex.Lock()
+	defer mock.mutex.Unlock()
+	return append([]interface{}(nil), mock.sent...)
+}
+
+// WaitSent waits until at least n messages were sent.
+func (mock *MockSession) WaitSent(n int, timeout time.Duration) ([]interface{}, error) {
+	timer := clock.Or(mock.Clock).NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		if sent := mock.Sent(); len(sent) >= n {
+			return sent, nil
+		}
+		select {
+		case <-mock.notify:
+		case <-timer.C():
+			return mock.Sent(), fmt.Errorf("linktest: %d messages sent, want %d", len(mock.Sent()), n)
+		}
+	}
+}
+
+type step struct {
+	send   interface{}
+	expect interface{}
+	check  func(msg interface{}) error
+	closed bool
+}
+
+// ScriptedPeer plays a fixed conversation against a handler: it sends the
+// scripted messages and checks the replies in order.
+type ScriptedPeer struct {
+	steps   []step
+	Timeout time.Duration
+	Clock   clock.Clock // of Timeout, nil is clock.Default()
+}
+
+func NewScriptedPeer() *ScriptedPeer {
+	return &ScriptedPeer{Timeout: time.Second}
+}
+
+func (p *ScriptedPeer) Send(msg interface{}) *ScriptedPeer {
+	p.steps = append(p.steps, step{send: msg})
+	return p
+}
+
+// Expect waits for a reply deeply equal to msg.
+func (p *ScriptedPeer) Expect(msg interface{}) *ScriptedPeer {
+	p.steps = append(p.steps, step{expect: msg})
+	return p
+}
+
+func (p *ScriptedPeer) ExpectFunc(check func(msg interface{}) error) *ScriptedPeer {
+	p.steps = append(p.steps, step{check: check})
+	return p
+}
+
+// ExpectClose waits for the handler to close the session.
+func (p *ScriptedPeer) ExpectClose() *ScriptedPeer {
+	p.steps = append(p.steps, step{closed: true})
+	return p
+}
+
+type received struct {
+	msg interface{}
+	err error
+}
+
+// Run starts handler on one end of a Pair and plays the script on the
+// other end. The first step which fails is reported.
+func (p *ScriptedPeer) Run(handler link.Handler) error {
+	peer, session := Pair(0)
+	defer peer.Close()
+	go handler.HandleSession(session)
+
+	recvChan := make(chan received, 1)
+	go func() {
+		for {
+			msg, err := peer.Receive()
+			recvChan <- received{msg, err}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	for i, s := range p.steps {
+		if s.send != nil {
+			if err := peer.Send(s.send); err != nil {
+				return fmt.Errorf("linktest: step %d: send: %v", i, err)
+			}
+			continue
+		}
+		var r received
+		timer := clock.Or(p.Clock).NewTimer(p.Timeout)
+		select {
+		case r = <-recvChan:
+			timer.Stop()
+		case <-timer.C():
+			return fmt.Errorf("linktest: step %d: timeout", i)
+		}
+		switch {
+		case s.closed:
+			if r.err == nil {
+				return fmt.Errorf("linktest: step %d: want close, got %#v", i, r.msg)
+			}
+		case r.err != nil:
+			return fmt.Errorf("linktest: step %d: %v", i, r.err)
+		case s.check != nil:
+			if err := s.check(r.msg); err != nil {
+				return fmt.Errorf("linktest: step %d: %v", i, err)
+			}
+		case !reflect.DeepEqual(r.msg, s.expect):
+			return fmt.Errorf("linktest: step %d: got %#v, want %#v", i, r.msg, s.expect)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package linktest
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/utest"
+)
+
+var upper = link.HandlerFunc(func(session *link.Session) {
+	for {
+		msg, err := session.Receive()
+		if err != nil {
+			return
+		}
+		if msg.(string) == "bye" {
+			session.Close()
+			return
+		}
+		session.Send(strings.ToUpper(msg.(string)))
+	}
+})
+
+func Test_MockSession(t *testing.T) {
+	mock := NewMockSession()
+	mock.Push("a")
+	mock.Push("b")
+	mock.EndInput()
+	upper.HandleSession(mock.Session)
+
+	sent, err := mock.WaitSent(2, time.Second)
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, sent, []interface{}{"A", "B"})
+}
+
+func Test_ScriptedPeer(t *testing.T) {
+	err := NewScriptedPeer().
+		Send("hello").Expect("HELLO").
+		Send("x").ExpectFunc(func(msg interface{}) error {
+		if msg != "X" {
+			return errors.New("not X")
+		}
+		return nil
+	}).
+		Send("bye").ExpectClose().
+		Run(upper)
+	utest.IsNilNow(t, err)
+
+	err = NewScriptedPeer().Send("hello").Expect("hello").Run(upper)
+	utest.NotNilNow(t, err)
+	utest.Assert(t, strings.HasPrefix(err.Error(), "linktest: step 1:"))
+}
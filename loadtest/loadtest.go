@@ -0,0 +1,236 @@
+package loadtest
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/codec"
+)
+
+// Protocol frames []byte messages with a 4 byte length, it is what Echo and
+// Run speak.
+func Protocol(maxPacket int) link.Protocol {
+	return codec.FixLen(link.ProtocolFunc(newRawCodec), 4, binary.LittleEndian, maxPacket, maxPacket)
+}
+
+type rawCodec struct {
+	rw io.ReadWriter
+}
+
+func newRawCodec(rw io.ReadWriter) (link.Codec, error) {
+	return &rawCodec{rw}, nil
+}
+
+func (c *rawCodec) Receive() (interface{}, error) {
+	return io.ReadAll(c.rw)
+}
+
+func (c *rawCodec) Send(msg interface{}) error {
+	_, err := c.rw.Write(msg.([]byte))
+	return err
+}
+
+func (c *rawCodec) Close() error {
+	if closer, ok := c.rw.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// Echo sends every message back to where it came from.
+var Echo = link.HandlerFunc(func(session *link.Session) {
+	for {
+		msg, err := session.Receive()
+		if err != nil {
+			return
+		}
+		if session.Send(msg) != nil {
+			return
+		}
+	}
+})
+
+type Config struct {
+	Network     string
+	Address     string
+	Protocol    link.Protocol // defaults to Protocol(PayloadSize)
+	Connections int
+	Rate        float64       // messages per second per connection, 0 sends the next after the reply
+	PayloadSize int           // at least 8, the send time is in the first bytes
+	RampUp      time.Duration // connections are started evenly over this period
+	Duration    time.Duration // how long each connection sends
+	MaxSamples  int           // latency samples kept, default 100000
+}
+
+type Result struct {
+	Connections int
+	ConnErrors  uint64
+	Sent        uint64
+	Received    uint64
+	Errors      uint64
+	Elapsed     time.Duration
+	P50         time.Duration
+	P90         time.Duration
+	P99         time.Duration
+	Max         time.Duration
+}
+
+type runner struct {
+	config  Config
+	result  Result
+	mutex   sync.Mutex
+	samples []time.Duration
+	seen    uint64
+}
+
+// Run generates load against an echo service until the duration elapsed or
+// ctx is done.
+func Run(ctx context.Context, config Config) *Result {
+	if config.Network == "" {
+		config.Network = "tcp"
+	}
+	if config.PayloadSize < 8 {
+		config.PayloadSize = 8
+	}
+	if config.Protocol == nil {
+		config.Protocol = Protocol(config.PayloadSize)
+	}
+	if config.MaxSamples <= 0 {
+		config.MaxSamples = 100000
+	}
+	r := &runner{config: config}
+	r.result.Connections = config.Connections
+
+	start := time.Now()
+	var wait sync.WaitGroup
+	for i := 0; i < config.Connections; i++ {
+		delay := time.Duration(0)
+		if config.Connections > 1 {
+			delay = config.RampUp * time.Duration(i) / time.Duration(config.Connections)
+		}
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return
+			}
+			r.connection(ctx)
+		}()
+	}
+	wait.Wait()
+	r.result.Elapsed = time.Since(start)
+	r.summarize()
+	return &r.result
+}
+
+func (r *runner) connection(ctx context.Context) {
+	session, err := link.Dial(r.config.Network, r.config.Address, r.config.Protocol, 0)
+	if err != nil {
+		atomic.AddUint64(&r.result.ConnErrors, 1)
+		return
+	}
+	defer session.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, r.config.Duration)
+	defer cancel()
+	context.AfterFunc(ctx, func() { session.Close() })
+
+	if r.config.Rate <= 0 {
+		for ctx.Err() == nil {
+			if !r.send(session) || !r.receive(session) {
+				return
+			}
+		}
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for r.receive(session) {
+		}
+	}()
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / r.config.Rate))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if !r.send(session) {
+				<-done
+				return
+			}
+		case <-ctx.Done():
+			session.Close()
+			<-done
+			return
+		}
+	}
+}
+
+func (r *runner) send(session *link.Session) bool {
+	payload := make([]byte, r.config.PayloadSize)
+	binary.LittleEndian.PutUint64(payload, uint64(time.Now().UnixNano()))
+	if err := session.Send(payload); err != nil {
+		if !session.IsClosed() {
+			atomic.AddUint64(&r.result.Errors, 1)
+		}
+		return false
+	}
+	atomic.AddUint64(&r.result.Sent, 1)
+	return true
+}
+
+func (r *runner) receive(session *link.Session) bool {
+	msg, err := session.Receive()
+	if err != nil {
+		return false
+	}
+	payload := msg.([]byte)
+	if len(payload) < 8 {
+		atomic.AddUint64(&r.result.Errors, 1)
+		return true
+	}
+	atomic.AddUint64(&r.result.Received, 1)
+	latency := time.Since(time.Unix(0, int64(binary.LittleEndian.Uint64(payload))))
+	r.sample(latency)
+	return true
+}
+
+// sample keeps a uniform sample of the latencies for the percentiles.
+func (r *runner) sample(latency time.Duration) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.seen++
+	if latency > r.result.Max {
+		r.result.Max = latency
+	}
+	if len(r.samples) < r.config.MaxSamples {
+		r.samples = append(r.samples, latency)
+		return
+	}
+	if i := rand.Int63n(int64(r.seen)); i < int64(len(r.samples)) {
+		r.samples[i] = latency
+	}
+}
+
+func (r *runner) summarize() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if len(r.samples) == 0 {
+		return
+	}
+	sort.Slice(r.samples, func(i, j int) bool { return r.samples[i] < r.samples[j] })
+	at := func(p float64) time.Duration {
+		return r.samples[int(p*float64(len(r.samples)-1))]
+	}
+	r.result.P50, r.result.P90, r.result.P99 = at(0.5), at(0.9), at(0.99)
+}
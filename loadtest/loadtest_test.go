@@ -0,0 +1,33 @@
+package loadtest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/utest"
+)
+
+func Test_LoadTest(t *testing.T) {
+	server, err := link.Listen("tcp", "127.0.0.1:0", Protocol(1024), 0, Echo)
+	utest.IsNilNow(t, err)
+	go server.Serve()
+	defer server.Stop()
+
+	for _, rate := range []float64{0, 200} {
+		result := Run(context.Background(), Config{
+			Address:     server.Listener().Addr().String(),
+			Connections: 4,
+			Rate:        rate,
+			PayloadSize: 64,
+			RampUp:      20 * time.Millisecond,
+			Duration:    100 * time.Millisecond,
+		})
+		utest.EqualNow(t, result.ConnErrors, uint64(0))
+		utest.EqualNow(t, result.Errors, uint64(0))
+		utest.Assert(t, result.Received > 0)
+		utest.Assert(t, result.Sent >= result.Received)
+		utest.Assert(t, result.P50 <= result.P99 && result.P99 <= result.Max)
+	}
+}
@@ -0,0 +1,43 @@
+package link
+
+import (
+	"sync/atomic"
+)
+
+// Logger receives connection events, protocol errors and drops. The method
+// set matches *slog.Logger, so slog.Default() can be passed as is.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...interface{}) {}
+func (nopLogger) Info(string, ...interface{})  {}
+func (nopLogger) Warn(string, ...interface{})  {}
+func (nopLogger) Error(string, ...interface{}) {}
+
+type loggerHolder struct {
+	Logger
+}
+
+var globalLogger atomic.Value
+
+func init() {
+	globalLogger.Store(loggerHolder{nopLogger{}})
+}
+
+// SetLogger sets the logger of the package, nil turns logging off.
+func SetLogger(logger Logger) {
+	if logger == nil {
+		logger = nopLogger{}
+	}
+	globalLogger.Store(loggerHolder{logger})
+}
+
+func GetLogger() Logger {
+	return globalLogger.Load().(loggerHolder).Logger
+}
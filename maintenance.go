@@ -0,0 +1,89 @@
+package link
+
+import (
+	"time"
+
+	"github.com/funny/link/clock"
+)
+
+// maintenanceGrace is how long a session closed by maintenance may take to
+// flush its send queue.
+const maintenanceGrace = time.Second
+
+type maintenance struct {
+	timer clock.Timer
+}
+
+// EnterMaintenance sends notice to every session, stops taking new sessions
+// and closes the remaining ones at the deadline once their send queues are
+// flushed. Entering again moves the deadline and sends the new notice, a nil
+// notice sends nothing.
+func (server *Server) EnterMaintenance(at time.Time, notice interface{}) {
+	server.maintenanceMutex.Lock()
+	if server.maintenance != nil {
+		server.maintenance.timer.Stop()
+	}
+	m := &maintenance{}
+	clk := clock.Default()
+	m.timer = clk.AfterFunc(at.Sub(clk.Now()), func() {
+		server.maintenanceMutex.Lock()
+		current := server.maintenance == m
+		server.maintenanceMutex.Unlock()
+		if current {
+			server.closeForMaintenance()
+		}
+	})
+	server.maintenance = m
+	server.maintenanceMutex.Unlock()
+	GetLogger().Info("link: maintenance", "addr", server.listener.Addr(), "at", at)
+
+	if notice == nil {
+		return
+	}
+	var sessions []*Session
+	server.manager.Fetch(func(session *Session) {
+		sessions = append(sessions, session)
+	})
+	for _, session := range sessions {
+		session.Send(notice)
+	}
+}
+
+// LeaveMaintenance takes new sessions again, sessions which are still open
+// stay open.
+func (server *Server) LeaveMaintenance() {
+	server.maintenanceMutex.Lock()
+	defer server.maintenanceMutex.Unlock()
+	if server.maintenance != nil {
+		server.maintenance.timer.Stop()
+		server.maintenance = nil
+	}
+}
+
+// InMaintenance reports whether the server is in maintenance mode.
+func (server *Server) InMaintenance() bool {
+	server.maintenanceMutex.Lock()
+	defer server.maintenanceMutex.Unlock()
+	return server.maintenance != nil
+}
+
+func (server *Server) closeForMaintenance() {
+	var sessions []*Session
+	server.manager.Fetch(func(session *Session) {
+		sessions = append(sessions, session)
+	})
+	GetLogger().Info("link: closing sessions for maintenance", "addr", server.listener.Addr(), "sessions", len(sessions))
+	for _, session := range sessions {
+		go closeFlushed(session, maintenanceGrace)
+	}
+}
+
+// closeFlushed closes session when its send queue is empty or after timeout.
+func closeFlushed(session *Session, timeout time.Duration) {
+	clk := clock.Default()
+	deadline := clk.Now().Add(timeout)
+	for session.SendQueueLen() > 0 && clk.Now().Before(deadline) {
+		<-clk.NewTimer(10 * time.Millisecond).C()
+	}
+	session.Close()
+}
@@ -8,6 +8,7 @@ type Manager struct {
 	sessionMaps [sessionMapNum]sessionMap
 	disposeOnce sync.Once
 	disposeWait sync.WaitGroup
+	index       labelIndex
 }
 
 type sessionMap struct {
@@ -54,6 +55,40 @@ func (manager *Manager) GetSession(sessionID uint64) *Session {
 	return session
 }
 
+// Fetch calls callback for every session of the manager. The callback must
+// not block, it runs under the lock of one session map.
+func (manager *Manager) Fetch(callback func(*Session)) {
+	for i := 0; i < sessionMapNum; i++ {
+		smap := &manager.sessionMaps[i]
+		smap.RLock()
+		for _, session := range smap.sessions {
+			callback(session)
+		}
+		smap.RUnlock()
+	}
+}
+
+func (manager *Manager) Len() int {
+	n := 0
+	for i := 0; i < sessionMapNum; i++ {
+		smap := &manager.sessionMaps[i]
+		smap.RLock()
+		n += len(smap.sessions)
+		smap.RUnlock()
+	}
+	return n
+}
+
+func (manager *Manager) Multicast(msg interface{}, ids []uint64) (failed int) {
+	for _, id := range ids {
+		session := manager.GetSession(id)
+		if session == nil || session.Send(msg) != nil {
+			failed++
+		}
+	}
+	return
+}
+
 func (manager *Manager) putSession(session *Session) {
 	smap := &manager.sessionMaps[session.id%sessionMapNum]
 
@@ -70,6 +105,8 @@ func (manager *Manager) putSession(session *Session) {
 }
 
 func (manager *Manager) delSession(session *Session) {
+	session.unindex()
+
 	smap := &manager.sessionMaps[session.id%sessionMapNum]
 
 	smap.Lock()
@@ -0,0 +1,121 @@
+package metrics
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/funny/link"
+)
+
+type protocol struct {
+	m    *Metrics
+	base link.Protocol
+}
+
+// Protocol wraps base to count messages, bytes and codec latency.
+func (m *Metrics) Protocol(base link.Protocol) link.Protocol {
+	return &protocol{m, base}
+}
+
+func (p *protocol) NewCodec(rw io.ReadWriter) (link.Codec, error) {
+	crw := &countingReadWriter{ReadWriter: rw, m: p.m}
+	base, err := p.base.NewCodec(crw)
+	if err != nil {
+		return nil, err
+	}
+	return &metricsCodec{base, p.m, crw}, nil
+}
+
+type countingReadWriter struct {
+	io.ReadWriter
+	m         *Metrics
+	firstRead time.Time
+	recvBytes int
+	sendBytes int64
+}
+
+func (rw *countingReadWriter) Read(p []byte) (int, error) {
+	n, err := rw.ReadWriter.Read(p)
+	if n > 0 {
+		if rw.firstRead.IsZero() {
+			rw.firstRead = time.Now()
+		}
+		atomic.AddUint64(&rw.m.bytesRecv, uint64(n))
+		rw.recvBytes += n
+	}
+	return n, err
+}
+
+func (rw *countingReadWriter) Write(p []byte) (int, error) {
+	n, err := rw.ReadWriter.Write(p)
+	atomic.AddUint64(&rw.m.bytesSent, uint64(n))
+	atomic.AddInt64(&rw.sendBytes, int64(n))
+	return n, err
+}
+
+func (rw *countingReadWriter) Close() error {
+	if closer, ok := rw.ReadWriter.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+type metricsCodec struct {
+	base link.Codec
+	m    *Metrics
+	rw   *countingReadWriter
+}
+
+// Receive measures the decode latency from the first byte of a message, so
+// the time spent waiting for the peer is not counted. The payload size is
+// the bytes read during Receive, codecs reading ahead make it approximate.
+func (c *metricsCodec) Receive() (interface{}, error) {
+	msg, err := c.base.Receive()
+	if err != nil {
+		return nil, err
+	}
+	if !c.rw.firstRead.IsZero() {
+		c.m.decodeLatency.ObserveDuration(time.Since(c.rw.firstRead))
+		c.rw.firstRead = time.Time{}
+	}
+	c.m.countRecv(msg, c.rw.recvBytes)
+	c.rw.recvBytes = 0
+	return msg, nil
+}
+
+// Send is serialized by the session, so the bytes written in between are
+// the size of msg.
+func (c *metricsCodec) Send(msg interface{}) error {
+	start := time.Now()
+	before := atomic.LoadInt64(&c.rw.sendBytes)
+	if err := c.base.Send(msg); err != nil {
+		return err
+	}
+	c.m.encodeLatency.ObserveDuration(time.Since(start))
+	c.m.countSend(msg, int(atomic.LoadInt64(&c.rw.sendBytes)-before))
+	return nil
+}
+
+func (c *metricsCodec) Close() error {
+	return c.base.Close()
+}
+
+// ClearSendChan counts the messages still queued when the session closed.
+func (c *metricsCodec) ClearSendChan(ch <-chan interface{}) {
+	clear, ok := c.base.(link.ClearSendChan)
+	var rest chan interface{}
+	if ok {
+		rest = make(chan interface{}, len(ch))
+	}
+	for msg := range ch {
+		atomic.AddUint64(&c.m.dropped, 1)
+		if ok {
+			rest <- msg
+		}
+	}
+	if ok {
+		close(rest)
+		clear.ClearSendChan(rest)
+	}
+}
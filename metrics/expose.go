@@ -0,0 +1,131 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type writer struct {
+	w         *bufio.Writer
+	namespace string
+}
+
+func (w *writer) name(name string) string {
+	if w.namespace == "" {
+		return name
+	}
+	return w.namespace + "_" + name
+}
+
+func (w *writer) header(name, typ, help string) {
+	fmt.Fprintf(w.w, "# HELP %s %s\n# TYPE %s %s\n", w.name(name), help, w.name(name), typ)
+}
+
+func (w *writer) value(name, typ, help string, v float64) {
+	w.header(name, typ, help)
+	fmt.Fprintf(w.w, "%s %s\n", w.name(name), formatFloat(v))
+}
+
+func (w *writer) labeled(name, help, label string, values map[string]uint64) {
+	w.header(name, "counter", help)
+	for _, k := range sortedKeys(values) {
+		fmt.Fprintf(w.w, "%s{%s=\"%s\"} %d\n", w.name(name), label, escapeLabel(k), values[k])
+	}
+}
+
+func (w *writer) histogram(name, help string, h *Histogram) {
+	w.header(name, "histogram", help)
+	w.histogramValues(name, "", h)
+}
+
+// histogramValues writes the samples of h, labels is either empty or a
+// label list ending with a comma.
+func (w *writer) histogramValues(name, labels string, h *Histogram) {
+	bounds, counts := h.Cumulative()
+	for i, bound := range bounds {
+		fmt.Fprintf(w.w, "%s_bucket{%sle=\"%s\"} %d\n", w.name(name), labels, formatFloat(bound), counts[i])
+	}
+	fmt.Fprintf(w.w, "%s_bucket{%sle=\"+Inf\"} %d\n", w.name(name), labels, h.Count())
+	if labels != "" {
+		labels = "{" + strings.TrimSuffix(labels, ",") + "}"
+	}
+	fmt.Fprintf(w.w, "%s_sum%s %s\n", w.name(name), labels, formatFloat(h.Sum()))
+	fmt.Fprintf(w.w, "%s_count%s %d\n", w.name(name), labels, h.Count())
+}
+
+func (w *writer) typeHistograms(name, help string, types map[string]*typeStats, pick func(*typeStats) *Histogram) {
+	w.header(name, "histogram", help)
+	for _, k := range sortedKeys(types) {
+		if h := pick(types[k]); h.Count() > 0 {
+			w.histogramValues(name, "type=\""+escapeLabel(k)+"\",", h)
+		}
+	}
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// escapeLabel escapes a label value as the text format wants it, only
+// backslash, double quote and line feed are escaped, other UTF-8 is kept.
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(strings.ToValidUTF8(s, "?"))
+}
+
+// WriteTo writes all metrics in the Prometheus text exposition format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	cw := &countWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	pw := &writer{bw, m.namespace}
+	s := m.Snapshot()
+
+	pw.value("accepts_total", "counter", "Accepted connections.", float64(s.Accepts))
+	pw.value("accept_errors_total", "counter", "Failed accepts.", float64(s.AcceptErrors))
+	pw.value("sessions_total", "counter", "Sessions created.", float64(s.SessionsTotal))
+	pw.value("sessions_active", "gauge", "Sessions currently open.", float64(s.SessionsActive))
+	pw.value("received_bytes_total", "counter", "Bytes read from connections.", float64(s.BytesRecv))
+	pw.value("sent_bytes_total", "counter", "Bytes written to connections.", float64(s.BytesSent))
+	pw.value("send_queue_depth", "gauge", "Messages waiting in send channels.", float64(s.SendQueueDepth))
+	pw.value("dropped_messages_total", "counter", "Queued messages discarded on session close.", float64(s.Dropped))
+	pw.labeled("received_messages_total", "Messages received by type.", "type", s.RecvMsgs)
+	pw.labeled("sent_messages_total", "Messages sent by type.", "type", s.SendMsgs)
+	pw.histogram("decode_seconds", "Time to decode a message.", m.decodeLatency)
+	pw.histogram("encode_seconds", "Time to encode and write a message.", m.encodeLatency)
+	pw.histogram("one_way_latency_seconds", "Time from send to receive of stamped frames.", m.oneWayLatency)
+	pw.histogram("rtt_seconds", "Round trip time of stamped frames.", m.rttLatency)
+
+	m.mutex.RLock()
+	types := make(map[string]*typeStats, len(m.types))
+	for k, v := range m.types {
+		types[k] = v
+	}
+	m.mutex.RUnlock()
+	pw.typeHistograms("handler_seconds", "Handler time by message type.", types, func(ts *typeStats) *Histogram { return ts.handler })
+	pw.typeHistograms("received_message_bytes", "Received payload size by message type.", types, func(ts *typeStats) *Histogram { return ts.recvSize })
+	pw.typeHistograms("sent_message_bytes", "Sent payload size by message type.", types, func(ts *typeStats) *Histogram { return ts.sendSize })
+
+	err := bw.Flush()
+	return cw.n, err
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
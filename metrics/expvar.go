@@ -0,0 +1,40 @@
+package metrics
+
+import (
+	"expvar"
+	"runtime"
+)
+
+type expvarValue struct {
+	Snapshot
+	Goroutines int
+	HeapAlloc  uint64
+	HeapInuse  uint64
+	Mallocs    uint64
+	Frees      uint64
+	NumGC      uint32
+}
+
+// Var returns an expvar.Var reporting the current snapshot together with
+// the allocator numbers of the runtime.
+func (m *Metrics) Var() expvar.Var {
+	return expvar.Func(func() interface{} {
+		var mem runtime.MemStats
+		runtime.ReadMemStats(&mem)
+		return expvarValue{
+			Snapshot:   m.Snapshot(),
+			Goroutines: runtime.NumGoroutine(),
+			HeapAlloc:  mem.HeapAlloc,
+			HeapInuse:  mem.HeapInuse,
+			Mallocs:    mem.Mallocs,
+			Frees:      mem.Frees,
+			NumGC:      mem.NumGC,
+		}
+	})
+}
+
+// Publish registers m under name in expvar, so it shows up on /debug/vars.
+// Like expvar.Publish it panics if the name is already in use.
+func (m *Metrics) Publish(name string) {
+	expvar.Publish(name, m.Var())
+}
@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// DefBuckets are latency buckets in seconds, the same as the Prometheus
+// client defaults.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// SizeBuckets are payload size buckets in bytes.
+var SizeBuckets = []float64{32, 64, 128, 256, 512, 1024, 4096, 16384, 65536, 262144, 1048576}
+
+type Histogram struct {
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sumBits uint64
+}
+
+func NewHistogram(buckets []float64) *Histogram {
+	return &Histogram{
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)),
+	}
+}
+
+func (h *Histogram) Observe(v float64) {
+	for i, bound := range h.buckets {
+		if v <= bound {
+			atomic.AddUint64(&h.counts[i], 1)
+			break
+		}
+	}
+	atomic.AddUint64(&h.count, 1)
+	for {
+		old := atomic.LoadUint64(&h.sumBits)
+		sum := math.Float64bits(math.Float64frombits(old) + v)
+		if atomic.CompareAndSwapUint64(&h.sumBits, old, sum) {
+			return
+		}
+	}
+}
+
+func (h *Histogram) ObserveDuration(d time.Duration) {
+	h.Observe(d.Seconds())
+}
+
+func (h *Histogram) Count() uint64 {
+	return atomic.LoadUint64(&h.count)
+}
+
+func (h *Histogram) Sum() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&h.sumBits))
+}
+
+// Cumulative returns the bucket upper bounds and the cumulative count of
+// every bucket, the +Inf bucket is Count.
+func (h *Histogram) Cumulative() ([]float64, []uint64) {
+	counts := make([]uint64, len(h.counts))
+	var total uint64
+	for i := range h.counts {
+		total += atomic.LoadUint64(&h.counts[i])
+		counts[i] = total
+	}
+	return h.buckets, counts
+}
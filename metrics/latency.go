@@ -0,0 +1,137 @@
+package metrics
+
+import (
+	"encoding/binary"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/funny/link"
+)
+
+const stampSize = 16
+
+// Latency wraps base to stamp every frame with the time it was sent and an
+// echo of the last stamp received, so both ends learn the one way latency
+// and the round trip time of each frame. Like codec.Seq it goes around a
+// framing protocol and reads the stream itself, only Bufio and Protocol may
+// sit above it. One way latency is corrected by Session.ClockOffset, see the
+// timesync package, for sessions passed to Track, otherwise the clocks are
+// taken to be in sync.
+func (m *Metrics) Latency(base link.Protocol) link.Protocol {
+	return link.ProtocolFunc(func(rw io.ReadWriter) (link.Codec, error) {
+		c := &latencyCodec{
+			rw:     rw,
+			m:      m,
+			oneWay: NewHistogram(DefBuckets),
+			rtt:    NewHistogram(DefBuckets),
+		}
+		var err error
+		if c.base, err = base.NewCodec(rw); err != nil {
+			return nil, err
+		}
+		return c, nil
+	})
+}
+
+type latencyCodec struct {
+	base    link.Codec
+	rw      io.ReadWriter
+	m       *Metrics
+	session atomic.Pointer[link.Session]
+
+	mutex  sync.Mutex
+	echo   int64 // the last stamp of the peer and when it came
+	echoAt time.Time
+
+	oneWay, rtt *Histogram
+
+	sendHead [stampSize]byte
+	recvHead [stampSize]byte
+}
+
+// LatencyOf returns the latency histograms of a session of Latency, nil
+// when it has none.
+func LatencyOf(session *link.Session) (oneWay, rtt *Histogram) {
+	if c := latencyCodecOf(session.Codec()); c != nil {
+		return c.oneWay, c.rtt
+	}
+	return nil, nil
+}
+
+func latencyCodecOf(codec link.Codec) *latencyCodec {
+	if c, ok := codec.(*metricsCodec); ok {
+		codec = c.base
+	}
+	c, _ := codec.(*latencyCodec)
+	return c
+}
+
+func (c *latencyCodec) Receive() (interface{}, error) {
+	if _, err := io.ReadFull(c.rw, c.recvHead[:]); err != nil {
+		return nil, err
+	}
+	msg, err := c.base.Receive()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	sent := int64(binary.LittleEndian.Uint64(c.recvHead[:]))
+	echo := int64(binary.LittleEndian.Uint64(c.recvHead[8:]))
+
+	var offset time.Duration
+	session := c.session.Load()
+	if session != nil {
+		offset = session.ClockOffset()
+	}
+	oneWay := max(now.Sub(time.Unix(0, sent).Add(-offset)), 0)
+	c.oneWay.ObserveDuration(oneWay)
+	c.m.oneWayLatency.ObserveDuration(oneWay)
+	if echo != 0 {
+		rtt := max(now.Sub(time.Unix(0, echo)), 0)
+		c.rtt.ObserveDuration(rtt)
+		c.m.rttLatency.ObserveDuration(rtt)
+		if session != nil {
+			session.SetRTT(rtt)
+		}
+	}
+
+	c.mutex.Lock()
+	c.echo, c.echoAt = sent, now
+	c.mutex.Unlock()
+	return msg, nil
+}
+
+// Send echoes the last stamp of the peer once, moved on by the time it was
+// held, so the peer gets its round trip without the time in between.
+func (c *latencyCodec) Send(msg interface{}) error {
+	now := time.Now()
+	var echo int64
+	c.mutex.Lock()
+	if c.echo != 0 {
+		echo = c.echo + int64(now.Sub(c.echoAt))
+		c.echo = 0
+	}
+	c.mutex.Unlock()
+	binary.LittleEndian.PutUint64(c.sendHead[:], uint64(now.UnixNano()))
+	binary.LittleEndian.PutUint64(c.sendHead[8:], uint64(echo))
+	if _, err := c.rw.Write(c.sendHead[:]); err != nil {
+		return err
+	}
+	// encoded by a codec of this protocol, with a stamp of its own.
+	if encoded, ok := msg.(link.Encoded); ok && len(encoded) >= stampSize {
+		msg = encoded[stampSize:]
+	}
+	return c.base.Send(msg)
+}
+
+func (c *latencyCodec) Close() error {
+	return c.base.Close()
+}
+
+func (c *latencyCodec) ClearSendChan(ch <-chan interface{}) {
+	if clear, ok := c.base.(link.ClearSendChan); ok {
+		clear.ClearSendChan(ch)
+	}
+}
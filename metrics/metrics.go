@@ -0,0 +1,243 @@
+package metrics
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/funny/link"
+)
+
+// Metrics collects counters and histograms of the sessions that go through
+// its Protocol, Listener and Handler wrappers. It serves the Prometheus
+// text format, so it can be scraped directly or mounted next to promhttp,
+// prom.NewCollector registers it with a prometheus.Registry.
+type Metrics struct {
+	namespace string
+
+	// MessageType names a message for the per type counters, the default
+	// is the Go type name.
+	MessageType func(msg interface{}) string
+
+	accepts        uint64
+	acceptErrors   uint64
+	sessionsTotal  uint64
+	sessionsActive int64
+	bytesRecv      uint64
+	bytesSent      uint64
+	dropped        uint64
+
+	decodeLatency *Histogram
+	encodeLatency *Histogram
+	oneWayLatency *Histogram
+	rttLatency    *Histogram
+
+	mutex    sync.RWMutex
+	recvMsgs map[string]*uint64
+	sendMsgs map[string]*uint64
+	types    map[string]*typeStats
+	sessions map[uint64]*link.Session
+}
+
+// typeStats are the histograms of one message type.
+type typeStats struct {
+	handler  *Histogram
+	recvSize *Histogram
+	sendSize *Histogram
+}
+
+func New(namespace string) *Metrics {
+	return &Metrics{
+		namespace:     namespace,
+		MessageType:   func(msg interface{}) string { return fmt.Sprintf("%T", msg) },
+		decodeLatency: NewHistogram(DefBuckets),
+		encodeLatency: NewHistogram(DefBuckets),
+		oneWayLatency: NewHistogram(DefBuckets),
+		rttLatency:    NewHistogram(DefBuckets),
+		recvMsgs:      make(map[string]*uint64),
+		sendMsgs:      make(map[string]*uint64),
+		types:         make(map[string]*typeStats),
+		sessions:      make(map[uint64]*link.Session),
+	}
+}
+
+func (m *Metrics) counter(counters map[string]*uint64, name string) *uint64 {
+	m.mutex.RLock()
+	c, exists := counters[name]
+	m.mutex.RUnlock()
+	if exists {
+		return c
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if c, exists = counters[name]; !exists {
+		c = new(uint64)
+		counters[name] = c
+	}
+	return c
+}
+
+func (m *Metrics) typeStats(name string) *typeStats {
+	m.mutex.RLock()
+	ts, exists := m.types[name]
+	m.mutex.RUnlock()
+	if exists {
+		return ts
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if ts, exists = m.types[name]; !exists {
+		ts = &typeStats{
+			handler:  NewHistogram(DefBuckets),
+			recvSize: NewHistogram(SizeBuckets),
+			sendSize: NewHistogram(SizeBuckets),
+		}
+		m.types[name] = ts
+	}
+	return ts
+}
+
+func (m *Metrics) countRecv(msg interface{}, size int) {
+	name := m.MessageType(msg)
+	atomic.AddUint64(m.counter(m.recvMsgs, name), 1)
+	m.typeStats(name).recvSize.Observe(float64(size))
+}
+
+func (m *Metrics) countSend(msg interface{}, size int) {
+	name := m.MessageType(msg)
+	atomic.AddUint64(m.counter(m.sendMsgs, name), 1)
+	m.typeStats(name).sendSize.Observe(float64(size))
+}
+
+// ObserveHandler records how long the handler of msg took.
+func (m *Metrics) ObserveHandler(msg interface{}, d time.Duration) {
+	m.typeStats(m.MessageType(msg)).handler.ObserveDuration(d)
+}
+
+// TypeStats reports the handler latency and the payload size histograms
+// of one message type, nil if the type was never seen.
+func (m *Metrics) TypeStats(name string) (handler, recvSize, sendSize *Histogram) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	if ts, exists := m.types[name]; exists {
+		return ts.handler, ts.recvSize, ts.sendSize
+	}
+	return nil, nil, nil
+}
+
+func snapshot(counters map[string]*uint64) map[string]uint64 {
+	result := make(map[string]uint64, len(counters))
+	for name, c := range counters {
+		result[name] = atomic.LoadUint64(c)
+	}
+	return result
+}
+
+// Snapshot of the numbers, mainly for tests and other exporters.
+type Snapshot struct {
+	Accepts        uint64
+	AcceptErrors   uint64
+	SessionsTotal  uint64
+	SessionsActive int64
+	BytesRecv      uint64
+	BytesSent      uint64
+	Dropped        uint64
+	SendQueueDepth int
+	RecvMsgs       map[string]uint64
+	SendMsgs       map[string]uint64
+}
+
+func (m *Metrics) Snapshot() Snapshot {
+	m.mutex.RLock()
+	s := Snapshot{
+		RecvMsgs: snapshot(m.recvMsgs),
+		SendMsgs: snapshot(m.sendMsgs),
+	}
+	for _, session := range m.sessions {
+		s.SendQueueDepth += session.SendQueueLen()
+	}
+	m.mutex.RUnlock()
+	s.Accepts = atomic.LoadUint64(&m.accepts)
+	s.AcceptErrors = atomic.LoadUint64(&m.acceptErrors)
+	s.SessionsTotal = atomic.LoadUint64(&m.sessionsTotal)
+	s.SessionsActive = atomic.LoadInt64(&m.sessionsActive)
+	s.BytesRecv = atomic.LoadUint64(&m.bytesRecv)
+	s.BytesSent = atomic.LoadUint64(&m.bytesSent)
+	s.Dropped = atomic.LoadUint64(&m.dropped)
+	return s
+}
+
+func sortedKeys[V any](counters map[string]V) []string {
+	keys := make([]string, 0, len(counters))
+	for k := range counters {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type handler struct {
+	m    *Metrics
+	base link.Handler
+}
+
+// Handler tracks the sessions passed to base.
+func (m *Metrics) Handler(base link.Handler) link.Handler {
+	return &handler{m, base}
+}
+
+func (h *handler) HandleSession(session *link.Session) {
+	h.m.Track(session)
+	h.base.HandleSession(session)
+}
+
+// Track counts session as active until it is closed, a closed session or one
+// tracked already is ignored.
+func (m *Metrics) Track(session *link.Session) {
+	m.mutex.Lock()
+	if _, exists := m.sessions[session.ID()]; exists {
+		m.mutex.Unlock()
+		return
+	}
+	m.sessions[session.ID()] = session
+	m.mutex.Unlock()
+
+	untrack := func() {
+		atomic.AddInt64(&m.sessionsActive, -1)
+		m.mutex.Lock()
+		delete(m.sessions, session.ID())
+		m.mutex.Unlock()
+	}
+	atomic.AddInt64(&m.sessionsActive, 1)
+	if !session.AddCloseCallback(m, nil, untrack) {
+		untrack()
+		return
+	}
+	atomic.AddUint64(&m.sessionsTotal, 1)
+	if c := latencyCodecOf(session.Codec()); c != nil {
+		c.session.Store(session)
+	}
+}
+
+type listener struct {
+	net.Listener
+	m *Metrics
+}
+
+// Listener counts the accepted connections of base.
+func (m *Metrics) Listener(base net.Listener) net.Listener {
+	return &listener{base, m}
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		atomic.AddUint64(&l.m.acceptErrors, 1)
+		return nil, err
+	}
+	atomic.AddUint64(&l.m.accepts, 1)
+	return conn, nil
+}
@@ -0,0 +1,212 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/binary"
+	"expvar"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/codec"
+	"github.com/funny/utest"
+)
+
+type Msg struct {
+	Text string
+}
+
+func Test_Metrics(t *testing.T) {
+	m := New("link")
+	json := codec.Json()
+	json.Register(Msg{})
+	protocol := m.Protocol(codec.FixLen(json, 2, binary.LittleEndian, 1024, 1024))
+
+	lsn, err := net.Listen("tcp", "127.0.0.1:0")
+	utest.IsNilNow(t, err)
+	server := link.NewServer(m.Listener(lsn), protocol, 0, m.Handler(link.HandlerFunc(func(session *link.Session) {
+		for {
+			msg, err := session.Receive()
+			if err != nil {
+				return
+			}
+			session.Send(msg)
+		}
+	})))
+	go server.Serve()
+	defer server.Stop()
+
+	client, err := link.Dial("tcp", lsn.Addr().String(), protocol, 0)
+	utest.IsNilNow(t, err)
+	for i := 0; i < 10; i++ {
+		utest.IsNilNow(t, client.Send(&Msg{"hello"}))
+		_, err := client.Receive()
+		utest.IsNilNow(t, err)
+	}
+	client.Close()
+
+	for m.Snapshot().SessionsActive != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	s := m.Snapshot()
+	utest.EqualNow(t, s.Accepts, uint64(1))
+	utest.EqualNow(t, s.SessionsTotal, uint64(1))
+	// client and server share the counters.
+	utest.EqualNow(t, s.RecvMsgs["*metrics.Msg"], uint64(20))
+	utest.EqualNow(t, s.SendMsgs["*metrics.Msg"], uint64(20))
+	utest.Assert(t, s.BytesRecv > 0 && s.BytesRecv == s.BytesSent)
+
+	_, recvSize, sendSize := m.TypeStats("*metrics.Msg")
+	utest.EqualNow(t, recvSize.Count(), uint64(20))
+	utest.EqualNow(t, recvSize.Sum(), float64(s.BytesRecv))
+	utest.EqualNow(t, sendSize.Sum(), float64(s.BytesSent))
+
+	m.ObserveHandler(&Msg{}, 30*time.Millisecond)
+	var buf bytes.Buffer
+	_, err = m.WriteTo(&buf)
+	utest.IsNilNow(t, err)
+	text := buf.String()
+	utest.Assert(t, strings.Contains(text, "# TYPE link_sessions_active gauge\n"))
+	utest.Assert(t, strings.Contains(text, `link_received_messages_total{type="*metrics.Msg"} 20`))
+	utest.Assert(t, strings.Contains(text, `link_decode_seconds_count 20`))
+	utest.Assert(t, strings.Contains(text, `link_handler_seconds_bucket{type="*metrics.Msg",le="0.05"} 1`))
+	utest.Assert(t, strings.Contains(text, `link_received_message_bytes_count{type="*metrics.Msg"} 20`))
+}
+
+func Test_Latency(t *testing.T) {
+	m := New("link")
+	json := codec.Json()
+	json.Register(Msg{})
+	protocol := m.Latency(codec.FixLen(json, 2, binary.LittleEndian, 1024, 1024))
+	a, b, err := link.Pipe(protocol, 0)
+	utest.IsNilNow(t, err)
+	defer a.Close()
+	// b thinks the clock of a is an hour ahead.
+	m.Track(b)
+	b.SetClock(time.Hour, 0)
+
+	broadcaster, err := link.NewBroadcaster(protocol)
+	utest.IsNilNow(t, err)
+	encoded, err := broadcaster.Encode(&Msg{"all"})
+	utest.IsNilNow(t, err)
+	for i := 0; i < 5; i++ {
+		if i == 2 {
+			utest.IsNilNow(t, a.Send(encoded))
+		} else {
+			utest.IsNilNow(t, a.Send(&Msg{"ping"}))
+		}
+		_, err := b.Receive()
+		utest.IsNilNow(t, err)
+		utest.IsNilNow(t, b.Send(&Msg{"pong"}))
+		_, err = a.Receive()
+		utest.IsNilNow(t, err)
+	}
+
+	oneWay, rtt := LatencyOf(a)
+	utest.EqualNow(t, oneWay.Count(), uint64(5))
+	utest.EqualNow(t, rtt.Count(), uint64(5))
+	utest.Assert(t, rtt.Sum() < 1)
+	oneWay, rtt = LatencyOf(b)
+	utest.EqualNow(t, oneWay.Count(), uint64(5))
+	utest.Assert(t, oneWay.Sum() >= 5*3600)
+	// the first frame of a had nothing to echo.
+	utest.EqualNow(t, rtt.Count(), uint64(4))
+	// the session tracked has its RTT from the stamps.
+	utest.Assert(t, b.RTT() > 0)
+
+	var buf bytes.Buffer
+	m.WriteTo(&buf)
+	utest.Assert(t, strings.Contains(buf.String(), "link_rtt_seconds_count 9"))
+}
+
+func Test_Expvar(t *testing.T) {
+	m := New("")
+	session := link.NewSession(nil, 0)
+	m.Track(session)
+	if expvar.Get("link_test") == nil {
+		m.Publish("link_test")
+		utest.NotNilNow(t, expvar.Get("link_test"))
+	}
+
+	v := m.Var()
+	utest.Assert(t, strings.Contains(v.String(), `"SessionsActive":1`))
+	utest.Assert(t, strings.Contains(v.String(), `"HeapAlloc":`))
+}
+
+func stuckHandler(release chan struct{}) {
+	<-release
+}
+
+func Test_SlowDetector(t *testing.T) {
+	events := make(chan SlowEvent, 10)
+	d := NewSlowDetector(SlowConfig{
+		HandlerThreshold: 20 * time.Millisecond,
+		QueueWatermark:   2,
+		QueueDuration:    20 * time.Millisecond,
+		Interval:         5 * time.Millisecond,
+		Stacks:           true,
+	}, func(e SlowEvent) { events <- e })
+	defer d.Stop()
+
+	release := make(chan struct{})
+	go d.Handle(nil, &Msg{}, func() { stuckHandler(release) })
+	e := <-events
+	close(release)
+	utest.EqualNow(t, e.Kind, SlowHandler)
+	utest.Assert(t, bytes.Contains(e.Stack, []byte("stuckHandler")))
+
+	// the next dump waits for StackInterval.
+	release2 := make(chan struct{})
+	go d.Handle(nil, &Msg{}, func() { stuckHandler(release2) })
+	e = <-events
+	close(release2)
+	utest.EqualNow(t, e.Kind, SlowHandler)
+	utest.Assert(t, e.Stack == nil)
+
+	// a session whose peer never reads.
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	json := codec.Json()
+	json.Register(Msg{})
+	c, err := json.NewCodec(conn)
+	utest.IsNilNow(t, err)
+	session := link.NewSession(c, 10)
+	defer session.Close()
+	d.Watch(session)
+	for i := 0; i < 5; i++ {
+		utest.IsNilNow(t, session.Send(&Msg{"x"}))
+	}
+	e = <-events
+	utest.EqualNow(t, e.Kind, SlowConsumer)
+	utest.Assert(t, e.QueueLen >= 2)
+}
+
+func Test_EscapeLabel(t *testing.T) {
+	utest.EqualNow(t, escapeLabel(`a\b"c`+"\nd\xffé"), `a\\b\"c\nd?é`)
+}
+
+func Test_TrackClosed(t *testing.T) {
+	m := New("link")
+	peer, session, err := link.Pipe(codec.Json(), 0)
+	utest.IsNilNow(t, err)
+	defer peer.Close()
+	session.Close()
+	m.Track(session)
+	utest.EqualNow(t, m.Snapshot().SessionsActive, int64(0))
+}
+
+func Test_TrackTwice(t *testing.T) {
+	m := New("link")
+	peer, session, err := link.Pipe(codec.Json(), 0)
+	utest.IsNilNow(t, err)
+	defer peer.Close()
+	m.Track(session)
+	m.Track(session)
+	utest.EqualNow(t, m.Snapshot().SessionsActive, int64(1))
+	session.Close()
+	for m.Snapshot().SessionsActive != 0 {
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,88 @@
+// Package prom plugs a metrics.Metrics into the Prometheus client library,
+// for processes which already serve their own collectors with promhttp.
+package prom
+
+import (
+	"bytes"
+
+	"github.com/funny/link/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+var invalidDesc = prometheus.NewDesc("link_metrics_invalid", "Metrics which failed to convert.", nil, nil)
+
+// Collector is a prometheus.Collector and a prometheus.Gatherer of the
+// metrics of m, register it with a prometheus.Registry or pass it to
+// promhttp.HandlerFor in a prometheus.Gatherers.
+type Collector struct {
+	m *metrics.Metrics
+}
+
+func NewCollector(m *metrics.Metrics) *Collector {
+	return &Collector{m}
+}
+
+// Gather returns the metrics of m in the data model of Prometheus.
+func (c *Collector) Gather() ([]*dto.MetricFamily, error) {
+	var buf bytes.Buffer
+	if _, err := c.m.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(&buf)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*dto.MetricFamily, 0, len(families))
+	for _, family := range families {
+		if len(family.Metric) > 0 {
+			result = append(result, family)
+		}
+	}
+	return result, nil
+}
+
+// Describe sends nothing, which makes the collector unchecked, the labels
+// depend on the message types seen so far.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	families, err := c.Gather()
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(invalidDesc, err)
+		return
+	}
+	for _, family := range families {
+		for _, metric := range family.Metric {
+			labels := make([]string, len(metric.Label))
+			for i, label := range metric.Label {
+				labels[i] = label.GetName()
+			}
+			desc := prometheus.NewDesc(family.GetName(), family.GetHelp(), labels, nil)
+			ch <- &gathered{desc, metric}
+		}
+	}
+}
+
+// gathered is a metric as Gather returned it.
+type gathered struct {
+	desc   *prometheus.Desc
+	metric *dto.Metric
+}
+
+func (m *gathered) Desc() *prometheus.Desc {
+	return m.desc
+}
+
+func (m *gathered) Write(out *dto.Metric) error {
+	out.Label = m.metric.Label
+	out.Counter = m.metric.Counter
+	out.Gauge = m.metric.Gauge
+	out.Histogram = m.metric.Histogram
+	out.Summary = m.metric.Summary
+	out.Untyped = m.metric.Untyped
+	return nil
+}
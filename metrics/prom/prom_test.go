@@ -0,0 +1,36 @@
+package prom
+
+import (
+	"testing"
+	"time"
+
+	"github.com/funny/link/metrics"
+	"github.com/funny/utest"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type Msg struct{}
+
+func Test_Collector(t *testing.T) {
+	m := metrics.New("link")
+	// a type name which needs escaping.
+	m.MessageType = func(msg interface{}) string { return "a\"b\\c\nd" }
+	m.ObserveHandler(&Msg{}, 30*time.Millisecond)
+
+	registry := prometheus.NewRegistry()
+	utest.IsNilNow(t, registry.Register(NewCollector(m)))
+	families, err := registry.Gather()
+	utest.IsNilNow(t, err)
+
+	found := make(map[string]bool)
+	for _, family := range families {
+		found[family.GetName()] = true
+		if family.GetName() == "link_handler_seconds" {
+			metric := family.Metric[0]
+			utest.EqualNow(t, metric.Label[0].GetValue(), "a\"b\\c\nd")
+			utest.EqualNow(t, metric.Histogram.GetSampleCount(), uint64(1))
+		}
+	}
+	utest.Assert(t, found["link_sessions_active"])
+	utest.Assert(t, found["link_handler_seconds"])
+}
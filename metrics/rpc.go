@@ -0,0 +1,26 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/rpc"
+)
+
+type rpcHandler struct {
+	m    *Metrics
+	base rpc.Handler
+}
+
+// RPCHandler wraps base to record the handler time per request type.
+func (m *Metrics) RPCHandler(base rpc.Handler) rpc.Handler {
+	return &rpcHandler{m, base}
+}
+
+func (h *rpcHandler) ServeRPC(ctx context.Context, session *link.Session, req interface{}) (interface{}, error) {
+	start := time.Now()
+	rsp, err := h.base.ServeRPC(ctx, session, req)
+	h.m.ObserveHandler(req, time.Since(start))
+	return rsp, err
+}
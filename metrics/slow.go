@@ -0,0 +1,238 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/clock"
+	"github.com/funny/link/rpc"
+)
+
+type SlowKind int
+
+const (
+	SlowHandler SlowKind = iota
+	SlowConsumer
+)
+
+type SlowEvent struct {
+	Kind     SlowKind
+	Session  *link.Session
+	Msg      interface{} // the message being handled, SlowHandler only
+	Duration time.Duration
+	QueueLen int    // send queue length, SlowConsumer only
+	Stack    []byte // stack of the handler goroutine, SlowHandler with Stacks only
+}
+
+type SlowConfig struct {
+	HandlerThreshold time.Duration // 0 disables handler checks
+	QueueWatermark   int           // 0 disables consumer checks
+	QueueDuration    time.Duration // how long the queue must stay above the watermark
+	Interval         time.Duration // how often send queues are sampled
+	Clock            clock.Clock   // nil is clock.Default()
+
+	// Stacks adds the stack of the handler to SlowHandler events. Getting it
+	// dumps every goroutine and stops the world meanwhile, so it happens at
+	// most once per StackInterval, default a minute.
+	Stacks        bool
+	StackInterval time.Duration
+}
+
+// SlowDetector reports handlers running longer than a threshold, optionally
+// with the stack of the handler at that moment, and sessions whose send
+// queue stays above a watermark, which usually means the client can't keep
+// up.
+type SlowDetector struct {
+	config  SlowConfig
+	onEvent func(SlowEvent)
+
+	mutex     sync.Mutex
+	sessions  map[uint64]*watched
+	lastStack time.Time
+	stopChan  chan struct{}
+	stopOnce  sync.Once
+}
+
+type watched struct {
+	session  *link.Session
+	since    time.Time
+	reported bool
+}
+
+func NewSlowDetector(config SlowConfig, onEvent func(SlowEvent)) *SlowDetector {
+	if config.Interval <= 0 {
+		config.Interval = time.Second
+	}
+	if config.StackInterval <= 0 {
+		config.StackInterval = time.Minute
+	}
+	d := &SlowDetector{
+		config:   config,
+		onEvent:  onEvent,
+		sessions: make(map[uint64]*watched),
+		stopChan: make(chan struct{}),
+	}
+	if config.QueueWatermark > 0 {
+		go d.loop()
+	}
+	return d
+}
+
+func goroutineID() []byte {
+	var buf [64]byte
+	b := buf[:runtime.Stack(buf[:], false)]
+	// "goroutine 18 [running]:"
+	b = bytes.TrimPrefix(b, []byte("goroutine "))
+	if i := bytes.IndexByte(b, ' '); i > 0 {
+		return append([]byte("goroutine "), b[:i+1]...)
+	}
+	return nil
+}
+
+func goroutineStack(id []byte) []byte {
+	buf := make([]byte, 64*1024)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, len(buf)*2)
+	}
+	for _, stack := range bytes.Split(buf, []byte("\n\n")) {
+		if bytes.HasPrefix(stack, id) {
+			return stack
+		}
+	}
+	return nil
+}
+
+// stackAllowed reports whether a stack may be dumped now.
+func (d *SlowDetector) stackAllowed(now time.Time) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if !d.lastStack.IsZero() && now.Sub(d.lastStack) < d.config.StackInterval {
+		return false
+	}
+	d.lastStack = now
+	return true
+}
+
+// Handle runs fn, the handler of msg, and reports it if it runs too long.
+func (d *SlowDetector) Handle(session *link.Session, msg interface{}, fn func()) {
+	if d.config.HandlerThreshold <= 0 {
+		fn()
+		return
+	}
+	var id []byte
+	if d.config.Stacks {
+		id = goroutineID()
+	}
+	clk := clock.Or(d.config.Clock)
+	start := clk.Now()
+	timer := clk.AfterFunc(d.config.HandlerThreshold, func() {
+		event := SlowEvent{
+			Kind:     SlowHandler,
+			Session:  session,
+			Msg:      msg,
+			Duration: clk.Since(start),
+		}
+		if id != nil && d.stackAllowed(clk.Now()) {
+			event.Stack = goroutineStack(id)
+		}
+		d.onEvent(event)
+	})
+	defer timer.Stop()
+	fn()
+}
+
+type slowRPCHandler struct {
+	d    *SlowDetector
+	base rpc.Handler
+}
+
+func (d *SlowDetector) RPCHandler(base rpc.Handler) rpc.Handler {
+	return &slowRPCHandler{d, base}
+}
+
+func (h *slowRPCHandler) ServeRPC(ctx context.Context, session *link.Session, req interface{}) (rsp interface{}, err error) {
+	h.d.Handle(session, req, func() {
+		rsp, err = h.base.ServeRPC(ctx, session, req)
+	})
+	return
+}
+
+// Watch samples the send queue of session until it is closed.
+func (d *SlowDetector) Watch(session *link.Session) {
+	d.mutex.Lock()
+	d.sessions[session.ID()] = &watched{session: session}
+	d.mutex.Unlock()
+	session.AddCloseCallback(d, nil, func() {
+		d.mutex.Lock()
+		delete(d.sessions, session.ID())
+		d.mutex.Unlock()
+	})
+}
+
+func (d *SlowDetector) loop() {
+	ticker := clock.Or(d.config.Clock).NewTicker(d.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C():
+			d.check(now)
+		case <-d.stopChan:
+			return
+		}
+	}
+}
+
+func (d *SlowDetector) check(now time.Time) {
+	var events []SlowEvent
+	d.mutex.Lock()
+	for _, w := range d.sessions {
+		n := w.session.SendQueueLen()
+		if n < d.config.QueueWatermark {
+			w.since, w.reported = time.Time{}, false
+			continue
+		}
+		if w.since.IsZero() {
+			w.since = now
+		}
+		if !w.reported && now.Sub(w.since) >= d.config.QueueDuration {
+			w.reported = true
+			events = append(events, SlowEvent{
+				Kind:     SlowConsumer,
+				Session:  w.session,
+				Duration: now.Sub(w.since),
+				QueueLen: n,
+			})
+		}
+	}
+	d.mutex.Unlock()
+
+	for _, event := range events {
+		d.onEvent(event)
+	}
+}
+
+func (d *SlowDetector) Stop() {
+	d.stopOnce.Do(func() {
+		close(d.stopChan)
+	})
+}
+
+func (k SlowKind) String() string {
+	switch k {
+	case SlowHandler:
+		return "slow handler"
+	case SlowConsumer:
+		return "slow consumer"
+	}
+	return "SlowKind(" + strconv.Itoa(int(k)) + ")"
+}
@@ -0,0 +1,221 @@
+package link
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/funny/link/clock"
+)
+
+var NotMigratableError = NewError(PolicyError, "Session Not Migratable")
+var ConnDetachedError = NewError(TransportError, "Conn Detached")
+var FrameBrokenError = NewError(TransportError, "Frame Broken By Conn Loss")
+
+// Migratable wraps a protocol so that the underlying connection of a session
+// can be replaced by Session.Migrate. When the connection breaks between
+// messages, reads and writes wait up to timeout for a replacement before
+// failing. A message can't be resumed on another connection, so a break in
+// the middle of one fails with FrameBrokenError. The base codec must not
+// read ahead of the message it returns.
+func Migratable(base Protocol, timeout time.Duration) Protocol {
+	return ProtocolFunc(func(rw io.ReadWriter) (Codec, error) {
+		raw, ok := rw.(net.Conn)
+		if !ok {
+			return nil, NotMigratableError
+		}
+		conn := newMigratableConn(raw, timeout)
+		codec, err := base.NewCodec(conn)
+		if err != nil {
+			return nil, err
+		}
+		return &migratableCodec{codec, conn}, nil
+	})
+}
+
+type migratableCodec struct {
+	Codec
+	conn *migratableConn
+}
+
+func (c *migratableCodec) Send(msg interface{}) error {
+	c.conn.wrote = false
+	return c.Codec.Send(msg)
+}
+
+func (c *migratableCodec) Receive() (interface{}, error) {
+	c.conn.read = false
+	return c.Codec.Receive()
+}
+
+func (c *migratableCodec) ReceiveBatch(msgs []interface{}, max int) ([]interface{}, error) {
+	c.conn.read = false
+	return ReceiveBatch(c.Codec, msgs, max)
+}
+
+func (c *migratableCodec) ClearSendChan(ch <-chan interface{}) {
+	if clear, ok := c.Codec.(ClearSendChan); ok {
+		clear.ClearSendChan(ch)
+	}
+}
+
+func (session *Session) Migrate(from *Session) error {
+	dst, ok1 := session.codec.(*migratableCodec)
+	src, ok2 := from.codec.(*migratableCodec)
+	if !ok1 || !ok2 {
+		return NotMigratableError
+	}
+	conn := src.conn.detach()
+	if conn == nil {
+		return ConnDetachedError
+	}
+	from.Close()
+	return dst.conn.replace(conn)
+}
+
+type migratableConn struct {
+	mutex    sync.Mutex
+	cond     sync.Cond
+	conn     net.Conn
+	gen      uint64
+	closed   bool
+	detached bool
+	timeout  time.Duration
+
+	// read and wrote tell whether the current message moved any bytes, only
+	// the receiving and the sending goroutine touch them.
+	read  bool
+	wrote bool
+}
+
+func newMigratableConn(conn net.Conn, timeout time.Duration) *migratableConn {
+	c := &migratableConn{
+		conn:    conn,
+		timeout: timeout,
+	}
+	c.cond.L = &c.mutex
+	return c
+}
+
+func (c *migratableConn) current() (net.Conn, uint64, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.conn == nil && !c.closed {
+		gen := c.gen
+		timer := clock.Default().AfterFunc(c.timeout, func() {
+			c.mutex.Lock()
+			// a replace which came in before the timer stopped wins.
+			if c.gen == gen && c.conn == nil {
+				c.closed = true
+			}
+			c.mutex.Unlock()
+			c.cond.Broadcast()
+		})
+		for c.conn == nil && !c.closed {
+			c.cond.Wait()
+		}
+		timer.Stop()
+	}
+	if c.closed {
+		if c.detached {
+			return nil, 0, ConnDetachedError
+		}
+		return nil, 0, io.EOF
+	}
+	return c.conn, c.gen, nil
+}
+
+func (c *migratableConn) broken(gen uint64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.gen == gen && c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+}
+
+func (c *migratableConn) Read(p []byte) (int, error) {
+	for {
+		conn, gen, err := c.current()
+		if err != nil {
+			return 0, err
+		}
+		n, err := conn.Read(p)
+		if n > 0 {
+			c.read = true
+		}
+		if err == nil || n > 0 {
+			return n, err
+		}
+		c.broken(gen)
+		if c.read {
+			return 0, FrameBrokenError
+		}
+	}
+}
+
+func (c *migratableConn) Write(p []byte) (int, error) {
+	written := 0
+	for {
+		conn, gen, err := c.current()
+		if err != nil {
+			return written, err
+		}
+		n, err := conn.Write(p[written:])
+		written += n
+		if n > 0 {
+			c.wrote = true
+		}
+		if err == nil {
+			return written, nil
+		}
+		c.broken(gen)
+		if c.wrote {
+			return written, FrameBrokenError
+		}
+	}
+}
+
+func (c *migratableConn) replace(conn net.Conn) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closed {
+		conn.Close()
+		return SessionClosedError
+	}
+	if c.conn != nil {
+		c.conn.Close()
+	}
+	c.conn = conn
+	c.gen++
+	c.cond.Broadcast()
+	return nil
+}
+
+func (c *migratableConn) detach() net.Conn {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closed || c.conn == nil {
+		return nil
+	}
+	conn := c.conn
+	c.conn = nil
+	c.closed = true
+	c.detached = true
+	c.cond.Broadcast()
+	return conn
+}
+
+func (c *migratableConn) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.closed = true
+	c.cond.Broadcast()
+	if c.conn != nil {
+		err := c.conn.Close()
+		c.conn = nil
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,254 @@
+package mux
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/funny/link"
+)
+
+var MuxClosedError = link.NewError(link.TransportError, "Mux Closed")
+var StreamClosedError = link.NewError(link.TransportError, "Stream Closed")
+var WindowExceededError = link.NewError(link.ProtocolError, "Mux Stream Window Exceeded")
+
+const (
+	frameOpen byte = iota
+	frameData
+	frameClose
+	frameWindow
+)
+
+const (
+	headSize     = 7
+	maxFrameSize = 0xFFFF
+)
+
+// Window is the credit of a stream in bytes, a negative one turns flow
+// control off. A stream sends at most Window bytes the peer didn't read yet,
+// so a bulk transfer fills its own window instead of the connection and the
+// buffers of the peer, and the other streams stay responsive. Both ends need
+// the same setting. Zero fields take the values of DefaultConfig.
+type Config struct {
+	AcceptBacklog int
+	Window        int
+}
+
+var DefaultConfig = Config{
+	AcceptBacklog: 128,
+	Window:        256 * 1024,
+}
+
+type Mux struct {
+	conn       net.Conn
+	config     Config
+	writeMutex sync.Mutex
+
+	streamMutex sync.Mutex
+	streams     map[uint32]*Stream
+	nextID      uint32
+
+	acceptChan chan *Stream
+	closeOnce  sync.Once
+	closeChan  chan struct{}
+}
+
+// Client and server side stream IDs are odd and even respectively,
+// so both sides can open streams without negotiating IDs.
+func Client(conn net.Conn, config Config) *Mux {
+	return newMux(conn, config, 1)
+}
+
+func Server(conn net.Conn, config Config) *Mux {
+	return newMux(conn, config, 2)
+}
+
+func newMux(conn net.Conn, config Config, firstID uint32) *Mux {
+	if config.AcceptBacklog <= 0 {
+		config.AcceptBacklog = DefaultConfig.AcceptBacklog
+	}
+	if config.Window == 0 {
+		config.Window = DefaultConfig.Window
+	}
+	mux := &Mux{
+		conn:       conn,
+		config:     config,
+		streams:    make(map[uint32]*Stream),
+		nextID:     firstID,
+		acceptChan: make(chan *Stream, config.AcceptBacklog),
+		closeChan:  make(chan struct{}),
+	}
+	go mux.readLoop()
+	return mux
+}
+
+func (mux *Mux) writeFrame(id uint32, kind byte, payload []byte) error {
+	var head [headSize]byte
+	binary.LittleEndian.PutUint32(head[0:], id)
+	head[4] = kind
+	binary.LittleEndian.PutUint16(head[5:], uint16(len(payload)))
+
+	mux.writeMutex.Lock()
+	defer mux.writeMutex.Unlock()
+
+	if mux.IsClosed() {
+		return MuxClosedError
+	}
+	if _, err := mux.conn.Write(head[:]); err != nil {
+		mux.Close()
+		return err
+	}
+	if len(payload) > 0 {
+		if _, err := mux.conn.Write(payload); err != nil {
+			mux.Close()
+			return err
+		}
+	}
+	return nil
+}
+
+func (mux *Mux) readLoop() {
+	defer mux.Close()
+	var head [headSize]byte
+	buf := make([]byte, maxFrameSize)
+	for {
+		if _, err := io.ReadFull(mux.conn, head[:]); err != nil {
+			return
+		}
+		id := binary.LittleEndian.Uint32(head[0:])
+		kind := head[4]
+		size := int(binary.LittleEndian.Uint16(head[5:]))
+		payload := buf[:size]
+		if _, err := io.ReadFull(mux.conn, payload); err != nil {
+			return
+		}
+		if !mux.handleFrame(id, kind, payload) {
+			return
+		}
+	}
+}
+
+func (mux *Mux) handleFrame(id uint32, kind byte, payload []byte) bool {
+	switch kind {
+	case frameOpen:
+		stream := newStream(mux, id)
+		mux.streamMutex.Lock()
+		mux.streams[id] = stream
+		mux.streamMutex.Unlock()
+		select {
+		case mux.acceptChan <- stream:
+		case <-mux.closeChan:
+			return false
+		default:
+			stream.Close()
+		}
+	case frameData:
+		if stream := mux.getStream(id); stream != nil {
+			if !stream.recv.write(payload, mux.config.Window) {
+				link.GetLogger().Warn("mux: stream window exceeded", "stream", id)
+				return false
+			}
+		}
+	case frameWindow:
+		if stream := mux.getStream(id); stream != nil && len(payload) == 4 {
+			stream.addCredit(int(binary.LittleEndian.Uint32(payload)))
+		}
+	case frameClose:
+		if stream := mux.getStream(id); stream != nil {
+			stream.remoteClose()
+		}
+	}
+	return true
+}
+
+func (mux *Mux) getStream(id uint32) *Stream {
+	mux.streamMutex.Lock()
+	defer mux.streamMutex.Unlock()
+	stream, _ := mux.streams[id]
+	return stream
+}
+
+func (mux *Mux) delStream(id uint32) {
+	mux.streamMutex.Lock()
+	defer mux.streamMutex.Unlock()
+	delete(mux.streams, id)
+}
+
+func (mux *Mux) Open() (*Stream, error) {
+	mux.streamMutex.Lock()
+	if mux.IsClosed() {
+		mux.streamMutex.Unlock()
+		return nil, MuxClosedError
+	}
+	id := mux.nextID
+	mux.nextID += 2
+	stream := newStream(mux, id)
+	mux.streams[id] = stream
+	mux.streamMutex.Unlock()
+
+	if err := mux.writeFrame(id, frameOpen, nil); err != nil {
+		mux.delStream(id)
+		return nil, err
+	}
+	return stream, nil
+}
+
+func (mux *Mux) Accept() (net.Conn, error) {
+	select {
+	case stream := <-mux.acceptChan:
+		return stream, nil
+	case <-mux.closeChan:
+		return nil, io.EOF
+	}
+}
+
+func (mux *Mux) IsClosed() bool {
+	select {
+	case <-mux.closeChan:
+		return true
+	default:
+		return false
+	}
+}
+
+func (mux *Mux) Close() error {
+	err := MuxClosedError
+	mux.closeOnce.Do(func() {
+		close(mux.closeChan)
+		err = mux.conn.Close()
+
+		mux.streamMutex.Lock()
+		streams := mux.streams
+		mux.streams = make(map[uint32]*Stream)
+		mux.streamMutex.Unlock()
+
+		for _, stream := range streams {
+			stream.remoteClose()
+		}
+	})
+	return err
+}
+
+func (mux *Mux) Addr() net.Addr {
+	return mux.conn.LocalAddr()
+}
+
+func (mux *Mux) NumStreams() int {
+	mux.streamMutex.Lock()
+	defer mux.streamMutex.Unlock()
+	return len(mux.streams)
+}
+
+func (mux *Mux) Dial(protocol link.Protocol, sendChanSize int) (*link.Session, error) {
+	stream, err := mux.Open()
+	if err != nil {
+		return nil, err
+	}
+	codec, err := protocol.NewCodec(stream)
+	if err != nil {
+		stream.Close()
+		return nil, err
+	}
+	return link.NewSession(codec, sendChanSize), nil
+}
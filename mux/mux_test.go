@@ -0,0 +1,114 @@
+package mux
+
+import (
+	"encoding/binary"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/codec"
+	"github.com/funny/utest"
+)
+
+type EchoMsg struct {
+	Seq  int
+	Data []byte
+}
+
+func Test_Mux(t *testing.T) {
+	json := codec.Json()
+	json.Register(EchoMsg{})
+	protocol := codec.FixLen(json, 4, binary.LittleEndian, 1024*1024, 1024*1024)
+
+	conn1, conn2 := link.PipeConn()
+	client := Client(conn1, DefaultConfig)
+	serverMux := Server(conn2, DefaultConfig)
+
+	server := link.NewServer(serverMux, protocol, 0, link.HandlerFunc(func(session *link.Session) {
+		defer session.Close()
+		for {
+			msg, err := session.Receive()
+			if err != nil {
+				return
+			}
+			if session.Send(msg) != nil {
+				return
+			}
+		}
+	}))
+	go server.Serve()
+	defer server.Stop()
+
+	wait := new(sync.WaitGroup)
+	for i := 0; i < 20; i++ {
+		wait.Add(1)
+		go func(n int) {
+			defer wait.Done()
+			session, err := client.Dial(protocol, 0)
+			utest.IsNilNow(t, err)
+			defer session.Close()
+			for j := 0; j < 20; j++ {
+				utest.IsNilNow(t, session.Send(&EchoMsg{n*1000 + j, make([]byte, 64*1024)}))
+				msg, err := session.Receive()
+				utest.IsNilNow(t, err)
+				utest.EqualNow(t, msg.(*EchoMsg).Seq, n*1000+j)
+			}
+		}(i)
+	}
+	wait.Wait()
+	client.Close()
+}
+
+func Test_Window(t *testing.T) {
+	config := Config{AcceptBacklog: 16, Window: 64 * 1024}
+	conn1, conn2 := link.PipeConn()
+	client := Client(conn1, config)
+	server := Server(conn2, config)
+	defer client.Close()
+
+	bulk, err := client.Open()
+	utest.IsNilNow(t, err)
+	control, err := client.Open()
+	utest.IsNilNow(t, err)
+	bulkPeer, err := server.Accept()
+	utest.IsNilNow(t, err)
+	controlPeer, err := server.Accept()
+	utest.IsNilNow(t, err)
+
+	// nobody reads the bulk stream, its writer stops at the window.
+	written := make(chan int)
+	go func() {
+		n, _ := bulk.Write(make([]byte, 1024*1024))
+		written <- n
+	}()
+	for i := 0; i < 100 && bulkPeer.(*Stream).recv.len() < config.Window; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	utest.EqualNow(t, bulkPeer.(*Stream).recv.len(), config.Window)
+
+	// the control stream still gets through.
+	_, err = control.Write([]byte("ping"))
+	utest.IsNilNow(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(controlPeer, buf)
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, string(buf), "ping")
+
+	// reading hands out credit until the whole transfer is through.
+	n, err := io.Copy(io.Discard, io.LimitReader(bulkPeer, 1024*1024))
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, n, int64(1024*1024))
+	utest.EqualNow(t, <-written, 1024*1024)
+}
+
+func Test_ZeroConfig(t *testing.T) {
+	conn1, conn2 := link.PipeConn()
+	client := Client(conn1, Config{})
+	defer client.Close()
+	server := Server(conn2, Config{Window: -1})
+	defer server.Close()
+	utest.EqualNow(t, client.config, DefaultConfig)
+	utest.EqualNow(t, server.config, Config{AcceptBacklog: DefaultConfig.AcceptBacklog, Window: -1})
+}
@@ -0,0 +1,190 @@
+package mux
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type streamBuffer struct {
+	mutex  sync.Mutex
+	cond   sync.Cond
+	buf    bytes.Buffer
+	closed bool
+}
+
+// write returns false when p doesn't fit window, zero is no limit.
+func (b *streamBuffer) write(p []byte, window int) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.closed {
+		return true
+	}
+	if window > 0 && b.buf.Len()+len(p) > window {
+		return false
+	}
+	b.buf.Write(p)
+	b.cond.Broadcast()
+	return true
+}
+
+func (b *streamBuffer) Read(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for b.buf.Len() == 0 {
+		if b.closed {
+			return 0, io.EOF
+		}
+		b.cond.Wait()
+	}
+	return b.buf.Read(p)
+}
+
+func (b *streamBuffer) len() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.Len()
+}
+
+func (b *streamBuffer) close() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.closed = true
+	b.cond.Broadcast()
+}
+
+type streamAddr uint32
+
+func (a streamAddr) Network() string { return "mux" }
+func (a streamAddr) String() string  { return strconv.FormatUint(uint64(a), 10) }
+
+type Stream struct {
+	mux    *Mux
+	id     uint32
+	recv   streamBuffer
+	mutex  sync.Mutex
+	closed bool
+
+	credit     int // bytes the peer has room for
+	creditCond sync.Cond
+	readMutex  sync.Mutex
+	consumed   int // bytes read since the last window frame
+}
+
+func newStream(mux *Mux, id uint32) *Stream {
+	stream := &Stream{
+		mux:    mux,
+		id:     id,
+		credit: mux.config.Window,
+	}
+	stream.recv.cond.L = &stream.recv.mutex
+	stream.creditCond.L = &stream.mutex
+	return stream
+}
+
+func (stream *Stream) ID() uint32 {
+	return stream.id
+}
+
+// Read gives the peer credit again once half of the window was read.
+func (stream *Stream) Read(p []byte) (int, error) {
+	n, err := stream.recv.Read(p)
+	window := stream.mux.config.Window
+	if n == 0 || window <= 0 {
+		return n, err
+	}
+	stream.readMutex.Lock()
+	stream.consumed += n
+	consumed := stream.consumed
+	if consumed < window/2 {
+		stream.readMutex.Unlock()
+		return n, err
+	}
+	stream.consumed = 0
+	stream.readMutex.Unlock()
+	var credit [4]byte
+	binary.LittleEndian.PutUint32(credit[:], uint32(consumed))
+	// a broken connection shows on the next read.
+	stream.mux.writeFrame(stream.id, frameWindow, credit[:])
+	return n, err
+}
+
+func (stream *Stream) addCredit(n int) {
+	stream.mutex.Lock()
+	defer stream.mutex.Unlock()
+	stream.credit += n
+	stream.creditCond.Broadcast()
+}
+
+// take waits for credit and returns how much of size may be sent.
+func (stream *Stream) take(size int) (int, error) {
+	stream.mutex.Lock()
+	defer stream.mutex.Unlock()
+	if stream.mux.config.Window <= 0 {
+		if stream.closed {
+			return 0, StreamClosedError
+		}
+		return size, nil
+	}
+	for stream.credit == 0 && !stream.closed {
+		stream.creditCond.Wait()
+	}
+	if stream.closed {
+		return 0, StreamClosedError
+	}
+	size = min(size, stream.credit)
+	stream.credit -= size
+	return size, nil
+}
+
+func (stream *Stream) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		size, err := stream.take(min(len(p), maxFrameSize))
+		if err != nil {
+			return n, err
+		}
+		if err := stream.mux.writeFrame(stream.id, frameData, p[:size]); err != nil {
+			return n, err
+		}
+		n += size
+		p = p[size:]
+	}
+	return n, nil
+}
+
+func (stream *Stream) markClosed() bool {
+	stream.mutex.Lock()
+	defer stream.mutex.Unlock()
+	if stream.closed {
+		return false
+	}
+	stream.closed = true
+	stream.creditCond.Broadcast()
+	return true
+}
+
+func (stream *Stream) remoteClose() {
+	stream.markClosed()
+	stream.recv.close()
+	stream.mux.delStream(stream.id)
+}
+
+func (stream *Stream) Close() error {
+	if !stream.markClosed() {
+		return StreamClosedError
+	}
+	stream.recv.close()
+	stream.mux.delStream(stream.id)
+	return stream.mux.writeFrame(stream.id, frameClose, nil)
+}
+
+func (stream *Stream) LocalAddr() net.Addr                { return streamAddr(stream.id) }
+func (stream *Stream) RemoteAddr() net.Addr               { return streamAddr(stream.id) }
+func (stream *Stream) SetDeadline(t time.Time) error      { return nil }
+func (stream *Stream) SetReadDeadline(t time.Time) error  { return nil }
+func (stream *Stream) SetWriteDeadline(t time.Time) error { return nil }
@@ -0,0 +1,186 @@
+package netpoll
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"runtime"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/funny/link"
+)
+
+var NotSupportedError = link.NewError(link.PolicyError, "Netpoll Not Supported")
+
+// Dispatcher handles one received message, *link.Router is one.
+type Dispatcher interface {
+	Dispatch(session *link.Session, msg interface{}) error
+}
+
+type Config struct {
+	Workers      int // default runtime.NumCPU()
+	QueueSize    int // tasks per worker, default 64
+	SendChanSize int // see link.NewSession, > 0 costs a goroutine per session
+
+	// ReadTimeout bounds the read of a message once its connection turned
+	// readable, default 5 seconds. A peer which sends part of a message and
+	// stalls would hold a worker, and every session queued behind it,
+	// until the rest arrives, it is closed instead.
+	ReadTimeout time.Duration
+}
+
+// Server is an event driven alternative to link.Server. Instead of a
+// goroutine per connection it waits for readable connections with epoll and
+// receives and dispatches their messages on a worker pool, so idle sessions
+// cost no goroutine. Messages of one session are dispatched in order.
+//
+// The protocol must not read ahead, a codec buffering more than the current
+// message (like codec.Bufio) leaves messages unnoticed until new data
+// arrives.
+type Server struct {
+	listener   net.Listener
+	protocol   link.Protocol
+	dispatcher Dispatcher
+	config     Config
+	manager    *link.Manager
+	pool       *link.WorkerPool
+	poller     *poller
+
+	mutex sync.Mutex
+	conns map[int]*pollConn
+}
+
+type pollConn struct {
+	conn    net.Conn
+	session *link.Session
+}
+
+func NewServer(listener net.Listener, protocol link.Protocol, dispatcher Dispatcher, config Config) (*Server, error) {
+	if config.Workers <= 0 {
+		config.Workers = runtime.NumCPU()
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = 64
+	}
+	if config.ReadTimeout <= 0 {
+		config.ReadTimeout = 5 * time.Second
+	}
+	server := &Server{
+		listener:   listener,
+		protocol:   protocol,
+		dispatcher: dispatcher,
+		config:     config,
+		manager:    link.NewManager(),
+		pool:       link.NewWorkerPool(config.Workers, config.QueueSize),
+		conns:      make(map[int]*pollConn),
+	}
+	var err error
+	server.poller, err = newPoller(server.readable)
+	if err != nil {
+		server.pool.Close()
+		return nil, err
+	}
+	go server.poller.wait()
+	return server, nil
+}
+
+func (server *Server) Manager() *link.Manager {
+	return server.manager
+}
+
+func (server *Server) Listener() net.Listener {
+	return server.listener
+}
+
+func (server *Server) Serve() error {
+	for {
+		conn, err := link.Accept(server.listener)
+		if err != nil {
+			return err
+		}
+		if err := server.add(conn); err != nil {
+			link.GetLogger().Warn("link: netpoll add failed", "remote", conn.RemoteAddr(), "error", err)
+			conn.Close()
+		}
+	}
+}
+
+func fdOf(conn net.Conn) (int, error) {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return 0, fmt.Errorf("netpoll: %T has no file descriptor", conn)
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var fd int
+	err = raw.Control(func(f uintptr) { fd = int(f) })
+	return fd, err
+}
+
+func (server *Server) add(conn net.Conn) error {
+	fd, err := fdOf(conn)
+	if err != nil {
+		return err
+	}
+	codec, err := server.protocol.NewCodec(conn)
+	if err != nil {
+		return err
+	}
+	session := server.manager.NewSession(codec, server.config.SendChanSize)
+	server.mutex.Lock()
+	server.conns[fd] = &pollConn{conn, session}
+	server.mutex.Unlock()
+	session.AddCloseCallback(server, nil, func() {
+		server.mutex.Lock()
+		if pc := server.conns[fd]; pc != nil && pc.session == session {
+			delete(server.conns, fd)
+		}
+		server.mutex.Unlock()
+	})
+	if err := server.poller.add(fd); err != nil {
+		session.Close()
+		return err
+	}
+	return nil
+}
+
+func (server *Server) readable(fd int) {
+	server.mutex.Lock()
+	pc, exists := server.conns[fd]
+	server.mutex.Unlock()
+	if !exists {
+		return
+	}
+	session := pc.session
+	err := server.pool.Submit(session.ID(), func() {
+		pc.conn.SetReadDeadline(time.Now().Add(server.config.ReadTimeout))
+		msg, err := session.Receive()
+		pc.conn.SetReadDeadline(time.Time{})
+		if err != nil {
+			if err != io.EOF {
+				link.GetLogger().Debug("link: netpoll receive failed", "session", session.ID(), "error", err)
+			}
+			return
+		}
+		if err := server.dispatcher.Dispatch(session, msg); err != nil {
+			link.GetLogger().Warn("link: dispatch failed", "session", session.ID(), "error", err)
+		}
+		if !session.IsClosed() {
+			server.poller.rearm(fd)
+		}
+	})
+	if err != nil {
+		session.Close()
+	}
+}
+
+func (server *Server) Stop() {
+	server.listener.Close()
+	server.poller.close()
+	server.manager.Dispose()
+	server.pool.Close()
+}
@@ -0,0 +1,104 @@
+package netpoll
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/codec"
+	"github.com/funny/utest"
+)
+
+type Echo struct {
+	N int
+}
+
+func testProtocol() link.Protocol {
+	json := codec.Json()
+	json.Register(Echo{})
+	return codec.FixLen(json, 2, binary.LittleEndian, 1024, 1024)
+}
+
+func Test_Server(t *testing.T) {
+	router := link.NewRouter()
+	link.RegisterHandler(router, func(session *link.Session, msg *Echo) {
+		session.Send(msg)
+	})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	utest.IsNilNow(t, err)
+	server, err := NewServer(listener, testProtocol(), router, Config{Workers: 4})
+	utest.IsNilNow(t, err)
+	go server.Serve()
+	defer server.Stop()
+
+	const clients = 50
+	sessions := make([]*link.Session, clients)
+	for i := range sessions {
+		sessions[i], err = link.Dial("tcp", listener.Addr().String(), testProtocol(), 0)
+		utest.IsNilNow(t, err)
+	}
+	for i := 0; server.Manager().Len() < clients; i++ {
+		utest.Assert(t, i < 1000)
+		time.Sleep(time.Millisecond)
+	}
+	// idle sessions don't hold goroutines on the server side.
+	utest.Assert(t, runtime.NumGoroutine() < clients*2)
+
+	var wg sync.WaitGroup
+	for _, session := range sessions {
+		wg.Add(1)
+		go func(session *link.Session) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				utest.IsNilNow(t, session.Send(&Echo{j}))
+				msg, err := session.Receive()
+				utest.IsNilNow(t, err)
+				utest.EqualNow(t, msg.(*Echo).N, j)
+			}
+			session.Close()
+		}(session)
+	}
+	wg.Wait()
+	for i := 0; server.Manager().Len() > 0; i++ {
+		utest.Assert(t, i < 1000)
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func Test_ServerStalledPeer(t *testing.T) {
+	router := link.NewRouter()
+	link.RegisterHandler(router, func(session *link.Session, msg *Echo) {
+		session.Send(msg)
+	})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	utest.IsNilNow(t, err)
+	server, err := NewServer(listener, testProtocol(), router, Config{Workers: 1, ReadTimeout: 50 * time.Millisecond})
+	utest.IsNilNow(t, err)
+	go server.Serve()
+	defer server.Stop()
+
+	// half a head, then nothing.
+	stalled, err := net.Dial("tcp", listener.Addr().String())
+	utest.IsNilNow(t, err)
+	defer stalled.Close()
+	_, err = stalled.Write([]byte{1})
+	utest.IsNilNow(t, err)
+
+	session, err := link.Dial("tcp", listener.Addr().String(), testProtocol(), 0)
+	utest.IsNilNow(t, err)
+	defer session.Close()
+	utest.IsNilNow(t, session.Send(&Echo{1}))
+	msg, err := session.Receive()
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, msg.(*Echo).N, 1)
+
+	// the stalled peer is closed.
+	stalled.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = stalled.Read(make([]byte, 1))
+	utest.Assert(t, err == io.EOF, err)
+}
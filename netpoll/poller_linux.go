@@ -0,0 +1,80 @@
+package netpoll
+
+import (
+	"sync"
+	"syscall"
+)
+
+type poller struct {
+	epfd      int
+	wake      [2]int
+	onReady   func(fd int)
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+func newPoller(onReady func(fd int)) (*poller, error) {
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	p := &poller{epfd: epfd, onReady: onReady, done: make(chan struct{})}
+	if err := syscall.Pipe2(p.wake[:], syscall.O_NONBLOCK|syscall.O_CLOEXEC); err != nil {
+		syscall.Close(epfd)
+		return nil, err
+	}
+	event := syscall.EpollEvent{Events: syscall.EPOLLIN, Fd: int32(p.wake[0])}
+	if err := syscall.EpollCtl(epfd, syscall.EPOLL_CTL_ADD, p.wake[0], &event); err != nil {
+		p.closeFds()
+		return nil, err
+	}
+	return p, nil
+}
+
+const readEvents = syscall.EPOLLIN | syscall.EPOLLRDHUP | syscall.EPOLLONESHOT
+
+// add watches fd until it is readable once, rearm watches it again.
+func (p *poller) add(fd int) error {
+	event := syscall.EpollEvent{Events: readEvents, Fd: int32(fd)}
+	return syscall.EpollCtl(p.epfd, syscall.EPOLL_CTL_ADD, fd, &event)
+}
+
+func (p *poller) rearm(fd int) error {
+	event := syscall.EpollEvent{Events: readEvents, Fd: int32(fd)}
+	return syscall.EpollCtl(p.epfd, syscall.EPOLL_CTL_MOD, fd, &event)
+}
+
+func (p *poller) wait() {
+	defer close(p.done)
+	events := make([]syscall.EpollEvent, 128)
+	for {
+		n, err := syscall.EpollWait(p.epfd, events, -1)
+		if err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
+			return
+		}
+		for i := 0; i < n; i++ {
+			fd := int(events[i].Fd)
+			if fd == p.wake[0] {
+				return
+			}
+			p.onReady(fd)
+		}
+	}
+}
+
+func (p *poller) close() {
+	p.closeOnce.Do(func() {
+		syscall.Write(p.wake[1], []byte{0})
+		<-p.done
+		p.closeFds()
+	})
+}
+
+func (p *poller) closeFds() {
+	syscall.Close(p.wake[0])
+	syscall.Close(p.wake[1])
+	syscall.Close(p.epfd)
+}
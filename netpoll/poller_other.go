@@ -0,0 +1,14 @@
+//go:build !linux
+
+package netpoll
+
+type poller struct{}
+
+func newPoller(onReady func(fd int)) (*poller, error) {
+	return nil, NotSupportedError
+}
+
+func (p *poller) add(fd int) error   { return NotSupportedError }
+func (p *poller) rearm(fd int) error { return NotSupportedError }
+func (p *poller) wait()              {}
+func (p *poller) close()             {}
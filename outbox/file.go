@@ -0,0 +1,161 @@
+package outbox
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/funny/link/clock"
+)
+
+// FileStore keeps every outbox in a file of its own in a directory, so it
+// survives restarts. A file starts with the last Seq of the outbox, then
+// come the entries, each with its Seq, expire time in unix nanoseconds and
+// the length of its data. Appends go to the end, acks rewrite the file.
+type FileStore struct {
+	dir   string
+	mutex sync.Mutex
+
+	Clock clock.Clock // of the expiry, nil is clock.Default()
+}
+
+const entryHead = 8 + 8 + 4
+
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (s *FileStore) path(user string) string {
+	return filepath.Join(s.dir, hex.EncodeToString([]byte(user)))
+}
+
+func (s *FileStore) Append(user string, data []byte, expire time.Time) (uint64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	file, err := os.OpenFile(s.path(user), os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	var head [8]byte
+	if _, err := file.ReadAt(head[:], 0); err != nil && err != io.EOF {
+		return 0, err
+	}
+	seq := binary.LittleEndian.Uint64(head[:]) + 1
+	end, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	if end < 8 {
+		end = 8
+	}
+	entry := make([]byte, entryHead+len(data))
+	binary.LittleEndian.PutUint64(entry, seq)
+	binary.LittleEndian.PutUint64(entry[8:], uint64(expireNano(expire)))
+	binary.LittleEndian.PutUint32(entry[16:], uint32(len(data)))
+	copy(entry[entryHead:], data)
+	if _, err := file.WriteAt(entry, end); err != nil {
+		return 0, err
+	}
+	// the header goes last, a torn entry behind it is cut off on read.
+	binary.LittleEndian.PutUint64(head[:], seq)
+	if _, err := file.WriteAt(head[:], 0); err != nil {
+		return 0, err
+	}
+	return seq, file.Sync()
+}
+
+func expireNano(expire time.Time) int64 {
+	if expire.IsZero() {
+		return 0
+	}
+	return expire.UnixNano()
+}
+
+// read returns the last Seq and the entries of user.
+func (s *FileStore) read(user string) (uint64, []Entry, error) {
+	file, err := os.Open(s.path(user))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil, nil
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	defer file.Close()
+	r := bufio.NewReader(file)
+	var head [entryHead]byte
+	if _, err := io.ReadFull(r, head[:8]); err != nil {
+		return 0, nil, nil
+	}
+	last := binary.LittleEndian.Uint64(head[:8])
+	var entries []Entry
+	for {
+		if _, err := io.ReadFull(r, head[:]); err != nil {
+			break
+		}
+		entry := Entry{Seq: binary.LittleEndian.Uint64(head[:])}
+		if nano := int64(binary.LittleEndian.Uint64(head[8:])); nano != 0 {
+			entry.Expire = time.Unix(0, nano)
+		}
+		entry.Data = make([]byte, binary.LittleEndian.Uint32(head[16:]))
+		if _, err := io.ReadFull(r, entry.Data); err != nil || entry.Seq > last {
+			break
+		}
+		entries = append(entries, entry)
+	}
+	return last, entries, nil
+}
+
+func (s *FileStore) List(user string, after uint64, n int) ([]Entry, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, entries, err := s.read(user)
+	if err != nil {
+		return nil, err
+	}
+	now := clock.Or(s.Clock).Now()
+	var result []Entry
+	for _, entry := range entries {
+		if entry.Seq > after && !entry.expired(now) && len(result) < n {
+			result = append(result, entry)
+		}
+	}
+	return result, nil
+}
+
+// Ack rewrites the file without the entries up to seq and the expired ones.
+func (s *FileStore) Ack(user string, seq uint64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	last, entries, err := s.read(user)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, 8, 8+len(entries)*entryHead)
+	binary.LittleEndian.PutUint64(buf, last)
+	now := clock.Or(s.Clock).Now()
+	for _, entry := range entries {
+		if entry.Seq <= seq || entry.expired(now) {
+			continue
+		}
+		var head [entryHead]byte
+		binary.LittleEndian.PutUint64(head[:], entry.Seq)
+		binary.LittleEndian.PutUint64(head[8:], uint64(expireNano(entry.Expire)))
+		binary.LittleEndian.PutUint32(head[16:], uint32(len(entry.Data)))
+		buf = append(append(buf, head[:]...), entry.Data...)
+	}
+	tmp := s.path(user) + ".tmp"
+	if err := os.WriteFile(tmp, buf, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path(user))
+}
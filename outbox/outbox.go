@@ -0,0 +1,305 @@
+package outbox
+
+import (
+	"sync"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/clock"
+)
+
+// Message carries an entry of the outbox to the client, Body is the message
+// marshaled by the Outbox. The client answers with an Ack once it handled
+// the message. Messages may come again after a reconnect, clients drop the
+// ones with a Seq they have seen.
+type Message struct {
+	Seq  uint64
+	Body []byte
+}
+
+// Ack confirms the messages up to Seq.
+type Ack struct {
+	Seq uint64
+}
+
+// Entry is a stored message, Expire is zero when it doesn't expire.
+type Entry struct {
+	Seq    uint64
+	Expire time.Time
+	Data   []byte
+}
+
+func (e *Entry) expired(now time.Time) bool {
+	return !e.Expire.IsZero() && !now.Before(e.Expire)
+}
+
+// Store keeps the outboxes, such as MemoryStore, FileStore and RedisStore.
+// Stores on top of other databases implement the same three calls.
+type Store interface {
+	// Append adds data to the outbox of user, with a Seq larger than the
+	// ones before, also after a restart.
+	Append(user string, data []byte, expire time.Time) (uint64, error)
+	// List returns up to n entries which come after Seq after and did not
+	// expire.
+	List(user string, after uint64, n int) ([]Entry, error)
+	// Ack removes the entries up to seq.
+	Ack(user string, seq uint64) error
+}
+
+// Outbox delivers messages to users at least once. A message is stored
+// before it is sent, so messages to users who are offline go out when they
+// attach a session again, and stay until they are acknowledged or expire.
+// At most Window messages are unacknowledged at once. The Message and Ack
+// types have to be registered with the codec of the sessions.
+type Outbox struct {
+	store   Store
+	marshal func(msg interface{}) ([]byte, error)
+
+	// TTL of the messages, zero means they don't expire.
+	TTL time.Duration
+
+	// Window is the most unacknowledged messages sent to a session.
+	Window int
+
+	Clock clock.Clock // of TTL, nil is clock.Default()
+
+	mutex    sync.Mutex
+	online   map[string]*attached
+	sessions map[uint64]string
+}
+
+type attached struct {
+	session  *link.Session
+	sent     uint64   // the highest Seq sent
+	acked    uint64   // the highest Seq acknowledged
+	inflight []uint64 // sent and not acknowledged
+	pumping  bool     // a pump of the user is running
+	again    bool     // the window changed while pumping
+}
+
+func New(store Store, marshal func(msg interface{}) ([]byte, error)) *Outbox {
+	return &Outbox{
+		store:    store,
+		marshal:  marshal,
+		Window:   64,
+		online:   make(map[string]*attached),
+		sessions: make(map[uint64]string),
+	}
+}
+
+// Register adds the handler of Ack to router.
+func (o *Outbox) Register(router *link.Router) {
+	link.RegisterHandler(router, o.handleAck)
+}
+
+// Attach makes session the one of user, which gets the messages of the
+// outbox from where the user acknowledged. It replaces an earlier session
+// and is undone when session closes.
+func (o *Outbox) Attach(user string, session *link.Session) error {
+	o.mutex.Lock()
+	if old, exists := o.online[user]; exists {
+		delete(o.sessions, old.session.ID())
+		old.session.RemoveCloseCallback(o, user)
+	}
+	o.online[user] = &attached{session: session}
+	o.sessions[session.ID()] = user
+	session.AddCloseCallback(o, user, func() {
+		o.mutex.Lock()
+		defer o.mutex.Unlock()
+		if a, exists := o.online[user]; exists && a.session == session {
+			delete(o.online, user)
+		}
+		delete(o.sessions, session.ID())
+	})
+	o.mutex.Unlock()
+	return o.pump(user)
+}
+
+// AttachFrom is Attach for a session moved from another process, cursor
+// is what the user acknowledged there, see Cursor. The entries up to cursor
+// are acknowledged first in case the store didn't see the ack yet.
+func (o *Outbox) AttachFrom(user string, session *link.Session, cursor uint64) error {
+	if cursor > 0 {
+		if err := o.store.Ack(user, cursor); err != nil {
+			return err
+		}
+	}
+	if err := o.Attach(user, session); err != nil {
+		return err
+	}
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if a, exists := o.online[user]; exists && a.session == session {
+		a.acked = max(a.acked, cursor)
+	}
+	return nil
+}
+
+// Cursor returns the highest Seq the online session of user acknowledged,
+// false when the user is offline.
+func (o *Outbox) Cursor(user string) (uint64, bool) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	a, exists := o.online[user]
+	if !exists {
+		return 0, false
+	}
+	return a.acked, true
+}
+
+// Send stores msg in the outbox of user and sends it when the user is
+// online, it returns after msg was stored.
+func (o *Outbox) Send(user string, msg interface{}) error {
+	data, err := o.marshal(msg)
+	if err != nil {
+		return err
+	}
+	var expire time.Time
+	if o.TTL > 0 {
+		expire = clock.Or(o.Clock).Now().Add(o.TTL)
+	}
+	if _, err := o.store.Append(user, data, expire); err != nil {
+		return err
+	}
+	return o.pump(user)
+}
+
+func (o *Outbox) handleAck(session *link.Session, ack *Ack) {
+	o.mutex.Lock()
+	user, exists := o.sessions[session.ID()]
+	if !exists || ack.Seq > o.online[user].sent {
+		o.mutex.Unlock()
+		return
+	}
+	o.mutex.Unlock()
+	if err := o.store.Ack(user, ack.Seq); err != nil {
+		link.GetLogger().Warn("outbox: ack failed", "user", user, "seq", ack.Seq, "error", err)
+		return
+	}
+	o.mutex.Lock()
+	if a, exists := o.online[user]; exists && a.session == session {
+		a.acked = max(a.acked, ack.Seq)
+		for len(a.inflight) > 0 && a.inflight[0] <= ack.Seq {
+			a.inflight = a.inflight[1:]
+		}
+	}
+	o.mutex.Unlock()
+	if err := o.pump(user); err != nil {
+		link.GetLogger().Warn("outbox: send failed", "user", user, "error", err)
+	}
+}
+
+// pump fills the window of an online user. The store and the session are
+// used outside of the mutex, so a slow user doesn't hold up the others, and
+// one pump per user runs at a time, so the messages go out in order.
+func (o *Outbox) pump(user string) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	a, exists := o.online[user]
+	if !exists {
+		return nil
+	}
+	if a.pumping {
+		a.again = true
+		return nil
+	}
+	a.pumping = true
+	defer func() { a.pumping = false }()
+	for {
+		a.again = false
+		n := o.Window - len(a.inflight)
+		if n <= 0 {
+			return nil
+		}
+		sent := a.sent
+		o.mutex.Unlock()
+		entries, err := o.store.List(user, sent, n)
+		o.mutex.Lock()
+		if err != nil {
+			return err
+		}
+		// the entries count as sent before they go, acks may come at once.
+		for _, entry := range entries {
+			a.sent = entry.Seq
+			a.inflight = append(a.inflight, entry.Seq)
+		}
+		o.mutex.Unlock()
+		for _, entry := range entries {
+			if err = a.session.Send(&Message{entry.Seq, entry.Data}); err != nil {
+				break
+			}
+		}
+		o.mutex.Lock()
+		if err != nil || !a.again {
+			return err
+		}
+	}
+}
+
+// MemoryStore keeps the outboxes in memory, they are lost with the process.
+type MemoryStore struct {
+	mutex sync.Mutex
+	boxes map[string]*memoryBox
+
+	Clock clock.Clock // of the expiry, nil is clock.Default()
+}
+
+type memoryBox struct {
+	seq     uint64
+	entries []Entry
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{boxes: make(map[string]*memoryBox)}
+}
+
+func (s *MemoryStore) box(user string) *memoryBox {
+	box, exists := s.boxes[user]
+	if !exists {
+		box = &memoryBox{}
+		s.boxes[user] = box
+	}
+	return box
+}
+
+func (s *MemoryStore) Append(user string, data []byte, expire time.Time) (uint64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	box := s.box(user)
+	box.seq++
+	box.entries = append(box.entries, Entry{box.seq, expire, data})
+	return box.seq, nil
+}
+
+func (s *MemoryStore) List(user string, after uint64, n int) ([]Entry, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := clock.Or(s.Clock).Now()
+	box := s.box(user)
+	// expired entries go, the seq of the box stays.
+	entries := box.entries[:0]
+	var result []Entry
+	for _, entry := range box.entries {
+		if entry.expired(now) {
+			continue
+		}
+		entries = append(entries, entry)
+		if entry.Seq > after && len(result) < n {
+			result = append(result, entry)
+		}
+	}
+	box.entries = entries
+	return result, nil
+}
+
+func (s *MemoryStore) Ack(user string, seq uint64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	box := s.box(user)
+	i := 0
+	for i < len(box.entries) && box.entries[i].Seq <= seq {
+		i++
+	}
+	box.entries = append(box.entries[:0], box.entries[i:]...)
+	return nil
+}
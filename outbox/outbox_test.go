@@ -0,0 +1,229 @@
+package outbox
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/codec"
+	"github.com/funny/link/internal/resp"
+	"github.com/funny/utest"
+)
+
+type Notice struct {
+	Text string
+}
+
+func testOutbox(t *testing.T, store Store) {
+	protocol := codec.Json()
+	protocol.Register(Message{})
+	protocol.Register(Ack{})
+	o := New(store, json.Marshal)
+	o.Window = 2
+	router := link.NewRouter()
+	o.Register(router)
+
+	attach := func() *link.Session {
+		peer, session, err := link.Pipe(protocol, 0)
+		utest.IsNilNow(t, err)
+		go router.HandleSession(session)
+		utest.IsNilNow(t, o.Attach("alice", session))
+		return peer
+	}
+	receive := func(peer *link.Session, seq uint64, text string) {
+		msg, err := peer.Receive()
+		utest.IsNilNow(t, err)
+		m := msg.(*Message)
+		utest.EqualNow(t, m.Seq, seq)
+		var notice Notice
+		utest.IsNilNow(t, json.Unmarshal(m.Body, &notice))
+		utest.EqualNow(t, notice.Text, text)
+	}
+
+	// sent while alice is offline.
+	for _, text := range []string{"one", "two", "three"} {
+		utest.IsNilNow(t, o.Send("alice", &Notice{text}))
+	}
+	peer := attach()
+	receive(peer, 1, "one")
+	receive(peer, 2, "two")
+	// the window is full until an ack.
+	utest.IsNilNow(t, peer.Send(&Ack{1}))
+	receive(peer, 3, "three")
+	peer.Close()
+
+	// what was not acknowledged comes again.
+	peer = attach()
+	receive(peer, 2, "two")
+	receive(peer, 3, "three")
+	utest.IsNilNow(t, peer.Send(&Ack{3}))
+	utest.IsNilNow(t, o.Send("alice", &Notice{"four"}))
+	receive(peer, 4, "four")
+	utest.IsNilNow(t, peer.Send(&Ack{4}))
+
+	// expired messages are not delivered.
+	o.TTL = time.Millisecond
+	utest.IsNilNow(t, o.Send("bob", &Notice{"late"}))
+	time.Sleep(5 * time.Millisecond)
+	entries, err := store.List("bob", 0, 10)
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, len(entries), 0)
+	peer.Close()
+}
+
+func Test_MemoryStore(t *testing.T) {
+	testOutbox(t, NewMemoryStore())
+}
+
+func Test_SlowUser(t *testing.T) {
+	protocol := codec.Json()
+	protocol.Register(Message{})
+	o := New(NewMemoryStore(), json.Marshal)
+
+	// nobody reads from bob, sending to him blocks.
+	conn, _ := net.Pipe()
+	c, err := protocol.NewCodec(conn)
+	utest.IsNilNow(t, err)
+	bob := link.NewSession(c, 0)
+	defer bob.Close()
+	utest.IsNilNow(t, o.Send("bob", &Notice{"stuck"}))
+	go o.Attach("bob", bob)
+	time.Sleep(10 * time.Millisecond)
+
+	peer, session, err := link.Pipe(protocol, 0)
+	utest.IsNilNow(t, err)
+	defer peer.Close()
+	go func() {
+		o.Attach("alice", session)
+		o.Send("alice", &Notice{"hi"})
+	}()
+	msg, err := peer.Receive()
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, msg.(*Message).Seq, uint64(1))
+}
+
+func Test_FileStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileStore(dir)
+	utest.IsNilNow(t, err)
+	testOutbox(t, store)
+
+	// the outboxes and their seq survive a restart.
+	store, err = NewFileStore(dir)
+	utest.IsNilNow(t, err)
+	seq, err := store.Append("alice", []byte("five"), time.Time{})
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, seq, uint64(5))
+	entries, err := store.List("alice", 0, 10)
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, len(entries), 1)
+	utest.EqualNow(t, string(entries[0].Data), "five")
+}
+
+// fakeRedis serves the sorted set commands RedisStore uses.
+func fakeRedis(t *testing.T) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	utest.IsNilNow(t, err)
+	var mutex sync.Mutex
+	counters := make(map[string]int64)
+	sets := make(map[string]map[string]uint64)
+	score := func(arg string, inf uint64) (uint64, bool) {
+		if arg == "+inf" || arg == "-inf" {
+			return inf, false
+		}
+		open := strings.HasPrefix(arg, "(")
+		n, _ := strconv.ParseUint(strings.TrimPrefix(arg, "("), 10, 64)
+		return n, open
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					v, err := resp.ReadValue(r)
+					if err != nil {
+						return
+					}
+					var args []string
+					for _, arg := range v.([]interface{}) {
+						args = append(args, arg.(string))
+					}
+					mutex.Lock()
+					set := sets[args[1]]
+					if set == nil {
+						set = make(map[string]uint64)
+						sets[args[1]] = set
+					}
+					switch args[0] {
+					case "INCR":
+						counters[args[1]]++
+						fmt.Fprintf(conn, ":%d\r\n", counters[args[1]])
+					case "ZADD":
+						n, _ := strconv.ParseUint(args[2], 10, 64)
+						set[args[3]] = n
+						io.WriteString(conn, ":1\r\n")
+					case "ZREM":
+						for _, member := range args[2:] {
+							delete(set, member)
+						}
+						fmt.Fprintf(conn, ":%d\r\n", len(args)-2)
+					case "ZREMRANGEBYSCORE":
+						max, _ := score(args[3], 0)
+						for member, n := range set {
+							if n <= max {
+								delete(set, member)
+							}
+						}
+						io.WriteString(conn, ":0\r\n")
+					case "ZRANGEBYSCORE":
+						min, open := score(args[2], 0)
+						limit, _ := strconv.Atoi(args[6])
+						var members []string
+						for member, n := range set {
+							if n > min || (!open && n == min) {
+								members = append(members, member)
+							}
+						}
+						sort.Slice(members, func(i, j int) bool { return set[members[i]] < set[members[j]] })
+						if len(members) > limit {
+							members = members[:limit]
+						}
+						resp.WriteCommand(conn, members...)
+					}
+					mutex.Unlock()
+				}
+			}()
+		}
+	}()
+	return listener
+}
+
+func Test_RedisStore(t *testing.T) {
+	listener := fakeRedis(t)
+	defer listener.Close()
+	store := NewRedisStore(listener.Addr().String(), "")
+	defer store.Close()
+	testOutbox(t, store)
+
+	seq, err := store.Append("alice", []byte("five"), time.Time{})
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, seq, uint64(5))
+	entries, err := store.List("alice", 0, 10)
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, len(entries), 1)
+	utest.EqualNow(t, string(entries[0].Data), "five")
+}
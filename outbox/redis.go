@@ -0,0 +1,106 @@
+package outbox
+
+import (
+	"encoding/binary"
+	"strconv"
+	"time"
+
+	"github.com/funny/link/clock"
+	"github.com/funny/link/internal/resp"
+)
+
+// RedisStore keeps every outbox in a sorted set of Redis, scored by Seq, so
+// backends share the outboxes and they survive restarts. A member is an
+// entry in the layout of FileStore, the last Seq of an outbox is a counter
+// next to its set. Seqs are exact up to 2^53, the precision of a score.
+type RedisStore struct {
+	client *resp.Client
+
+	// Prefix of the keys, default "outbox:".
+	Prefix string
+
+	Clock clock.Clock // of the expiry, nil is clock.Default()
+}
+
+// NewRedisStore makes a store on the Redis server at address, with AUTH when
+// password is not empty.
+func NewRedisStore(address, password string) *RedisStore {
+	return &RedisStore{client: resp.NewClient(address, password), Prefix: "outbox:"}
+}
+
+func (s *RedisStore) key(user string) string {
+	return s.Prefix + user
+}
+
+func (s *RedisStore) Append(user string, data []byte, expire time.Time) (uint64, error) {
+	v, err := s.client.Do("INCR", s.key(user)+":seq")
+	if err != nil {
+		return 0, err
+	}
+	seq, ok := v.(int64)
+	if !ok {
+		return 0, resp.BadReplyError
+	}
+	member := make([]byte, entryHead+len(data))
+	binary.LittleEndian.PutUint64(member, uint64(seq))
+	binary.LittleEndian.PutUint64(member[8:], uint64(expireNano(expire)))
+	binary.LittleEndian.PutUint32(member[16:], uint32(len(data)))
+	copy(member[entryHead:], data)
+	if _, err := s.client.Do("ZADD", s.key(user), strconv.FormatInt(seq, 10), string(member)); err != nil {
+		return 0, err
+	}
+	return uint64(seq), nil
+}
+
+// List skips the expired entries and removes them.
+func (s *RedisStore) List(user string, after uint64, n int) ([]Entry, error) {
+	now := clock.Or(s.Clock).Now()
+	min := "(" + strconv.FormatUint(after, 10)
+	var result []Entry
+	for len(result) < n {
+		v, err := s.client.Do("ZRANGEBYSCORE", s.key(user), min, "+inf", "LIMIT", "0", strconv.Itoa(n-len(result)))
+		if err != nil {
+			return nil, err
+		}
+		members, ok := v.([]interface{})
+		if !ok {
+			return nil, resp.BadReplyError
+		}
+		if len(members) == 0 {
+			break
+		}
+		expired := []string{"ZREM", s.key(user)}
+		for _, m := range members {
+			member, _ := m.(string)
+			if len(member) < entryHead {
+				return nil, resp.BadReplyError
+			}
+			entry := Entry{Seq: binary.LittleEndian.Uint64([]byte(member))}
+			if nano := int64(binary.LittleEndian.Uint64([]byte(member[8:]))); nano != 0 {
+				entry.Expire = time.Unix(0, nano)
+			}
+			entry.Data = []byte(member[entryHead:])
+			min = "(" + strconv.FormatUint(entry.Seq, 10)
+			if entry.expired(now) {
+				expired = append(expired, member)
+				continue
+			}
+			result = append(result, entry)
+		}
+		if len(expired) > 2 {
+			if _, err := s.client.Do(expired...); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return result, nil
+}
+
+func (s *RedisStore) Ack(user string, seq uint64) error {
+	_, err := s.client.Do("ZREMRANGEBYSCORE", s.key(user), "-inf", strconv.FormatUint(seq, 10))
+	return err
+}
+
+func (s *RedisStore) Close() error {
+	return s.client.Close()
+}
@@ -0,0 +1,102 @@
+package link
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+func Pipe(protocol Protocol, sendChanSize int) (*Session, *Session, error) {
+	conn1, conn2 := PipeConn()
+	codec1, err := protocol.NewCodec(conn1)
+	if err != nil {
+		return nil, nil, err
+	}
+	codec2, err := protocol.NewCodec(conn2)
+	if err != nil {
+		codec1.Close()
+		return nil, nil, err
+	}
+	return NewSession(codec1, sendChanSize), NewSession(codec2, sendChanSize), nil
+}
+
+func PipeConn() (net.Conn, net.Conn) {
+	buf1 := newPipeBuffer()
+	buf2 := newPipeBuffer()
+	return &pipeConn{buf1, buf2}, &pipeConn{buf2, buf1}
+}
+
+type pipeBuffer struct {
+	mutex  sync.Mutex
+	cond   sync.Cond
+	buf    bytes.Buffer
+	closed bool
+}
+
+func newPipeBuffer() *pipeBuffer {
+	b := &pipeBuffer{}
+	b.cond.L = &b.mutex
+	return b
+}
+
+func (b *pipeBuffer) Read(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for b.buf.Len() == 0 {
+		if b.closed {
+			return 0, io.EOF
+		}
+		b.cond.Wait()
+	}
+	return b.buf.Read(p)
+}
+
+func (b *pipeBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.closed {
+		return 0, io.ErrClosedPipe
+	}
+	n, err := b.buf.Write(p)
+	b.cond.Broadcast()
+	return n, err
+}
+
+func (b *pipeBuffer) Close() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.closed = true
+	b.cond.Broadcast()
+}
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
+
+type pipeConn struct {
+	recv *pipeBuffer
+	send *pipeBuffer
+}
+
+func (conn *pipeConn) Read(p []byte) (int, error) {
+	return conn.recv.Read(p)
+}
+
+func (conn *pipeConn) Write(p []byte) (int, error) {
+	return conn.send.Write(p)
+}
+
+func (conn *pipeConn) Close() error {
+	conn.recv.Close()
+	conn.send.Close()
+	return nil
+}
+
+func (conn *pipeConn) LocalAddr() net.Addr                { return pipeAddr{} }
+func (conn *pipeConn) RemoteAddr() net.Addr               { return pipeAddr{} }
+func (conn *pipeConn) SetDeadline(t time.Time) error      { return nil }
+func (conn *pipeConn) SetReadDeadline(t time.Time) error  { return nil }
+func (conn *pipeConn) SetWriteDeadline(t time.Time) error { return nil }
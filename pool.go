@@ -0,0 +1,89 @@
+package link
+
+import (
+	"sync"
+)
+
+var PoolClosedError = NewError(TransportError, "Worker Pool Closed")
+
+// WorkerPool runs tasks on a fixed number of goroutines. Tasks with the same
+// key always go to the same worker, so the tasks of a session run one by one
+// in submit order while different sessions run in parallel.
+type WorkerPool struct {
+	queues    []chan func()
+	closeOnce sync.Once
+	closeChan chan struct{}
+	workers   sync.WaitGroup
+
+	// submitMutex is held shared by Submit, the workers drain their queues
+	// and stop once Close got it exclusive, so no task is left behind.
+	submitMutex sync.RWMutex
+	drainChan   chan struct{}
+}
+
+// NewWorkerPool starts n workers, each buffering up to queueSize tasks.
+func NewWorkerPool(n, queueSize int) *WorkerPool {
+	if n < 1 {
+		n = 1
+	}
+	pool := &WorkerPool{
+		queues:    make([]chan func(), n),
+		closeChan: make(chan struct{}),
+		drainChan: make(chan struct{}),
+	}
+	for i := range pool.queues {
+		queue := make(chan func(), queueSize)
+		pool.queues[i] = queue
+		pool.workers.Add(1)
+		go pool.work(queue)
+	}
+	return pool
+}
+
+func (pool *WorkerPool) work(queue chan func()) {
+	defer pool.workers.Done()
+	for {
+		select {
+		case task := <-queue:
+			task()
+		case <-pool.drainChan:
+			// finish what was accepted.
+			for {
+				select {
+				case task := <-queue:
+					task()
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// Submit queues task on the worker of key, it blocks while the queue is full.
+func (pool *WorkerPool) Submit(key uint64, task func()) error {
+	pool.submitMutex.RLock()
+	defer pool.submitMutex.RUnlock()
+	select {
+	case <-pool.closeChan:
+		return PoolClosedError
+	default:
+	}
+	select {
+	case pool.queues[key%uint64(len(pool.queues))] <- task:
+		return nil
+	case <-pool.closeChan:
+		return PoolClosedError
+	}
+}
+
+// Close stops accepting tasks and waits for the queued ones.
+func (pool *WorkerPool) Close() {
+	pool.closeOnce.Do(func() {
+		close(pool.closeChan)
+		pool.submitMutex.Lock()
+		close(pool.drainChan)
+		pool.submitMutex.Unlock()
+	})
+	pool.workers.Wait()
+}
@@ -0,0 +1,230 @@
+package presence
+
+import (
+	"sync"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/clock"
+)
+
+// Notify builds the message pushed to watchers when a user's state changes.
+type Notify func(user link.KEY, online bool) interface{}
+
+type user struct {
+	sessions map[uint64]*link.Session
+	online   bool
+	timer    clock.Timer
+}
+
+type event struct {
+	user   link.KEY
+	online bool
+}
+
+type Presence struct {
+	mutex    sync.Mutex
+	debounce time.Duration
+	notify   Notify
+	users    map[link.KEY]*user
+	watchers map[link.KEY]map[uint64]*link.Session
+	events   []event
+	emitting bool
+
+	// OnChange is invoked after watchers were notified.
+	OnChange func(user link.KEY, online bool)
+
+	// Clock runs the debounce timers, nil is clock.Default().
+	Clock clock.Clock
+}
+
+// New creates a Presence. A user is reported offline only after its last
+// session has been gone for debounce, so quick reconnects don't flap.
+func New(debounce time.Duration, notify Notify) *Presence {
+	return &Presence{
+		debounce: debounce,
+		notify:   notify,
+		users:    make(map[link.KEY]*user),
+		watchers: make(map[link.KEY]map[uint64]*link.Session),
+	}
+}
+
+type sessionKey struct {
+	user  link.KEY
+	watch bool
+}
+
+// Online counts session for the user of key, a closed session is ignored.
+func (p *Presence) Online(key link.KEY, session *link.Session) {
+	p.mutex.Lock()
+	u, exists := p.users[key]
+	if !exists || u.sessions[session.ID()] == nil {
+		if !session.AddCloseCallback(p, sessionKey{key, false}, func() {
+			p.Offline(key, session)
+		}) {
+			p.mutex.Unlock()
+			return
+		}
+		if !exists {
+			u = &user{sessions: make(map[uint64]*link.Session)}
+			p.users[key] = u
+		}
+		u.sessions[session.ID()] = session
+	}
+	if u.timer != nil {
+		u.timer.Stop()
+		u.timer = nil
+	}
+	if !u.online {
+		u.online = true
+		p.queue(key, true)
+	}
+	p.mutex.Unlock()
+	p.emit()
+}
+
+func (p *Presence) Offline(key link.KEY, session *link.Session) {
+	p.mutex.Lock()
+	u, exists := p.users[key]
+	if !exists {
+		p.mutex.Unlock()
+		return
+	}
+	if _, exists := u.sessions[session.ID()]; !exists {
+		p.mutex.Unlock()
+		return
+	}
+	delete(u.sessions, session.ID())
+	session.RemoveCloseCallback(p, sessionKey{key, false})
+
+	if len(u.sessions) > 0 || u.timer != nil {
+		p.mutex.Unlock()
+		return
+	}
+	if p.debounce <= 0 {
+		p.setOffline(key, u)
+		p.mutex.Unlock()
+		p.emit()
+		return
+	}
+	// a timer which was stopped too late finds another one, or none, in
+	// u.timer and leaves the user alone.
+	var timer clock.Timer
+	timer = clock.Or(p.Clock).AfterFunc(p.debounce, func() {
+		p.mutex.Lock()
+		if p.users[key] == u && len(u.sessions) == 0 && u.timer == timer {
+			u.timer = nil
+			p.setOffline(key, u)
+		}
+		p.mutex.Unlock()
+		p.emit()
+	})
+	u.timer = timer
+	p.mutex.Unlock()
+}
+
+// setOffline must be called with the mutex held.
+func (p *Presence) setOffline(key link.KEY, u *user) {
+	delete(p.users, key)
+	if u.online {
+		u.online = false
+		p.queue(key, false)
+	}
+}
+
+func (p *Presence) IsOnline(key link.KEY) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	u, exists := p.users[key]
+	return exists && u.online
+}
+
+func (p *Presence) Sessions(key link.KEY) []*link.Session {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	u, exists := p.users[key]
+	if !exists {
+		return nil
+	}
+	sessions := make([]*link.Session, 0, len(u.sessions))
+	for _, session := range u.sessions {
+		sessions = append(sessions, session)
+	}
+	return sessions
+}
+
+func (p *Presence) Watch(watcher *link.Session, key link.KEY) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	watchers, exists := p.watchers[key]
+	if !exists {
+		watchers = make(map[uint64]*link.Session)
+		p.watchers[key] = watchers
+	}
+	if _, exists := watchers[watcher.ID()]; exists {
+		return
+	}
+	watchers[watcher.ID()] = watcher
+	watcher.AddCloseCallback(p, sessionKey{key, true}, func() {
+		p.Unwatch(watcher, key)
+	})
+}
+
+func (p *Presence) Unwatch(watcher *link.Session, key link.KEY) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	watchers, exists := p.watchers[key]
+	if !exists {
+		return
+	}
+	if _, exists := watchers[watcher.ID()]; !exists {
+		return
+	}
+	delete(watchers, watcher.ID())
+	if len(watchers) == 0 {
+		delete(p.watchers, key)
+	}
+	watcher.RemoveCloseCallback(p, sessionKey{key, true})
+}
+
+// queue adds an event in the order of the changes, it must be called with
+// the mutex held and followed by emit once the mutex is released.
+func (p *Presence) queue(key link.KEY, online bool) {
+	p.events = append(p.events, event{key, online})
+}
+
+// emit delivers the queued events outside the mutex. Only one goroutine
+// delivers at a time, the others leave their events to it, so watchers see
+// the events of a user in the order they happened.
+func (p *Presence) emit() {
+	p.mutex.Lock()
+	if p.emitting {
+		p.mutex.Unlock()
+		return
+	}
+	p.emitting = true
+	for len(p.events) > 0 {
+		e := p.events[0]
+		p.events = p.events[1:]
+		watchers := make([]*link.Session, 0, len(p.watchers[e.user]))
+		for _, watcher := range p.watchers[e.user] {
+			watchers = append(watchers, watcher)
+		}
+		p.mutex.Unlock()
+
+		if p.notify != nil && len(watchers) > 0 {
+			msg := p.notify(e.user, e.online)
+			for _, watcher := range watchers {
+				watcher.Send(msg)
+			}
+		}
+		if p.OnChange != nil {
+			p.OnChange(e.user, e.online)
+		}
+
+		p.mutex.Lock()
+	}
+	p.events = nil
+	p.emitting = false
+	p.mutex.Unlock()
+}
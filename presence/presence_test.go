@@ -0,0 +1,131 @@
+package presence
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/clock"
+	"github.com/funny/link/codec"
+	"github.com/funny/utest"
+)
+
+type PresenceMsg struct {
+	User   string
+	Online bool
+}
+
+func Test_Presence(t *testing.T) {
+	json := codec.Json()
+	json.Register(PresenceMsg{})
+
+	p := New(50*time.Millisecond, func(user link.KEY, online bool) interface{} {
+		return &PresenceMsg{user.(string), online}
+	})
+	clk := clock.NewFake(time.Now())
+	p.Clock = clk
+
+	watcherPeer, watcher, err := link.Pipe(json, 0)
+	utest.IsNilNow(t, err)
+	p.Watch(watcher, "alice")
+
+	_, session1, err := link.Pipe(json, 0)
+	utest.IsNilNow(t, err)
+	p.Online("alice", session1)
+	utest.Assert(t, p.IsOnline("alice"))
+
+	msg, err := watcherPeer.Receive()
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, *msg.(*PresenceMsg), PresenceMsg{"alice", true})
+
+	// a quick reconnect produces no events.
+	session1.Close()
+	clk.BlockUntil(1)
+	clk.Advance(10 * time.Millisecond)
+	_, session2, err := link.Pipe(json, 0)
+	utest.IsNilNow(t, err)
+	p.Online("alice", session2)
+	utest.EqualNow(t, clk.Waiters(), 0)
+	clk.Advance(100 * time.Millisecond)
+	utest.Assert(t, p.IsOnline("alice"))
+
+	session2.Close()
+	clk.BlockUntil(1)
+	clk.Advance(50 * time.Millisecond)
+	msg, err = watcherPeer.Receive()
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, *msg.(*PresenceMsg), PresenceMsg{"alice", false})
+	utest.Assert(t, !p.IsOnline("alice"))
+}
+
+func Test_PresenceOrder(t *testing.T) {
+	p := New(0, nil)
+	var events []bool
+	p.OnChange = func(user link.KEY, online bool) {
+		events = append(events, online)
+	}
+
+	// a second Online of a session doesn't count it twice.
+	_, session, err := link.Pipe(codec.Json(), 0)
+	utest.IsNilNow(t, err)
+	p.Online("alice", session)
+	p.Online("alice", session)
+	session.Close()
+	for p.IsOnline("alice") {
+		time.Sleep(time.Millisecond)
+	}
+	utest.EqualNow(t, events, []bool{true, false})
+
+	// a closed session doesn't bring the user online.
+	p.Online("alice", session)
+	utest.Assert(t, !p.IsOnline("alice"))
+
+	// events of concurrent changes alternate like the changes did.
+	events = nil
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		_, session, err := link.Pipe(codec.Json(), 0)
+		utest.IsNilNow(t, err)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				p.Online("bob", session)
+				p.Offline("bob", session)
+			}
+		}()
+	}
+	wg.Wait()
+	utest.Assert(t, len(events) > 0)
+	for i, online := range events {
+		utest.EqualNow(t, online, i%2 == 0)
+	}
+}
+
+func Test_PresenceStaleTimer(t *testing.T) {
+	p := New(50*time.Millisecond, func(user link.KEY, online bool) interface{} {
+		return &PresenceMsg{user.(string), online}
+	})
+	clk := clock.NewFake(time.Now())
+	p.Clock = clk
+	_, session, err := link.Pipe(codec.Json(), 0)
+	utest.IsNilNow(t, err)
+	p.Online("alice", session)
+	p.Offline("alice", session)
+
+	// the timer fires while a reconnect and another disconnect replace it.
+	p.mutex.Lock()
+	fired := make(chan struct{})
+	go func() {
+		clk.Advance(50 * time.Millisecond)
+		close(fired)
+	}()
+	for clk.Waiters() > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	p.users["alice"].timer = clk.AfterFunc(time.Hour, func() {})
+	p.mutex.Unlock()
+	<-fired
+	utest.Assert(t, p.IsOnline("alice"))
+}
@@ -0,0 +1,27 @@
+package link
+
+import (
+	"context"
+	"runtime/pprof"
+	"strconv"
+)
+
+// SessionBucket maps a session ID to one of n buckets, keeping the label
+// cardinality of profiles bounded.
+func SessionBucket(id uint64, n int) string {
+	if n <= 0 {
+		return strconv.FormatUint(id, 10)
+	}
+	return strconv.FormatUint(id%uint64(n), 10)
+}
+
+// Profiled runs base with the pprof label "session" set to the session
+// bucket, so CPU profiles can be split by groups of sessions.
+func Profiled(base Handler, buckets int) Handler {
+	return HandlerFunc(func(session *Session) {
+		labels := pprof.Labels("session", SessionBucket(session.ID(), buckets))
+		pprof.Do(context.Background(), labels, func(context.Context) {
+			base.HandleSession(session)
+		})
+	})
+}
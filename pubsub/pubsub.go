@@ -0,0 +1,176 @@
+package pubsub
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/funny/link"
+)
+
+// Topics are dot separated. In a pattern "*" matches exactly one segment
+// and a trailing ">" matches one or more segments.
+const (
+	separator      = "."
+	singleWildcard = "*"
+	multiWildcard  = ">"
+)
+
+type PubSub struct {
+	mutex    sync.RWMutex
+	exact    map[string]map[uint64]*link.Session
+	patterns map[string]map[uint64]*link.Session
+	topics   map[uint64]map[string]struct{}
+}
+
+func New() *PubSub {
+	return &PubSub{
+		exact:    make(map[string]map[uint64]*link.Session),
+		patterns: make(map[string]map[uint64]*link.Session),
+		topics:   make(map[uint64]map[string]struct{}),
+	}
+}
+
+func isPattern(topic string) bool {
+	for _, segment := range strings.Split(topic, separator) {
+		if segment == singleWildcard || segment == multiWildcard {
+			return true
+		}
+	}
+	return false
+}
+
+func Match(pattern, topic string) bool {
+	ps := strings.Split(pattern, separator)
+	ts := strings.Split(topic, separator)
+	for i, p := range ps {
+		if p == multiWildcard {
+			return i == len(ps)-1 && len(ts) > i
+		}
+		if i >= len(ts) {
+			return false
+		}
+		if p != singleWildcard && p != ts[i] {
+			return false
+		}
+	}
+	return len(ps) == len(ts)
+}
+
+func (ps *PubSub) table(topic string) map[string]map[uint64]*link.Session {
+	if isPattern(topic) {
+		return ps.patterns
+	}
+	return ps.exact
+}
+
+// Subscribe adds session to the subscribers of topic until it unsubscribes
+// or closes, a closed session isn't added.
+func (ps *PubSub) Subscribe(session *link.Session, topic string) {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+
+	table := ps.table(topic)
+	if _, subscribed := table[topic][session.ID()]; subscribed {
+		return
+	}
+	if !session.AddCloseCallback(ps, topic, func() {
+		ps.Unsubscribe(session, topic)
+	}) {
+		return
+	}
+	sessions, exists := table[topic]
+	if !exists {
+		sessions = make(map[uint64]*link.Session)
+		table[topic] = sessions
+	}
+	sessions[session.ID()] = session
+
+	topics, exists := ps.topics[session.ID()]
+	if !exists {
+		topics = make(map[string]struct{})
+		ps.topics[session.ID()] = topics
+	}
+	topics[topic] = struct{}{}
+}
+
+func (ps *PubSub) Unsubscribe(session *link.Session, topic string) bool {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	return ps.unsubscribe(session, topic)
+}
+
+func (ps *PubSub) unsubscribe(session *link.Session, topic string) bool {
+	table := ps.table(topic)
+	sessions, exists := table[topic]
+	if !exists {
+		return false
+	}
+	if _, subscribed := sessions[session.ID()]; !subscribed {
+		return false
+	}
+	delete(sessions, session.ID())
+	if len(sessions) == 0 {
+		delete(table, topic)
+	}
+
+	topics := ps.topics[session.ID()]
+	delete(topics, topic)
+	if len(topics) == 0 {
+		delete(ps.topics, session.ID())
+	}
+
+	session.RemoveCloseCallback(ps, topic)
+	return true
+}
+
+func (ps *PubSub) UnsubscribeAll(session *link.Session) {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	for topic := range ps.topics[session.ID()] {
+		ps.unsubscribe(session, topic)
+	}
+}
+
+func (ps *PubSub) Topics(session *link.Session) []string {
+	ps.mutex.RLock()
+	defer ps.mutex.RUnlock()
+	topics := make([]string, 0, len(ps.topics[session.ID()]))
+	for topic := range ps.topics[session.ID()] {
+		topics = append(topics, topic)
+	}
+	return topics
+}
+
+func (ps *PubSub) Subscribers(topic string) []*link.Session {
+	ps.mutex.RLock()
+	defer ps.mutex.RUnlock()
+
+	var result []*link.Session
+	seen := make(map[uint64]struct{})
+	add := func(sessions map[uint64]*link.Session) {
+		for id, session := range sessions {
+			if _, exists := seen[id]; !exists {
+				seen[id] = struct{}{}
+				result = append(result, session)
+			}
+		}
+	}
+	add(ps.exact[topic])
+	for pattern, sessions := range ps.patterns {
+		if Match(pattern, topic) {
+			add(sessions)
+		}
+	}
+	return result
+}
+
+// Publish sends msg to the subscribers of topic and returns how many sends
+// failed, like link.Channel.Broadcast.
+func (ps *PubSub) Publish(topic string, msg interface{}) (failed int) {
+	for _, session := range ps.Subscribers(topic) {
+		if session.Send(msg) != nil {
+			failed++
+		}
+	}
+	return
+}
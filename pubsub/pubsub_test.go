@@ -0,0 +1,79 @@
+package pubsub
+
+import (
+	"testing"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/codec"
+	"github.com/funny/utest"
+)
+
+type Event struct {
+	Topic string
+}
+
+func Test_Match(t *testing.T) {
+	utest.Assert(t, Match("room.1", "room.1"))
+	utest.Assert(t, !Match("room.1", "room.2"))
+	utest.Assert(t, Match("room.*", "room.2"))
+	utest.Assert(t, !Match("room.*", "room.2.chat"))
+	utest.Assert(t, Match("room.>", "room.2.chat"))
+	utest.Assert(t, !Match("room.>", "room"))
+	utest.Assert(t, Match("*.chat", "room.chat"))
+}
+
+func Test_PubSub(t *testing.T) {
+	json := codec.Json()
+	json.Register(Event{})
+
+	ps := New()
+
+	peer1, session1, err := link.Pipe(json, 0)
+	utest.IsNilNow(t, err)
+	peer2, session2, err := link.Pipe(json, 0)
+	utest.IsNilNow(t, err)
+
+	ps.Subscribe(session1, "room.1")
+	ps.Subscribe(session1, "room.*")
+	ps.Subscribe(session2, "room.>")
+
+	utest.EqualNow(t, len(ps.Subscribers("room.1")), 2)
+	utest.EqualNow(t, ps.Publish("room.1", &Event{"room.1"}), 0)
+	utest.EqualNow(t, len(ps.Subscribers("room.1.chat")), 1)
+	utest.EqualNow(t, ps.Publish("room.1.chat", &Event{"room.1.chat"}), 0)
+	utest.EqualNow(t, len(ps.Subscribers("lobby")), 0)
+	utest.EqualNow(t, ps.Publish("lobby", &Event{"lobby"}), 0)
+
+	msg, err := peer1.Receive()
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, msg.(*Event).Topic, "room.1")
+
+	msg, err = peer2.Receive()
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, msg.(*Event).Topic, "room.1")
+	msg, err = peer2.Receive()
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, msg.(*Event).Topic, "room.1.chat")
+
+	utest.Assert(t, ps.Unsubscribe(session1, "room.1"))
+	utest.EqualNow(t, len(ps.Topics(session1)), 1)
+
+	session2.Close()
+	for len(ps.Topics(session2)) != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	utest.EqualNow(t, len(ps.Subscribers("room.1.chat")), 0)
+}
+
+func Test_SubscribeClosed(t *testing.T) {
+	peer, session, err := link.Pipe(codec.Json(), 0)
+	utest.IsNilNow(t, err)
+	defer peer.Close()
+	session.Close()
+
+	ps := New()
+	ps.Subscribe(session, "room.1")
+	utest.EqualNow(t, len(ps.Topics(session)), 0)
+	utest.EqualNow(t, len(ps.Subscribers("room.1")), 0)
+}
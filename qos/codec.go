@@ -0,0 +1,100 @@
+package qos
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/funny/link"
+)
+
+const (
+	KindAtMostOnce byte = iota + 1
+	KindAtLeastOnce
+	KindAck
+)
+
+const headSize = 9
+
+var BadKindError = link.NewError(link.ProtocolError, "QoS Bad Packet Kind")
+var NotPacketError = link.NewError(link.ProtocolError, "QoS Message Is Not A Packet")
+
+// Packet is a message with its delivery level. ID numbers the at-least-once
+// messages of a session, acks carry the ID they confirm and no body.
+type Packet struct {
+	Kind byte
+	ID   uint64
+	Body interface{}
+}
+
+type protocol struct {
+	base link.Protocol
+}
+
+// Protocol wraps base with the QoS packet header. Like rpc.Protocol it expects
+// one packet per read, so it must sit inside a packet protocol such as
+// codec.FixLen.
+func Protocol(base link.Protocol) link.Protocol {
+	return &protocol{base}
+}
+
+func (p *protocol) NewCodec(rw io.ReadWriter) (link.Codec, error) {
+	base, err := p.base.NewCodec(rw)
+	if err != nil {
+		return nil, err
+	}
+	return &qosCodec{base: base, rw: rw}, nil
+}
+
+type qosCodec struct {
+	base     link.Codec
+	rw       io.ReadWriter
+	recvHead [headSize]byte
+	sendHead [headSize]byte
+}
+
+func (c *qosCodec) Receive() (interface{}, error) {
+	if _, err := io.ReadFull(c.rw, c.recvHead[:]); err != nil {
+		return nil, err
+	}
+	packet := &Packet{
+		Kind: c.recvHead[0],
+		ID:   binary.LittleEndian.Uint64(c.recvHead[1:]),
+	}
+	switch packet.Kind {
+	case KindAck:
+		return packet, nil
+	case KindAtMostOnce, KindAtLeastOnce:
+	default:
+		return nil, BadKindError
+	}
+	body, err := c.base.Receive()
+	if err != nil {
+		return nil, err
+	}
+	packet.Body = body
+	return packet, nil
+}
+
+func (c *qosCodec) Send(msg interface{}) error {
+	if encoded, ok := msg.(link.Encoded); ok {
+		_, err := c.rw.Write(encoded)
+		return err
+	}
+	packet, ok := msg.(*Packet)
+	if !ok {
+		return NotPacketError
+	}
+	c.sendHead[0] = packet.Kind
+	binary.LittleEndian.PutUint64(c.sendHead[1:], packet.ID)
+	if _, err := c.rw.Write(c.sendHead[:]); err != nil {
+		return err
+	}
+	if packet.Kind == KindAck {
+		return nil
+	}
+	return c.base.Send(packet.Body)
+}
+
+func (c *qosCodec) Close() error {
+	return c.base.Close()
+}
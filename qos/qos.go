@@ -0,0 +1,210 @@
+package qos
+
+import (
+	"sync"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/clock"
+)
+
+// Level is the delivery guarantee of a message.
+type Level byte
+
+const (
+	// AtMostOnce messages are sent once, they are lost with the connection.
+	AtMostOnce Level = iota
+	// AtLeastOnce messages are sent again until the peer acks them, the
+	// peer drops the copies it has seen.
+	AtLeastOnce
+)
+
+var TooManyPendingError = link.NewError(link.PolicyError, "QoS Too Many Pending")
+
+type Config struct {
+	Retry      time.Duration // between redeliveries, default 1s
+	MaxRetries int           // redeliveries before a message is dropped, zero means no limit
+	MaxPending int           // unacknowledged messages, default 1024
+
+	// OnDrop is called with the messages given up after MaxRetries.
+	OnDrop func(msg interface{})
+
+	Clock clock.Clock // nil is clock.Default()
+}
+
+// Session sends and receives messages with a delivery level over a session
+// of Protocol. Both ends of the connection wrap their session in one.
+type Session struct {
+	session *link.Session
+	config  Config
+
+	mutex   sync.Mutex
+	nextID  uint64
+	pending map[uint64]*pending
+	seen    seenSet
+}
+
+type pending struct {
+	packet *Packet
+	retry  int
+	timer  clock.Timer
+}
+
+func New(session *link.Session, config Config) *Session {
+	if config.Retry <= 0 {
+		config.Retry = time.Second
+	}
+	if config.MaxPending <= 0 {
+		config.MaxPending = 1024
+	}
+	config.Clock = clock.Or(config.Clock)
+	s := &Session{
+		session: session,
+		config:  config,
+		pending: make(map[uint64]*pending),
+		seen:    seenSet{above: make(map[uint64]struct{})},
+	}
+	session.AddCloseCallback(s, nil, s.stop)
+	return s
+}
+
+func (s *Session) Session() *link.Session {
+	return s.session
+}
+
+// Pending returns the at-least-once messages which are not acked yet.
+func (s *Session) Pending() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.pending)
+}
+
+// Send sends msg with level, at-least-once messages are redelivered every
+// Retry until they are acked or the session closes.
+func (s *Session) Send(msg interface{}, level Level) error {
+	if level == AtMostOnce {
+		return s.session.Send(&Packet{Kind: KindAtMostOnce, Body: msg})
+	}
+	s.mutex.Lock()
+	if s.session.IsClosed() {
+		s.mutex.Unlock()
+		return link.SessionClosedError
+	}
+	if len(s.pending) >= s.config.MaxPending {
+		s.mutex.Unlock()
+		return TooManyPendingError
+	}
+	s.nextID++
+	id := s.nextID
+	p := &pending{packet: &Packet{Kind: KindAtLeastOnce, ID: id, Body: msg}}
+	// pending before sent, the ack may come back first.
+	s.pending[id] = p
+	p.timer = s.config.Clock.AfterFunc(s.config.Retry, func() { s.redeliver(id) })
+	s.mutex.Unlock()
+	return s.session.Send(p.packet)
+}
+
+func (s *Session) redeliver(id uint64) {
+	s.mutex.Lock()
+	p, exists := s.pending[id]
+	if !exists {
+		s.mutex.Unlock()
+		return
+	}
+	if p.retry++; s.config.MaxRetries > 0 && p.retry > s.config.MaxRetries {
+		delete(s.pending, id)
+		s.mutex.Unlock()
+		if s.config.OnDrop != nil {
+			s.config.OnDrop(p.packet.Body)
+		}
+		return
+	}
+	p.timer.Reset(s.config.Retry)
+	s.mutex.Unlock()
+	if err := s.session.Send(p.packet); err != nil {
+		link.GetLogger().Warn("qos: redelivery failed", "session", s.session.ID(), "id", id, "error", err)
+	}
+}
+
+func (s *Session) stop() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for id, p := range s.pending {
+		p.timer.Stop()
+		delete(s.pending, id)
+	}
+}
+
+// Receive returns the next message from the peer. At-least-once messages are
+// acked as they are returned, copies of the ones returned before are dropped.
+func (s *Session) Receive() (interface{}, error) {
+	for {
+		msg, err := s.session.Receive()
+		if err != nil {
+			return nil, err
+		}
+		packet := msg.(*Packet)
+		switch packet.Kind {
+		case KindAtMostOnce:
+			return packet.Body, nil
+		case KindAck:
+			s.ack(packet.ID)
+		case KindAtLeastOnce:
+			// acked again when it is a copy, the first ack may be lost.
+			if err := s.session.Send(&Packet{Kind: KindAck, ID: packet.ID}); err != nil {
+				return nil, err
+			}
+			s.mutex.Lock()
+			fresh := s.seen.add(packet.ID, s.config.MaxPending)
+			s.mutex.Unlock()
+			if fresh {
+				return packet.Body, nil
+			}
+		}
+	}
+}
+
+func (s *Session) ack(id uint64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if p, exists := s.pending[id]; exists {
+		p.timer.Stop()
+		delete(s.pending, id)
+	}
+}
+
+// seenSet holds the received IDs, all up to low and the ones in above.
+type seenSet struct {
+	low   uint64
+	above map[uint64]struct{}
+}
+
+// add reports whether id is new. Gaps left by messages the peer dropped
+// would keep above growing, so it holds at most max IDs and low skips the
+// oldest gap.
+func (s *seenSet) add(id uint64, max int) bool {
+	if id <= s.low {
+		return false
+	}
+	if _, exists := s.above[id]; exists {
+		return false
+	}
+	s.above[id] = struct{}{}
+	if len(s.above) > max {
+		min := id
+		for id := range s.above {
+			if id < min {
+				min = id
+			}
+		}
+		s.low = min - 1
+	}
+	for {
+		if _, exists := s.above[s.low+1]; !exists {
+			break
+		}
+		delete(s.above, s.low+1)
+		s.low++
+	}
+	return true
+}
@@ -0,0 +1,143 @@
+package qos
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/clock"
+	"github.com/funny/link/codec"
+	"github.com/funny/utest"
+)
+
+type Chat struct {
+	Text string
+}
+
+func pipe(t *testing.T) (*link.Session, *link.Session) {
+	json := codec.Json()
+	json.Register(Chat{})
+	protocol := codec.FixLen(Protocol(json), 4, binary.LittleEndian, 64*1024, 64*1024)
+	a, b, err := link.Pipe(protocol, 0)
+	utest.IsNilNow(t, err)
+	return a, b
+}
+
+func receive(t *testing.T, session *link.Session) *Packet {
+	msg, err := session.Receive()
+	utest.IsNilNow(t, err)
+	return msg.(*Packet)
+}
+
+func Test_Redelivery(t *testing.T) {
+	fake := clock.NewFake(time.Unix(0, 0))
+	a, peer := pipe(t)
+	defer a.Close()
+	var dropped []interface{}
+	sender := New(a, Config{Retry: time.Second, MaxRetries: 2, Clock: fake, OnDrop: func(msg interface{}) {
+		dropped = append(dropped, msg)
+	}})
+
+	utest.IsNilNow(t, sender.Send(&Chat{"fire"}, AtMostOnce))
+	packet := receive(t, peer)
+	utest.EqualNow(t, packet.Kind, KindAtMostOnce)
+	utest.EqualNow(t, packet.Body.(*Chat).Text, "fire")
+	utest.EqualNow(t, sender.Pending(), 0)
+
+	utest.IsNilNow(t, sender.Send(&Chat{"hello"}, AtLeastOnce))
+	first := receive(t, peer)
+	utest.EqualNow(t, first.Kind, KindAtLeastOnce)
+	utest.EqualNow(t, sender.Pending(), 1)
+
+	// not acked, it comes again with the same ID.
+	fake.Advance(time.Second)
+	again := receive(t, peer)
+	utest.EqualNow(t, again.ID, first.ID)
+	utest.EqualNow(t, again.Body.(*Chat).Text, "hello")
+
+	// the ack goes through the Receive of the sender.
+	go sender.Receive()
+	utest.IsNilNow(t, peer.Send(&Packet{Kind: KindAck, ID: first.ID}))
+	for i := 0; sender.Pending() != 0 && i < 100; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	utest.EqualNow(t, sender.Pending(), 0)
+
+	// given up after MaxRetries.
+	utest.IsNilNow(t, sender.Send(&Chat{"lost"}, AtLeastOnce))
+	receive(t, peer)
+	fake.Advance(time.Second)
+	receive(t, peer)
+	fake.Advance(time.Second)
+	receive(t, peer)
+	fake.Advance(time.Second)
+	utest.EqualNow(t, sender.Pending(), 0)
+	utest.EqualNow(t, len(dropped), 1)
+	utest.EqualNow(t, dropped[0].(*Chat).Text, "lost")
+}
+
+func Test_Duplicates(t *testing.T) {
+	peer, b := pipe(t)
+	defer peer.Close()
+	receiver := New(b, Config{})
+
+	utest.IsNilNow(t, peer.Send(&Packet{Kind: KindAtLeastOnce, ID: 1, Body: &Chat{"one"}}))
+	utest.IsNilNow(t, peer.Send(&Packet{Kind: KindAtLeastOnce, ID: 1, Body: &Chat{"one"}}))
+	utest.IsNilNow(t, peer.Send(&Packet{Kind: KindAtLeastOnce, ID: 3, Body: &Chat{"three"}}))
+	utest.IsNilNow(t, peer.Send(&Packet{Kind: KindAtLeastOnce, ID: 2, Body: &Chat{"two"}}))
+	utest.IsNilNow(t, peer.Send(&Packet{Kind: KindAtLeastOnce, ID: 3, Body: &Chat{"three"}}))
+	utest.IsNilNow(t, peer.Send(&Packet{Kind: KindAtMostOnce, Body: &Chat{"end"}}))
+
+	var texts []string
+	for len(texts) < 4 {
+		msg, err := receiver.Receive()
+		utest.IsNilNow(t, err)
+		texts = append(texts, msg.(*Chat).Text)
+	}
+	utest.EqualNow(t, texts, []string{"one", "three", "two", "end"})
+
+	// every copy is acked.
+	var acks []uint64
+	for i := 0; i < 5; i++ {
+		packet := receive(t, peer)
+		utest.EqualNow(t, packet.Kind, KindAck)
+		acks = append(acks, packet.ID)
+	}
+	utest.EqualNow(t, acks, []uint64{1, 1, 3, 2, 3})
+	utest.EqualNow(t, receiver.seen.low, uint64(3))
+}
+
+func Test_SeenSet(t *testing.T) {
+	s := seenSet{above: make(map[uint64]struct{})}
+	utest.Assert(t, s.add(2, 2))
+	utest.Assert(t, s.add(4, 2))
+	// 1 and 3 never came, the oldest gap is skipped.
+	utest.Assert(t, s.add(5, 2))
+	utest.EqualNow(t, s.low, uint64(2))
+	utest.Assert(t, !s.add(1, 2))
+	utest.Assert(t, s.add(3, 2))
+	utest.EqualNow(t, s.low, uint64(5))
+}
+
+func Test_CodecSend(t *testing.T) {
+	json := codec.Json()
+	json.Register(Chat{})
+	a, b, err := link.Pipe(Protocol(json), 0)
+	utest.IsNilNow(t, err)
+	defer a.Close()
+	defer b.Close()
+	utest.Assert(t, a.Send(&Chat{"hi"}) == NotPacketError)
+
+	// encoded frames go out as they are.
+	broadcaster, err := link.NewBroadcaster(codec.FixLen(Protocol(json), 4, binary.LittleEndian, 1024, 1024))
+	utest.IsNilNow(t, err)
+	encoded, err := broadcaster.Encode(&Packet{Kind: KindAtMostOnce, Body: &Chat{"all"}})
+	utest.IsNilNow(t, err)
+	c, d := pipe(t)
+	defer c.Close()
+	defer d.Close()
+	go c.Send(encoded)
+	packet := receive(t, d)
+	utest.EqualNow(t, packet.Body, &Chat{"all"})
+}
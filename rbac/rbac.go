@@ -0,0 +1,183 @@
+package rbac
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"path"
+	"reflect"
+	"sync"
+	"sync/atomic"
+
+	"github.com/funny/link"
+)
+
+var DeniedError = link.NewError(link.PolicyError, "Permission Denied")
+
+// Rule lets sessions with one of Roles send the messages Message of Service,
+// "*" as Message stands for every message of the service.
+type Rule struct {
+	Service string   `json:"service"`
+	Message string   `json:"message"`
+	Roles   []string `json:"roles"`
+}
+
+// Source is where the rules come from, such as Static or a store of its own.
+// Roles returns the roles allowed to send a message, ok is false when no rule
+// covers it.
+type Source interface {
+	Roles(service, message string) (roles []string, ok bool)
+}
+
+// Static is a Source of rules in memory, Set replaces them at runtime.
+type Static struct {
+	rules atomic.Pointer[map[[2]string][]string]
+}
+
+func NewStatic(rules ...Rule) *Static {
+	s := &Static{}
+	s.Set(rules)
+	return s
+}
+
+// LoadJSON reads a JSON array of rules.
+func LoadJSON(r io.Reader) (*Static, error) {
+	var rules []Rule
+	if err := json.NewDecoder(r).Decode(&rules); err != nil {
+		return nil, err
+	}
+	return NewStatic(rules...), nil
+}
+
+func (s *Static) Set(rules []Rule) {
+	m := make(map[[2]string][]string, len(rules))
+	for _, rule := range rules {
+		key := [2]string{rule.Service, rule.Message}
+		m[key] = append(m[key], rule.Roles...)
+	}
+	s.rules.Store(&m)
+}
+
+func (s *Static) Roles(service, message string) ([]string, bool) {
+	rules := *s.rules.Load()
+	if roles, ok := rules[[2]string{service, message}]; ok {
+		return roles, true
+	}
+	roles, ok := rules[[2]string{service, "*"}]
+	return roles, ok
+}
+
+// Authorizer checks the roles of a session before its messages are handled.
+// Messages are named by the package and the type of their Go type, such as
+// "chat" and "Say" for *chat.Say, unless Name says otherwise. Messages no
+// rule covers are denied unless AllowUnlisted is set.
+type Authorizer struct {
+	source Source
+	mutex  sync.RWMutex
+	grants map[uint64][]string
+	denied atomic.Uint64
+
+	// Name returns the service and message of msg.
+	Name func(msg interface{}) (service, message string)
+
+	// Roles returns the roles of session, by default the ones given to Grant.
+	Roles func(session *link.Session) []string
+
+	// AllowUnlisted lets through the messages no rule covers, for adding
+	// rules to a running service bit by bit.
+	AllowUnlisted bool
+
+	// OnDeny is called with every denied message, for audit events or a
+	// reply, by default it is logged.
+	OnDeny func(session *link.Session, msg interface{}, service, message string)
+}
+
+func New(source Source) *Authorizer {
+	a := &Authorizer{
+		source: source,
+		grants: make(map[uint64][]string),
+		Name:   TypeName,
+	}
+	a.Roles = a.granted
+	return a
+}
+
+// TypeName is the default Name of an Authorizer.
+func TypeName(msg interface{}) (string, string) {
+	t := reflect.TypeOf(msg)
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return path.Base(t.PkgPath()), t.Name()
+}
+
+// Grant gives session roles after it was authenticated, they go when it
+// closes. A closed session is ignored.
+func (a *Authorizer) Grant(session *link.Session, roles ...string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if _, exists := a.grants[session.ID()]; !exists {
+		if !session.AddCloseCallback(a, nil, func() {
+			a.mutex.Lock()
+			defer a.mutex.Unlock()
+			delete(a.grants, session.ID())
+		}) {
+			return
+		}
+	}
+	a.grants[session.ID()] = append(a.grants[session.ID()], roles...)
+}
+
+func (a *Authorizer) granted(session *link.Session) []string {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	return a.grants[session.ID()]
+}
+
+// Denied returns the number of denied messages.
+func (a *Authorizer) Denied() uint64 {
+	return a.denied.Load()
+}
+
+// Check returns DeniedError unless session may send msg.
+func (a *Authorizer) Check(session *link.Session, msg interface{}) error {
+	service, message := a.Name(msg)
+	if a.allowed(session, service, message) {
+		return nil
+	}
+	a.denied.Add(1)
+	if a.OnDeny != nil {
+		a.OnDeny(session, msg, service, message)
+	} else {
+		link.GetLogger().Warn("rbac: denied", "session", session.ID(), "service", service, "message", message)
+	}
+	return DeniedError
+}
+
+func (a *Authorizer) allowed(session *link.Session, service, message string) bool {
+	allowed, ok := a.source.Roles(service, message)
+	if !ok {
+		return a.AllowUnlisted
+	}
+	for _, role := range a.Roles(session) {
+		for _, r := range allowed {
+			if r == role {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Middleware drops the messages a session may not send, before they reach
+// the handler.
+func (a *Authorizer) Middleware() link.Middleware {
+	return func(next link.MessageHandler) link.MessageHandler {
+		return func(ctx context.Context, session *link.Session, msg interface{}) {
+			if a.Check(session, msg) != nil {
+				return
+			}
+			next(ctx, session, msg)
+		}
+	}
+}
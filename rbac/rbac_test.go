@@ -0,0 +1,66 @@
+package rbac
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/funny/link"
+	"github.com/funny/link/codec"
+	"github.com/funny/utest"
+)
+
+type Say struct{ Text string }
+type Ban struct{ User string }
+type Ping struct{}
+
+func Test_Authorizer(t *testing.T) {
+	source, err := LoadJSON(strings.NewReader(`[
+		{"service": "rbac", "message": "Say", "roles": ["user", "admin"]},
+		{"service": "rbac", "message": "Ban", "roles": ["admin"]}
+	]`))
+	utest.IsNilNow(t, err)
+	a := New(source)
+	var denied []string
+	a.OnDeny = func(session *link.Session, msg interface{}, service, message string) {
+		denied = append(denied, service+"."+message)
+	}
+
+	var handled []interface{}
+	router := link.NewRouter()
+	router.Use(a.Middleware())
+	link.RegisterHandler(router, func(session *link.Session, msg *Say) { handled = append(handled, msg) })
+	link.RegisterHandler(router, func(session *link.Session, msg *Ban) { handled = append(handled, msg) })
+	link.RegisterHandler(router, func(session *link.Session, msg *Ping) { handled = append(handled, msg) })
+
+	session := link.NewSession(nil, 0)
+	// not authenticated yet, and no rule covers Ping.
+	router.Dispatch(session, &Say{"hi"})
+	router.Dispatch(session, &Ping{})
+	utest.EqualNow(t, len(handled), 0)
+
+	a.Grant(session, "user")
+	router.Dispatch(session, &Say{"hi"})
+	router.Dispatch(session, &Ban{"bob"})
+	utest.EqualNow(t, len(handled), 1)
+	utest.EqualNow(t, denied, []string{"rbac.Say", "rbac.Ping", "rbac.Ban"})
+	utest.EqualNow(t, a.Denied(), uint64(3))
+
+	// the rules change at runtime.
+	source.Set([]Rule{{Service: "rbac", Message: "*", Roles: []string{"user"}}})
+	router.Dispatch(session, &Ban{"bob"})
+	utest.EqualNow(t, len(handled), 2)
+
+	a.AllowUnlisted = true
+	source.Set(nil)
+	utest.IsNilNow(t, a.Check(session, &Ping{}))
+}
+
+func Test_GrantClosed(t *testing.T) {
+	a := New(NewStatic())
+	peer, session, err := link.Pipe(codec.Json(), 0)
+	utest.IsNilNow(t, err)
+	defer peer.Close()
+	session.Close()
+	a.Grant(session, "user")
+	utest.EqualNow(t, len(a.grants), 0)
+}
@@ -0,0 +1,61 @@
+package link
+
+import (
+	"errors"
+	"io"
+)
+
+// RelayFunc converts a message received from one session of a relay before
+// it is sent to the other, a nil message is dropped.
+type RelayFunc func(from *Session, msg interface{}) (interface{}, error)
+
+// Relay forwards the messages of a to b and of b to a until one of them
+// fails or closes, then closes both. Messages are passed on as decoded by
+// the codec of one session and encoded by the codec of the other, so the two
+// may speak different protocols. It returns nil when a session was closed.
+func Relay(a, b *Session) error {
+	return RelayWith(a, b, nil)
+}
+
+// RelayWith is Relay with convert applied to every message, see RelayFunc.
+func RelayWith(a, b *Session, convert RelayFunc) error {
+	errs := make(chan error, 2)
+	go relay(a, b, convert, errs)
+	go relay(b, a, convert, errs)
+	err := <-errs
+	a.Close()
+	b.Close()
+	<-errs
+	if err == io.EOF || errors.Is(err, SessionClosedError) {
+		err = nil
+	}
+	return err
+}
+
+func relay(from, to *Session, convert RelayFunc, errs chan<- error) {
+	var buf []interface{}
+	for {
+		msgs, err := from.ReceiveBatch(buf[:0], 64)
+		for _, msg := range msgs {
+			if convert != nil {
+				var cerr error
+				if msg, cerr = convert(from, msg); cerr != nil {
+					errs <- cerr
+					return
+				}
+				if msg == nil {
+					continue
+				}
+			}
+			if serr := to.Send(msg); serr != nil {
+				errs <- serr
+				return
+			}
+		}
+		if err != nil {
+			errs <- err
+			return
+		}
+		buf = msgs
+	}
+}
@@ -0,0 +1,232 @@
+package reverse
+
+import (
+	"io"
+	"net"
+	"sync"
+
+	"github.com/funny/link"
+	"github.com/funny/link/internal/redial"
+	"github.com/funny/link/mux"
+)
+
+var NoBackendError = link.NewError(link.TransportError, "No Backend")
+
+type Listener struct {
+	network    string
+	address    string
+	config     mux.Config
+	acceptChan chan net.Conn
+	closeOnce  sync.Once
+	closeChan  chan struct{}
+
+	mutex   sync.Mutex
+	current *mux.Mux
+}
+
+// Listen dials out to the rendezvous point and accepts the streams it opens.
+// The connection is redialed with backoff whenever it breaks.
+func Listen(network, address string, config mux.Config) *Listener {
+	listener := &Listener{
+		network:    network,
+		address:    address,
+		config:     config,
+		acceptChan: make(chan net.Conn),
+		closeChan:  make(chan struct{}),
+	}
+	go listener.dialLoop()
+	return listener
+}
+
+func (listener *Listener) dialLoop() {
+	var backoff redial.Backoff
+	for {
+		conn, err := net.Dial(listener.network, listener.address)
+		if err == nil {
+			backoff.Reset()
+			listener.serve(mux.Server(conn, listener.config))
+		}
+		if !backoff.Wait(listener.closeChan) {
+			return
+		}
+	}
+}
+
+func (listener *Listener) serve(m *mux.Mux) {
+	listener.mutex.Lock()
+	select {
+	case <-listener.closeChan:
+		listener.mutex.Unlock()
+		m.Close()
+		return
+	default:
+	}
+	listener.current = m
+	listener.mutex.Unlock()
+
+	defer m.Close()
+	for {
+		conn, err := m.Accept()
+		if err != nil {
+			return
+		}
+		select {
+		case listener.acceptChan <- conn:
+		case <-listener.closeChan:
+			conn.Close()
+			return
+		}
+	}
+}
+
+func (listener *Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-listener.acceptChan:
+		return conn, nil
+	case <-listener.closeChan:
+		return nil, io.EOF
+	}
+}
+
+func (listener *Listener) Close() error {
+	listener.closeOnce.Do(func() {
+		close(listener.closeChan)
+		listener.mutex.Lock()
+		if listener.current != nil {
+			listener.current.Close()
+		}
+		listener.mutex.Unlock()
+	})
+	return nil
+}
+
+func (listener *Listener) Addr() net.Addr {
+	listener.mutex.Lock()
+	defer listener.mutex.Unlock()
+	if listener.current != nil {
+		return listener.current.Addr()
+	}
+	return rendezvousAddr{listener.network, listener.address}
+}
+
+type rendezvousAddr struct {
+	network string
+	address string
+}
+
+func (a rendezvousAddr) Network() string { return a.network }
+func (a rendezvousAddr) String() string  { return a.address }
+
+type Rendezvous struct {
+	listener net.Listener
+	config   mux.Config
+	mutex    sync.Mutex
+	backends []*mux.Mux
+	next     int
+}
+
+func NewRendezvous(listener net.Listener, config mux.Config) *Rendezvous {
+	return &Rendezvous{
+		listener: listener,
+		config:   config,
+	}
+}
+
+func (r *Rendezvous) Serve() error {
+	for {
+		conn, err := link.Accept(r.listener)
+		if err != nil {
+			return err
+		}
+		backend := mux.Client(conn, r.config)
+		r.mutex.Lock()
+		r.backends = append(r.backends, backend)
+		r.mutex.Unlock()
+	}
+}
+
+func (r *Rendezvous) Stop() {
+	r.listener.Close()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, backend := range r.backends {
+		backend.Close()
+	}
+	r.backends = nil
+}
+
+func (r *Rendezvous) NumBackends() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.prune()
+	return len(r.backends)
+}
+
+func (r *Rendezvous) prune() {
+	alive := r.backends[:0]
+	for _, backend := range r.backends {
+		if !backend.IsClosed() {
+			alive = append(alive, backend)
+		}
+	}
+	for i := len(alive); i < len(r.backends); i++ {
+		r.backends[i] = nil
+	}
+	r.backends = alive
+}
+
+// DialConn opens a stream to one of the connected backends in round-robin order.
+func (r *Rendezvous) DialConn() (net.Conn, error) {
+	for {
+		r.mutex.Lock()
+		r.prune()
+		if len(r.backends) == 0 {
+			r.mutex.Unlock()
+			return nil, NoBackendError
+		}
+		r.next = (r.next + 1) % len(r.backends)
+		backend := r.backends[r.next]
+		r.mutex.Unlock()
+
+		stream, err := backend.Open()
+		if err == nil {
+			return stream, nil
+		}
+	}
+}
+
+func (r *Rendezvous) Dial(protocol link.Protocol, sendChanSize int) (*link.Session, error) {
+	conn, err := r.DialConn()
+	if err != nil {
+		return nil, err
+	}
+	codec, err := protocol.NewCodec(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return link.NewSession(codec, sendChanSize), nil
+}
+
+// Forward accepts public connections and pipes each one to a backend stream.
+func (r *Rendezvous) Forward(public net.Listener) error {
+	for {
+		conn, err := link.Accept(public)
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			stream, err := r.DialConn()
+			if err != nil {
+				return
+			}
+			defer stream.Close()
+			go func() {
+				io.Copy(stream, conn)
+				stream.Close()
+			}()
+			io.Copy(conn, stream)
+		}()
+	}
+}
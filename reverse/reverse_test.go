@@ -0,0 +1,71 @@
+package reverse
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/codec"
+	"github.com/funny/link/mux"
+	"github.com/funny/utest"
+)
+
+type EchoMsg struct {
+	Seq int
+}
+
+func Test_Reverse(t *testing.T) {
+	json := codec.Json()
+	json.Register(EchoMsg{})
+	protocol := codec.FixLen(json, 2, binary.LittleEndian, 1024, 1024)
+
+	rendezvousListener, err := net.Listen("tcp", "127.0.0.1:0")
+	utest.IsNilNow(t, err)
+	rendezvous := NewRendezvous(rendezvousListener, mux.DefaultConfig)
+	go rendezvous.Serve()
+	defer rendezvous.Stop()
+
+	listener := Listen("tcp", rendezvousListener.Addr().String(), mux.DefaultConfig)
+	server := link.NewServer(listener, protocol, 0, link.HandlerFunc(func(session *link.Session) {
+		defer session.Close()
+		for {
+			msg, err := session.Receive()
+			if err != nil {
+				return
+			}
+			if session.Send(msg) != nil {
+				return
+			}
+		}
+	}))
+	go server.Serve()
+	defer server.Stop()
+
+	for rendezvous.NumBackends() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	public, err := net.Listen("tcp", "127.0.0.1:0")
+	utest.IsNilNow(t, err)
+	defer public.Close()
+	go rendezvous.Forward(public)
+
+	direct, err := rendezvous.Dial(protocol, 0)
+	utest.IsNilNow(t, err)
+	defer direct.Close()
+
+	forwarded, err := link.Dial("tcp", public.Addr().String(), protocol, 0)
+	utest.IsNilNow(t, err)
+	defer forwarded.Close()
+
+	for _, session := range []*link.Session{direct, forwarded} {
+		for i := 0; i < 20; i++ {
+			utest.IsNilNow(t, session.Send(&EchoMsg{i}))
+			msg, err := session.Receive()
+			utest.IsNilNow(t, err)
+			utest.EqualNow(t, msg.(*EchoMsg).Seq, i)
+		}
+	}
+}
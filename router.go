@@ -0,0 +1,289 @@
+package link
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+var NoRouteError = NewError(ProtocolError, "No Handler For Message")
+
+// PanicPolicy says what a Router does when a handler panics.
+type PanicPolicy int
+
+const (
+	PanicPropagate    PanicPolicy = iota // don't recover, the process crashes
+	PanicCloseSession                    // close the session of the message
+	PanicReply                           // send Router.PanicReply and go on
+)
+
+// PanicError is a recovered handler panic.
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("link: handler panic: %v", e.Value)
+}
+
+func (e *PanicError) Unwrap() error {
+	return HandlerError
+}
+
+// Router is a Handler which receives messages and dispatches them by their
+// Go type to handlers registered with RegisterHandler.
+type Router struct {
+	handlers   map[routeKey]MessageHandler
+	routes     map[routeKey]MessageHandler // handlers wrapped by middleware
+	middleware []Middleware
+	timeouts   map[reflect.Type]time.Duration
+
+	// Timeout is the execution time of handlers without their own timeout,
+	// zero means no limit.
+	Timeout time.Duration
+
+	// OnTimeout is called when a handler is still running at its deadline,
+	// by default it is logged.
+	OnTimeout func(session *Session, msg interface{}, timeout time.Duration)
+
+	// Panic is the policy for panicking handlers. Unless it is PanicPropagate
+	// OnPanic is called with the stack, by default the panic is logged.
+	Panic      PanicPolicy
+	OnPanic    func(session *Session, msg interface{}, err *PanicError)
+	PanicReply func(msg interface{}, err *PanicError) interface{}
+
+	// NotFound is called for messages without a handler, by default they
+	// are logged and dropped.
+	NotFound func(session *Session, msg interface{})
+
+	// Version returns the protocol version of a session, it selects among
+	// the handlers registered with RegisterVersionedHandler.
+	Version func(session *Session) byte
+
+	// Pool runs the handlers when set, instead of the receive goroutine of
+	// the session. Messages of one session are still handled in order.
+	Pool *WorkerPool
+
+	// Batch is the most messages HandleSession takes from the read buffer of
+	// the session at once, they are handled in one go or one Pool task.
+	Batch int
+}
+
+var _ Handler = (*Router)(nil)
+
+// MessageHandler is the untyped form of the handlers in a Router.
+type MessageHandler func(ctx context.Context, session *Session, msg interface{})
+
+// Middleware wraps every handler of a Router, for things like auth checks,
+// metrics, logging and rate limits.
+type Middleware func(next MessageHandler) MessageHandler
+
+func NewRouter() *Router {
+	return &Router{
+		handlers: make(map[routeKey]MessageHandler),
+		routes:   make(map[routeKey]MessageHandler),
+		timeouts: make(map[reflect.Type]time.Duration),
+	}
+}
+
+// RegisterHandler registers handler for messages of type T, they may arrive
+// as *T or T. It panics when T already has a handler.
+func RegisterHandler[T any](router *Router, handler func(*Session, *T)) {
+	RegisterHandlerContext(router, func(_ context.Context, session *Session, msg *T) {
+		handler(session, msg)
+	})
+}
+
+// RegisterHandlerContext is RegisterHandler for handlers which watch the
+// context, it is cancelled when the timeout of T expires.
+func RegisterHandlerContext[T any](router *Router, handler func(context.Context, *Session, *T)) {
+	registerHandler(router, routeKey{t: reflect.TypeOf((*T)(nil))}, handler)
+}
+
+// RegisterVersionedHandler registers handler for messages of type T from
+// sessions of the given protocol version, see Router.Version. Sessions of
+// other versions fall back to the handler of RegisterHandler, so old and new
+// clients can be served side by side.
+func RegisterVersionedHandler[T any](router *Router, version byte, handler func(context.Context, *Session, *T)) {
+	registerHandler(router, routeKey{reflect.TypeOf((*T)(nil)), version, true}, handler)
+}
+
+type routeKey struct {
+	t         reflect.Type
+	version   byte
+	versioned bool
+}
+
+func registerHandler[T any](router *Router, key routeKey, handler func(context.Context, *Session, *T)) {
+	if _, exists := router.handlers[key]; exists {
+		if key.versioned {
+			panic(fmt.Sprintf("link: handler of %v version %d registered twice", key.t.Elem(), key.version))
+		}
+		panic(fmt.Sprintf("link: handler of %v registered twice", key.t.Elem()))
+	}
+	router.handlers[key] = func(ctx context.Context, session *Session, msg interface{}) {
+		switch m := msg.(type) {
+		case *T:
+			handler(ctx, session, m)
+		case T:
+			handler(ctx, session, &m)
+		}
+	}
+	router.wrap(key)
+}
+
+// Use appends middleware, the first one is the outermost. It applies to the
+// handlers registered before and after.
+func (router *Router) Use(middleware ...Middleware) {
+	router.middleware = append(router.middleware, middleware...)
+	for key := range router.handlers {
+		router.wrap(key)
+	}
+}
+
+func (router *Router) wrap(key routeKey) {
+	handler := router.handlers[key]
+	for i := len(router.middleware) - 1; i >= 0; i-- {
+		handler = router.middleware[i](handler)
+	}
+	router.routes[key] = handler
+}
+
+// SetTimeout sets the execution time of the handler of T, overriding
+// Router.Timeout. Zero means no limit.
+func SetTimeout[T any](router *Router, timeout time.Duration) {
+	router.timeouts[reflect.TypeOf((*T)(nil))] = timeout
+}
+
+func routeType(msg interface{}) reflect.Type {
+	t := reflect.TypeOf(msg)
+	if t != nil && t.Kind() != reflect.Ptr {
+		t = reflect.PointerTo(t)
+	}
+	return t
+}
+
+// Dispatch calls the handler of msg, NoRouteError when there is none.
+func (router *Router) Dispatch(session *Session, msg interface{}) error {
+	return router.DispatchContext(context.Background(), session, msg)
+}
+
+func (router *Router) DispatchContext(ctx context.Context, session *Session, msg interface{}) error {
+	t := routeType(msg)
+	route, exists := router.routes[routeKey{t: t}]
+	if router.Version != nil {
+		if versioned, ok := router.routes[routeKey{t, router.Version(session), true}]; ok {
+			route, exists = versioned, true
+		}
+	}
+	if !exists {
+		return NoRouteError
+	}
+	timeout, exists := router.timeouts[t]
+	if !exists {
+		timeout = router.Timeout
+	}
+	if router.Panic != PanicPropagate {
+		defer router.recover(session, msg)
+	}
+	if timeout <= 0 {
+		route(ctx, session, msg)
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	stop := context.AfterFunc(ctx, func() {
+		if ctx.Err() == context.DeadlineExceeded {
+			router.timedOut(session, msg, timeout)
+		}
+	})
+	route(ctx, session, msg)
+	stop()
+	return nil
+}
+
+func (router *Router) recover(session *Session, msg interface{}) {
+	value := recover()
+	if value == nil {
+		return
+	}
+	err := &PanicError{value, debug.Stack()}
+	if router.OnPanic != nil {
+		router.OnPanic(session, msg, err)
+	} else {
+		GetLogger().Error("link: handler panic", "session", session.ID(), "type", fmt.Sprintf("%T", msg), "panic", value, "stack", string(err.Stack))
+	}
+	switch router.Panic {
+	case PanicCloseSession:
+		session.Close()
+	case PanicReply:
+		if router.PanicReply != nil {
+			if reply := router.PanicReply(msg, err); reply != nil {
+				session.Send(reply)
+			}
+		}
+	}
+}
+
+func (router *Router) timedOut(session *Session, msg interface{}, timeout time.Duration) {
+	if router.OnTimeout != nil {
+		router.OnTimeout(session, msg, timeout)
+		return
+	}
+	GetLogger().Warn("link: handler timeout", "session", session.ID(), "type", fmt.Sprintf("%T", msg), "timeout", timeout)
+}
+
+func (router *Router) HandleSession(session *Session) {
+	defer session.Close()
+	var pending sync.WaitGroup
+	defer pending.Wait()
+	var buf []interface{}
+	for {
+		msgs, err := session.ReceiveBatch(buf[:0], max(router.Batch, 1))
+		if len(msgs) > 0 && !router.handle(session, msgs, &pending) {
+			return
+		}
+		if err != nil {
+			return
+		}
+		if router.Pool == nil {
+			buf = msgs
+		}
+	}
+}
+
+func (router *Router) handle(session *Session, msgs []interface{}, pending *sync.WaitGroup) bool {
+	if router.Pool == nil {
+		for _, msg := range msgs {
+			router.route(session, msg)
+		}
+		return true
+	}
+	pending.Add(1)
+	err := router.Pool.Submit(session.ID(), func() {
+		defer pending.Done()
+		for _, msg := range msgs {
+			router.route(session, msg)
+		}
+	})
+	if err != nil {
+		pending.Done()
+		return false
+	}
+	return true
+}
+
+func (router *Router) route(session *Session, msg interface{}) {
+	if router.Dispatch(session, msg) == nil {
+		return
+	}
+	if router.NotFound != nil {
+		router.NotFound(session, msg)
+	} else {
+		GetLogger().Warn("link: no handler", "session", session.ID(), "type", fmt.Sprintf("%T", msg))
+	}
+}
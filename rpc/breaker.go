@@ -0,0 +1,200 @@
+package rpc
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/clock"
+)
+
+var BreakerOpenError = link.NewError(link.PolicyError, "RPC Circuit Breaker Open")
+
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+type BreakerConfig struct {
+	Window        time.Duration // statistics are reset every Window while closed
+	MinRequests   int           // no decision is made below this many calls
+	ErrorRate     float64       // open when failed/total reaches this, 0 disables
+	SlowCall      time.Duration // calls slower than this count as slow, 0 disables
+	SlowRate      float64       // open when slow/total reaches this
+	OpenTimeout   time.Duration // how long to fast-fail before probing
+	HalfOpenCalls int           // probe calls allowed and needed to close again
+	Clock         clock.Clock   // nil is clock.Default()
+}
+
+var DefaultBreakerConfig = BreakerConfig{
+	Window:        10 * time.Second,
+	MinRequests:   20,
+	ErrorRate:     0.5,
+	SlowCall:      time.Second,
+	SlowRate:      0.8,
+	OpenTimeout:   5 * time.Second,
+	HalfOpenCalls: 3,
+}
+
+// Breaker is a circuit breaker for one remote endpoint. Errors count as
+// failures, except a context.Canceled from the caller which is not counted
+// at all, a cancelled probe gives its half-open slot back.
+type Breaker struct {
+	config BreakerConfig
+
+	mutex       sync.Mutex
+	state       BreakerState
+	generation  uint64
+	windowStart time.Time
+	openedAt    time.Time
+	total       int
+	failed      int
+	slow        int
+	probes      int
+	succeeded   int
+
+	OnStateChange func(from, to BreakerState)
+}
+
+// NewBreaker panics when SlowCall is set without a SlowRate, which would
+// open the breaker on the first call.
+func NewBreaker(config BreakerConfig) *Breaker {
+	if config.SlowCall > 0 && config.SlowRate <= 0 {
+		panic("rpc: BreakerConfig.SlowCall needs a SlowRate")
+	}
+	if config.HalfOpenCalls <= 0 {
+		config.HalfOpenCalls = 1
+	}
+	config.Clock = clock.Or(config.Clock)
+	return &Breaker{
+		config:      config,
+		windowStart: config.Clock.Now(),
+	}
+}
+
+func (b *Breaker) State() BreakerState {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.state == BreakerOpen && b.config.Clock.Since(b.openedAt) >= b.config.OpenTimeout {
+		return BreakerHalfOpen
+	}
+	return b.state
+}
+
+func (b *Breaker) setState(state BreakerState, now time.Time) func() {
+	from := b.state
+	b.state = state
+	b.generation++
+	b.total, b.failed, b.slow = 0, 0, 0
+	b.probes, b.succeeded = 0, 0
+	b.windowStart = now
+	if state == BreakerOpen {
+		b.openedAt = now
+	}
+	if b.OnStateChange == nil || from == state {
+		return nil
+	}
+	callback := b.OnStateChange
+	return func() { callback(from, state) }
+}
+
+// Allow reports whether a call may go out. The returned generation must be
+// passed to Record when the call finished.
+func (b *Breaker) Allow() (generation uint64, err error) {
+	var notify func()
+	b.mutex.Lock()
+	now := b.config.Clock.Now()
+	switch b.state {
+	case BreakerOpen:
+		if now.Sub(b.openedAt) < b.config.OpenTimeout {
+			err = BreakerOpenError
+			break
+		}
+		notify = b.setState(BreakerHalfOpen, now)
+		fallthrough
+	case BreakerHalfOpen:
+		if b.probes >= b.config.HalfOpenCalls {
+			err = BreakerOpenError
+			break
+		}
+		b.probes++
+	case BreakerClosed:
+		if now.Sub(b.windowStart) >= b.config.Window {
+			b.total, b.failed, b.slow = 0, 0, 0
+			b.windowStart = now
+		}
+	}
+	generation = b.generation
+	b.mutex.Unlock()
+
+	if notify != nil {
+		notify()
+	}
+	return
+}
+
+func (b *Breaker) Record(generation uint64, err error, latency time.Duration) {
+	var notify func()
+	b.mutex.Lock()
+	if generation != b.generation {
+		b.mutex.Unlock()
+		return
+	}
+	if err == context.Canceled {
+		if b.state == BreakerHalfOpen && b.probes > 0 {
+			b.probes--
+		}
+		b.mutex.Unlock()
+		return
+	}
+	now := b.config.Clock.Now()
+	slow := b.config.SlowCall > 0 && latency >= b.config.SlowCall
+	switch b.state {
+	case BreakerHalfOpen:
+		if err != nil || slow {
+			notify = b.setState(BreakerOpen, now)
+			break
+		}
+		b.succeeded++
+		if b.succeeded >= b.config.HalfOpenCalls {
+			notify = b.setState(BreakerClosed, now)
+		}
+	case BreakerClosed:
+		b.total++
+		if err != nil {
+			b.failed++
+		}
+		if slow {
+			b.slow++
+		}
+		if b.total < b.config.MinRequests {
+			break
+		}
+		total := float64(b.total)
+		if (b.config.ErrorRate > 0 && float64(b.failed)/total >= b.config.ErrorRate) ||
+			(b.config.SlowCall > 0 && float64(b.slow)/total >= b.config.SlowRate) {
+			notify = b.setState(BreakerOpen, now)
+		}
+	}
+	b.mutex.Unlock()
+
+	if notify != nil {
+		notify()
+	}
+}
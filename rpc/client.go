@@ -0,0 +1,229 @@
+package rpc
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/funny/link"
+)
+
+var ClientClosedError = link.NewError(link.TransportError, "RPC Client Closed")
+
+type RemoteError struct {
+	Message string
+}
+
+func (e *RemoteError) Error() string {
+	return e.Message
+}
+
+// rejections of the server, they arrive as a RemoteError with the same text.
+var remotePolicyErrors = []error{OverloadedError, BusyError, NoStreamHandlerError}
+
+// Is reports a failed handler as link.HandlerError. Rejections by the remote
+// server match the rejection and link.PolicyError instead.
+func (e *RemoteError) Is(target error) bool {
+	for _, policy := range remotePolicyErrors {
+		if e.Message == policy.Error() {
+			return target == policy || target == link.PolicyError
+		}
+	}
+	return target == link.HandlerError
+}
+
+type Client struct {
+	session *link.Session
+	mutex   sync.Mutex
+	nextID  uint64
+	pending map[uint64]*Future
+	streams *streamTable
+	breaker *Breaker
+	tracer  Tracer
+	err     error
+}
+
+func NewClient(session *link.Session) *Client {
+	client := &Client{
+		session: session,
+		pending: make(map[uint64]*Future),
+		streams: newStreamTable(),
+	}
+	go client.receiveLoop()
+	return client
+}
+
+func (client *Client) Session() *link.Session {
+	return client.session
+}
+
+// SetTracer creates a span for every call and propagates its context to
+// the server. It must be set before any call is made.
+func (client *Client) SetTracer(tracer Tracer) {
+	client.tracer = tracer
+}
+
+// SetBreaker puts a circuit breaker in front of the calls of this client.
+// It must be set before any call is made.
+func (client *Client) SetBreaker(breaker *Breaker) {
+	client.breaker = breaker
+}
+
+func (client *Client) receiveLoop() {
+	var err error
+	for {
+		var msg interface{}
+		msg, err = client.session.Receive()
+		if err != nil {
+			break
+		}
+		packet, ok := msg.(*Packet)
+		if !ok {
+			continue
+		}
+		switch packet.Kind {
+		case KindStreamData, KindStreamClose, KindStreamWindow:
+			client.streams.dispatch(packet)
+			continue
+		case KindResponse:
+		default:
+			continue
+		}
+		client.mutex.Lock()
+		f, exists := client.pending[packet.ID]
+		delete(client.pending, packet.ID)
+		client.mutex.Unlock()
+		if !exists {
+			continue
+		}
+		if packet.Error != "" {
+			f.complete(nil, &RemoteError{packet.Error})
+		} else {
+			f.complete(packet.Body, nil)
+		}
+	}
+
+	client.mutex.Lock()
+	client.err = err
+	pending := client.pending
+	client.pending = make(map[uint64]*Future)
+	client.mutex.Unlock()
+
+	for _, f := range pending {
+		f.complete(nil, err)
+	}
+	client.streams.abortAll(err)
+}
+
+func (client *Client) register(f *Future) error {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	if client.err != nil {
+		return client.err
+	}
+	client.nextID++
+	f.id = client.nextID
+	client.pending[f.id] = f
+	return nil
+}
+
+func (client *Client) unregister(id uint64) bool {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	_, exists := client.pending[id]
+	delete(client.pending, id)
+	return exists
+}
+
+func (client *Client) send(ctx context.Context, req interface{}) *Future {
+	f := newFuture(client)
+	if client.breaker != nil {
+		generation, err := client.breaker.Allow()
+		if err != nil {
+			f.complete(nil, err)
+			return f
+		}
+		clk := client.breaker.config.Clock
+		start := clk.Now()
+		f.finishers = append(f.finishers, func(_ interface{}, err error) {
+			client.breaker.Record(generation, err, clk.Since(start))
+		})
+	}
+	packet := &Packet{Kind: KindRequest, Key: KeyFromContext(ctx), Extensions: ExtensionsFromContext(ctx), Body: req}
+	packet.Deadline, _ = ctx.Deadline()
+	if client.tracer != nil {
+		spanCtx, span := client.tracer.Start(ctx, "rpc.call", time.Now(), messageType(req))
+		packet.Trace = client.tracer.Inject(spanCtx)
+		f.finishers = append(f.finishers, func(_ interface{}, err error) {
+			endSpan(span, err)
+		})
+	}
+	if err := client.register(f); err != nil {
+		f.complete(nil, err)
+		return f
+	}
+	packet.ID = f.id
+	if err := client.session.Send(packet); err != nil {
+		client.unregister(f.id)
+		f.complete(nil, err)
+	}
+	return f
+}
+
+func (client *Client) CallAsync(req interface{}) *Future {
+	return client.send(context.Background(), req)
+}
+
+// Call sends req and waits for the reply. When ctx carries an idempotency
+// key (see WithKey) retries of the same call are answered from the server's
+// replay cache instead of being processed again.
+func (client *Client) Call(ctx context.Context, req interface{}) (interface{}, error) {
+	return client.send(ctx, req).Wait(ctx)
+}
+
+// Notify sends a oneway request. The server handles it like any other request
+// but never replies, so nothing is tracked on the client side.
+func (client *Client) Notify(req interface{}) error {
+	client.mutex.Lock()
+	err := client.err
+	client.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	return client.session.Send(&Packet{Kind: KindRequest, Oneway: true, Body: req})
+}
+
+func (client *Client) nextStreamID() (uint64, error) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	if client.err != nil {
+		return 0, client.err
+	}
+	client.nextID++
+	return client.nextID, nil
+}
+
+// OpenStream starts a streaming call. req is delivered to the server side
+// StreamHandler, after that both sides can send messages on the stream.
+func (client *Client) OpenStream(ctx context.Context, req interface{}) (*Stream, error) {
+	id, err := client.nextStreamID()
+	if err != nil {
+		return nil, err
+	}
+	stream := newStream(ctx, id, client.session, client.streams, func() {
+		client.session.Send(&Packet{Kind: KindCancel, ID: id})
+	})
+	packet := &Packet{Kind: KindStreamOpen, ID: id, Body: req}
+	if deadline, ok := ctx.Deadline(); ok {
+		packet.Deadline = deadline
+	}
+	if err := client.session.Send(packet); err != nil {
+		stream.abort(err)
+		return nil, err
+	}
+	return stream, nil
+}
+
+func (client *Client) Close() error {
+	return client.session.Close()
+}
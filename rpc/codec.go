@@ -0,0 +1,346 @@
+package rpc
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"time"
+
+	"github.com/funny/link"
+)
+
+const (
+	KindRequest byte = iota + 1
+	KindResponse
+	KindCancel
+	KindStreamOpen
+	KindStreamData
+	KindStreamClose
+	KindStreamWindow
+)
+
+const (
+	flagError byte = 1 << iota
+	flagNoBody
+	flagDeadline
+	flagWindow
+	flagOneway
+	flagKey
+	flagExt
+)
+
+const (
+	headSize    = 10
+	maxKeySize  = 255
+	maxHeadSize = headSize + 8 + 4 + (1 + maxKeySize)
+)
+
+var KeyTooLongError = link.NewError(link.ProtocolError, "RPC Key Too Long")
+var TraceTooLongError = link.NewError(link.ProtocolError, "RPC Trace Context Too Long")
+var ExtensionsTooLongError = link.NewError(link.ProtocolError, "RPC Extensions Too Long")
+var NotPacketError = link.NewError(link.ProtocolError, "RPC Message Is Not A Packet")
+var BadExtensionsError = link.NewError(link.ProtocolError, "RPC Bad Extensions")
+
+// Extension is an entry of the extensions block of a packet header, which
+// holds type, length, value entries after a flag bit. New features add a
+// Type instead of a flag, decoders keep the types they don't know in
+// Packet.Extensions and go on. Types from 128 on are for applications.
+type Extension struct {
+	Type  byte
+	Value []byte
+}
+
+// ExtTrace carries Packet.Trace, decoders move it out of Packet.Extensions.
+const ExtTrace byte = 1
+
+const maxExtensionsSize = 0xFFFF
+
+type Packet struct {
+	Kind     byte
+	ID       uint64
+	Deadline time.Time
+	Window   uint32
+	Oneway   bool
+	Key      string
+	Trace    string // propagated trace context as ExtTrace, see Tracer
+	Error    string
+
+	Extensions []Extension
+	Body       interface{}
+
+	decodeStart time.Time
+	decodeEnd   time.Time
+}
+
+type protocol struct {
+	base link.Protocol
+}
+
+// Protocol wraps base with the RPC packet header. The returned protocol
+// expects one packet per read, so it must sit inside a packet protocol
+// such as codec.FixLen or codec.Packet.
+func Protocol(base link.Protocol) link.Protocol {
+	return &protocol{base}
+}
+
+func (p *protocol) NewCodec(rw io.ReadWriter) (cc link.Codec, err error) {
+	codec := &rpcCodec{rw: rw}
+	codec.base, err = p.base.NewCodec(rw)
+	if err != nil {
+		return
+	}
+	cc = codec
+	return
+}
+
+type rpcCodec struct {
+	base     link.Codec
+	rw       io.ReadWriter
+	recvHead [maxHeadSize]byte
+	sendHead [maxHeadSize]byte
+}
+
+func (c *rpcCodec) Receive() (interface{}, error) {
+	head := c.recvHead[:headSize]
+	if _, err := io.ReadFull(c.rw, head); err != nil {
+		return nil, err
+	}
+	packet := &Packet{
+		Kind:        head[0],
+		ID:          binary.LittleEndian.Uint64(head[2:]),
+		decodeStart: time.Now(),
+	}
+	flags := head[1]
+	packet.Oneway = flags&flagOneway != 0
+	var err error
+
+	if flags&flagDeadline != 0 {
+		ext := c.recvHead[headSize : headSize+8]
+		if _, err := io.ReadFull(c.rw, ext); err != nil {
+			return nil, err
+		}
+		packet.Deadline = time.Unix(0, int64(binary.LittleEndian.Uint64(ext)))
+	}
+	if flags&flagWindow != 0 {
+		ext := c.recvHead[headSize : headSize+4]
+		if _, err := io.ReadFull(c.rw, ext); err != nil {
+			return nil, err
+		}
+		packet.Window = binary.LittleEndian.Uint32(ext)
+	}
+	if flags&flagKey != 0 {
+		if packet.Key, err = c.readShort(); err != nil {
+			return nil, err
+		}
+	}
+	if flags&flagExt != 0 {
+		if packet.Extensions, err = c.readExtensions(); err != nil {
+			return nil, err
+		}
+		if trace, ok := packet.Extension(ExtTrace); ok {
+			packet.Trace = string(trace)
+			packet.removeExtension(ExtTrace)
+		}
+	}
+
+	if flags&flagNoBody != 0 {
+		return packet, nil
+	}
+	if flags&flagError != 0 {
+		b, err := io.ReadAll(c.rw)
+		if err != nil {
+			return nil, err
+		}
+		packet.Error = string(b)
+		return packet, nil
+	}
+
+	body, err := c.base.Receive()
+	if err != nil {
+		return nil, err
+	}
+	packet.Body = body
+	packet.decodeEnd = time.Now()
+	return packet, nil
+}
+
+// readExtensions reads the block of a 2 byte size and the entries, each a
+// type, a 1 byte length and the value.
+func (c *rpcCodec) readExtensions() ([]Extension, error) {
+	n := c.recvHead[headSize : headSize+2]
+	if _, err := io.ReadFull(c.rw, n); err != nil {
+		return nil, err
+	}
+	block := make([]byte, binary.LittleEndian.Uint16(n))
+	if _, err := io.ReadFull(c.rw, block); err != nil {
+		return nil, err
+	}
+	var extensions []Extension
+	for len(block) > 0 {
+		if len(block) < 2 || len(block) < 2+int(block[1]) {
+			return nil, BadExtensionsError
+		}
+		size := int(block[1])
+		extensions = append(extensions, Extension{block[0], block[2 : 2+size : 2+size]})
+		block = block[2+size:]
+	}
+	return extensions, nil
+}
+
+func appendExtensions(head []byte, extensions []Extension) ([]byte, error) {
+	size := 0
+	for _, ext := range extensions {
+		if len(ext.Value) > 0xFF {
+			return nil, ExtensionsTooLongError
+		}
+		size += 2 + len(ext.Value)
+	}
+	if size > maxExtensionsSize {
+		return nil, ExtensionsTooLongError
+	}
+	head = binary.LittleEndian.AppendUint16(head, uint16(size))
+	for _, ext := range extensions {
+		head = append(head, ext.Type, byte(len(ext.Value)))
+		head = append(head, ext.Value...)
+	}
+	return head, nil
+}
+
+// Extension returns the value of the first extension of type t.
+func (packet *Packet) Extension(t byte) ([]byte, bool) {
+	for _, ext := range packet.Extensions {
+		if ext.Type == t {
+			return ext.Value, true
+		}
+	}
+	return nil, false
+}
+
+// SetExtension replaces the extensions of type t with value.
+func (packet *Packet) SetExtension(t byte, value []byte) {
+	packet.removeExtension(t)
+	packet.Extensions = append(packet.Extensions, Extension{t, value})
+}
+
+// removeExtension drops the extensions of type t, into a new slice so the
+// one of a context isn't touched.
+func (packet *Packet) removeExtension(t byte) {
+	extensions := packet.Extensions[:0:0]
+	for _, ext := range packet.Extensions {
+		if ext.Type != t {
+			extensions = append(extensions, ext)
+		}
+	}
+	packet.Extensions = extensions
+}
+
+type extensionsContext struct{}
+
+// WithExtension attaches an extension to calls made with the returned
+// context, the server puts the extensions of a request into the context of
+// its handler. The context keeps them, so calls made by handlers with that
+// context carry them on.
+func WithExtension(ctx context.Context, t byte, value []byte) context.Context {
+	packet := &Packet{Extensions: ExtensionsFromContext(ctx)}
+	packet.SetExtension(t, value)
+	return context.WithValue(ctx, extensionsContext{}, packet.Extensions)
+}
+
+func ExtensionsFromContext(ctx context.Context) []Extension {
+	extensions, _ := ctx.Value(extensionsContext{}).([]Extension)
+	return extensions
+}
+
+// ExtensionFromContext returns the value of the extension of type t.
+func ExtensionFromContext(ctx context.Context, t byte) ([]byte, bool) {
+	packet := &Packet{Extensions: ExtensionsFromContext(ctx)}
+	return packet.Extension(t)
+}
+
+func (c *rpcCodec) readShort() (string, error) {
+	n := c.recvHead[headSize : headSize+1]
+	if _, err := io.ReadFull(c.rw, n); err != nil {
+		return "", err
+	}
+	b := c.recvHead[headSize+1 : headSize+1+int(n[0])]
+	if _, err := io.ReadFull(c.rw, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func (c *rpcCodec) Send(msg interface{}) error {
+	if encoded, ok := msg.(link.Encoded); ok {
+		_, err := c.rw.Write(encoded)
+		return err
+	}
+	packet, ok := msg.(*Packet)
+	if !ok {
+		return NotPacketError
+	}
+	if len(packet.Key) > maxKeySize {
+		return KeyTooLongError
+	}
+	if len(packet.Trace) > maxKeySize {
+		return TraceTooLongError
+	}
+
+	var flags byte
+	switch {
+	case packet.Error != "":
+		flags |= flagError
+	case packet.Body == nil:
+		flags |= flagNoBody
+	}
+
+	if packet.Oneway {
+		flags |= flagOneway
+	}
+
+	head := c.sendHead[:headSize]
+	if !packet.Deadline.IsZero() {
+		flags |= flagDeadline
+		head = binary.LittleEndian.AppendUint64(head, uint64(packet.Deadline.UnixNano()))
+	}
+	if packet.Window != 0 {
+		flags |= flagWindow
+		head = binary.LittleEndian.AppendUint32(head, packet.Window)
+	}
+	if packet.Key != "" {
+		flags |= flagKey
+		head = append(head, byte(len(packet.Key)))
+		head = append(head, packet.Key...)
+	}
+	extensions := packet.Extensions
+	if packet.Trace != "" {
+		traced := &Packet{Extensions: extensions}
+		traced.SetExtension(ExtTrace, []byte(packet.Trace))
+		extensions = traced.Extensions
+	}
+	if len(extensions) > 0 {
+		flags |= flagExt
+		var err error
+		if head, err = appendExtensions(head, extensions); err != nil {
+			return err
+		}
+	}
+	head[0] = packet.Kind
+	head[1] = flags
+	binary.LittleEndian.PutUint64(head[2:], packet.ID)
+	if _, err := c.rw.Write(head); err != nil {
+		return err
+	}
+
+	switch {
+	case flags&flagNoBody != 0:
+		return nil
+	case flags&flagError != 0:
+		_, err := io.WriteString(c.rw, packet.Error)
+		return err
+	}
+	return c.base.Send(packet.Body)
+}
+
+func (c *rpcCodec) Close() error {
+	return c.base.Close()
+}
@@ -0,0 +1,93 @@
+package rpc
+
+import (
+	"context"
+	"sync"
+)
+
+type Future struct {
+	client    *Client
+	id        uint64
+	mutex     sync.Mutex
+	done      chan struct{}
+	completed bool
+	reply     interface{}
+	err       error
+	callbacks []func(interface{}, error)
+	finishers []func(interface{}, error) // run before the waiters wake up
+}
+
+func newFuture(client *Client) *Future {
+	return &Future{
+		client: client,
+		done:   make(chan struct{}),
+	}
+}
+
+func (f *Future) complete(reply interface{}, err error) bool {
+	f.mutex.Lock()
+	if f.completed {
+		f.mutex.Unlock()
+		return false
+	}
+	f.completed = true
+	f.reply, f.err = reply, err
+	for _, finish := range f.finishers {
+		finish(reply, err)
+	}
+	callbacks := f.callbacks
+	f.callbacks = nil
+	close(f.done)
+	f.mutex.Unlock()
+
+	for _, callback := range callbacks {
+		callback(reply, err)
+	}
+	return true
+}
+
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Result blocks until the call finished.
+func (f *Future) Result() (interface{}, error) {
+	<-f.done
+	return f.reply, f.err
+}
+
+// Wait blocks until the call finished or ctx is done. In the latter case
+// the call is cancelled.
+func (f *Future) Wait(ctx context.Context) (interface{}, error) {
+	select {
+	case <-f.done:
+		return f.reply, f.err
+	case <-ctx.Done():
+		f.cancel(ctx.Err())
+		return f.Result()
+	}
+}
+
+// Then registers a callback which is invoked once the call finished. If the
+// call has already finished the callback is invoked immediately.
+func (f *Future) Then(callback func(reply interface{}, err error)) {
+	f.mutex.Lock()
+	if !f.completed {
+		f.callbacks = append(f.callbacks, callback)
+		f.mutex.Unlock()
+		return
+	}
+	f.mutex.Unlock()
+	callback(f.reply, f.err)
+}
+
+func (f *Future) Cancel() {
+	f.cancel(context.Canceled)
+}
+
+func (f *Future) cancel(err error) {
+	if f.client.unregister(f.id) {
+		f.complete(nil, err)
+		f.client.session.Send(&Packet{Kind: KindCancel, ID: f.id})
+	}
+}
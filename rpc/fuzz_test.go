@@ -0,0 +1,21 @@
+package rpc
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/funny/link/codec"
+)
+
+func FuzzProtocol(f *testing.F) {
+	var stream bytes.Buffer
+	c, _ := testProtocol().NewCodec(&stream)
+	c.Send(&Packet{Kind: KindRequest, ID: 1, Key: "key", Trace: "trace", Deadline: time.Unix(1, 0), Extensions: []Extension{{200, []byte("ext")}}, Body: &AddReq{1, 2}})
+	c.Send(&Packet{Kind: KindResponse, ID: 1, Error: "error"})
+	c.Send(&Packet{Kind: KindStreamWindow, ID: 2, Window: 32})
+	f.Add(stream.Bytes())
+	f.Fuzz(func(t *testing.T, data []byte) {
+		codec.FuzzDecode(testProtocol(), data)
+	})
+}
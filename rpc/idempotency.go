@@ -0,0 +1,92 @@
+package rpc
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/funny/link/clock"
+)
+
+type keyContext struct{}
+
+// WithKey attaches an idempotency key to calls made with the returned context.
+func WithKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, keyContext{}, key)
+}
+
+func KeyFromContext(ctx context.Context) string {
+	key, _ := ctx.Value(keyContext{}).(string)
+	return key
+}
+
+type replayEntry struct {
+	done      chan struct{}
+	body      interface{}
+	err       string
+	expire    time.Time
+	abandoned bool
+}
+
+// replayCache remembers the responses of keyed requests for a window, so a
+// retry after reconnect gets the first result instead of running twice.
+type replayCache struct {
+	mutex     sync.Mutex
+	window    time.Duration
+	clock     clock.Clock
+	entries   map[string]*replayEntry
+	nextSweep time.Time
+}
+
+func newReplayCache(window time.Duration, clk clock.Clock) *replayCache {
+	return &replayCache{
+		window:  window,
+		clock:   clock.Or(clk),
+		entries: make(map[string]*replayEntry),
+	}
+}
+
+// begin returns the entry of key, owner is true when the caller should run
+// the request and finish the entry.
+func (c *replayCache) begin(key string) (entry *replayEntry, owner bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := c.clock.Now()
+	if now.After(c.nextSweep) {
+		for k, e := range c.entries {
+			if !e.expire.IsZero() && now.After(e.expire) {
+				delete(c.entries, k)
+			}
+		}
+		c.nextSweep = now.Add(c.window)
+	}
+
+	if entry, exists := c.entries[key]; exists {
+		if entry.expire.IsZero() || now.Before(entry.expire) {
+			return entry, false
+		}
+	}
+	entry = &replayEntry{done: make(chan struct{})}
+	c.entries[key] = entry
+	return entry, true
+}
+
+func (c *replayCache) finish(entry *replayEntry, body interface{}, err string) {
+	c.mutex.Lock()
+	entry.body, entry.err = body, err
+	entry.expire = c.clock.Now().Add(c.window)
+	c.mutex.Unlock()
+	close(entry.done)
+}
+
+// abandon drops an unfinished entry so the next retry runs the request.
+func (c *replayCache) abandon(key string, entry *replayEntry) {
+	c.mutex.Lock()
+	if c.entries[key] == entry {
+		delete(c.entries, key)
+	}
+	entry.abandoned = true
+	c.mutex.Unlock()
+	close(entry.done)
+}
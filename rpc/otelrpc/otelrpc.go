@@ -0,0 +1,81 @@
+// Package otelrpc is the OpenTelemetry rpc.Tracer, pass it to the SetTracer
+// of rpc.Client and rpc.Server.
+package otelrpc
+
+import (
+	"context"
+	"net/url"
+	"time"
+
+	"github.com/funny/link/rpc"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maxTrace is the longest trace context an rpc.ExtTrace extension holds.
+const maxTrace = 0xFF
+
+type Tracer struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+// New makes a Tracer of the spans of tracer, nil is the tracer "link/rpc"
+// of the global provider. The trace context travels in the W3C Trace
+// Context format, contexts longer than the extension allows aren't sent.
+func New(tracer trace.Tracer) *Tracer {
+	if tracer == nil {
+		tracer = otel.Tracer("link/rpc")
+	}
+	return &Tracer{tracer, propagation.TraceContext{}}
+}
+
+func (t *Tracer) Start(ctx context.Context, name string, start time.Time, attrs ...rpc.Attr) (context.Context, rpc.Span) {
+	kvs := make([]attribute.KeyValue, len(attrs))
+	for i, attr := range attrs {
+		kvs[i] = attribute.String(attr.Key, attr.Value)
+	}
+	ctx, s := t.tracer.Start(ctx, name, trace.WithTimestamp(start), trace.WithAttributes(kvs...))
+	return ctx, span{s}
+}
+
+func (t *Tracer) Inject(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	t.propagator.Inject(ctx, carrier)
+	values := url.Values{}
+	for key, value := range carrier {
+		values.Set(key, value)
+	}
+	if encoded := values.Encode(); len(encoded) <= maxTrace {
+		return encoded
+	}
+	return ""
+}
+
+func (t *Tracer) Extract(ctx context.Context, encoded string) context.Context {
+	values, err := url.ParseQuery(encoded)
+	if err != nil {
+		return ctx
+	}
+	carrier := propagation.MapCarrier{}
+	for key := range values {
+		carrier.Set(key, values.Get(key))
+	}
+	return t.propagator.Extract(ctx, carrier)
+}
+
+type span struct {
+	span trace.Span
+}
+
+func (s span) SetError(err error) {
+	s.span.RecordError(err)
+	s.span.SetStatus(codes.Error, err.Error())
+}
+
+func (s span) End(end time.Time) {
+	s.span.End(trace.WithTimestamp(end))
+}
@@ -0,0 +1,39 @@
+package otelrpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/funny/link/rpc"
+	"github.com/funny/utest"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func Test_Tracer(t *testing.T) {
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1, 2, 3},
+		SpanID:     trace.SpanID{4, 5, 6},
+		TraceFlags: trace.FlagsSampled,
+	})
+	tracer := New(noop.NewTracerProvider().Tracer("test"))
+
+	encoded := tracer.Inject(trace.ContextWithSpanContext(context.Background(), sc))
+	utest.Assert(t, encoded != "" && len(encoded) <= maxTrace)
+	ctx := tracer.Extract(context.Background(), encoded)
+	got := trace.SpanContextFromContext(ctx)
+	utest.EqualNow(t, got.TraceID(), sc.TraceID())
+	utest.EqualNow(t, got.SpanID(), sc.SpanID())
+	utest.Assert(t, got.IsRemote())
+
+	// the spans of the server join the trace of the caller.
+	ctx, span := tracer.Start(ctx, "rpc.serve", time.Now(), rpc.Attr{Key: "rpc.message_type", Value: "*Req"})
+	span.SetError(errors.New("failed"))
+	span.End(time.Now())
+	utest.EqualNow(t, trace.SpanContextFromContext(ctx).TraceID(), sc.TraceID())
+
+	ctx = tracer.Extract(context.Background(), "%zz")
+	utest.Assert(t, !trace.SpanContextFromContext(ctx).IsValid())
+}
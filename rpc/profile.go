@@ -0,0 +1,31 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"runtime/pprof"
+
+	"github.com/funny/link"
+)
+
+type profiledHandler struct {
+	base    Handler
+	buckets int
+}
+
+// Profiled runs every request of base with the pprof labels "message", the
+// request type, and "session", the bucket of the session ID.
+func Profiled(base Handler, buckets int) Handler {
+	return &profiledHandler{base, buckets}
+}
+
+func (h *profiledHandler) ServeRPC(ctx context.Context, session *link.Session, req interface{}) (rsp interface{}, err error) {
+	labels := pprof.Labels(
+		"message", fmt.Sprintf("%T", req),
+		"session", link.SessionBucket(session.ID(), h.buckets),
+	)
+	pprof.Do(ctx, labels, func(ctx context.Context) {
+		rsp, err = h.base.ServeRPC(ctx, session, req)
+	})
+	return
+}
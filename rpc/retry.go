@@ -0,0 +1,210 @@
+package rpc
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/funny/link/clock"
+)
+
+type RetryPolicy struct {
+	MaxAttempts int           // including the first one
+	Backoff     time.Duration // base of the exponential backoff
+	MaxBackoff  time.Duration
+	// Calls without a key, see WithKey, are neither retried nor hedged
+	// unless RetryUnkeyed is set, a server without a replay window may run
+	// them twice.
+	RetryUnkeyed bool
+	Budget       *RetryBudget
+	// HedgeDelay enables hedging: if an attempt has no reply after this long
+	// the same request is sent to the next client, the first reply wins.
+	HedgeDelay time.Duration
+	// Retryable decides if a failed attempt may be retried. By default
+	// remote errors and context errors are not retried.
+	Retryable func(err error) bool
+	// Clock runs the backoff and hedge timers, nil is clock.Default().
+	Clock clock.Clock
+}
+
+func defaultRetryable(err error) bool {
+	switch err.(type) {
+	case *RemoteError:
+		return false
+	}
+	return err != context.Canceled && err != context.DeadlineExceeded
+}
+
+// RetryBudget limits retries to a ratio of the calls, so retries can't
+// multiply the load on a backend that is already struggling.
+type RetryBudget struct {
+	mutex  sync.Mutex
+	ratio  float64
+	max    float64
+	tokens float64
+}
+
+func NewRetryBudget(ratio float64, max int) *RetryBudget {
+	return &RetryBudget{
+		ratio:  ratio,
+		max:    float64(max),
+		tokens: float64(max),
+	}
+}
+
+func (b *RetryBudget) deposit() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.tokens += b.ratio
+	if b.tokens > b.max {
+		b.tokens = b.max
+	}
+}
+
+func (b *RetryBudget) withdraw() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// RetryClient calls a group of clients connected to equivalent backends.
+// Attempt n goes to client n modulo the number of clients.
+type RetryClient struct {
+	clients []*Client
+	policy  RetryPolicy
+}
+
+// NewRetryClient panics without clients, every call would fail.
+func NewRetryClient(policy RetryPolicy, clients ...*Client) *RetryClient {
+	if len(clients) == 0 {
+		panic("rpc: NewRetryClient needs a client")
+	}
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+	if policy.Retryable == nil {
+		policy.Retryable = defaultRetryable
+	}
+	policy.Clock = clock.Or(policy.Clock)
+	return &RetryClient{
+		clients: clients,
+		policy:  policy,
+	}
+}
+
+// defaultMaxBackoff caps the backoff when the policy has no MaxBackoff.
+const defaultMaxBackoff = 30 * time.Second
+
+func (rc *RetryClient) backoff(attempt int) time.Duration {
+	if rc.policy.Backoff <= 0 {
+		return 0
+	}
+	limit := rc.policy.MaxBackoff
+	if limit <= 0 {
+		limit = max(defaultMaxBackoff, rc.policy.Backoff)
+	}
+	// doubling stops at the limit, so large attempts can't overflow.
+	d := min(rc.policy.Backoff, limit)
+	for i := 1; i < attempt; i++ {
+		if d > limit/2 {
+			d = limit
+			break
+		}
+		d *= 2
+	}
+	return time.Duration(rand.Int63n(int64(d)) + 1)
+}
+
+func (rc *RetryClient) Call(ctx context.Context, req interface{}) (interface{}, error) {
+	repeatable := rc.policy.RetryUnkeyed || KeyFromContext(ctx) != ""
+	if rc.policy.Budget != nil {
+		rc.policy.Budget.deposit()
+	}
+
+	var lastErr error
+	for attempt := 0; attempt < rc.policy.MaxAttempts; attempt++ {
+		if attempt > 0 {
+			if !repeatable || !rc.policy.Retryable(lastErr) {
+				return nil, lastErr
+			}
+			if rc.policy.Budget != nil && !rc.policy.Budget.withdraw() {
+				return nil, lastErr
+			}
+			if d := rc.backoff(attempt); d > 0 {
+				timer := rc.policy.Clock.NewTimer(d)
+				select {
+				case <-timer.C():
+				case <-ctx.Done():
+					timer.Stop()
+					return nil, ctx.Err()
+				}
+			}
+		}
+		reply, err := rc.attempt(ctx, req, attempt, repeatable)
+		if err == nil {
+			return reply, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+func (rc *RetryClient) attempt(ctx context.Context, req interface{}, n int, hedge bool) (interface{}, error) {
+	primary := rc.clients[n%len(rc.clients)]
+	if !hedge || rc.policy.HedgeDelay <= 0 || len(rc.clients) < 2 {
+		return primary.send(ctx, req).Wait(ctx)
+	}
+
+	first := primary.send(ctx, req)
+	timer := rc.policy.Clock.NewTimer(rc.policy.HedgeDelay)
+	defer timer.Stop()
+	select {
+	case <-first.Done():
+		return first.Result()
+	case <-ctx.Done():
+		first.cancel(ctx.Err())
+		return first.Result()
+	case <-timer.C():
+	}
+
+	second := rc.clients[(n+1)%len(rc.clients)].send(ctx, req)
+	futures := []*Future{first, second}
+	var lastErr error
+	for len(futures) > 0 {
+		select {
+		case <-futures[0].Done():
+		case <-futures[len(futures)-1].Done():
+		case <-ctx.Done():
+			for _, f := range futures {
+				f.cancel(ctx.Err())
+			}
+			return nil, ctx.Err()
+		}
+		for i := 0; i < len(futures); i++ {
+			f := futures[i]
+			select {
+			case <-f.Done():
+			default:
+				continue
+			}
+			reply, err := f.Result()
+			if err == nil {
+				for _, other := range futures {
+					if other != f {
+						other.Cancel()
+					}
+				}
+				return reply, nil
+			}
+			lastErr = err
+			futures = append(futures[:i], futures[i+1:]...)
+			i--
+		}
+	}
+	return nil, lastErr
+}
@@ -0,0 +1,690 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"runtime/pprof"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/clock"
+	"github.com/funny/link/codec"
+	"github.com/funny/utest"
+)
+
+type AddReq struct {
+	A, B int
+}
+
+type AddRsp struct {
+	C int
+}
+
+func testProtocol() link.Protocol {
+	json := codec.Json()
+	json.Register(AddReq{})
+	json.Register(AddRsp{})
+	return codec.FixLen(Protocol(json), 4, binary.LittleEndian, 1024*1024, 1024*1024)
+}
+
+func addHandler(ctx context.Context, session *link.Session, req interface{}) (interface{}, error) {
+	add := req.(*AddReq)
+	if add.A < 0 {
+		return nil, errors.New("negative")
+	}
+	return &AddRsp{add.A + add.B}, nil
+}
+
+func newTestClient(t *testing.T, handler Handler) *Client {
+	return newTestStreamClient(t, handler, nil)
+}
+
+func newTestStreamClient(t *testing.T, handler Handler, streamHandler StreamHandler) *Client {
+	server := NewServer(handler)
+	server.SetStreamHandler(streamHandler)
+	return newTestServerClient(t, server)
+}
+
+func newTestServerClient(t *testing.T, server *Server) *Client {
+	clientSession, serverSession, err := link.Pipe(testProtocol(), 0)
+	utest.IsNilNow(t, err)
+	go server.HandleSession(serverSession)
+	return NewClient(clientSession)
+}
+
+func Test_Call(t *testing.T) {
+	client := newTestClient(t, HandlerFunc(addHandler))
+	defer client.Close()
+
+	var wait sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wait.Add(1)
+		go func(i int) {
+			defer wait.Done()
+			rsp, err := client.Call(context.Background(), &AddReq{i, i})
+			utest.IsNilNow(t, err)
+			utest.EqualNow(t, rsp.(*AddRsp).C, i+i)
+		}(i)
+	}
+	wait.Wait()
+
+	_, err := client.Call(context.Background(), &AddReq{-1, 0})
+	utest.NotNilNow(t, err)
+	utest.EqualNow(t, err.Error(), "negative")
+}
+
+func Test_Cancel(t *testing.T) {
+	started := make(chan struct{})
+	canceled := make(chan struct{})
+	var served int32
+	client := newTestClient(t, HandlerFunc(func(ctx context.Context, session *link.Session, req interface{}) (interface{}, error) {
+		if req.(*AddReq).A == 0 {
+			close(started)
+			<-ctx.Done()
+			close(canceled)
+			return nil, ctx.Err()
+		}
+		served++
+		return addHandler(ctx, session, req)
+	}))
+	defer client.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+	_, err := client.Call(ctx, &AddReq{0, 0})
+	utest.EqualNow(t, err, context.Canceled)
+
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("server side context not cancelled")
+	}
+
+	// an already expired request is skipped by the server.
+	ctx, cancel = context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	_, err = client.Call(ctx, &AddReq{1, 1})
+	utest.EqualNow(t, err, context.DeadlineExceeded)
+
+	rsp, err := client.Call(context.Background(), &AddReq{2, 2})
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, rsp.(*AddRsp).C, 4)
+	utest.EqualNow(t, served, int32(1))
+}
+
+func Test_CallAsync(t *testing.T) {
+	client := newTestClient(t, HandlerFunc(addHandler))
+	defer client.Close()
+
+	futures := make([]*Future, 20)
+	results := make(chan int, 20)
+	for i := range futures {
+		futures[i] = client.CallAsync(&AddReq{i, 1})
+		futures[i].Then(func(reply interface{}, err error) {
+			utest.IsNilNow(t, err)
+			results <- reply.(*AddRsp).C
+		})
+	}
+	for i, f := range futures {
+		<-f.Done()
+		rsp, err := f.Result()
+		utest.IsNilNow(t, err)
+		utest.EqualNow(t, rsp.(*AddRsp).C, i+1)
+	}
+	sum := 0
+	for range futures {
+		sum += <-results
+	}
+	utest.EqualNow(t, sum, 20*21/2)
+}
+
+func Test_Stream(t *testing.T) {
+	// echo every AddReq back as AddRsp, then reply n extra messages on close.
+	handler := StreamHandlerFunc(func(stream *Stream, req interface{}) error {
+		n := req.(*AddReq).A
+		if n < 0 {
+			return errors.New("negative")
+		}
+		for {
+			msg, err := stream.Recv()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			add := msg.(*AddReq)
+			if err := stream.Send(&AddRsp{add.A + add.B}); err != nil {
+				return err
+			}
+		}
+		for i := 0; i < n; i++ {
+			if err := stream.Send(&AddRsp{i}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	client := newTestStreamClient(t, HandlerFunc(addHandler), handler)
+	defer client.Close()
+
+	stream, err := client.OpenStream(context.Background(), &AddReq{StreamWindow * 4, 0})
+	utest.IsNilNow(t, err)
+	for i := 0; i < 10; i++ {
+		utest.IsNilNow(t, stream.Send(&AddReq{i, 1}))
+		rsp, err := stream.Recv()
+		utest.IsNilNow(t, err)
+		utest.EqualNow(t, rsp.(*AddRsp).C, i+1)
+	}
+	utest.IsNilNow(t, stream.CloseSend())
+
+	// the server sends more than one window, it must wait for our updates.
+	for i := 0; i < StreamWindow*4; i++ {
+		rsp, err := stream.Recv()
+		utest.IsNilNow(t, err)
+		utest.EqualNow(t, rsp.(*AddRsp).C, i)
+	}
+	_, err = stream.Recv()
+	utest.EqualNow(t, err, io.EOF)
+
+	stream, err = client.OpenStream(context.Background(), &AddReq{-1, 0})
+	utest.IsNilNow(t, err)
+	_, err = stream.Recv()
+	_, ok := err.(*RemoteError)
+	utest.Assert(t, ok)
+
+	// the call side still works on the same session.
+	rsp, err := client.Call(context.Background(), &AddReq{1, 2})
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, rsp.(*AddRsp).C, 3)
+}
+
+func Test_StreamCancel(t *testing.T) {
+	done := make(chan error, 1)
+	handler := StreamHandlerFunc(func(stream *Stream, req interface{}) error {
+		_, err := stream.Recv()
+		done <- err
+		return err
+	})
+	client := newTestStreamClient(t, HandlerFunc(addHandler), handler)
+	defer client.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := client.OpenStream(ctx, &AddReq{})
+	utest.IsNilNow(t, err)
+	cancel()
+	_, err = stream.Recv()
+	utest.EqualNow(t, err, context.Canceled)
+
+	select {
+	case err := <-done:
+		utest.EqualNow(t, err, context.Canceled)
+	case <-time.After(time.Second):
+		t.Fatal("stream handler not canceled")
+	}
+}
+
+func Test_Notify(t *testing.T) {
+	notified := make(chan int, 10)
+	client := newTestClient(t, HandlerFunc(func(ctx context.Context, session *link.Session, req interface{}) (interface{}, error) {
+		add := req.(*AddReq)
+		if add.B == 0 {
+			notified <- add.A
+			return &AddRsp{}, nil
+		}
+		return addHandler(ctx, session, req)
+	}))
+	defer client.Close()
+
+	for i := 0; i < 10; i++ {
+		utest.IsNilNow(t, client.Notify(&AddReq{i, 0}))
+	}
+	// the requests are served in order, a call after them sees all of them done.
+	rsp, err := client.Call(context.Background(), &AddReq{1, 1})
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, rsp.(*AddRsp).C, 2)
+	utest.EqualNow(t, len(notified), 10)
+	for i := 0; i < 10; i++ {
+		utest.EqualNow(t, <-notified, i)
+	}
+}
+
+func Test_Pipelining(t *testing.T) {
+	release := make(chan struct{})
+	server := NewServer(HandlerFunc(func(ctx context.Context, session *link.Session, req interface{}) (interface{}, error) {
+		if req.(*AddReq).A == 0 {
+			<-release
+		}
+		return addHandler(ctx, session, req)
+	}))
+	server.SetConcurrency(4)
+	client := newTestServerClient(t, server)
+	defer client.Close()
+
+	slow := client.CallAsync(&AddReq{0, 0})
+	for i := 1; i < 10; i++ {
+		rsp, err := client.Call(context.Background(), &AddReq{i, i})
+		utest.IsNilNow(t, err)
+		utest.EqualNow(t, rsp.(*AddRsp).C, i+i)
+	}
+	select {
+	case <-slow.Done():
+		t.Fatal("slow call returned too early")
+	default:
+	}
+
+	close(release)
+	rsp, err := slow.Wait(context.Background())
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, rsp.(*AddRsp).C, 0)
+}
+
+func Test_Idempotency(t *testing.T) {
+	var served int32
+	server := NewServer(HandlerFunc(func(ctx context.Context, session *link.Session, req interface{}) (interface{}, error) {
+		atomic.AddInt32(&served, 1)
+		return addHandler(ctx, session, req)
+	}))
+	server.SetReplayWindow(time.Minute)
+	server.SetConcurrency(4)
+
+	// a retry on a new session, as after reconnect, must not run twice.
+	for i := 0; i < 3; i++ {
+		client := newTestServerClient(t, server)
+		rsp, err := client.Call(WithKey(context.Background(), "add-1"), &AddReq{1, 2})
+		utest.IsNilNow(t, err)
+		utest.EqualNow(t, rsp.(*AddRsp).C, 3)
+		client.Close()
+	}
+	utest.EqualNow(t, atomic.LoadInt32(&served), int32(1))
+
+	client := newTestServerClient(t, server)
+	defer client.Close()
+	_, err := client.Call(WithKey(context.Background(), "add-2"), &AddReq{-1, 0})
+	utest.NotNilNow(t, err)
+	_, err = client.Call(WithKey(context.Background(), "add-2"), &AddReq{-1, 0})
+	utest.EqualNow(t, err.Error(), "negative")
+	utest.EqualNow(t, atomic.LoadInt32(&served), int32(2))
+
+	_, err = client.Call(context.Background(), &AddReq{1, 2})
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, atomic.LoadInt32(&served), int32(3))
+}
+
+func Test_Extensions(t *testing.T) {
+	client := newTestClient(t, HandlerFunc(func(ctx context.Context, session *link.Session, req interface{}) (interface{}, error) {
+		v, ok := ExtensionFromContext(ctx, 200)
+		if !ok {
+			return nil, errors.New("no extension")
+		}
+		return &AddRsp{int(v[0])}, nil
+	}))
+	defer client.Close()
+
+	ctx := WithExtension(context.Background(), 201, []byte("other"))
+	ctx = WithExtension(ctx, 200, []byte{1})
+	ctx = WithExtension(ctx, 200, []byte{7})
+	rsp, err := client.Call(ctx, &AddReq{})
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, rsp.(*AddRsp).C, 7)
+	utest.EqualNow(t, len(ExtensionsFromContext(ctx)), 2)
+
+	_, err = client.Call(context.Background(), &AddReq{})
+	utest.EqualNow(t, err.Error(), "no extension")
+
+	// types a decoder doesn't know are kept, the trace context is one too.
+	var stream bytes.Buffer
+	c, _ := testProtocol().NewCodec(&stream)
+	packet := &Packet{Kind: KindRequest, ID: 1, Key: "key", Trace: "trace", Body: &AddReq{1, 2}}
+	packet.SetExtension(9, []byte("nine"))
+	packet.SetExtension(10, nil)
+	utest.IsNilNow(t, c.Send(packet))
+	msg, err := c.Receive()
+	utest.IsNilNow(t, err)
+	got := msg.(*Packet)
+	utest.EqualNow(t, got.Key, "key")
+	utest.EqualNow(t, got.Trace, "trace")
+	utest.EqualNow(t, got.Body.(*AddReq).B, 2)
+	utest.EqualNow(t, len(got.Extensions), 2)
+	utest.EqualNow(t, len(packet.Extensions), 2)
+	v, ok := got.Extension(9)
+	utest.Assert(t, ok)
+	utest.EqualNow(t, string(v), "nine")
+
+	packet.SetExtension(11, make([]byte, 256))
+	utest.Assert(t, errors.Is(c.Send(packet), ExtensionsTooLongError))
+}
+
+func Test_SendEncoded(t *testing.T) {
+	c, err := testProtocol().NewCodec(new(bytes.Buffer))
+	utest.IsNilNow(t, err)
+	utest.Assert(t, errors.Is(c.Send(&AddReq{1, 2}), NotPacketError))
+
+	a, b, err := link.Pipe(testProtocol(), 0)
+	utest.IsNilNow(t, err)
+	defer a.Close()
+	broadcaster, err := link.NewBroadcaster(testProtocol())
+	utest.IsNilNow(t, err)
+	encoded, err := broadcaster.Encode(&Packet{Kind: KindRequest, Oneway: true, Body: &AddReq{1, 2}})
+	utest.IsNilNow(t, err)
+	utest.IsNilNow(t, a.Send(encoded))
+	msg, err := b.Receive()
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, *msg.(*Packet).Body.(*AddReq), AddReq{1, 2})
+}
+
+func Test_Breaker(t *testing.T) {
+	client := newTestClient(t, HandlerFunc(addHandler))
+	defer client.Close()
+
+	clk := clock.NewFake(time.Now())
+	breaker := NewBreaker(BreakerConfig{
+		Window:        time.Minute,
+		MinRequests:   4,
+		ErrorRate:     0.5,
+		OpenTimeout:   50 * time.Millisecond,
+		HalfOpenCalls: 2,
+		Clock:         clk,
+	})
+	client.SetBreaker(breaker)
+
+	for i := 0; i < 4; i++ {
+		_, err := client.Call(context.Background(), &AddReq{-1, 0})
+		_, ok := err.(*RemoteError)
+		utest.Assert(t, ok)
+	}
+	utest.EqualNow(t, breaker.State(), BreakerOpen)
+	_, err := client.Call(context.Background(), &AddReq{1, 1})
+	utest.EqualNow(t, err, BreakerOpenError)
+
+	clk.Advance(60 * time.Millisecond)
+	utest.EqualNow(t, breaker.State(), BreakerHalfOpen)
+	for i := 0; i < 2; i++ {
+		_, err := client.Call(context.Background(), &AddReq{1, 1})
+		utest.IsNilNow(t, err)
+	}
+	utest.EqualNow(t, breaker.State(), BreakerClosed)
+}
+
+func Test_Retry(t *testing.T) {
+	broken := newTestClient(t, HandlerFunc(addHandler))
+	broken.Close()
+	healthy := newTestClient(t, HandlerFunc(addHandler))
+	defer healthy.Close()
+
+	rc := NewRetryClient(RetryPolicy{
+		MaxAttempts: 3,
+		Backoff:     time.Millisecond,
+		MaxBackoff:  10 * time.Millisecond,
+	}, broken, healthy)
+	rsp, err := rc.Call(WithKey(context.Background(), "k1"), &AddReq{1, 2})
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, rsp.(*AddRsp).C, 3)
+
+	// remote errors are not retried.
+	_, err = rc.Call(WithKey(context.Background(), "k2"), &AddReq{-1, 0})
+	_, ok := err.(*RemoteError)
+	utest.Assert(t, ok)
+
+	// calls without a key are retried only when the policy says so.
+	_, err = rc.Call(context.Background(), &AddReq{1, 2})
+	utest.NotNilNow(t, err)
+	rc = NewRetryClient(RetryPolicy{MaxAttempts: 3, RetryUnkeyed: true}, broken, healthy)
+	_, err = rc.Call(context.Background(), &AddReq{1, 2})
+	utest.IsNilNow(t, err)
+
+	rc = NewRetryClient(RetryPolicy{MaxAttempts: 3, RetryUnkeyed: true, Budget: NewRetryBudget(0.1, 0)}, broken, healthy)
+	_, err = rc.Call(context.Background(), &AddReq{1, 2})
+	utest.NotNilNow(t, err)
+}
+
+func Test_Hedge(t *testing.T) {
+	slow := newTestClient(t, HandlerFunc(func(ctx context.Context, session *link.Session, req interface{}) (interface{}, error) {
+		select {
+		case <-time.After(time.Second):
+		case <-ctx.Done():
+		}
+		return addHandler(ctx, session, req)
+	}))
+	defer slow.Close()
+	fast := newTestClient(t, HandlerFunc(addHandler))
+	defer fast.Close()
+
+	rc := NewRetryClient(RetryPolicy{HedgeDelay: 20 * time.Millisecond}, slow, fast)
+	start := time.Now()
+	rsp, err := rc.Call(WithKey(context.Background(), "k"), &AddReq{2, 3})
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, rsp.(*AddRsp).C, 5)
+	utest.Assert(t, time.Since(start) < 500*time.Millisecond)
+}
+
+func Test_Admission(t *testing.T) {
+	release := make(chan struct{})
+	server := NewServer(HandlerFunc(func(ctx context.Context, session *link.Session, req interface{}) (interface{}, error) {
+		<-release
+		return addHandler(ctx, session, req)
+	}))
+	admission := NewAdmissionController(AdmissionConfig{
+		MaxQueue: 2,
+		Priority: func(req interface{}) int { return req.(*AddReq).B },
+		Critical: 1,
+	})
+	server.SetAdmission(admission)
+	client := newTestServerClient(t, server)
+	defer client.Close()
+
+	queued := []*Future{client.CallAsync(&AddReq{1, 0}), client.CallAsync(&AddReq{2, 0})}
+	for admission.Load() < 1 {
+		time.Sleep(time.Millisecond)
+	}
+	_, err := client.Call(context.Background(), &AddReq{3, 0})
+	utest.EqualNow(t, err.Error(), OverloadedError.Error())
+	utest.Assert(t, errors.Is(err, OverloadedError) && errors.Is(err, link.PolicyError))
+	utest.Assert(t, !errors.Is(err, link.HandlerError))
+	utest.EqualNow(t, admission.Rejected(), uint64(1))
+
+	critical := client.CallAsync(&AddReq{4, 1})
+	close(release)
+	for _, f := range append(queued, critical) {
+		_, err := f.Wait(context.Background())
+		utest.IsNilNow(t, err)
+	}
+}
+
+type testSpan struct {
+	tracer *testTracer
+	name   string
+	parent string
+	attrs  []Attr
+	err    error
+}
+
+func (s *testSpan) SetError(err error) { s.err = err }
+
+func (s *testSpan) End(time.Time) {
+	s.tracer.mutex.Lock()
+	defer s.tracer.mutex.Unlock()
+	s.tracer.ended = append(s.tracer.ended, s)
+}
+
+type spanContext struct{}
+
+type testTracer struct {
+	mutex sync.Mutex
+	ended []*testSpan
+}
+
+func (t *testTracer) Start(ctx context.Context, name string, start time.Time, attrs ...Attr) (context.Context, Span) {
+	parent, _ := ctx.Value(spanContext{}).(string)
+	span := &testSpan{tracer: t, name: name, parent: parent, attrs: attrs}
+	return context.WithValue(ctx, spanContext{}, name), span
+}
+
+func (t *testTracer) Inject(ctx context.Context) string {
+	name, _ := ctx.Value(spanContext{}).(string)
+	return name
+}
+
+func (t *testTracer) Extract(ctx context.Context, trace string) context.Context {
+	return context.WithValue(ctx, spanContext{}, trace)
+}
+
+func (t *testTracer) spans() map[string]*testSpan {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	spans := make(map[string]*testSpan)
+	for _, span := range t.ended {
+		spans[span.name] = span
+	}
+	return spans
+}
+
+func Test_Tracing(t *testing.T) {
+	tracer := &testTracer{}
+	server := NewServer(HandlerFunc(addHandler))
+	server.SetTracer(tracer)
+	client := newTestServerClient(t, server)
+	client.SetTracer(tracer)
+	defer client.Close()
+
+	_, err := client.Call(context.Background(), &AddReq{-1, 0})
+	utest.NotNilNow(t, err)
+
+	// the server ends its spans after the response is sent.
+	spans := tracer.spans()
+	for i := 0; len(spans) < 5 && i < 100; i++ {
+		time.Sleep(time.Millisecond)
+		spans = tracer.spans()
+	}
+	utest.EqualNow(t, len(spans), 5)
+	utest.EqualNow(t, spans["rpc.call"].attrs[0].Value, "*rpc.AddReq")
+	utest.NotNilNow(t, spans["rpc.call"].err)
+	utest.EqualNow(t, spans["rpc.serve"].parent, "rpc.call")
+	utest.EqualNow(t, spans["rpc.decode"].parent, "rpc.serve")
+	utest.EqualNow(t, spans["rpc.handler"].parent, "rpc.serve")
+	utest.EqualNow(t, spans["rpc.encode"].parent, "rpc.serve")
+	utest.EqualNow(t, spans["rpc.handler"].err.Error(), "negative")
+}
+
+func Test_Profiled(t *testing.T) {
+	client := newTestClient(t, Profiled(HandlerFunc(func(ctx context.Context, session *link.Session, req interface{}) (interface{}, error) {
+		message, _ := pprof.Label(ctx, "message")
+		bucket, _ := pprof.Label(ctx, "session")
+		if message != "*rpc.AddReq" || bucket != link.SessionBucket(session.ID(), 16) {
+			return nil, errors.New("bad labels: " + message + " " + bucket)
+		}
+		return addHandler(ctx, session, req)
+	}), 16))
+	defer client.Close()
+
+	_, err := client.Call(context.Background(), &AddReq{1, 2})
+	utest.IsNilNow(t, err)
+}
+
+func Test_RetryBackoff(t *testing.T) {
+	rc := NewRetryClient(RetryPolicy{MaxAttempts: 100, Backoff: time.Millisecond}, new(Client))
+	for attempt := 1; attempt < 100; attempt++ {
+		d := rc.backoff(attempt)
+		utest.Assert(t, d > 0 && d <= defaultMaxBackoff, attempt, d)
+	}
+	rc = NewRetryClient(RetryPolicy{Backoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond}, new(Client))
+	utest.Assert(t, rc.backoff(1000) <= 10*time.Millisecond)
+
+	defer func() { utest.NotNilNow(t, recover()) }()
+	NewRetryClient(RetryPolicy{})
+}
+
+func Test_BreakerCancelledProbe(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	breaker := NewBreaker(BreakerConfig{
+		Window:        time.Minute,
+		MinRequests:   1,
+		ErrorRate:     0.5,
+		OpenTimeout:   time.Second,
+		HalfOpenCalls: 1,
+		Clock:         clk,
+	})
+	gen, err := breaker.Allow()
+	utest.IsNilNow(t, err)
+	breaker.Record(gen, errors.New("boom"), 0)
+	utest.EqualNow(t, breaker.State(), BreakerOpen)
+
+	// a cancelled probe doesn't use up the half-open slots.
+	clk.Advance(2 * time.Second)
+	gen, err = breaker.Allow()
+	utest.IsNilNow(t, err)
+	breaker.Record(gen, context.Canceled, 0)
+	gen, err = breaker.Allow()
+	utest.IsNilNow(t, err)
+	breaker.Record(gen, nil, 0)
+	utest.EqualNow(t, breaker.State(), BreakerClosed)
+
+	defer func() { utest.NotNilNow(t, recover()) }()
+	NewBreaker(BreakerConfig{SlowCall: time.Second})
+}
+
+func Test_AdmissionLatencyDecay(t *testing.T) {
+	ac := NewAdmissionController(AdmissionConfig{MaxLatency: 10 * time.Millisecond, Critical: 1})
+	ac.admit(nil)
+	ac.done(100 * time.Millisecond)
+	utest.Assert(t, ac.Load() >= 1)
+	utest.Assert(t, !ac.admit(nil))
+
+	// without samples the average decays and requests go through again.
+	ac.mutex.Lock()
+	ac.latencyTime = ac.latencyTime.Add(-10 * latencyHalfLife)
+	ac.mutex.Unlock()
+	utest.Assert(t, ac.Load() < 1)
+	utest.Assert(t, ac.admit(nil))
+}
+
+func Test_QueueFull(t *testing.T) {
+	started := make(chan struct{})
+	canceled := make(chan struct{})
+	release := make(chan struct{})
+	client := newTestClient(t, HandlerFunc(func(ctx context.Context, session *link.Session, req interface{}) (interface{}, error) {
+		if req.(*AddReq).A == 1 {
+			close(started)
+			<-ctx.Done()
+			close(canceled)
+			return nil, ctx.Err()
+		}
+		<-release
+		return addHandler(ctx, session, req)
+	}))
+	defer client.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go client.Call(ctx, &AddReq{1, 0})
+	<-started
+	futures := make([]*Future, 64)
+	for i := range futures {
+		futures[i] = client.CallAsync(&AddReq{0, i})
+	}
+	_, err := client.Call(context.Background(), &AddReq{2, 2})
+	utest.Assert(t, errors.Is(err, BusyError))
+
+	// the cancel is read although the queue is full.
+	cancel()
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("cancel not read while the queue is full")
+	}
+	close(release)
+	for i, f := range futures {
+		<-f.Done()
+		rsp, err := f.Result()
+		utest.IsNilNow(t, err)
+		utest.EqualNow(t, rsp.(*AddRsp).C, i)
+	}
+}
@@ -0,0 +1,334 @@
+package rpc
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/clock"
+)
+
+type Handler interface {
+	ServeRPC(ctx context.Context, session *link.Session, req interface{}) (interface{}, error)
+}
+
+type HandlerFunc func(ctx context.Context, session *link.Session, req interface{}) (interface{}, error)
+
+func (f HandlerFunc) ServeRPC(ctx context.Context, session *link.Session, req interface{}) (interface{}, error) {
+	return f(ctx, session, req)
+}
+
+var BusyError = link.NewError(link.PolicyError, "RPC Server Busy")
+
+type Server struct {
+	handler       Handler
+	streamHandler StreamHandler
+	queueSize     int
+	concurrency   int
+	replay        *replayCache
+	admission     *AdmissionController
+	tracer        Tracer
+
+	Clock clock.Clock // of the replay window, set before SetReplayWindow, nil is clock.Default()
+}
+
+var _ link.Handler = (*Server)(nil)
+
+// NewServer makes a server which queues up to 64 requests per session,
+// requests beyond are answered with BusyError.
+func NewServer(handler Handler) *Server {
+	return &Server{
+		handler:     handler,
+		queueSize:   64,
+		concurrency: 1,
+	}
+}
+
+// SetConcurrency sets how many requests of one session are served at the
+// same time. With n > 1 responses can be sent out of order, the client
+// matches them by request ID so a slow call doesn't hold up the others.
+func (server *Server) SetConcurrency(n int) {
+	if n < 1 {
+		n = 1
+	}
+	server.concurrency = n
+}
+
+func (server *Server) SetStreamHandler(handler StreamHandler) {
+	server.streamHandler = handler
+}
+
+// SetReplayWindow enables duplicate suppression for requests carrying an
+// idempotency key. The response of a keyed request is kept for window and
+// replayed to any retry with the same key, on any session of this server.
+func (server *Server) SetReplayWindow(window time.Duration) {
+	server.replay = nil
+	if window > 0 {
+		server.replay = newReplayCache(window, server.Clock)
+	}
+}
+
+// SetTracer records a span per request, with decode, handler and encode
+// child spans, joined to the trace context sent by the client.
+func (server *Server) SetTracer(tracer Tracer) {
+	server.tracer = tracer
+}
+
+// SetAdmission installs a load shedder in front of the request queue.
+// Rejected requests are answered with OverloadedError right away.
+func (server *Server) SetAdmission(admission *AdmissionController) {
+	server.admission = admission
+}
+
+type request struct {
+	packet *Packet
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+type serverSession struct {
+	server   *Server
+	session  *link.Session
+	mutex    sync.Mutex
+	inflight map[uint64]context.CancelFunc
+	streams  *streamTable
+}
+
+func (server *Server) HandleSession(session *link.Session) {
+	ss := &serverSession{
+		server:   server,
+		session:  session,
+		inflight: make(map[uint64]context.CancelFunc),
+		streams:  newStreamTable(),
+	}
+	queue := make(chan *request, server.queueSize)
+	var workers sync.WaitGroup
+	for i := 0; i < server.concurrency; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for req := range queue {
+				if ss.serve(req) != nil {
+					session.Close()
+				}
+			}
+		}()
+	}
+
+	ss.receiveLoop(queue)
+	close(queue)
+	workers.Wait()
+	session.Close()
+	ss.cancelAll()
+	ss.streams.abortAll(link.SessionClosedError)
+}
+
+func (ss *serverSession) receiveLoop(queue chan<- *request) {
+	for {
+		msg, err := ss.session.Receive()
+		if err != nil {
+			return
+		}
+		packet, ok := msg.(*Packet)
+		if !ok {
+			continue
+		}
+		switch packet.Kind {
+		case KindRequest:
+			if admission := ss.server.admission; admission != nil && !admission.admit(packet.Body) {
+				if !packet.Oneway {
+					ss.session.Send(&Packet{Kind: KindResponse, ID: packet.ID, Error: OverloadedError.Error()})
+				}
+				continue
+			}
+			// a full queue is answered at once, waiting for room would stop
+			// the cancels behind the request from being read.
+			req := ss.newRequest(packet)
+			select {
+			case queue <- req:
+			default:
+				if admission := ss.server.admission; admission != nil {
+					admission.release()
+				}
+				if packet.Oneway {
+					req.cancel()
+				} else {
+					ss.cancel(packet.ID)
+					ss.session.Send(&Packet{Kind: KindResponse, ID: packet.ID, Error: BusyError.Error()})
+				}
+			}
+		case KindCancel:
+			ss.cancel(packet.ID)
+		case KindStreamOpen:
+			ss.openStream(packet)
+		case KindStreamData, KindStreamClose, KindStreamWindow:
+			ss.streams.dispatch(packet)
+		}
+	}
+}
+
+func (ss *serverSession) newRequest(packet *Packet) *request {
+	ctx, cancel := context.Background(), context.CancelFunc(nil)
+	if !packet.Deadline.IsZero() {
+		ctx, cancel = context.WithDeadline(ctx, packet.Deadline)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	if len(packet.Extensions) > 0 {
+		ctx = context.WithValue(ctx, extensionsContext{}, packet.Extensions)
+	}
+	if packet.Oneway {
+		return &request{packet, ctx, cancel}
+	}
+	ss.mutex.Lock()
+	ss.inflight[packet.ID] = cancel
+	ss.mutex.Unlock()
+	return &request{packet, ctx, cancel}
+}
+
+func (ss *serverSession) openStream(packet *Packet) {
+	if ss.server.streamHandler == nil {
+		ss.session.Send(&Packet{Kind: KindStreamClose, ID: packet.ID, Error: NoStreamHandlerError.Error()})
+		return
+	}
+	req := ss.newRequest(packet)
+	stream := newStream(req.ctx, packet.ID, ss.session, ss.streams, nil)
+	go func() {
+		defer ss.cancel(packet.ID)
+		err := ss.server.streamHandler.ServeStream(stream, packet.Body)
+		if err != nil {
+			stream.closeSend(err.Error())
+		} else {
+			stream.closeSend("")
+		}
+		stream.abort(StreamClosedError)
+	}()
+}
+
+func (ss *serverSession) cancel(id uint64) {
+	ss.mutex.Lock()
+	cancel, exists := ss.inflight[id]
+	delete(ss.inflight, id)
+	ss.mutex.Unlock()
+	if exists {
+		cancel()
+	}
+}
+
+func (ss *serverSession) cancelAll() {
+	ss.mutex.Lock()
+	inflight := ss.inflight
+	ss.inflight = make(map[uint64]context.CancelFunc)
+	ss.mutex.Unlock()
+	for _, cancel := range inflight {
+		cancel()
+	}
+}
+
+func (ss *serverSession) serve(req *request) (err error) {
+	if admission := ss.server.admission; admission != nil {
+		clk := admission.config.Clock
+		start := clk.Now()
+		defer func() { admission.done(clk.Since(start)) }()
+	}
+	if req.packet.Oneway {
+		defer req.cancel()
+	} else {
+		defer ss.cancel(req.packet.ID)
+	}
+
+	// the caller has already given up, skip the work.
+	if req.ctx.Err() != nil {
+		return nil
+	}
+
+	tracer := ss.server.tracer
+	if tracer != nil {
+		var span Span
+		req.ctx, span = ss.startSpan(tracer, req)
+		defer func() { endSpan(span, err) }()
+	}
+
+	body, errMsg, ok := ss.call(req)
+	if !ok || req.packet.Oneway {
+		return nil
+	}
+
+	rsp := &Packet{Kind: KindResponse, ID: req.packet.ID, Error: errMsg}
+	if errMsg == "" {
+		rsp.Body = body
+	}
+	if tracer == nil {
+		return ss.session.Send(rsp)
+	}
+	_, span := tracer.Start(req.ctx, "rpc.encode", time.Now())
+	err = ss.session.Send(rsp)
+	endSpan(span, err)
+	return err
+}
+
+func (ss *serverSession) startSpan(tracer Tracer, req *request) (context.Context, Span) {
+	packet := req.packet
+	ctx := req.ctx
+	if packet.Trace != "" {
+		ctx = tracer.Extract(ctx, packet.Trace)
+	}
+	start := packet.decodeStart
+	if start.IsZero() {
+		start = time.Now()
+	}
+	ctx, span := tracer.Start(ctx, "rpc.serve", start, messageType(packet.Body))
+	if !packet.decodeEnd.IsZero() {
+		_, decode := tracer.Start(ctx, "rpc.decode", packet.decodeStart)
+		decode.End(packet.decodeEnd)
+	}
+	return ctx, span
+}
+
+func (ss *serverSession) call(req *request) (body interface{}, errMsg string, ok bool) {
+	key, replay := req.packet.Key, ss.server.replay
+	if key == "" || replay == nil {
+		return ss.invoke(req)
+	}
+	for {
+		entry, owner := replay.begin(key)
+		if !owner {
+			select {
+			case <-entry.done:
+			case <-req.ctx.Done():
+				return nil, "", false
+			}
+			if entry.abandoned {
+				continue
+			}
+			return entry.body, entry.err, true
+		}
+		body, errMsg, ok = ss.invoke(req)
+		if !ok {
+			replay.abandon(key, entry)
+			return
+		}
+		replay.finish(entry, body, errMsg)
+		return
+	}
+}
+
+func (ss *serverSession) invoke(req *request) (body interface{}, errMsg string, ok bool) {
+	ctx, tracer := req.ctx, ss.server.tracer
+	var span Span
+	if tracer != nil {
+		ctx, span = tracer.Start(ctx, "rpc.handler", time.Now(), messageType(req.packet.Body))
+	}
+	body, err := ss.server.handler.ServeRPC(ctx, ss.session, req.packet.Body)
+	if span != nil {
+		endSpan(span, err)
+	}
+	if req.ctx.Err() != nil {
+		return nil, "", false
+	}
+	if err != nil {
+		return nil, err.Error(), true
+	}
+	return body, "", true
+}
@@ -0,0 +1,144 @@
+package rpc
+
+import (
+	"math"
+	"runtime/metrics"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/clock"
+)
+
+var OverloadedError = link.NewError(link.PolicyError, "RPC Server Overloaded")
+
+type AdmissionConfig struct {
+	MaxQueue   int           // requests queued or running on the server
+	MaxMemory  uint64        // live heap bytes
+	MaxLatency time.Duration // moving average of the handler latency
+
+	// Requests below Degrade are rejected once the load reaches
+	// DegradeLoad, all requests below Critical are rejected at full load.
+	Priority    func(req interface{}) int
+	Degrade     int
+	Critical    int
+	DegradeLoad float64
+
+	Clock clock.Clock // of the latency decay, nil is clock.Default()
+}
+
+// AdmissionController rejects requests before they are queued when the
+// server runs out of room. Load is the highest of the queue, memory and
+// latency ratios against their limits, limits left at 0 are ignored.
+type AdmissionController struct {
+	config   AdmissionConfig
+	queued   int64
+	rejected uint64
+
+	mutex       sync.Mutex
+	latency     float64
+	latencyTime time.Time // of the last sample
+	heap        uint64
+	heapTime    time.Time
+	heapSample  []metrics.Sample
+}
+
+// latencyHalfLife is how fast the latency average decays without samples,
+// so shedding on latency lets requests through again once it stops them
+// from running and updating the average.
+const latencyHalfLife = time.Second
+
+func NewAdmissionController(config AdmissionConfig) *AdmissionController {
+	if config.DegradeLoad <= 0 {
+		config.DegradeLoad = 0.8
+	}
+	config.Clock = clock.Or(config.Clock)
+	return &AdmissionController{
+		config:     config,
+		heapSample: []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}},
+	}
+}
+
+func (ac *AdmissionController) heapBytes() uint64 {
+	ac.mutex.Lock()
+	defer ac.mutex.Unlock()
+	if now := ac.config.Clock.Now(); now.Sub(ac.heapTime) > 100*time.Millisecond {
+		metrics.Read(ac.heapSample)
+		if ac.heapSample[0].Value.Kind() == metrics.KindUint64 {
+			ac.heap = ac.heapSample[0].Value.Uint64()
+		}
+		ac.heapTime = now
+	}
+	return ac.heap
+}
+
+func (ac *AdmissionController) Load() float64 {
+	var load float64
+	if ac.config.MaxQueue > 0 {
+		load = float64(atomic.LoadInt64(&ac.queued)) / float64(ac.config.MaxQueue)
+	}
+	if ac.config.MaxMemory > 0 {
+		if l := float64(ac.heapBytes()) / float64(ac.config.MaxMemory); l > load {
+			load = l
+		}
+	}
+	if ac.config.MaxLatency > 0 {
+		ac.mutex.Lock()
+		l := ac.decayedLatency(ac.config.Clock.Now()) / float64(ac.config.MaxLatency)
+		ac.mutex.Unlock()
+		if l > load {
+			load = l
+		}
+	}
+	return load
+}
+
+func (ac *AdmissionController) Rejected() uint64 {
+	return atomic.LoadUint64(&ac.rejected)
+}
+
+func (ac *AdmissionController) admit(req interface{}) bool {
+	priority := 0
+	if ac.config.Priority != nil {
+		priority = ac.config.Priority(req)
+	}
+	if priority < ac.config.Critical {
+		load := ac.Load()
+		if load >= 1 || (load >= ac.config.DegradeLoad && priority < ac.config.Degrade) {
+			atomic.AddUint64(&ac.rejected, 1)
+			return false
+		}
+	}
+	atomic.AddInt64(&ac.queued, 1)
+	return true
+}
+
+// release undoes admit for a request which didn't run.
+func (ac *AdmissionController) release() {
+	atomic.AddInt64(&ac.queued, -1)
+}
+
+func (ac *AdmissionController) done(latency time.Duration) {
+	atomic.AddInt64(&ac.queued, -1)
+	ac.mutex.Lock()
+	defer ac.mutex.Unlock()
+	now := ac.config.Clock.Now()
+	if ac.latency == 0 {
+		ac.latency = float64(latency)
+	} else {
+		ac.latency = ac.decayedLatency(now)
+		ac.latency += (float64(latency) - ac.latency) * 0.1
+	}
+	ac.latencyTime = now
+}
+
+// decayedLatency halves the average every latencyHalfLife since the last
+// sample.
+func (ac *AdmissionController) decayedLatency(now time.Time) float64 {
+	idle := now.Sub(ac.latencyTime)
+	if ac.latency == 0 || idle <= 0 {
+		return ac.latency
+	}
+	return ac.latency * math.Exp2(-float64(idle)/float64(latencyHalfLife))
+}
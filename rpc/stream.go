@@ -0,0 +1,247 @@
+package rpc
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/funny/link"
+)
+
+var StreamClosedError = link.NewError(link.TransportError, "RPC Stream Closed")
+var StreamOverflowError = link.NewError(link.ProtocolError, "RPC Stream Window Overflow")
+var NoStreamHandlerError = link.NewError(link.PolicyError, "RPC Stream Not Supported")
+
+// StreamWindow is the number of messages a peer may send on a stream before
+// it has to wait for the receiver to consume them.
+const StreamWindow = 64
+
+type StreamHandler interface {
+	ServeStream(stream *Stream, req interface{}) error
+}
+
+type StreamHandlerFunc func(stream *Stream, req interface{}) error
+
+func (f StreamHandlerFunc) ServeStream(stream *Stream, req interface{}) error {
+	return f(stream, req)
+}
+
+type streamTable struct {
+	mutex   sync.Mutex
+	streams map[uint64]*Stream
+}
+
+func newStreamTable() *streamTable {
+	return &streamTable{
+		streams: make(map[uint64]*Stream),
+	}
+}
+
+func (t *streamTable) add(stream *Stream) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.streams[stream.id] = stream
+}
+
+func (t *streamTable) get(id uint64) *Stream {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	stream, _ := t.streams[id]
+	return stream
+}
+
+func (t *streamTable) del(id uint64) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	delete(t.streams, id)
+}
+
+func (t *streamTable) dispatch(packet *Packet) {
+	stream := t.get(packet.ID)
+	if stream == nil {
+		return
+	}
+	switch packet.Kind {
+	case KindStreamData, KindStreamClose:
+		select {
+		case stream.recvChan <- packet:
+		default:
+			stream.abort(StreamOverflowError)
+		}
+	case KindStreamWindow:
+		stream.grant(int(packet.Window))
+	}
+}
+
+func (t *streamTable) abortAll(err error) {
+	t.mutex.Lock()
+	streams := t.streams
+	t.streams = make(map[uint64]*Stream)
+	t.mutex.Unlock()
+	for _, stream := range streams {
+		stream.abort(err)
+	}
+}
+
+type Stream struct {
+	id       uint64
+	session  *link.Session
+	table    *streamTable
+	ctx      context.Context
+	recvChan chan *Packet
+	consumed int
+	recvErr  error
+
+	mutex      sync.Mutex
+	cond       sync.Cond
+	credits    int
+	sendClosed bool
+	recvClosed bool
+	err        error
+	abortOnce  sync.Once
+	abortChan  chan struct{}
+	stopCtx    func() bool
+}
+
+func newStream(ctx context.Context, id uint64, session *link.Session, table *streamTable, onCancel func()) *Stream {
+	stream := &Stream{
+		id:        id,
+		session:   session,
+		table:     table,
+		ctx:       ctx,
+		recvChan:  make(chan *Packet, StreamWindow+1),
+		credits:   StreamWindow,
+		abortChan: make(chan struct{}),
+	}
+	stream.cond.L = &stream.mutex
+	stream.stopCtx = context.AfterFunc(ctx, func() {
+		if onCancel != nil && table.get(id) == stream {
+			onCancel()
+		}
+		stream.abort(ctx.Err())
+	})
+	table.add(stream)
+	return stream
+}
+
+func (stream *Stream) ID() uint64 {
+	return stream.id
+}
+
+func (stream *Stream) Context() context.Context {
+	return stream.ctx
+}
+
+func (stream *Stream) Session() *link.Session {
+	return stream.session
+}
+
+func (stream *Stream) grant(n int) {
+	stream.mutex.Lock()
+	defer stream.mutex.Unlock()
+	stream.credits += n
+	stream.cond.Broadcast()
+}
+
+func (stream *Stream) abort(err error) {
+	stream.abortOnce.Do(func() {
+		stream.mutex.Lock()
+		if stream.err == nil {
+			stream.err = err
+		}
+		stream.cond.Broadcast()
+		stream.mutex.Unlock()
+		close(stream.abortChan)
+		stream.table.del(stream.id)
+		stream.stopCtx()
+	})
+}
+
+func (stream *Stream) Send(msg interface{}) error {
+	stream.mutex.Lock()
+	for stream.credits == 0 && stream.err == nil && !stream.sendClosed {
+		stream.cond.Wait()
+	}
+	if stream.err != nil {
+		stream.mutex.Unlock()
+		return stream.err
+	}
+	if stream.sendClosed {
+		stream.mutex.Unlock()
+		return StreamClosedError
+	}
+	stream.credits--
+	stream.mutex.Unlock()
+
+	return stream.session.Send(&Packet{Kind: KindStreamData, ID: stream.id, Body: msg})
+}
+
+// Recv returns the next message of the stream, or io.EOF after the peer
+// closed its sending side.
+func (stream *Stream) Recv() (interface{}, error) {
+	if stream.recvErr != nil {
+		return nil, stream.recvErr
+	}
+	select {
+	case packet := <-stream.recvChan:
+		if packet.Kind == KindStreamClose {
+			stream.recvErr = io.EOF
+			if packet.Error != "" {
+				stream.recvErr = &RemoteError{packet.Error}
+			}
+			stream.markRecvClosed()
+			return nil, stream.recvErr
+		}
+		stream.consumed++
+		if stream.consumed >= StreamWindow/2 {
+			stream.session.Send(&Packet{Kind: KindStreamWindow, ID: stream.id, Window: uint32(stream.consumed)})
+			stream.consumed = 0
+		}
+		return packet.Body, nil
+	case <-stream.abortChan:
+		stream.mutex.Lock()
+		defer stream.mutex.Unlock()
+		return nil, stream.err
+	}
+}
+
+func (stream *Stream) markRecvClosed() {
+	stream.mutex.Lock()
+	stream.recvClosed = true
+	done := stream.sendClosed
+	stream.mutex.Unlock()
+	if done {
+		stream.abort(StreamClosedError)
+	}
+}
+
+func (stream *Stream) closeSend(errMsg string) error {
+	stream.mutex.Lock()
+	if stream.sendClosed || stream.err != nil {
+		stream.mutex.Unlock()
+		return StreamClosedError
+	}
+	stream.sendClosed = true
+	done := stream.recvClosed
+	stream.cond.Broadcast()
+	stream.mutex.Unlock()
+
+	err := stream.session.Send(&Packet{Kind: KindStreamClose, ID: stream.id, Error: errMsg})
+	if done {
+		stream.abort(StreamClosedError)
+	}
+	return err
+}
+
+// CloseSend tells the peer that no more messages will be sent. The stream
+// can still receive until the peer closes its side.
+func (stream *Stream) CloseSend() error {
+	return stream.closeSend("")
+}
+
+// Close closes the sending side and stops receiving.
+func (stream *Stream) Close() error {
+	err := stream.closeSend("")
+	stream.abort(StreamClosedError)
+	return err
+}
@@ -0,0 +1,37 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+type Attr struct {
+	Key, Value string
+}
+
+type Span interface {
+	SetError(err error)
+	End(end time.Time)
+}
+
+// Tracer is the seam to a tracing system, otelrpc is the one of
+// OpenTelemetry. Inject and Extract carry the trace context in the ExtTrace
+// extension of the packet header, so the server side spans join the
+// caller's trace.
+type Tracer interface {
+	Start(ctx context.Context, name string, start time.Time, attrs ...Attr) (context.Context, Span)
+	Inject(ctx context.Context) string
+	Extract(ctx context.Context, trace string) context.Context
+}
+
+func messageType(msg interface{}) Attr {
+	return Attr{"rpc.message_type", fmt.Sprintf("%T", msg)}
+}
+
+func endSpan(span Span, err error) {
+	if err != nil {
+		span.SetError(err)
+	}
+	span.End(time.Now())
+}
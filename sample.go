@@ -0,0 +1,99 @@
+package link
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+type PayloadLogConfig struct {
+	// Rate logs one message out of Rate, 0 or 1 logs every message.
+	Rate uint64
+	// Redact returns what is logged for msg. It must not modify msg, return
+	// a copy with the sensitive fields blanked instead. Returning nil logs
+	// only the message type.
+	Redact func(msg interface{}) interface{}
+	// Logger defaults to the package logger at the time of logging.
+	Logger Logger
+}
+
+// SampledPayloads wraps base to log a sample of the sent and received
+// messages at info level.
+func SampledPayloads(base Protocol, config PayloadLogConfig) Protocol {
+	if config.Rate == 0 {
+		config.Rate = 1
+	}
+	sampler := &payloadSampler{config: config}
+	return ProtocolFunc(func(rw io.ReadWriter) (Codec, error) {
+		codec, err := base.NewCodec(rw)
+		if err != nil {
+			return nil, err
+		}
+		return &sampledCodec{codec, sampler}, nil
+	})
+}
+
+type payloadSampler struct {
+	config PayloadLogConfig
+	count  uint64
+}
+
+func (s *payloadSampler) log(dir string, msg interface{}) {
+	if atomic.AddUint64(&s.count, 1)%s.config.Rate != 0 {
+		return
+	}
+	logger := s.config.Logger
+	if logger == nil {
+		logger = GetLogger()
+	}
+	// wire bytes of a broadcast, Redact can't look into them.
+	if encoded, ok := msg.(Encoded); ok {
+		logger.Info("link: payload", "dir", dir, "type", fmt.Sprintf("%T", msg), "bytes", len(encoded))
+		return
+	}
+	payload := msg
+	if s.config.Redact != nil {
+		payload = s.config.Redact(msg)
+	}
+	if payload == nil {
+		logger.Info("link: payload", "dir", dir, "type", fmt.Sprintf("%T", msg))
+		return
+	}
+	logger.Info("link: payload", "dir", dir, "type", fmt.Sprintf("%T", msg), "payload", fmt.Sprintf("%+v", payload))
+}
+
+type sampledCodec struct {
+	Codec
+	sampler *payloadSampler
+}
+
+func (c *sampledCodec) Receive() (interface{}, error) {
+	msg, err := c.Codec.Receive()
+	if err == nil {
+		c.sampler.log("recv", msg)
+	}
+	return msg, err
+}
+
+func (c *sampledCodec) ReceiveBatch(msgs []interface{}, max int) ([]interface{}, error) {
+	n := len(msgs)
+	msgs, err := ReceiveBatch(c.Codec, msgs, max)
+	for _, msg := range msgs[n:] {
+		c.sampler.log("recv", msg)
+	}
+	return msgs, err
+}
+
+func (c *sampledCodec) Send(msg interface{}) error {
+	err := c.Codec.Send(msg)
+	if err == nil {
+		c.sampler.log("send", msg)
+	}
+	return err
+}
+
+func (c *sampledCodec) ClearSendChan(ch <-chan interface{}) {
+	if clear, ok := c.Codec.(ClearSendChan); ok {
+		clear.ClearSendChan(ch)
+	}
+}
@@ -0,0 +1,271 @@
+package script
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/funny/link"
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+var (
+	NoHooksError       = link.NewError(link.ProtocolError, "Script Hooks Not A Table")
+	BadHookResultError = link.NewError(link.ProtocolError, "Script Hook Result Invalid")
+)
+
+// Lua is an Engine running hooks written in Lua. The script fills the global
+// table hooks with a function per message type:
+//
+//	hooks.Chat = function(session, msg)
+//		if msg.Text == "" then return "drop" end
+//		msg.Text = string.lower(msg.Text)
+//	end
+//
+// session is the session ID, msg the fields of the message. A hook returns
+// nothing to let the message through, "drop" to drop it or the name of a
+// route to send it there. Numbers are Lua numbers, a value the hook doesn't
+// change keeps its exact JSON form, so large integer IDs survive. Scripts
+// get the base, string, table and math libraries, without the functions
+// which reach the file system or load modules.
+type Lua struct {
+	proto *lua.FunctionProto
+	names map[string]bool
+
+	// Timeout stops a hook which runs longer, like an endless loop, the
+	// message then goes on unchanged. Default 100ms.
+	Timeout time.Duration
+
+	mutex  sync.Mutex
+	states []*lua.LState
+}
+
+// NewLua compiles source and checks that it defines the hooks table, name
+// is used in error messages.
+func NewLua(name, source string) (*Lua, error) {
+	chunk, err := parse.Parse(strings.NewReader(source), name)
+	if err != nil {
+		return nil, err
+	}
+	proto, err := lua.Compile(chunk, name)
+	if err != nil {
+		return nil, err
+	}
+	engine := &Lua{proto: proto, names: make(map[string]bool), Timeout: 100 * time.Millisecond}
+	state, err := engine.newState()
+	if err != nil {
+		return nil, err
+	}
+	state.GetGlobal("hooks").(*lua.LTable).ForEach(func(key, value lua.LValue) {
+		if _, ok := value.(*lua.LFunction); ok {
+			engine.names[key.String()] = true
+		}
+	})
+	engine.put(state)
+	return engine, nil
+}
+
+// sandbox are the libraries a script gets.
+var sandbox = []struct {
+	name string
+	open lua.LGFunction
+}{
+	{lua.BaseLibName, lua.OpenBase},
+	{lua.StringLibName, lua.OpenString},
+	{lua.TabLibName, lua.OpenTable},
+	{lua.MathLibName, lua.OpenMath},
+}
+
+func (engine *Lua) newState() (*lua.LState, error) {
+	state := lua.NewState(lua.Options{SkipOpenLibs: true})
+	for _, lib := range sandbox {
+		state.Push(state.NewFunction(lib.open))
+		state.Push(lua.LString(lib.name))
+		state.Call(1, 0)
+	}
+	for _, name := range []string{"dofile", "loadfile", "require", "module"} {
+		state.SetGlobal(name, lua.LNil)
+	}
+	state.SetGlobal("hooks", state.NewTable())
+	state.Push(state.NewFunctionFromProto(engine.proto))
+	if err := state.PCall(0, lua.MultRet, nil); err != nil {
+		state.Close()
+		return nil, err
+	}
+	if _, ok := state.GetGlobal("hooks").(*lua.LTable); !ok {
+		state.Close()
+		return nil, NoHooksError
+	}
+	return state, nil
+}
+
+// get takes an idle state, a Lua state can't be used by two goroutines.
+func (engine *Lua) get() (*lua.LState, error) {
+	engine.mutex.Lock()
+	if n := len(engine.states); n > 0 {
+		state := engine.states[n-1]
+		engine.states = engine.states[:n-1]
+		engine.mutex.Unlock()
+		return state, nil
+	}
+	engine.mutex.Unlock()
+	return engine.newState()
+}
+
+func (engine *Lua) put(state *lua.LState) {
+	engine.mutex.Lock()
+	engine.states = append(engine.states, state)
+	engine.mutex.Unlock()
+}
+
+// Close releases the Lua states, the engine must not be used after.
+func (engine *Lua) Close() {
+	engine.mutex.Lock()
+	defer engine.mutex.Unlock()
+	for _, state := range engine.states {
+		state.Close()
+	}
+	engine.states = nil
+}
+
+func (engine *Lua) Handles(name string) bool {
+	return engine.names[name]
+}
+
+func (engine *Lua) Hook(session *link.Session, name string, fields map[string]interface{}) (Verdict, error) {
+	state, err := engine.get()
+	if err != nil {
+		return Verdict{}, err
+	}
+	hook := state.GetGlobal("hooks").(*lua.LTable).RawGetString(name)
+	table := toLua(state, fields).(*lua.LTable)
+	before := fromLua(table)
+	ctx, cancel := context.WithTimeout(context.Background(), engine.Timeout)
+	state.SetContext(ctx)
+	err = state.CallByParam(lua.P{Fn: hook, NRet: 1, Protect: true}, lua.LNumber(session.ID()), table)
+	state.RemoveContext()
+	cancel()
+	if err != nil {
+		// a failed call can leave the state in a bad shape.
+		state.Close()
+		return Verdict{}, err
+	}
+	ret := state.Get(-1)
+	state.Pop(1)
+	engine.put(state)
+
+	if after := fromLua(table); !reflect.DeepEqual(before, after) {
+		merged := merge(fields, before, after).(map[string]interface{})
+		for key := range fields {
+			delete(fields, key)
+		}
+		for key, value := range merged {
+			fields[key] = value
+		}
+	}
+	switch ret := ret.(type) {
+	case *lua.LNilType:
+		return Verdict{}, nil
+	case lua.LString:
+		if ret == "drop" {
+			return Verdict{Drop: true}, nil
+		}
+		return Verdict{Route: string(ret)}, nil
+	default:
+		return Verdict{}, fmt.Errorf("%w: %s returned %s", BadHookResultError, name, ret.Type())
+	}
+}
+
+// toLua converts a value decoded from JSON.
+func toLua(state *lua.LState, value interface{}) lua.LValue {
+	switch value := value.(type) {
+	case bool:
+		return lua.LBool(value)
+	case json.Number:
+		f, _ := value.Float64()
+		return lua.LNumber(f)
+	case float64:
+		return lua.LNumber(value)
+	case string:
+		return lua.LString(value)
+	case []interface{}:
+		table := state.CreateTable(len(value), 0)
+		for _, item := range value {
+			table.Append(toLua(state, item))
+		}
+		return table
+	case map[string]interface{}:
+		table := state.CreateTable(0, len(value))
+		for key, item := range value {
+			table.RawSetString(key, toLua(state, item))
+		}
+		return table
+	}
+	return lua.LNil
+}
+
+// fromLua converts back to JSON values, a table with a sequence part is an
+// array and any other table an object.
+func fromLua(value lua.LValue) interface{} {
+	switch value := value.(type) {
+	case lua.LBool:
+		return bool(value)
+	case lua.LNumber:
+		return formatNumber(float64(value))
+	case lua.LString:
+		return string(value)
+	case *lua.LTable:
+		if n := value.MaxN(); n > 0 {
+			items := make([]interface{}, 0, n)
+			for i := 1; i <= n; i++ {
+				items = append(items, fromLua(value.RawGetInt(i)))
+			}
+			return items
+		}
+		fields := make(map[string]interface{})
+		value.ForEach(func(key, item lua.LValue) {
+			fields[key.String()] = fromLua(item)
+		})
+		return fields
+	}
+	return nil
+}
+
+// formatNumber writes integers without an exponent, so they decode into
+// integer fields.
+func formatNumber(f float64) json.Number {
+	if f == math.Trunc(f) && math.Abs(f) < 1<<63 {
+		return json.Number(strconv.FormatInt(int64(f), 10))
+	}
+	return json.Number(strconv.FormatFloat(f, 'g', -1, 64))
+}
+
+// merge returns after with the parts the hook didn't change, those equal
+// in before and after, taken from orig.
+func merge(orig, before, after interface{}) interface{} {
+	if reflect.DeepEqual(before, after) {
+		return orig
+	}
+	o, ok1 := orig.(map[string]interface{})
+	b, ok2 := before.(map[string]interface{})
+	a, ok3 := after.(map[string]interface{})
+	if !ok1 || !ok2 || !ok3 {
+		return after
+	}
+	merged := make(map[string]interface{}, len(a))
+	for key, value := range a {
+		if _, exists := b[key]; exists {
+			merged[key] = merge(o[key], b[key], value)
+		} else {
+			merged[key] = value
+		}
+	}
+	return merged
+}
@@ -0,0 +1,180 @@
+package script
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"sync/atomic"
+
+	"github.com/funny/link"
+)
+
+// Verdict is what a hook decided about a message. A message which is
+// neither dropped nor routed goes on to its handler.
+type Verdict struct {
+	Drop  bool
+	Route string // name of a handler added with Hooks.Route
+}
+
+// Engine runs the hooks of a script. Hooks see a message as the fields of
+// its JSON form and may change them in place, the message is rebuilt from
+// the fields after the hook. Engines are called from many goroutines.
+type Engine interface {
+	// Handles reports whether the script has a hook for messages of type
+	// name, other messages skip the JSON round trip.
+	Handles(name string) bool
+	Hook(session *link.Session, name string, fields map[string]interface{}) (Verdict, error)
+}
+
+// Hooks is a middleware which passes inbound messages through the hooks of
+// an Engine, for live changes like muting a message type or rewriting a
+// field without a new build. The engine can be swapped at runtime, a hook
+// which fails lets the message through unchanged.
+type Hooks struct {
+	engine  atomic.Pointer[engineBox]
+	routes  map[string]link.MessageHandler
+	dropped atomic.Uint64
+
+	// OnError is called when a hook fails, by default the error is logged.
+	OnError func(session *link.Session, name string, err error)
+}
+
+type engineBox struct {
+	Engine
+}
+
+func New(engine Engine) *Hooks {
+	h := &Hooks{routes: make(map[string]link.MessageHandler)}
+	h.Swap(engine)
+	return h
+}
+
+// Swap replaces the engine, nil turns the hooks off.
+func (h *Hooks) Swap(engine Engine) {
+	if engine == nil {
+		h.engine.Store(nil)
+		return
+	}
+	h.engine.Store(&engineBox{engine})
+}
+
+// Route names a handler which hooks can send messages to, routes are added
+// before the hooks are used.
+func (h *Hooks) Route(name string, handler link.MessageHandler) {
+	h.routes[name] = handler
+}
+
+// Dropped returns the number of messages dropped by hooks.
+func (h *Hooks) Dropped() uint64 {
+	return h.dropped.Load()
+}
+
+func (h *Hooks) Middleware() link.Middleware {
+	return func(next link.MessageHandler) link.MessageHandler {
+		return func(ctx context.Context, session *link.Session, msg interface{}) {
+			box := h.engine.Load()
+			if box == nil {
+				next(ctx, session, msg)
+				return
+			}
+			name := typeName(msg)
+			if !box.Handles(name) {
+				next(ctx, session, msg)
+				return
+			}
+			changed, verdict, err := h.run(box.Engine, session, name, msg)
+			if err != nil {
+				h.fail(session, name, err)
+				next(ctx, session, msg)
+				return
+			}
+			switch {
+			case verdict.Drop:
+				h.dropped.Add(1)
+			case verdict.Route != "":
+				handler, exists := h.routes[verdict.Route]
+				if !exists {
+					link.GetLogger().Warn("script: unknown route", "type", name, "route", verdict.Route)
+					next(ctx, session, changed)
+					return
+				}
+				handler(ctx, session, changed)
+			default:
+				next(ctx, session, changed)
+			}
+		}
+	}
+}
+
+func (h *Hooks) fail(session *link.Session, name string, err error) {
+	if h.OnError != nil {
+		h.OnError(session, name, err)
+		return
+	}
+	link.GetLogger().Warn("script: hook failed", "type", name, "error", err)
+}
+
+func (h *Hooks) run(engine Engine, session *link.Session, name string, msg interface{}) (interface{}, Verdict, error) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return nil, Verdict{}, err
+	}
+	// numbers stay json.Number, so large integers come back as they were.
+	var fields map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&fields); err != nil {
+		return nil, Verdict{}, err
+	}
+	verdict, err := engine.Hook(session, name, fields)
+	if err != nil || verdict.Drop {
+		return nil, verdict, err
+	}
+	changed, err := rebuild(msg, fields)
+	return changed, verdict, err
+}
+
+// rebuild makes a new message of the type of msg from fields, a pointer
+// when msg is one. The fields JSON doesn't see, unexported or tagged "-",
+// keep the values of msg. Exported fields of unexported embedded structs
+// are not rebuilt.
+func rebuild(msg interface{}, fields map[string]interface{}) (interface{}, error) {
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	orig := reflect.ValueOf(msg)
+	t := orig.Type()
+	isPtr := t.Kind() == reflect.Pointer
+	if isPtr {
+		t = t.Elem()
+		orig = orig.Elem()
+	}
+	v := reflect.New(t)
+	if err := json.Unmarshal(data, v.Interface()); err != nil {
+		return nil, err
+	}
+	if t.Kind() == reflect.Struct {
+		merged := reflect.New(t)
+		merged.Elem().Set(orig)
+		for i := 0; i < t.NumField(); i++ {
+			if f := t.Field(i); f.IsExported() && f.Tag.Get("json") != "-" {
+				merged.Elem().Field(i).Set(v.Elem().Field(i))
+			}
+		}
+		v = merged
+	}
+	if isPtr {
+		return v.Interface(), nil
+	}
+	return v.Elem().Interface(), nil
+}
+
+func typeName(msg interface{}) string {
+	t := reflect.TypeOf(msg)
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t.Name()
+}
@@ -0,0 +1,125 @@
+package script
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/utest"
+)
+
+type Chat struct{ Text string }
+type Move struct{ X, Y int }
+type Ping struct{}
+
+func Test_LuaHooks(t *testing.T) {
+	engine, err := NewLua("test", `
+		hooks.Chat = function(session, msg)
+			if msg.Text == "" then return "drop" end
+			msg.Text = string.upper(msg.Text)
+		end
+		hooks.Move = function(session, msg)
+			if msg.X < 0 then return "audit" end
+		end
+	`)
+	utest.IsNilNow(t, err)
+	defer engine.Close()
+
+	h := New(engine)
+	var audited []interface{}
+	h.Route("audit", func(ctx context.Context, session *link.Session, msg interface{}) {
+		audited = append(audited, msg)
+	})
+
+	var handled []interface{}
+	router := link.NewRouter()
+	router.Use(h.Middleware())
+	link.RegisterHandler(router, func(session *link.Session, msg *Chat) { handled = append(handled, msg) })
+	link.RegisterHandler(router, func(session *link.Session, msg *Move) { handled = append(handled, msg) })
+	link.RegisterHandler(router, func(session *link.Session, msg *Ping) { handled = append(handled, msg) })
+
+	session := link.NewSession(nil, 0)
+	router.Dispatch(session, &Chat{"hello"})
+	router.Dispatch(session, &Chat{""})
+	router.Dispatch(session, &Move{-1, 2})
+	router.Dispatch(session, &Move{1, 2})
+	router.Dispatch(session, &Ping{})
+	utest.EqualNow(t, handled, []interface{}{&Chat{"HELLO"}, &Move{1, 2}, &Ping{}})
+	utest.EqualNow(t, audited, []interface{}{&Move{-1, 2}})
+	utest.EqualNow(t, h.Dropped(), uint64(1))
+
+	// a failing hook lets the message through.
+	broken, err := NewLua("broken", `hooks.Chat = function(session, msg) error("boom") end`)
+	utest.IsNilNow(t, err)
+	defer broken.Close()
+	var failed int
+	h.OnError = func(session *link.Session, name string, err error) { failed++ }
+	h.Swap(broken)
+	router.Dispatch(session, &Chat{"hi"})
+	utest.EqualNow(t, failed, 1)
+	utest.EqualNow(t, handled[len(handled)-1], &Chat{"hi"})
+
+	h.Swap(nil)
+	router.Dispatch(session, &Chat{""})
+	utest.EqualNow(t, len(handled), 5)
+}
+
+func Test_LuaSyntaxError(t *testing.T) {
+	_, err := NewLua("bad", `hooks.Chat = function(`)
+	utest.NotNilNow(t, err)
+	_, err = NewLua("bad", `hooks = 1`)
+	utest.Assert(t, errors.Is(err, NoHooksError))
+}
+
+func Test_LuaSandbox(t *testing.T) {
+	for _, source := range []string{
+		`os.exit(1)`,
+		`io.open("/etc/passwd")`,
+		`dofile("/etc/passwd")`,
+		`loadfile("/etc/passwd")`,
+		`require("os")`,
+	} {
+		_, err := NewLua("sandbox", source)
+		utest.Assert(t, err != nil, source)
+	}
+	_, err := NewLua("sandbox", `hooks.Chat = function(session, msg) msg.Text = string.upper(table.concat({msg.Text}, "")) .. math.floor(1.5) end`)
+	utest.IsNilNow(t, err)
+}
+
+type Order struct {
+	ID     uint64
+	Price  float64
+	Note   string
+	Secret string `json:"-"`
+	cache  int
+}
+
+func Test_LuaKeepsFields(t *testing.T) {
+	engine, err := NewLua("keep", `
+		hooks.Order = function(session, msg) msg.Note = "seen" end
+		hooks.Chat = function(session, msg) while true do end end
+	`)
+	utest.IsNilNow(t, err)
+	defer engine.Close()
+	engine.Timeout = 10 * time.Millisecond
+
+	h := New(engine)
+	var failed int
+	h.OnError = func(session *link.Session, name string, err error) { failed++ }
+	var handled []interface{}
+	router := link.NewRouter()
+	router.Use(h.Middleware())
+	link.RegisterHandler(router, func(session *link.Session, msg *Order) { handled = append(handled, msg) })
+	link.RegisterHandler(router, func(session *link.Session, msg *Chat) { handled = append(handled, msg) })
+
+	session := link.NewSession(nil, 0)
+	router.Dispatch(session, &Order{ID: 1<<64 - 1, Price: 0.1, Secret: "s", cache: 7})
+	utest.EqualNow(t, handled, []interface{}{&Order{ID: 1<<64 - 1, Price: 0.1, Note: "seen", Secret: "s", cache: 7}})
+
+	// a hook which doesn't end is stopped and the message goes on.
+	router.Dispatch(session, &Chat{"hi"})
+	utest.EqualNow(t, failed, 1)
+	utest.EqualNow(t, handled[1], &Chat{"hi"})
+}
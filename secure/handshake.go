@@ -0,0 +1,93 @@
+package secure
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"io"
+	"net"
+	"time"
+
+	"github.com/funny/link"
+)
+
+var HandshakeError = link.NewError(link.ProtocolError, "Secure Handshake Failed")
+
+const handshakeVersion = 1
+
+// Handshake is a protocol which agrees on a fresh key with the peer before
+// the session starts, so no key is fixed in the code. Both ends send an
+// ephemeral X25519 public key, the key of the session is derived from the
+// shared secret, both public keys and PSK, and handed to Protocol, which
+// usually wraps New. Without a PSK the peer isn't authenticated, a man in
+// the middle who rewrites the handshake sees everything, so clients should
+// have one or check the peer inside the session.
+type Handshake struct {
+	Protocol func(key []byte) link.Protocol
+	PSK      []byte
+	Timeout  time.Duration // of the handshake on a net.Conn, default 10s
+}
+
+func NewHandshake(psk []byte, protocol func(key []byte) link.Protocol) *Handshake {
+	return &Handshake{Protocol: protocol, PSK: psk, Timeout: 10 * time.Second}
+}
+
+// NewCodec runs the handshake on rw before the protocol gets it, link.Server
+// does this in the goroutine of the connection. It blocks until the peer
+// answers, so link.Pipe can't use it.
+func (h *Handshake) NewCodec(rw io.ReadWriter) (link.Codec, error) {
+	if conn, ok := rw.(net.Conn); ok && h.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(h.Timeout))
+		defer conn.SetDeadline(time.Time{})
+	}
+	key, err := h.exchange(rw)
+	if err != nil {
+		return nil, err
+	}
+	return h.Protocol(key).NewCodec(rw)
+}
+
+func (h *Handshake) exchange(rw io.ReadWriter) ([]byte, error) {
+	private, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	public := private.PublicKey().Bytes()
+	hello := append([]byte{handshakeVersion}, public...)
+	if _, err := rw.Write(hello); err != nil {
+		return nil, err
+	}
+	if flusher, ok := rw.(interface{ Flush() error }); ok {
+		if err := flusher.Flush(); err != nil {
+			return nil, err
+		}
+	}
+	peerHello := make([]byte, len(hello))
+	if _, err := io.ReadFull(rw, peerHello); err != nil {
+		return nil, err
+	}
+	if peerHello[0] != handshakeVersion {
+		return nil, HandshakeError
+	}
+	peer, err := ecdh.X25519().NewPublicKey(peerHello[1:])
+	if err != nil {
+		return nil, HandshakeError
+	}
+	shared, err := private.ECDH(peer)
+	if err != nil {
+		return nil, HandshakeError
+	}
+	// both ends order the public keys the same way.
+	first, second := public, peerHello[1:]
+	if bytes.Compare(first, second) > 0 {
+		first, second = second, first
+	}
+	mac := hmac.New(sha256.New, h.PSK)
+	mac.Write([]byte("link x25519"))
+	mac.Write(shared)
+	mac.Write(first)
+	mac.Write(second)
+	return mac.Sum(nil), nil
+}
@@ -0,0 +1,273 @@
+package secure
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+
+	"github.com/funny/link"
+)
+
+var (
+	ReplayError  = link.NewError(link.ProtocolError, "Secure Frame Replayed")
+	DecryptError = link.NewError(link.ProtocolError, "Secure Frame Decrypt Failed")
+	NoSaltError  = link.NewError(link.ProtocolError, "Secure Salt Missing")
+	ReflectError = link.NewError(link.ProtocolError, "Secure Frame Reflected")
+)
+
+const (
+	flagSalt byte = 1 << iota
+	flagRotate
+)
+
+const (
+	saltSize   = 16
+	headSize   = 1 + 8
+	windowSize = 64
+)
+
+// Protocol encrypts every frame of base with AES-256-GCM. It expects one
+// frame per read, so it sits inside a framing protocol such as codec.FixLen.
+// Frames are encrypted for one session, link.Encoded broadcasts can't go
+// through it.
+//
+// Each direction of a session has a key of its own, derived from the key of
+// the Protocol and a random salt which comes along with the first frame. A
+// frame carries the counter of its nonce, a counter seen before or older than
+// the last 64 fails Receive with ReplayError, so captured frames can't be
+// injected again, also over transports which reorder. After RotateAfter
+// frames the sender moves on to a key derived from the current one and counts
+// from zero again, the frame which starts it tells the receiver to follow.
+// Both ends derive their keys the same way, what tells the directions apart is
+// the salt: a frame carrying the salt of the receiver was sealed under its own
+// send key and fails Receive with ReflectError, so frames can't be bounced
+// back to their sender.
+// A key shared by every session lets frames of one session be replayed into
+// another, Handshake gives each session a fresh key.
+type Protocol struct {
+	base link.Protocol
+	key  []byte
+
+	RotateAfter uint64 // default 1<<20 frames
+}
+
+func New(base link.Protocol, key []byte) *Protocol {
+	return &Protocol{base: base, key: key, RotateAfter: 1 << 20}
+}
+
+func (p *Protocol) NewCodec(rw io.ReadWriter) (link.Codec, error) {
+	c := &secureCodec{rw: rw, Protocol: p}
+	if _, err := rand.Read(c.salt[:]); err != nil {
+		return nil, err
+	}
+	c.sendKey = derive(p.key, c.salt[:])
+	var err error
+	if c.send, err = newAEAD(c.sendKey); err != nil {
+		return nil, err
+	}
+	if c.base, err = p.base.NewCodec(&c.plain); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func derive(key []byte, info []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(info)
+	return mac.Sum(nil)
+}
+
+func rotate(key []byte) []byte {
+	return derive(key, []byte("rotate"))
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+type secureCodec struct {
+	base link.Codec
+	rw   io.ReadWriter
+	*Protocol
+
+	plain plainBuffer
+
+	salt     [saltSize]byte
+	sendKey  []byte
+	send     cipher.AEAD
+	sendSeq  uint64
+	saltSent bool
+
+	recvKey []byte
+	recv    cipher.AEAD
+	window  replayWindow
+
+	head  []byte
+	frame []byte
+}
+
+// plainBuffer holds the plaintext of one frame for the base codec.
+type plainBuffer struct {
+	recv []byte
+	send []byte
+}
+
+func (b *plainBuffer) Read(p []byte) (int, error) {
+	if len(b.recv) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, b.recv)
+	b.recv = b.recv[n:]
+	return n, nil
+}
+
+func (b *plainBuffer) Write(p []byte) (int, error) {
+	b.send = append(b.send, p...)
+	return len(p), nil
+}
+
+func nonceOf(seq uint64) []byte {
+	var nonce [12]byte
+	binary.LittleEndian.PutUint64(nonce[4:], seq)
+	return nonce[:]
+}
+
+func (c *secureCodec) Send(msg interface{}) error {
+	var flags byte
+	if c.sendSeq >= c.RotateAfter {
+		c.sendKey = rotate(c.sendKey)
+		send, err := newAEAD(c.sendKey)
+		if err != nil {
+			return err
+		}
+		c.send, c.sendSeq = send, 0
+		flags |= flagRotate
+	}
+	c.plain.send = c.plain.send[:0]
+	if err := c.base.Send(msg); err != nil {
+		return err
+	}
+	return c.write(flags, c.plain.send)
+}
+
+// write sends one frame: flags, the counter, the salt with the first frame,
+// then the sealed plaintext, the header is authenticated as well.
+func (c *secureCodec) write(flags byte, plain []byte) error {
+	if !c.saltSent {
+		flags |= flagSalt
+	}
+	head := append(c.head[:0], flags)
+	head = binary.LittleEndian.AppendUint64(head, c.sendSeq)
+	if flags&flagSalt != 0 {
+		head = append(head, c.salt[:]...)
+	}
+	c.head = head
+	frame := c.send.Seal(append(c.frame[:0], head...), nonceOf(c.sendSeq), plain, head)
+	c.frame = frame
+	if _, err := c.rw.Write(frame); err != nil {
+		return err
+	}
+	c.sendSeq++
+	c.saltSent = true
+	return nil
+}
+
+func (c *secureCodec) Receive() (interface{}, error) {
+	frame, err := io.ReadAll(c.rw)
+	if err != nil {
+		return nil, err
+	}
+	if len(frame) < headSize {
+		return nil, io.ErrUnexpectedEOF
+	}
+	flags := frame[0]
+	seq := binary.LittleEndian.Uint64(frame[1:])
+	n := headSize
+	if flags&flagSalt != 0 {
+		if len(frame) < headSize+saltSize {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if c.recv == nil {
+			salt := frame[headSize : headSize+saltSize]
+			if hmac.Equal(salt, c.salt[:]) {
+				return nil, ReflectError
+			}
+			c.recvKey = derive(c.key, salt)
+			if c.recv, err = newAEAD(c.recvKey); err != nil {
+				return nil, err
+			}
+		}
+		n += saltSize
+	}
+	if c.recv == nil {
+		return nil, NoSaltError
+	}
+
+	// the first frame under the next key, nothing changes until it opens.
+	key, recv, window := c.recvKey, c.recv, c.window
+	if flags&flagRotate != 0 {
+		key = rotate(key)
+		if recv, err = newAEAD(key); err != nil {
+			return nil, err
+		}
+		window = replayWindow{}
+	}
+	if !window.check(seq) {
+		return nil, ReplayError
+	}
+	plain, err := recv.Open(nil, nonceOf(seq), frame[n:], frame[:n])
+	if err != nil {
+		return nil, DecryptError
+	}
+	window.mark(seq)
+	c.recvKey, c.recv, c.window = key, recv, window
+
+	c.plain.recv = plain
+	return c.base.Receive()
+}
+
+func (c *secureCodec) Close() error {
+	return c.base.Close()
+}
+
+// replayWindow remembers the highest counter received and which of the 64
+// counters below it were seen.
+type replayWindow struct {
+	started bool
+	top     uint64
+	seen    uint64
+}
+
+func (w *replayWindow) check(seq uint64) bool {
+	switch {
+	case !w.started || seq > w.top:
+		return true
+	case w.top-seq >= windowSize:
+		return false
+	}
+	return w.seen&(1<<(w.top-seq)) == 0
+}
+
+func (w *replayWindow) mark(seq uint64) {
+	switch {
+	case !w.started:
+		w.started, w.top, w.seen = true, seq, 1
+	case seq > w.top:
+		if shift := seq - w.top; shift < windowSize {
+			w.seen = w.seen<<shift | 1
+		} else {
+			w.seen = 1
+		}
+		w.top = seq
+	default:
+		w.seen |= 1 << (w.top - seq)
+	}
+}
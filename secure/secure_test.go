@@ -0,0 +1,181 @@
+package secure
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/funny/link"
+	"github.com/funny/link/codec"
+	"github.com/funny/utest"
+)
+
+type Move struct {
+	X, Y int
+}
+
+func protocol(rotateAfter uint64) link.Protocol {
+	json := codec.Json()
+	json.Register(Move{})
+	p := New(json, []byte("shared secret"))
+	p.RotateAfter = rotateAfter
+	return codec.FixLen(p, 4, binary.LittleEndian, 64*1024, 64*1024)
+}
+
+func Test_Session(t *testing.T) {
+	a, b, err := link.Pipe(protocol(3), 0)
+	utest.IsNilNow(t, err)
+	defer a.Close()
+
+	// past a few rotations in both directions.
+	for i := 0; i < 10; i++ {
+		utest.IsNilNow(t, a.Send(&Move{i, -i}))
+		utest.IsNilNow(t, b.Send(&Move{-i, i}))
+		msg, err := b.Receive()
+		utest.IsNilNow(t, err)
+		utest.EqualNow(t, *msg.(*Move), Move{i, -i})
+		msg, err = a.Receive()
+		utest.IsNilNow(t, err)
+		utest.EqualNow(t, *msg.(*Move), Move{-i, i})
+	}
+}
+
+// frames sends n moves and returns their frames.
+func frames(t *testing.T, protocol link.Protocol, n int) [][]byte {
+	var stream bytes.Buffer
+	c, err := protocol.NewCodec(&stream)
+	utest.IsNilNow(t, err)
+	var result [][]byte
+	for i := 0; i < n; i++ {
+		utest.IsNilNow(t, c.Send(&Move{i, i}))
+		result = append(result, append([]byte(nil), stream.Bytes()...))
+		stream.Reset()
+	}
+	return result
+}
+
+func receive(protocol link.Protocol, frames ...[]byte) ([]int, error) {
+	c, _ := protocol.NewCodec(bytes.NewBuffer(bytes.Join(frames, nil)))
+	var got []int
+	for range frames {
+		msg, err := c.Receive()
+		if err != nil {
+			return got, err
+		}
+		got = append(got, msg.(*Move).X)
+	}
+	return got, nil
+}
+
+func Test_Replay(t *testing.T) {
+	p := protocol(1 << 20)
+	f := frames(t, p, 4)
+
+	// reordered frames are fine, a copy is not.
+	got, err := receive(p, f[0], f[2], f[1], f[3])
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, got, []int{0, 2, 1, 3})
+	got, err = receive(p, f[0], f[1], f[2], f[1])
+	utest.EqualNow(t, got, []int{0, 1, 2})
+	utest.Assert(t, errors.Is(err, ReplayError))
+
+	// the key comes from the salt of the first frame.
+	_, err = receive(p, f[1])
+	utest.Assert(t, errors.Is(err, NoSaltError))
+
+	// the header is authenticated.
+	tampered := append([]byte(nil), f[1]...)
+	tampered[5]++
+	_, err = receive(p, f[0], tampered)
+	utest.Assert(t, errors.Is(err, DecryptError))
+
+	// a rotation can't be replayed either.
+	rotating := frames(t, protocol(2), 4)
+	got, err = receive(protocol(2), rotating...)
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, got, []int{0, 1, 2, 3})
+	_, err = receive(protocol(2), rotating[0], rotating[1], rotating[2], rotating[2])
+	utest.Assert(t, errors.Is(err, DecryptError))
+}
+
+func Test_Reflect(t *testing.T) {
+	// a codec reading back what it wrote.
+	var loop bytes.Buffer
+	c, err := protocol(2).NewCodec(&loop)
+	utest.IsNilNow(t, err)
+	for i := 0; i < 3; i++ {
+		utest.IsNilNow(t, c.Send(&Move{i, i}))
+	}
+	_, err = c.Receive()
+	utest.Assert(t, errors.Is(err, ReflectError))
+}
+
+func Test_Window(t *testing.T) {
+	var w replayWindow
+	for _, seq := range []uint64{5, 3, 70, 10} {
+		utest.Assert(t, w.check(seq))
+		w.mark(seq)
+	}
+	utest.Assert(t, !w.check(70))
+	utest.Assert(t, !w.check(10))
+	utest.Assert(t, !w.check(5))
+	utest.Assert(t, w.check(7))
+	utest.Assert(t, w.check(71))
+}
+
+func handshake(psk string) link.Protocol {
+	json := codec.Json()
+	json.Register(Move{})
+	return NewHandshake([]byte(psk), func(key []byte) link.Protocol {
+		return codec.FixLen(New(json, key), 4, binary.LittleEndian, 64*1024, 64*1024)
+	})
+}
+
+// connect runs the handshakes of both ends at once.
+func connect(t *testing.T, p1, p2 link.Protocol) (*link.Session, *link.Session) {
+	conn1, conn2 := link.PipeConn()
+	done := make(chan link.Codec)
+	go func() {
+		c, err := p2.NewCodec(conn2)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- c
+	}()
+	c1, err := p1.NewCodec(conn1)
+	utest.IsNilNow(t, err)
+	return link.NewSession(c1, 0), link.NewSession(<-done, 0)
+}
+
+func Test_Handshake(t *testing.T) {
+	a, b := connect(t, handshake("psk"), handshake("psk"))
+	defer a.Close()
+	utest.IsNilNow(t, a.Send(&Move{1, 2}))
+	msg, err := b.Receive()
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, *msg.(*Move), Move{1, 2})
+	utest.IsNilNow(t, b.Send(&Move{3, 4}))
+	msg, err = a.Receive()
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, *msg.(*Move), Move{3, 4})
+
+	// every session gets a key of its own.
+	var keys [][]byte
+	for i := 0; i < 2; i++ {
+		conn1, conn2 := link.PipeConn()
+		h := NewHandshake(nil, nil)
+		go h.exchange(conn2)
+		key, err := h.exchange(conn1)
+		utest.IsNilNow(t, err)
+		keys = append(keys, key)
+	}
+	utest.Assert(t, !bytes.Equal(keys[0], keys[1]))
+
+	// a wrong PSK shows with the first frame.
+	a, b = connect(t, handshake("psk"), handshake("other"))
+	defer a.Close()
+	utest.IsNilNow(t, a.Send(&Move{1, 2}))
+	_, err = b.Receive()
+	utest.Assert(t, errors.Is(err, DecryptError))
+}
@@ -0,0 +1,137 @@
+package link
+
+import "sync/atomic"
+
+// sendQueue is the bounded send queue of async sessions. It is a ring of
+// slots with sequence numbers: producers claim a slot by moving tail, the
+// send loop by moving head, so Send takes no lock and allocates nothing. The
+// consumer side is safe for more than one goroutine too, Close drains the
+// queue while the send loop may still run.
+type sendQueue struct {
+	slots   []sendSlot
+	size    uint64
+	cap     uint64
+	head    atomic.Uint64
+	tail    atomic.Uint64
+	waiting atomic.Int32
+	wake    chan struct{}
+}
+
+type sendSlot struct {
+	seq atomic.Uint64
+	msg interface{}
+}
+
+func newSendQueue(size int) *sendQueue {
+	// one slot can't tell full from empty by its sequence number.
+	n := max(size, 2)
+	q := &sendQueue{
+		slots: make([]sendSlot, n),
+		size:  uint64(n),
+		cap:   uint64(size),
+		wake:  make(chan struct{}, 1),
+	}
+	for i := range q.slots {
+		q.slots[i].seq.Store(uint64(i))
+	}
+	return q
+}
+
+// push returns false when the queue is full.
+func (q *sendQueue) push(msg interface{}) bool {
+	pos := q.tail.Load()
+	for {
+		slot := &q.slots[pos%q.size]
+		diff := int64(slot.seq.Load() - pos)
+		if diff == 0 {
+			if q.cap < q.size && pos-q.head.Load() >= q.cap {
+				return false
+			}
+			if q.tail.CompareAndSwap(pos, pos+1) {
+				slot.msg = msg
+				slot.seq.Store(pos + 1)
+				break
+			}
+		} else if diff < 0 {
+			return false
+		}
+		pos = q.tail.Load()
+	}
+	if q.waiting.Load() == 1 && q.waiting.CompareAndSwap(1, 0) {
+		select {
+		case q.wake <- struct{}{}:
+		default:
+		}
+	}
+	return true
+}
+
+func (q *sendQueue) pop() (interface{}, bool) {
+	pos := q.head.Load()
+	for {
+		slot := &q.slots[pos%q.size]
+		diff := int64(slot.seq.Load() - (pos + 1))
+		if diff == 0 {
+			if q.head.CompareAndSwap(pos, pos+1) {
+				msg := slot.msg
+				slot.msg = nil
+				slot.seq.Store(pos + q.size)
+				return msg, true
+			}
+		} else if diff < 0 {
+			return nil, false
+		}
+		pos = q.head.Load()
+	}
+}
+
+// next blocks until a message is queued, it returns false once done is
+// closed.
+func (q *sendQueue) next(done <-chan int) (interface{}, bool) {
+	for {
+		if msg, ok := q.pop(); ok {
+			return msg, true
+		}
+		q.waiting.Store(1)
+		// a push between the pop above and the store would not wake us.
+		if msg, ok := q.pop(); ok {
+			q.waiting.Store(0)
+			return msg, true
+		}
+		select {
+		case <-q.wake:
+		case <-done:
+			return nil, false
+		}
+	}
+}
+
+// drain moves the queued messages to a closed channel, for ClearSendChan,
+// without the expired ones.
+func (q *sendQueue) drain() <-chan interface{} {
+	ch := make(chan interface{}, q.len())
+	for {
+		msg, ok := q.pop()
+		if !ok {
+			break
+		}
+		if msg, ok = unwrapExpiring(msg); !ok {
+			continue
+		}
+		select {
+		case ch <- msg:
+		default:
+			// the send loop was racing us, the rest is dropped.
+		}
+	}
+	close(ch)
+	return ch
+}
+
+func (q *sendQueue) len() int {
+	n := int64(q.tail.Load() - q.head.Load())
+	if n < 0 {
+		return 0
+	}
+	return min(int(n), int(q.cap))
+}
@@ -1,6 +1,10 @@
 package link
 
-import "net"
+import (
+	"io"
+	"net"
+	"sync"
+)
 
 type Server struct {
 	manager      *Manager
@@ -8,6 +12,10 @@ type Server struct {
 	protocol     Protocol
 	handler      Handler
 	sendChanSize int
+	config       *LiveConfig
+
+	maintenanceMutex sync.Mutex
+	maintenance      *maintenance
 }
 
 type Handler interface {
@@ -22,14 +30,52 @@ func (f HandlerFunc) HandleSession(session *Session) {
 	f(session)
 }
 
+type ServerOption func(*Server)
+
+// WithSendChanSize makes the sessions send asynchronously, see NewSession.
+func WithSendChanSize(n int) ServerOption {
+	return func(server *Server) { server.sendChanSize = n }
+}
+
+// WithManager puts the sessions into manager instead of a new one.
+func WithManager(manager *Manager) ServerOption {
+	return func(server *Server) { server.manager = manager }
+}
+
+// WithConfig applies live to the sessions, see Configured.
+func WithConfig(live *LiveConfig) ServerOption {
+	return func(server *Server) { server.config = live }
+}
+
 func NewServer(listener net.Listener, protocol Protocol, sendChanSize int, handler Handler) *Server {
-	return &Server{
-		manager:      NewManager(),
-		listener:     listener,
-		protocol:     protocol,
-		handler:      handler,
-		sendChanSize: sendChanSize,
+	return NewServerWith(listener, protocol, handler, WithSendChanSize(sendChanSize))
+}
+
+func NewServerWith(listener net.Listener, protocol Protocol, handler Handler, opts ...ServerOption) *Server {
+	server := &Server{
+		listener: listener,
+		protocol: protocol,
+		handler:  handler,
+	}
+	for _, opt := range opts {
+		opt(server)
 	}
+	if server.manager == nil {
+		server.manager = NewManager()
+	}
+	if server.config != nil {
+		server.protocol = Configured(server.protocol, server.config)
+	}
+	return server
+}
+
+func (server *Server) Manager() *Manager {
+	return server.manager
+}
+
+// Config returns the config given by WithConfig, nil without.
+func (server *Server) Config() *LiveConfig {
+	return server.config
 }
 
 func (server *Server) Listener() net.Listener {
@@ -40,16 +86,29 @@ func (server *Server) Serve() error {
 	for {
 		conn, err := Accept(server.listener)
 		if err != nil {
+			if err != io.EOF {
+				GetLogger().Error("link: accept failed", "addr", server.listener.Addr(), "error", err)
+			}
 			return err
 		}
 
+		if server.InMaintenance() {
+			GetLogger().Debug("link: refused in maintenance", "remote", conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
+
 		go func() {
 			codec, err := server.protocol.NewCodec(conn)
 			if err != nil {
+				GetLogger().Warn("link: new codec failed", "remote", conn.RemoteAddr(), "error", err)
 				conn.Close()
 				return
 			}
-			session := server.manager.NewSession(codec, server.sendChanSize)
+			session := newSession(server.manager, codec, server.sendChanSize)
+			session.remoteAddr = conn.RemoteAddr()
+			server.manager.putSession(session)
+			GetLogger().Debug("link: session opened", "session", session.ID(), "remote", conn.RemoteAddr())
 			server.handler.HandleSession(session)
 		}()
 	}
@@ -59,7 +118,12 @@ func (server *Server) GetSession(sessionID uint64) *Session {
 	return server.manager.GetSession(sessionID)
 }
 
+func (server *Server) Multicast(msg interface{}, ids []uint64) int {
+	return server.manager.Multicast(msg, ids)
+}
+
 func (server *Server) Stop() {
+	server.LeaveMaintenance()
 	server.listener.Close()
 	server.manager.Dispose()
 }
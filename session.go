@@ -1,23 +1,35 @@
 package link
 
 import (
-	"errors"
+	"io"
+	"net"
 	"sync"
 	"sync/atomic"
 )
 
-var SessionClosedError = errors.New("Session Closed")
-var SessionBlockedError = errors.New("Session Blocked")
+var SessionClosedError = NewError(TransportError, "Session Closed")
+var SessionBlockedError = NewError(PolicyError, "Session Blocked")
 
 var globalSessionId uint64
 
 type Session struct {
-	id        uint64
-	codec     Codec
-	manager   *Manager
-	sendChan  chan interface{}
-	recvMutex sync.Mutex
-	sendMutex sync.RWMutex
+	id         uint64
+	codec      Codec
+	remoteAddr net.Addr
+	manager    *Manager
+	sendQueue  *sendQueue
+	recvMutex  sync.Mutex
+	sendMutex  sync.RWMutex
+
+	expired    atomic.Uint64
+	watermarks atomic.Pointer[Watermarks]
+	congested  atomic.Bool
+
+	clockOffset atomic.Int64
+	rtt         atomic.Int64
+
+	labelMutex sync.Mutex
+	labels     map[string]string
 
 	closeFlag          int32
 	closeChan          chan int
@@ -40,7 +52,7 @@ func newSession(manager *Manager, codec Codec, sendChanSize int) *Session {
 		id:        atomic.AddUint64(&globalSessionId, 1),
 	}
 	if sendChanSize > 0 {
-		session.sendChan = make(chan interface{}, sendChanSize)
+		session.sendQueue = newSendQueue(sendChanSize)
 		go session.sendLoop()
 	}
 	return session
@@ -50,6 +62,12 @@ func (session *Session) ID() uint64 {
 	return session.id
 }
 
+// RemoteAddr returns the peer address of sessions created by Server, Dial
+// and DialTimeout, nil for the others.
+func (session *Session) RemoteAddr() net.Addr {
+	return session.remoteAddr
+}
+
 func (session *Session) IsClosed() bool {
 	return atomic.LoadInt32(&session.closeFlag) == 1
 }
@@ -58,16 +76,16 @@ func (session *Session) Close() error {
 	if atomic.CompareAndSwapInt32(&session.closeFlag, 0, 1) {
 		close(session.closeChan)
 
-		if session.sendChan != nil {
+		if session.sendQueue != nil {
 			session.sendMutex.Lock()
-			close(session.sendChan)
 			if clear, ok := session.codec.(ClearSendChan); ok {
-				clear.ClearSendChan(session.sendChan)
+				clear.ClearSendChan(session.sendQueue.drain())
 			}
 			session.sendMutex.Unlock()
 		}
 
 		err := session.codec.Close()
+		GetLogger().Debug("link: session closed", "session", session.id)
 
 		go func() {
 			session.invokeCloseCallbacks()
@@ -81,6 +99,20 @@ func (session *Session) Close() error {
 	return SessionClosedError
 }
 
+// SendQueueLen returns the number of messages waiting in the send queue.
+func (session *Session) SendQueueLen() int {
+	if session.sendQueue == nil {
+		return 0
+	}
+	return session.sendQueue.len()
+}
+
+// Expired returns the number of messages dropped because their TTL passed
+// before they were sent, see WithTTL.
+func (session *Session) Expired() uint64 {
+	return session.expired.Load()
+}
+
 func (session *Session) Codec() Codec {
 	return session.codec
 }
@@ -91,27 +123,51 @@ func (session *Session) Receive() (interface{}, error) {
 
 	msg, err := session.codec.Receive()
 	if err != nil {
+		if !session.IsClosed() && err != io.EOF {
+			GetLogger().Warn("link: receive failed", "session", session.id, "error", err)
+		}
 		session.Close()
 	}
 	return msg, err
 }
 
+// ReceiveBatch appends the next message and those already buffered by the
+// codec to msgs, at most max. See BatchCodec.
+func (session *Session) ReceiveBatch(msgs []interface{}, max int) ([]interface{}, error) {
+	session.recvMutex.Lock()
+	defer session.recvMutex.Unlock()
+
+	msgs, err := ReceiveBatch(session.codec, msgs, max)
+	if err != nil {
+		if !session.IsClosed() && err != io.EOF {
+			GetLogger().Warn("link: receive failed", "session", session.id, "error", err)
+		}
+		session.Close()
+	}
+	return msgs, err
+}
+
 func (session *Session) sendLoop() {
 	defer session.Close()
 	for {
-		select {
-		case msg, ok := <-session.sendChan:
-			if !ok || session.codec.Send(msg) != nil {
-				return
-			}
-		case <-session.closeChan:
+		msg, ok := session.sendQueue.next(session.closeChan)
+		if !ok {
+			return
+		}
+		session.checkLow()
+		if msg, ok = unwrapExpiring(msg); !ok {
+			session.expired.Add(1)
+			continue
+		}
+		if err := session.codec.Send(msg); err != nil {
+			GetLogger().Warn("link: send failed", "session", session.id, "error", err)
 			return
 		}
 	}
 }
 
 func (session *Session) Send(msg interface{}) error {
-	if session.sendChan == nil {
+	if session.sendQueue == nil {
 		if session.IsClosed() {
 			return SessionClosedError
 		}
@@ -119,8 +175,14 @@ func (session *Session) Send(msg interface{}) error {
 		session.sendMutex.Lock()
 		defer session.sendMutex.Unlock()
 
+		msg, ok := unwrapExpiring(msg)
+		if !ok {
+			session.expired.Add(1)
+			return nil
+		}
 		err := session.codec.Send(msg)
 		if err != nil {
+			GetLogger().Warn("link: send failed", "session", session.id, "error", err)
 			session.Close()
 		}
 		return err
@@ -132,15 +194,15 @@ func (session *Session) Send(msg interface{}) error {
 		return SessionClosedError
 	}
 
-	select {
-	case session.sendChan <- msg:
+	if session.sendQueue.push(msg) {
 		session.sendMutex.RUnlock()
+		session.checkHigh()
 		return nil
-	default:
-		session.sendMutex.RUnlock()
-		session.Close()
-		return SessionBlockedError
 	}
+	session.sendMutex.RUnlock()
+	GetLogger().Warn("link: send channel full, closing session", "session", session.id, "size", session.sendQueue.cap)
+	session.Close()
+	return SessionBlockedError
 }
 
 type closeCallback struct {
@@ -150,14 +212,18 @@ type closeCallback struct {
 	Next    *closeCallback
 }
 
-func (session *Session) AddCloseCallback(handler, key interface{}, callback func()) {
-	if session.IsClosed() {
-		return
-	}
-
+// AddCloseCallback registers callback to run once the session closes, it
+// reports false without registering it when the session is already closed.
+func (session *Session) AddCloseCallback(handler, key interface{}, callback func()) bool {
 	session.closeMutex.Lock()
 	defer session.closeMutex.Unlock()
 
+	// Close sets the flag before it takes the mutex to collect the callbacks,
+	// so a callback added while the flag is clear is always run.
+	if session.IsClosed() {
+		return false
+	}
+
 	newItem := &closeCallback{handler, key, callback, nil}
 
 	if session.firstCloseCallback == nil {
@@ -166,16 +232,17 @@ func (session *Session) AddCloseCallback(handler, key interface{}, callback func
 		session.lastCloseCallback.Next = newItem
 	}
 	session.lastCloseCallback = newItem
+	return true
 }
 
 func (session *Session) RemoveCloseCallback(handler, key interface{}) {
+	session.closeMutex.Lock()
+	defer session.closeMutex.Unlock()
+
 	if session.IsClosed() {
 		return
 	}
 
-	session.closeMutex.Lock()
-	defer session.closeMutex.Unlock()
-
 	var prev *closeCallback
 	for callback := session.firstCloseCallback; callback != nil; prev, callback = callback, callback.Next {
 		if callback.Handler == handler && callback.Key == key {
@@ -192,11 +259,15 @@ func (session *Session) RemoveCloseCallback(handler, key interface{}) {
 	}
 }
 
+// invokeCloseCallbacks runs the callbacks without the mutex, so they may
+// take locks which are held around AddCloseCallback. The list doesn't change
+// once the session is closed.
 func (session *Session) invokeCloseCallbacks() {
 	session.closeMutex.Lock()
-	defer session.closeMutex.Unlock()
+	first := session.firstCloseCallback
+	session.closeMutex.Unlock()
 
-	for callback := session.firstCloseCallback; callback != nil; callback = callback.Next {
+	for callback := first; callback != nil; callback = callback.Next {
 		callback.Func()
 	}
 }
@@ -2,13 +2,21 @@ package link
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
 	"math/rand"
+	"net"
+	"runtime"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/funny/link/clock"
 	"github.com/funny/utest"
 )
 
@@ -27,6 +35,10 @@ type TestCodec struct {
 }
 
 func (c *TestCodec) Send(msg interface{}) error {
+	if encoded, ok := msg.(Encoded); ok {
+		_, err := c.rw.Write(encoded)
+		return err
+	}
 	var head [2]byte
 	binary.LittleEndian.PutUint16(head[:], uint16(len(msg.([]byte))))
 	_, err := c.rw.Write(head[:])
@@ -143,6 +155,17 @@ func Test_CloseCallback(t *testing.T) {
 	}
 }
 
+func Test_CloseCallbackClosed(t *testing.T) {
+	a, b, err := Pipe(ProtocolFunc(NewTestCodec), 0)
+	utest.IsNilNow(t, err)
+	defer b.Close()
+	c := make(chan int, 1)
+	utest.Assert(t, a.AddCloseCallback(nil, nil, func() { c <- 1 }))
+	a.Close()
+	utest.EqualNow(t, <-c, 1)
+	utest.Assert(t, !a.AddCloseCallback(nil, nil, func() { c <- 2 }))
+}
+
 func Test_Sync(t *testing.T) {
 	SessionTest(t, 0, BytesTest)
 }
@@ -151,6 +174,79 @@ func Test_Async(t *testing.T) {
 	SessionTest(t, 1024, BytesTest)
 }
 
+func Test_Pipe(t *testing.T) {
+	client, server, err := Pipe(ProtocolFunc(NewTestCodec), 0)
+	utest.IsNilNow(t, err)
+
+	for i := 0; i < 100; i++ {
+		msg := RandBytes(512)
+		utest.IsNilNow(t, client.Send(msg))
+		recv, err := server.Receive()
+		utest.IsNilNow(t, err)
+		utest.Assert(t, bytes.Equal(msg, recv.([]byte)))
+	}
+
+	client.Close()
+	_, err = server.Receive()
+	utest.NotNilNow(t, err)
+	utest.Assert(t, server.IsClosed())
+}
+
+func Test_Migrate(t *testing.T) {
+	protocol := Migratable(ProtocolFunc(NewTestCodec), time.Second)
+
+	newPair := func() (*Session, *Session) {
+		conn1, conn2 := PipeConn()
+		codec1, err := protocol.NewCodec(conn1)
+		utest.IsNilNow(t, err)
+		codec2, err := protocol.NewCodec(conn2)
+		utest.IsNilNow(t, err)
+		return NewSession(codec1, 0), NewSession(codec2, 0)
+	}
+
+	client, server := newPair()
+	msg1 := RandBytes(512)
+	utest.IsNilNow(t, client.Send(msg1))
+	recv, err := server.Receive()
+	utest.IsNilNow(t, err)
+	utest.Assert(t, bytes.Equal(msg1, recv.([]byte)))
+
+	// break the connection and keep sending while the client reconnects.
+	client.codec.(*migratableCodec).conn.conn.Close()
+	msg2 := RandBytes(512)
+	sendDone := make(chan error, 1)
+	go func() {
+		sendDone <- client.Send(msg2)
+	}()
+
+	newClient, newServer := newPair()
+	utest.IsNilNow(t, client.Migrate(newClient))
+	utest.IsNilNow(t, server.Migrate(newServer))
+	utest.IsNilNow(t, <-sendDone)
+
+	recv, err = server.Receive()
+	utest.IsNilNow(t, err)
+	utest.Assert(t, bytes.Equal(msg2, recv.([]byte)))
+	utest.Assert(t, !client.IsClosed())
+	utest.Assert(t, newClient.IsClosed())
+}
+
+func Test_MigrateBrokenFrame(t *testing.T) {
+	protocol := Migratable(ProtocolFunc(NewTestCodec), time.Second)
+	conn1, conn2 := PipeConn()
+	codec, err := protocol.NewCodec(conn2)
+	utest.IsNilNow(t, err)
+	server := NewSession(codec, 0)
+
+	// a message cut off by the conn loss can't go on on another conn.
+	go func() {
+		conn1.Write([]byte{0, 2})
+		conn1.Close()
+	}()
+	_, err = server.Receive()
+	utest.EqualNow(t, err, FrameBrokenError)
+}
+
 func Test_Channel(t *testing.T) {
 	waitTestDone := make(chan struct{})
 
@@ -220,6 +316,244 @@ func Test_Channel(t *testing.T) {
 	server.Stop()
 }
 
+func Test_ChannelBroadcast(t *testing.T) {
+	channel := NewChannel()
+	peers := make([]*Session, 10)
+	members := make([]*Session, 10)
+	for i := 0; i < 10; i++ {
+		peer, member, err := Pipe(ProtocolFunc(NewTestCodec), 0)
+		utest.IsNilNow(t, err)
+		peers[i], members[i] = peer, member
+		channel.Join(member)
+	}
+	utest.EqualNow(t, channel.Len(), 10)
+
+	msg := RandBytes(128)
+	utest.EqualNow(t, channel.Broadcast(msg), 0)
+	for _, peer := range peers {
+		recv, err := peer.Receive()
+		utest.IsNilNow(t, err)
+		utest.Assert(t, bytes.Equal(msg, recv.([]byte)))
+	}
+
+	utest.Assert(t, channel.Leave(members[0]))
+	utest.Assert(t, !channel.Leave(members[0]))
+	utest.EqualNow(t, channel.Len(), 9)
+
+	members[1].Close()
+	for channel.Len() != 8 {
+		time.Sleep(time.Millisecond)
+	}
+
+	channel.Close()
+	utest.EqualNow(t, channel.Len(), 0)
+}
+
+func Test_ChannelEvents(t *testing.T) {
+	channel := NewChannel()
+
+	var events []ChannelEvent
+	channel.SetEventHandler(func(event ChannelEvent) {
+		events = append(events, event)
+	})
+
+	_, session1, _ := Pipe(ProtocolFunc(NewTestCodec), 0)
+	_, session2, _ := Pipe(ProtocolFunc(NewTestCodec), 0)
+
+	channel.Put("a", session1)
+	channel.Put("b", session2)
+	channel.Put("a", session2)
+
+	snapshot := channel.Snapshot()
+	utest.EqualNow(t, len(snapshot), 2)
+	utest.EqualNow(t, snapshot["a"], session2)
+
+	channel.Remove("b")
+	utest.EqualNow(t, len(channel.Keys()), 1)
+
+	expect := []ChannelEvent{
+		{ChannelJoin, "a", session1},
+		{ChannelJoin, "b", session2},
+		{ChannelLeave, "a", session1},
+		{ChannelJoin, "a", session2},
+		{ChannelLeave, "b", session2},
+	}
+	utest.EqualNow(t, len(events), len(expect))
+	for i := range expect {
+		utest.EqualNow(t, events[i], expect[i])
+	}
+}
+
+func Test_BroadcastExceptAndMulticast(t *testing.T) {
+	manager := NewManager()
+	defer manager.Dispose()
+
+	channel := NewChannel()
+	peers := make([]*Session, 5)
+	members := make([]*Session, 5)
+	for i := 0; i < 5; i++ {
+		conn1, conn2 := PipeConn()
+		codec1, _ := NewTestCodec(conn1)
+		codec2, _ := NewTestCodec(conn2)
+		peers[i] = NewSession(codec1, 0)
+		members[i] = manager.NewSession(codec2, 0)
+		channel.Join(members[i])
+	}
+
+	msg1 := RandBytes(128)
+	utest.EqualNow(t, channel.Broadcast(msg1, members[0]), 0)
+
+	msg2 := RandBytes(128)
+	utest.EqualNow(t, manager.Multicast(msg2, []uint64{members[0].ID(), members[3].ID(), 0}), 1)
+
+	for i, peer := range peers {
+		if i != 0 {
+			recv, err := peer.Receive()
+			utest.IsNilNow(t, err)
+			utest.Assert(t, bytes.Equal(msg1, recv.([]byte)))
+		}
+		if i == 0 || i == 3 {
+			recv, err := peer.Receive()
+			utest.IsNilNow(t, err)
+			utest.Assert(t, bytes.Equal(msg2, recv.([]byte)))
+		}
+	}
+	channel.Close()
+}
+
+func Test_ShardedChannel(t *testing.T) {
+	channel := NewShardedChannel(4)
+	peers := make([]*Session, 20)
+	for i := 0; i < 20; i++ {
+		peer, member, err := Pipe(ProtocolFunc(NewTestCodec), 0)
+		utest.IsNilNow(t, err)
+		peers[i] = peer
+		channel.Join(member)
+	}
+	utest.EqualNow(t, channel.Len(), 20)
+
+	msg := RandBytes(128)
+	utest.EqualNow(t, channel.Broadcast(msg), 0)
+	for _, peer := range peers {
+		recv, err := peer.Receive()
+		utest.IsNilNow(t, err)
+		utest.Assert(t, bytes.Equal(msg, recv.([]byte)))
+	}
+
+	stats := channel.Stats()
+	utest.EqualNow(t, stats.Members, 20)
+	utest.EqualNow(t, stats.Broadcasts, uint64(1))
+	utest.EqualNow(t, stats.Sent, uint64(20))
+	channel.Close()
+	utest.EqualNow(t, channel.Len(), 0)
+}
+
+func Test_ShardedChannelExcept(t *testing.T) {
+	channel := NewShardedChannel(0)
+	members := make([]*Session, 2)
+	for i := range members {
+		_, member, err := Pipe(ProtocolFunc(NewTestCodec), 1)
+		utest.IsNilNow(t, err)
+		members[i] = member
+		channel.Join(member)
+	}
+	utest.EqualNow(t, channel.Broadcast(RandBytes(8), members[0]), 0)
+	utest.EqualNow(t, channel.Stats().Sent, uint64(1))
+	channel.Close()
+}
+
+func Test_Broadcaster(t *testing.T) {
+	broadcaster, err := NewBroadcaster(ProtocolFunc(NewTestCodec))
+	utest.IsNilNow(t, err)
+
+	channel := NewChannel()
+	peers := make([]*Session, 10)
+	for i := 0; i < 10; i++ {
+		peer, member, err := Pipe(ProtocolFunc(NewTestCodec), 0)
+		utest.IsNilNow(t, err)
+		peers[i] = peer
+		channel.Join(member)
+	}
+
+	msg := RandBytes(128)
+	failed, err := broadcaster.Broadcast(channel, msg)
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, failed, 0)
+	for _, peer := range peers {
+		recv, err := peer.Receive()
+		utest.IsNilNow(t, err)
+		utest.Assert(t, bytes.Equal(msg, recv.([]byte)))
+	}
+	channel.Close()
+}
+
+func Test_Fanout(t *testing.T) {
+	fanout := NewFanout(4, 128, 100*time.Millisecond)
+	defer fanout.Close()
+
+	channel := NewChannel()
+	peers := make([]*Session, 10)
+	for i := 0; i < 10; i++ {
+		peer, member, err := Pipe(ProtocolFunc(NewTestCodec), 0)
+		utest.IsNilNow(t, err)
+		peers[i] = peer
+		channel.Join(member)
+	}
+
+	// nobody reads from the other side of a net.Pipe, so sends to it block.
+	stuckConn, _ := net.Pipe()
+	stuckCodec, _ := NewTestCodec(stuckConn)
+	stuck := NewSession(stuckCodec, 0)
+	channel.Join(stuck)
+
+	msgs := make([][]byte, 10)
+	for i := range msgs {
+		msgs[i] = RandBytes(128)
+		fanout.Broadcast(channel, msgs[i])
+	}
+
+	for _, peer := range peers {
+		for _, msg := range msgs {
+			recv, err := peer.Receive()
+			utest.IsNilNow(t, err)
+			utest.Assert(t, bytes.Equal(msg, recv.([]byte)))
+		}
+	}
+
+	for !stuck.IsClosed() {
+		time.Sleep(time.Millisecond)
+	}
+	utest.EqualNow(t, fanout.TimedOut(), uint64(1))
+	channel.Close()
+}
+
+func Test_FanoutClosed(t *testing.T) {
+	fanout := NewFanout(2, 8, 0)
+	fanout.Close()
+	fanout.Close()
+
+	channel := NewChannel()
+	_, member, err := Pipe(ProtocolFunc(NewTestCodec), 0)
+	utest.IsNilNow(t, err)
+	channel.Join(member)
+	utest.Assert(t, !fanout.Send(member, RandBytes(8)))
+	utest.EqualNow(t, fanout.Broadcast(channel, RandBytes(8)), 1)
+	channel.Close()
+}
+
+func Test_FanoutNoWorkers(t *testing.T) {
+	fanout := NewFanout(0, 8, 0)
+	defer fanout.Close()
+	a, b, err := Pipe(ProtocolFunc(NewTestCodec), 1)
+	utest.IsNilNow(t, err)
+	defer a.Close()
+	msg := RandBytes(8)
+	utest.Assert(t, fanout.Send(a, msg))
+	got, err := b.Receive()
+	utest.IsNilNow(t, err)
+	utest.Assert(t, bytes.Equal(got.([]byte), msg))
+}
+
 func Benchmark_BytesToInterface(b *testing.B) {
 	var a = []byte{}
 	var x interface{}
@@ -255,3 +589,690 @@ func Benchmark_InterfaceToPointer(b *testing.B) {
 	}
 	_ = a
 }
+
+func Test_SendQueue(t *testing.T) {
+	q := newSendQueue(1)
+	utest.Assert(t, q.push(1))
+	utest.Assert(t, !q.push(2))
+	utest.EqualNow(t, q.len(), 1)
+	msg, ok := q.pop()
+	utest.Assert(t, ok)
+	utest.EqualNow(t, msg, 1)
+	_, ok = q.pop()
+	utest.Assert(t, !ok)
+
+	// every message of every producer arrives once, in order per producer.
+	q = newSendQueue(16)
+	done := make(chan int)
+	var wg sync.WaitGroup
+	for p := 0; p < 4; p++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				for !q.push([2]int{p, i}) {
+					runtime.Gosched()
+				}
+			}
+		}()
+	}
+	var next [4]int
+	for n := 0; n < 4000; n++ {
+		msg, ok := q.next(done)
+		utest.Assert(t, ok)
+		m := msg.([2]int)
+		utest.EqualNow(t, m[1], next[m[0]])
+		next[m[0]]++
+	}
+	wg.Wait()
+	close(done)
+	_, ok = q.next(done)
+	utest.Assert(t, !ok)
+}
+
+type gateCodec struct {
+	gate chan struct{}
+	sent chan interface{}
+}
+
+func (c *gateCodec) Receive() (interface{}, error) { select {} }
+func (c *gateCodec) Close() error                  { return nil }
+
+func (c *gateCodec) Send(msg interface{}) error {
+	<-c.gate
+	c.sent <- msg
+	return nil
+}
+
+func Test_SendTTL(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	clock.SetDefault(clk)
+	defer clock.SetDefault(nil)
+
+	codec := &gateCodec{make(chan struct{}), make(chan interface{}, 4)}
+	session := NewSession(codec, 4)
+	defer session.Close()
+
+	// the first message holds the send loop while the others wait.
+	utest.IsNilNow(t, session.Send(1))
+	utest.IsNilNow(t, session.Send(WithTTL(2, time.Millisecond)))
+	utest.IsNilNow(t, session.Send(WithTTL(3, time.Hour)))
+	clk.Advance(5 * time.Millisecond)
+	close(codec.gate)
+	utest.EqualNow(t, <-codec.sent, 1)
+	utest.EqualNow(t, <-codec.sent, 3)
+	utest.EqualNow(t, session.Expired(), uint64(1))
+
+	// sync sessions drop them too.
+	codec = &gateCodec{make(chan struct{}), make(chan interface{}, 4)}
+	close(codec.gate)
+	session = NewSession(codec, 0)
+	utest.IsNilNow(t, session.Send(&Expiring{4, clk.Now().Add(-time.Second)}))
+	utest.IsNilNow(t, session.Send(WithTTL(5, time.Hour)))
+	utest.EqualNow(t, <-codec.sent, 5)
+	utest.EqualNow(t, session.Expired(), uint64(1))
+}
+
+func Test_Watermarks(t *testing.T) {
+	codec := &gateCodec{make(chan struct{}), make(chan interface{}, 16)}
+	session := NewSession(codec, 16)
+	defer session.Close()
+	var high, low atomic.Int32
+	session.SetWatermarks(&Watermarks{
+		High:   4,
+		Low:    1,
+		OnHigh: func(*Session) { high.Add(1) },
+		OnLow:  func(*Session) { low.Add(1) },
+	})
+
+	// the send loop holds the first message, the rest queue up.
+	for i := 0; i < 8; i++ {
+		utest.IsNilNow(t, session.Send(i))
+	}
+	utest.EqualNow(t, high.Load(), int32(1))
+	utest.Assert(t, session.Congested())
+	close(codec.gate)
+	for i := 0; i < 8; i++ {
+		<-codec.sent
+	}
+	utest.EqualNow(t, low.Load(), int32(1))
+	utest.Assert(t, !session.Congested())
+}
+
+// A broadcast like workload: the producers spread messages over the queues
+// of 64 sessions. Every message is delivered, a full queue is retried.
+func benchmarkSendQueue(b *testing.B, newQueue func() (push func(interface{}) bool, consume func(done chan int))) {
+	const sessions = 64
+	var pushes [sessions]func(interface{}) bool
+	var consumers sync.WaitGroup
+	done := make(chan int)
+	for i := range pushes {
+		push, consume := newQueue()
+		pushes[i] = push
+		consumers.Add(1)
+		go func() {
+			defer consumers.Done()
+			consume(done)
+		}()
+	}
+	var msg interface{} = []byte{1}
+	var next atomic.Uint64
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := next.Add(7)
+		for pb.Next() {
+			for !pushes[i%sessions](msg) {
+				runtime.Gosched()
+			}
+			i++
+		}
+	})
+	b.StopTimer()
+	close(done)
+	consumers.Wait()
+}
+
+func Benchmark_SendQueue(b *testing.B) {
+	benchmarkSendQueue(b, func() (func(interface{}) bool, func(chan int)) {
+		q := newSendQueue(1024)
+		return q.push, func(done chan int) {
+			for {
+				if _, ok := q.next(done); !ok {
+					return
+				}
+			}
+		}
+	})
+}
+
+func Benchmark_SendChan(b *testing.B) {
+	benchmarkSendQueue(b, func() (func(interface{}) bool, func(chan int)) {
+		ch := make(chan interface{}, 1024)
+		push := func(msg interface{}) bool {
+			select {
+			case ch <- msg:
+				return true
+			default:
+				return false
+			}
+		}
+		return push, func(done chan int) {
+			for {
+				select {
+				case <-ch:
+				case <-done:
+					return
+				}
+			}
+		}
+	})
+}
+
+type testLogger struct {
+	mutex sync.Mutex
+	warns []string
+}
+
+func (l *testLogger) Debug(string, ...interface{}) {}
+func (l *testLogger) Info(string, ...interface{})  {}
+func (l *testLogger) Error(string, ...interface{}) {}
+
+func (l *testLogger) Warn(msg string, args ...interface{}) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.warns = append(l.warns, msg)
+}
+
+func Test_Logger(t *testing.T) {
+	logger := &testLogger{}
+	SetLogger(logger)
+	defer SetLogger(nil)
+
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	codec, _ := NewTestCodec(conn)
+	session := NewSession(codec, 1)
+
+	// nobody reads the peer, the send loop blocks and the channel fills up.
+	var err error
+	for i := 0; i < 3 && err == nil; i++ {
+		err = session.Send([]byte{1})
+		time.Sleep(10 * time.Millisecond)
+	}
+	utest.EqualNow(t, err, SessionBlockedError)
+
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+	utest.Assert(t, len(logger.warns) > 0)
+	utest.EqualNow(t, logger.warns[0], "link: send channel full, closing session")
+}
+
+type payloadLogger struct {
+	testLogger
+	infos [][]interface{}
+}
+
+func (l *payloadLogger) Info(msg string, args ...interface{}) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.infos = append(l.infos, args)
+}
+
+func Test_SampledPayloads(t *testing.T) {
+	logger := &payloadLogger{}
+	protocol := SampledPayloads(ProtocolFunc(NewTestCodec), PayloadLogConfig{
+		Rate:   4,
+		Logger: logger,
+		Redact: func(msg interface{}) interface{} {
+			if bytes.HasPrefix(msg.([]byte), []byte("secret")) {
+				return nil
+			}
+			return string(msg.([]byte))
+		},
+	})
+	client, server, err := Pipe(protocol, 0)
+	utest.IsNilNow(t, err)
+	defer client.Close()
+
+	for i := 0; i < 4; i++ {
+		utest.IsNilNow(t, client.Send([]byte("secret password")))
+		_, err := server.Receive()
+		utest.IsNilNow(t, err)
+	}
+	for i := 0; i < 2; i++ {
+		utest.IsNilNow(t, client.Send([]byte("hello")))
+		_, err = server.Receive()
+		utest.IsNilNow(t, err)
+	}
+
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+	// 1 out of 4 of the 12 sends and receives.
+	utest.EqualNow(t, len(logger.infos), 3)
+	utest.EqualNow(t, logger.infos[0], []interface{}{"dir", "recv", "type", "[]uint8"})
+	utest.EqualNow(t, logger.infos[2], []interface{}{"dir", "recv", "type", "[]uint8", "payload", "hello"})
+}
+
+// batchCodec returns three messages per batch.
+type batchCodec struct{}
+
+func (batchCodec) Receive() (interface{}, error) { return 1, nil }
+func (batchCodec) Send(msg interface{}) error    { return nil }
+func (batchCodec) Close() error                  { return nil }
+
+func (batchCodec) ReceiveBatch(msgs []interface{}, max int) ([]interface{}, error) {
+	return append(msgs, 1, 2, 3), nil
+}
+
+func Test_SampledBatch(t *testing.T) {
+	logger := &payloadLogger{}
+	protocol := SampledPayloads(ProtocolFunc(func(rw io.ReadWriter) (Codec, error) {
+		return batchCodec{}, nil
+	}), PayloadLogConfig{Logger: logger})
+	codec, err := protocol.NewCodec(nil)
+	utest.IsNilNow(t, err)
+
+	msgs, err := ReceiveBatch(codec, nil, 8)
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, msgs, []interface{}{1, 2, 3})
+	// broadcast bytes are never logged.
+	utest.IsNilNow(t, codec.Send(Encoded("secret")))
+
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+	utest.EqualNow(t, len(logger.infos), 4)
+	utest.EqualNow(t, logger.infos[3], []interface{}{"dir", "send", "type", "link.Encoded", "bytes", 6})
+}
+
+func Test_Classify(t *testing.T) {
+	utest.Assert(t, Classify(nil) == nil)
+	utest.Assert(t, Classify(errors.New("?")) == nil)
+	utest.Assert(t, Classify(io.EOF) == TransportError)
+	utest.Assert(t, Classify(fmt.Errorf("read: %w", net.ErrClosed)) == TransportError)
+	utest.Assert(t, Classify(SessionClosedError) == TransportError)
+	utest.Assert(t, Classify(SessionBlockedError) == PolicyError)
+	utest.Assert(t, errors.Is(SessionBlockedError, PolicyError))
+	utest.EqualNow(t, SessionBlockedError.Error(), "Session Blocked")
+
+	cause := &net.OpError{Op: "read", Err: errors.New("reset")}
+	err := WrapError(ProtocolError, cause)
+	utest.Assert(t, Classify(err) == ProtocolError)
+	var opErr *net.OpError
+	utest.Assert(t, errors.As(err, &opErr) && opErr == cause)
+}
+
+func Test_ServerOptions(t *testing.T) {
+	manager := NewManager()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	utest.IsNilNow(t, err)
+	server := NewServerWith(listener, ProtocolFunc(NewTestCodec), HandlerFunc(func(session *Session) {}), WithManager(manager), WithSendChanSize(10))
+	defer server.Stop()
+	utest.Assert(t, server.Manager() == manager)
+	utest.EqualNow(t, server.sendChanSize, 10)
+}
+
+type routeA struct{ N int }
+type routeB struct{ S string }
+
+func Test_Router(t *testing.T) {
+	var got []interface{}
+	router := NewRouter()
+	RegisterHandler(router, func(session *Session, msg *routeA) {
+		got = append(got, msg.N)
+	})
+	RegisterHandler(router, func(session *Session, msg *routeB) {
+		got = append(got, msg.S)
+	})
+	router.NotFound = func(session *Session, msg interface{}) {
+		got = append(got, msg)
+	}
+
+	client, server, err := Pipe(ProtocolFunc(NewTestCodec), 0)
+	utest.IsNilNow(t, err)
+	utest.IsNilNow(t, router.Dispatch(server, &routeA{1}))
+	utest.IsNilNow(t, router.Dispatch(server, routeB{"b"}))
+	utest.EqualNow(t, router.Dispatch(server, 3), NoRouteError)
+
+	go func() {
+		client.Send([]byte("x"))
+		client.Close()
+	}()
+	router.HandleSession(server)
+	utest.EqualNow(t, got, []interface{}{1, "b", []byte("x")})
+}
+
+func Test_WorkerPool(t *testing.T) {
+	pool := NewWorkerPool(3, 8)
+	var mutex sync.Mutex
+	got := make(map[uint64][]byte)
+	router := NewRouter()
+	router.Pool = pool
+	router.Batch = 16
+	RegisterHandler(router, func(session *Session, msg *[]byte) {
+		mutex.Lock()
+		got[session.ID()] = append(got[session.ID()], (*msg)[0])
+		mutex.Unlock()
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		client, server, err := Pipe(ProtocolFunc(NewTestCodec), 0)
+		utest.IsNilNow(t, err)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			router.HandleSession(server)
+		}()
+		go func() {
+			for j := 0; j < 100; j++ {
+				client.Send([]byte{byte(j)})
+			}
+			client.Close()
+		}()
+	}
+	wg.Wait()
+	pool.Close()
+	utest.EqualNow(t, pool.Submit(0, func() {}), PoolClosedError)
+
+	utest.EqualNow(t, len(got), 5)
+	for _, seq := range got {
+		utest.EqualNow(t, len(seq), 100)
+		for j, b := range seq {
+			utest.EqualNow(t, int(b), j)
+		}
+	}
+}
+
+func Test_WorkerPoolCloseRace(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		pool := NewWorkerPool(2, 1)
+		var accepted, ran int64
+		var wg sync.WaitGroup
+		for j := 0; j < 8; j++ {
+			wg.Add(1)
+			go func(key uint64) {
+				defer wg.Done()
+				for pool.Submit(key, func() { atomic.AddInt64(&ran, 1) }) == nil {
+					atomic.AddInt64(&accepted, 1)
+				}
+			}(uint64(j))
+		}
+		pool.Close()
+		wg.Wait()
+		// every task taken before or during Close has run.
+		utest.EqualNow(t, atomic.LoadInt64(&ran), atomic.LoadInt64(&accepted))
+	}
+}
+
+func Test_RouterTimeout(t *testing.T) {
+	type slow struct{}
+	type fast struct{}
+	router := NewRouter()
+	router.Timeout = time.Hour
+	var ctxErr error
+	RegisterHandlerContext(router, func(ctx context.Context, session *Session, msg *slow) {
+		<-ctx.Done()
+		ctxErr = ctx.Err()
+	})
+	RegisterHandler(router, func(session *Session, msg *fast) {})
+	SetTimeout[slow](router, 10*time.Millisecond)
+
+	timeouts := make(chan time.Duration, 2)
+	router.OnTimeout = func(session *Session, msg interface{}, timeout time.Duration) {
+		timeouts <- timeout
+	}
+	session := NewSession(nil, 0)
+	utest.IsNilNow(t, router.Dispatch(session, &slow{}))
+	utest.EqualNow(t, ctxErr, context.DeadlineExceeded)
+	utest.EqualNow(t, <-timeouts, 10*time.Millisecond)
+
+	utest.IsNilNow(t, router.Dispatch(session, fast{}))
+	utest.EqualNow(t, len(timeouts), 0)
+}
+
+func Test_RouterPanic(t *testing.T) {
+	router := NewRouter()
+	RegisterHandler(router, func(session *Session, msg *[]byte) {
+		if string(*msg) == "panic" {
+			panic("boom")
+		}
+		session.Send(*msg)
+	})
+	var panics []*PanicError
+	router.OnPanic = func(session *Session, msg interface{}, err *PanicError) {
+		panics = append(panics, err)
+	}
+	router.Panic = PanicReply
+	router.PanicReply = func(msg interface{}, err *PanicError) interface{} {
+		return []byte("error")
+	}
+
+	client, server, err := Pipe(ProtocolFunc(NewTestCodec), 0)
+	utest.IsNilNow(t, err)
+	done := make(chan struct{})
+	go func() {
+		router.HandleSession(server)
+		close(done)
+	}()
+	for _, req := range []string{"a", "panic", "b"} {
+		client.Send([]byte(req))
+	}
+	for _, rsp := range []string{"a", "error", "b"} {
+		msg, err := client.Receive()
+		utest.IsNilNow(t, err)
+		utest.EqualNow(t, string(msg.([]byte)), rsp)
+	}
+
+	router.Panic = PanicCloseSession
+	client.Send([]byte("panic"))
+	<-done
+	_, err = client.Receive()
+	utest.NotNilNow(t, err)
+
+	utest.EqualNow(t, len(panics), 2)
+	utest.EqualNow(t, panics[0].Value, "boom")
+	utest.Assert(t, errors.Is(panics[0], HandlerError))
+	utest.Assert(t, bytes.Contains(panics[0].Stack, []byte("Test_RouterPanic")))
+}
+
+func Test_RouterMiddleware(t *testing.T) {
+	var calls []string
+	trace := func(name string) Middleware {
+		return func(next MessageHandler) MessageHandler {
+			return func(ctx context.Context, session *Session, msg interface{}) {
+				calls = append(calls, name)
+				next(ctx, session, msg)
+			}
+		}
+	}
+	deny := func(next MessageHandler) MessageHandler {
+		return func(ctx context.Context, session *Session, msg interface{}) {
+			if *msg.(*int) < 0 {
+				calls = append(calls, "denied")
+				return
+			}
+			next(ctx, session, msg)
+		}
+	}
+
+	router := NewRouter()
+	router.Use(trace("outer"))
+	RegisterHandler(router, func(session *Session, msg *int) {
+		calls = append(calls, "handler")
+	})
+	router.Use(trace("inner"), deny)
+
+	session := NewSession(nil, 0)
+	one, minus := 1, -1
+	router.Dispatch(session, &one)
+	router.Dispatch(session, &minus)
+	utest.EqualNow(t, calls, []string{"outer", "inner", "handler", "outer", "inner", "denied"})
+}
+
+func Test_RouterVersion(t *testing.T) {
+	type login struct{ User string }
+	var got []string
+	router := NewRouter()
+	router.Version = func(session *Session) byte { return session.State.(byte) }
+	RegisterHandler(router, func(session *Session, msg *login) {
+		got = append(got, "v1 "+msg.User)
+	})
+	RegisterVersionedHandler(router, 2, func(ctx context.Context, session *Session, msg *login) {
+		got = append(got, "v2 "+msg.User)
+	})
+
+	v1, v2, v3 := NewSession(nil, 0), NewSession(nil, 0), NewSession(nil, 0)
+	v1.State, v2.State, v3.State = byte(1), byte(2), byte(3)
+	router.Dispatch(v1, &login{"a"})
+	router.Dispatch(v2, &login{"b"})
+	router.Dispatch(v3, &login{"c"})
+	utest.EqualNow(t, got, []string{"v1 a", "v2 b", "v1 c"})
+}
+
+func Test_Relay(t *testing.T) {
+	client1, server1, err := Pipe(ProtocolFunc(NewTestCodec), 16)
+	utest.IsNilNow(t, err)
+	client2, server2, err := Pipe(ProtocolFunc(NewTestCodec), 16)
+	utest.IsNilNow(t, err)
+
+	relayed := make(chan error, 1)
+	go func() {
+		relayed <- RelayWith(server1, server2, func(from *Session, msg interface{}) (interface{}, error) {
+			if string(msg.([]byte)) == "drop" {
+				return nil, nil
+			}
+			return msg, nil
+		})
+	}()
+
+	utest.IsNilNow(t, client1.Send([]byte("drop")))
+	utest.IsNilNow(t, client1.Send([]byte("ping")))
+	msg, err := client2.Receive()
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, string(msg.([]byte)), "ping")
+	utest.IsNilNow(t, client2.Send([]byte("pong")))
+	msg, err = client1.Receive()
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, string(msg.([]byte)), "pong")
+
+	client1.Close()
+	utest.IsNilNow(t, <-relayed)
+	_, err = client2.Receive()
+	utest.NotNilNow(t, err)
+}
+
+func Test_LiveConfig(t *testing.T) {
+	live := NewLiveConfig(Config{RecvRate: 1000, RecvBurst: 2})
+	var seen []int
+	live.OnChange(func(config *Config) { seen = append(seen, config.RecvRate) })
+	live.Update(func(config *Config) { config.RecvRate = 0 })
+	utest.EqualNow(t, seen, []int{1000, 0})
+
+	protocol := Configured(ProtocolFunc(NewTestCodec), live)
+	conn1, conn2 := net.Pipe()
+	codec1, err := protocol.NewCodec(conn1)
+	utest.IsNilNow(t, err)
+	codec2, err := protocol.NewCodec(conn2)
+	utest.IsNilNow(t, err)
+	server, client := NewSession(codec1, 0), NewSession(codec2, 0)
+	defer client.Close()
+
+	go client.Send([]byte("hi"))
+	msg, err := server.Receive()
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, msg, []byte("hi"))
+
+	// the idle timeout applies from the next receive.
+	live.Update(func(config *Config) { config.IdleTimeout = 20 * time.Millisecond })
+	_, err = server.Receive()
+	var netErr net.Error
+	utest.Assert(t, errors.As(err, &netErr) && netErr.Timeout())
+}
+
+func Test_Maintenance(t *testing.T) {
+	server, err := Listen("tcp", "127.0.0.1:0", ProtocolFunc(NewTestCodec), 10, HandlerFunc(func(session *Session) {
+		for {
+			if _, err := session.Receive(); err != nil {
+				return
+			}
+		}
+	}))
+	utest.IsNilNow(t, err)
+	go server.Serve()
+	defer server.Stop()
+	addr := server.Listener().Addr().String()
+
+	client, err := Dial("tcp", addr, ProtocolFunc(NewTestCodec), 0)
+	utest.IsNilNow(t, err)
+	defer client.Close()
+	for server.Manager().Len() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	server.EnterMaintenance(time.Now().Add(50*time.Millisecond), []byte("bye"))
+	utest.Assert(t, server.InMaintenance())
+	msg, err := client.Receive()
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, msg, []byte("bye"))
+
+	// new connections are closed right away.
+	late, err := Dial("tcp", addr, ProtocolFunc(NewTestCodec), 0)
+	utest.IsNilNow(t, err)
+	_, err = late.Receive()
+	utest.NotNilNow(t, err)
+	late.Close()
+
+	// the remaining session is closed at the deadline.
+	_, err = client.Receive()
+	utest.NotNilNow(t, err)
+	for server.Manager().Len() != 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	server.LeaveMaintenance()
+	utest.Assert(t, !server.InMaintenance())
+}
+
+func Test_SessionLabels(t *testing.T) {
+	manager := NewManager()
+	defer manager.Dispose()
+	newSession := func() *Session {
+		conn, _ := PipeConn()
+		codec, _ := NewTestCodec(conn)
+		return manager.NewSession(codec, 0)
+	}
+	s1, s2, s3 := newSession(), newSession(), newSession()
+	s1.SetLabel("zone", "eu")
+	s2.SetLabel("zone", "eu")
+	s3.SetLabel("zone", "us")
+	s1.SetLabel("user", "alice")
+	s2.SetLabel("user", "bob")
+
+	ids := func(sessions []*Session) []uint64 {
+		var ids []uint64
+		for _, session := range sessions {
+			ids = append(ids, session.ID())
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		return ids
+	}
+	utest.EqualNow(t, ids(manager.Find("zone", "eu")), []uint64{s1.ID(), s2.ID()})
+	utest.EqualNow(t, ids(manager.Find("user", "bob")), []uint64{s2.ID()})
+	utest.EqualNow(t, ids(manager.FindFunc("user", func(v string) bool { return v < "b" })), []uint64{s1.ID()})
+	utest.EqualNow(t, ids(manager.Select(map[string]string{"zone": "eu", "user": "alice"})), []uint64{s1.ID()})
+	utest.EqualNow(t, s1.Labels(), map[string]string{"zone": "eu", "user": "alice"})
+
+	s2.SetLabel("zone", "us")
+	s3.SetLabel("zone", "")
+	utest.EqualNow(t, ids(manager.Find("zone", "us")), []uint64{s2.ID()})
+	utest.EqualNow(t, s3.Label("zone"), "")
+
+	s1.Close()
+	for manager.Len() != 2 {
+		time.Sleep(time.Millisecond)
+	}
+	utest.EqualNow(t, len(manager.Find("zone", "eu")), 0)
+	s1.SetLabel("zone", "eu")
+	utest.EqualNow(t, len(manager.Find("zone", "eu")), 0)
+}
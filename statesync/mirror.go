@@ -0,0 +1,94 @@
+package statesync
+
+import (
+	"sync"
+
+	"github.com/funny/link"
+)
+
+// Mirror is the client side of a Replicator. It applies snapshots and deltas,
+// acks every state it built and keeps the recent ones as baselines.
+type Mirror struct {
+	// History is the number of past states kept, it should be larger than
+	// the History of the Replicator.
+	History int
+
+	// OnUpdate is called with every new state, which must not be changed.
+	OnUpdate func(tick uint64, entities map[string][]byte)
+
+	mutex  sync.Mutex
+	frames []frame
+}
+
+func NewMirror() *Mirror {
+	return &Mirror{History: 64}
+}
+
+// Register adds the handlers of Snapshot and Delta to router.
+func (m *Mirror) Register(router *link.Router) {
+	link.RegisterHandler(router, m.handleSnapshot)
+	link.RegisterHandler(router, m.handleDelta)
+}
+
+// State returns the latest state.
+func (m *Mirror) State() (uint64, map[string][]byte) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if len(m.frames) == 0 {
+		return 0, nil
+	}
+	latest := m.frames[len(m.frames)-1]
+	return latest.tick, latest.entities
+}
+
+func (m *Mirror) handleSnapshot(session *link.Session, snapshot *Snapshot) {
+	m.apply(session, frame{snapshot.Tick, snapshot.Entities})
+}
+
+// handleDelta drops deltas from a baseline it doesn't have anymore, the
+// Replicator sends a snapshot once it falls out of its history too.
+func (m *Mirror) handleDelta(session *link.Session, delta *Delta) {
+	m.mutex.Lock()
+	var base *frame
+	for i := range m.frames {
+		if m.frames[i].tick == delta.Base {
+			base = &m.frames[i]
+		}
+	}
+	if base == nil {
+		m.mutex.Unlock()
+		return
+	}
+	entities := make(map[string][]byte, len(base.entities)+len(delta.Changed))
+	for id, data := range base.entities {
+		entities[id] = data
+	}
+	for id, data := range delta.Changed {
+		entities[id] = data
+	}
+	for _, id := range delta.Removed {
+		delete(entities, id)
+	}
+	m.mutex.Unlock()
+	m.apply(session, frame{delta.Tick, entities})
+}
+
+func (m *Mirror) apply(session *link.Session, f frame) {
+	m.mutex.Lock()
+	if n := len(m.frames); n > 0 && f.tick <= m.frames[n-1].tick {
+		m.mutex.Unlock()
+		return
+	}
+	if f.entities == nil {
+		f.entities = make(map[string][]byte)
+	}
+	m.frames = append(m.frames, f)
+	if n := len(m.frames) - m.History; n > 0 {
+		m.frames = append(m.frames[:0], m.frames[n:]...)
+	}
+	m.mutex.Unlock()
+	if m.OnUpdate != nil {
+		m.OnUpdate(f.tick, f.entities)
+	}
+	session.Send(&Ack{f.tick})
+}
@@ -0,0 +1,179 @@
+package statesync
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/funny/link"
+)
+
+// Snapshot is the whole state at Tick, sent to sessions without a baseline.
+type Snapshot struct {
+	Tick     uint64
+	Entities map[string][]byte
+}
+
+// Delta turns the state at Base into the one at Tick.
+type Delta struct {
+	Tick    uint64
+	Base    uint64
+	Changed map[string][]byte
+	Removed []string
+}
+
+// Ack tells the Replicator that the state at Tick arrived, it becomes the
+// baseline of the next deltas.
+type Ack struct {
+	Tick uint64
+}
+
+type frame struct {
+	tick     uint64
+	entities map[string][]byte
+}
+
+// Replicator replicates a state of entities to the sessions which joined.
+// Every Tick sends each session the delta from the last state it acked, or a
+// snapshot when it acked none or that state is older than History ticks.
+// Sessions with the same baseline get the same message, it is encoded once
+// by a link.Broadcaster. Snapshot, Delta and Ack have to be registered with
+// the codec, entity data is treated as immutable.
+type Replicator struct {
+	broadcaster *link.Broadcaster
+
+	// History is the number of past states kept as baselines.
+	History int
+
+	mutex    sync.Mutex
+	tick     uint64
+	current  map[string][]byte
+	frames   []frame
+	sessions map[uint64]*replica
+}
+
+type replica struct {
+	session *link.Session
+	acked   uint64
+}
+
+func New(protocol link.Protocol) (*Replicator, error) {
+	broadcaster, err := link.NewBroadcaster(protocol)
+	if err != nil {
+		return nil, err
+	}
+	return &Replicator{
+		broadcaster: broadcaster,
+		History:     32,
+		current:     make(map[string][]byte),
+		sessions:    make(map[uint64]*replica),
+	}, nil
+}
+
+// Register adds the handler of Ack to router.
+func (r *Replicator) Register(router *link.Router) {
+	link.RegisterHandler(router, r.handleAck)
+}
+
+func (r *Replicator) Set(id string, data []byte) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.current[id] = data
+}
+
+func (r *Replicator) Remove(id string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.current, id)
+}
+
+// Join makes session receive the state from the next Tick on, beginning
+// with a snapshot. It leaves when it closes.
+func (r *Replicator) Join(session *link.Session) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.sessions[session.ID()] = &replica{session: session}
+	session.AddCloseCallback(r, nil, func() { r.Leave(session) })
+}
+
+func (r *Replicator) Leave(session *link.Session) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.sessions, session.ID())
+}
+
+func (r *Replicator) handleAck(session *link.Session, ack *Ack) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if replica, exists := r.sessions[session.ID()]; exists && ack.Tick > replica.acked && ack.Tick <= r.tick {
+		replica.acked = ack.Tick
+	}
+}
+
+func (r *Replicator) baseline(tick uint64) *frame {
+	for i := range r.frames {
+		if r.frames[i].tick == tick {
+			return &r.frames[i]
+		}
+	}
+	return nil
+}
+
+// Tick records the current state as the next tick and sends it, it returns
+// the tick.
+func (r *Replicator) Tick() (uint64, error) {
+	r.mutex.Lock()
+	r.tick++
+	current := frame{r.tick, make(map[string][]byte, len(r.current))}
+	for id, data := range r.current {
+		current.entities[id] = data
+	}
+	r.frames = append(r.frames, current)
+	if n := len(r.frames) - r.History; n > 0 {
+		r.frames = append(r.frames[:0], r.frames[n:]...)
+	}
+
+	groups := make(map[uint64][]*link.Session)
+	for _, replica := range r.sessions {
+		base := replica.acked
+		if r.baseline(base) == nil {
+			base = 0
+		}
+		groups[base] = append(groups[base], replica.session)
+	}
+	msgs := make(map[uint64]interface{}, len(groups))
+	for base := range groups {
+		if base == 0 {
+			msgs[base] = &Snapshot{current.tick, current.entities}
+		} else {
+			msgs[base] = diff(r.baseline(base), &current)
+		}
+	}
+	r.mutex.Unlock()
+
+	for base, sessions := range groups {
+		encoded, err := r.broadcaster.Encode(msgs[base])
+		if err != nil {
+			return current.tick, err
+		}
+		for _, session := range sessions {
+			session.Send(encoded)
+		}
+	}
+	return current.tick, nil
+}
+
+func diff(base, current *frame) *Delta {
+	delta := &Delta{Tick: current.tick, Base: base.tick, Changed: make(map[string][]byte)}
+	for id, data := range current.entities {
+		if old, exists := base.entities[id]; !exists || string(old) != string(data) {
+			delta.Changed[id] = data
+		}
+	}
+	for id := range base.entities {
+		if _, exists := current.entities[id]; !exists {
+			delta.Removed = append(delta.Removed, id)
+		}
+	}
+	sort.Strings(delta.Removed)
+	return delta
+}
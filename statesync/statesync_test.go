@@ -0,0 +1,104 @@
+package statesync
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/codec"
+	"github.com/funny/utest"
+)
+
+func waitFor(cond func() bool) {
+	for i := 0; i < 1000 && !cond(); i++ {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func Test_Sync(t *testing.T) {
+	json := codec.Json()
+	json.Register(Snapshot{})
+	json.Register(Delta{})
+	json.Register(Ack{})
+	protocol := codec.FixLen(json, 4, binary.LittleEndian, 64*1024, 64*1024)
+	server, client, err := link.Pipe(protocol, 0)
+	utest.IsNilNow(t, err)
+	defer server.Close()
+
+	r, err := New(protocol)
+	utest.IsNilNow(t, err)
+	serverRouter := link.NewRouter()
+	r.Register(serverRouter)
+	go serverRouter.HandleSession(server)
+
+	mirror := NewMirror()
+	clientRouter := link.NewRouter()
+	mirror.Register(clientRouter)
+	var deltas []*Delta
+	clientRouter.Use(func(next link.MessageHandler) link.MessageHandler {
+		return func(ctx context.Context, session *link.Session, msg interface{}) {
+			if delta, ok := msg.(*Delta); ok {
+				deltas = append(deltas, delta)
+			}
+			next(ctx, session, msg)
+		}
+	})
+	go clientRouter.HandleSession(client)
+	acked := func(tick uint64) func() bool {
+		return func() bool {
+			r.mutex.Lock()
+			defer r.mutex.Unlock()
+			return r.sessions[server.ID()].acked == tick
+		}
+	}
+
+	// a snapshot on join.
+	r.Set("a", []byte("1"))
+	r.Set("b", []byte("2"))
+	r.Join(server)
+	tick, err := r.Tick()
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, tick, uint64(1))
+	waitFor(acked(1))
+	_, state := mirror.State()
+	utest.EqualNow(t, string(state["a"]), "1")
+	utest.EqualNow(t, len(deltas), 0)
+
+	// then deltas from the acked state.
+	r.Set("a", []byte("3"))
+	r.Remove("b")
+	r.Set("c", []byte("4"))
+	_, err = r.Tick()
+	utest.IsNilNow(t, err)
+	waitFor(acked(2))
+	tick, state = mirror.State()
+	utest.EqualNow(t, tick, uint64(2))
+	utest.EqualNow(t, len(state), 2)
+	utest.EqualNow(t, string(state["a"]), "3")
+	utest.EqualNow(t, string(state["c"]), "4")
+	utest.EqualNow(t, len(deltas), 1)
+	utest.EqualNow(t, deltas[0].Base, uint64(1))
+	utest.EqualNow(t, len(deltas[0].Changed), 2)
+	utest.EqualNow(t, deltas[0].Removed, []string{"b"})
+
+	// nothing changed, nothing but the tick goes out.
+	_, err = r.Tick()
+	utest.IsNilNow(t, err)
+	waitFor(acked(3))
+	utest.EqualNow(t, len(deltas), 2)
+	utest.EqualNow(t, len(deltas[1].Changed), 0)
+}
+
+func Test_History(t *testing.T) {
+	r, err := New(codec.Json())
+	utest.IsNilNow(t, err)
+	r.History = 2
+	for i := 0; i < 5; i++ {
+		r.Tick()
+	}
+	utest.EqualNow(t, len(r.frames), 2)
+	utest.Assert(t, r.baseline(3) == nil)
+	utest.Assert(t, r.baseline(4) != nil)
+}
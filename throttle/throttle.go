@@ -0,0 +1,164 @@
+package throttle
+
+import (
+	"io"
+
+	"github.com/funny/link"
+	"github.com/funny/link/clock"
+	"github.com/funny/link/internal/bucket"
+)
+
+// Bucket is a token bucket of bytes, it refills at rate per second up to
+// burst. Takers which find it short go into debt and sleep it off, so a big
+// write is delayed instead of refused.
+type Bucket struct {
+	bucket.Bucket
+}
+
+// NewBucket returns a full bucket, a zero burst is a tenth of rate.
+func NewBucket(rate, burst int) *Bucket {
+	b := &Bucket{}
+	b.SetRate(rate, burst)
+	return b
+}
+
+// SetRate changes the bucket at runtime, a zero rate is unlimited.
+func (b *Bucket) SetRate(rate, burst int) {
+	if burst <= 0 {
+		burst = max(rate/10, 1)
+	}
+	b.Bucket.SetRate(float64(rate), float64(burst))
+}
+
+// Burst returns the largest chunk worth taking at once, zero when the
+// bucket is unlimited.
+func (b *Bucket) Burst() int {
+	rate, burst := b.Rate()
+	if rate <= 0 {
+		return 0
+	}
+	return int(burst)
+}
+
+// Limits are the throttles of a Protocol. Read and Write are shared by all
+// its sessions, which keeps the process within them, every session also
+// gets buckets of its own with the Session rates. Zero is unlimited.
+type Limits struct {
+	Read, Write *Bucket
+
+	SessionRead, SessionWrite int // bytes per second
+	SessionBurst              int
+
+	Clock clock.Clock // of the Session buckets, nil is clock.Default()
+}
+
+// Protocol throttles the connections of base. It must be the outermost
+// protocol for Of to find the buckets of a session.
+func Protocol(base link.Protocol, limits *Limits) link.Protocol {
+	return link.ProtocolFunc(func(rw io.ReadWriter) (link.Codec, error) {
+		read := NewBucket(limits.SessionRead, limits.SessionBurst)
+		write := NewBucket(limits.SessionWrite, limits.SessionBurst)
+		read.Clock, write.Clock = limits.Clock, limits.Clock
+		conn := &Conn{
+			rw:    rw,
+			read:  [2]*Bucket{read, limits.Read},
+			write: [2]*Bucket{write, limits.Write},
+		}
+		codec, err := base.NewCodec(conn)
+		if err != nil {
+			return nil, err
+		}
+		return &Codec{codec, conn}, nil
+	})
+}
+
+// Codec is the codec of Protocol sessions.
+type Codec struct {
+	link.Codec
+	conn *Conn
+}
+
+func (c *Codec) Close() error {
+	return c.Codec.Close()
+}
+
+func (c *Codec) ReceiveBatch(msgs []interface{}, max int) ([]interface{}, error) {
+	return link.ReceiveBatch(c.Codec, msgs, max)
+}
+
+func (c *Codec) ClearSendChan(ch <-chan interface{}) {
+	if clear, ok := c.Codec.(link.ClearSendChan); ok {
+		clear.ClearSendChan(ch)
+	}
+}
+
+// Of returns the throttled connection of session, so its rates can change
+// by tenant after login, nil when it has none.
+func Of(session *link.Session) *Conn {
+	if c, ok := session.Codec().(*Codec); ok {
+		return c.conn
+	}
+	return nil
+}
+
+// Conn is a throttled connection, reads wait for the bytes just read and
+// writes go out in chunks of a burst.
+type Conn struct {
+	rw    io.ReadWriter
+	read  [2]*Bucket // of the session and the process
+	write [2]*Bucket
+}
+
+// Buckets returns the buckets of the session.
+func (c *Conn) Buckets() (read, write *Bucket) {
+	return c.read[0], c.write[0]
+}
+
+func wait(n int, buckets [2]*Bucket) {
+	for _, b := range buckets {
+		if b != nil {
+			b.Wait(n)
+		}
+	}
+}
+
+func chunk(n int, buckets [2]*Bucket) int {
+	for _, b := range buckets {
+		if b == nil {
+			continue
+		}
+		if burst := b.Burst(); burst > 0 {
+			n = min(n, burst)
+		}
+	}
+	return n
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	p = p[:chunk(len(p), c.read)]
+	n, err := c.rw.Read(p)
+	wait(n, c.read)
+	return n, err
+}
+
+func (c *Conn) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := chunk(len(p), c.write)
+		wait(n, c.write)
+		n, err := c.rw.Write(p[:n])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func (c *Conn) Close() error {
+	if closer, ok := c.rw.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package throttle
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/clock"
+	"github.com/funny/link/codec"
+	"github.com/funny/utest"
+)
+
+func Test_Bucket(t *testing.T) {
+	fake := clock.NewFake(time.Unix(0, 0))
+	b := NewBucket(100, 10)
+	b.Clock = fake
+	utest.EqualNow(t, b.Reserve(10), time.Duration(0))
+	utest.EqualNow(t, b.Reserve(10), 100*time.Millisecond)
+	// the debt is paid first, then it fills up to the burst.
+	fake.Advance(time.Second)
+	utest.EqualNow(t, b.Reserve(10), time.Duration(0))
+	utest.EqualNow(t, b.Reserve(1), 10*time.Millisecond)
+
+	b.SetRate(0, 0)
+	utest.EqualNow(t, b.Reserve(1000), time.Duration(0))
+	utest.EqualNow(t, b.Burst(), 0)
+}
+
+func Test_Conn(t *testing.T) {
+	// the process limit is the tighter one here.
+	var buf bytes.Buffer
+	conn := &Conn{
+		rw:    &buf,
+		read:  [2]*Bucket{NewBucket(0, 0), nil},
+		write: [2]*Bucket{NewBucket(1000000, 0), NewBucket(20000, 1000)},
+	}
+	start := time.Now()
+	n, err := conn.Write(make([]byte, 5000))
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, n, 5000)
+	utest.Assert(t, time.Since(start) >= 150*time.Millisecond)
+	utest.EqualNow(t, buf.Len(), 5000)
+
+	p := make([]byte, 5000)
+	n, err = conn.Read(p)
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, n, 5000)
+}
+
+type Blob struct {
+	Data []byte
+}
+
+func Test_Protocol(t *testing.T) {
+	json := codec.Json()
+	json.Register(Blob{})
+	limits := &Limits{SessionWrite: 1000000}
+	protocol := Protocol(codec.FixLen(json, 4, binary.LittleEndian, 64*1024, 64*1024), limits)
+	a, b, err := link.Pipe(protocol, 0)
+	utest.IsNilNow(t, err)
+	defer a.Close()
+
+	// slowed down after login.
+	_, write := Of(a).Buckets()
+	write.SetRate(20000, 1000)
+	start := time.Now()
+	utest.IsNilNow(t, a.Send(&Blob{make([]byte, 4000)}))
+	msg, err := b.Receive()
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, len(msg.(*Blob).Data), 4000)
+	utest.Assert(t, time.Since(start) >= 150*time.Millisecond)
+}
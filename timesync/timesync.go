@@ -0,0 +1,122 @@
+package timesync
+
+import (
+	"sync"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/clock"
+)
+
+// Ping asks the peer for its time, Origin is when it was sent in unix
+// nanoseconds.
+type Ping struct {
+	Origin int64
+}
+
+// Pong answers a Ping with the times it was received and sent by the peer.
+type Pong struct {
+	Origin   int64
+	Receive  int64
+	Transmit int64
+}
+
+// Sync estimates the clock offset and round trip time of sessions with an
+// NTP like exchange of Ping and Pong, which have to be registered with the
+// codec. Of the last Samples exchanges the one with the shortest round trip
+// wins, it was delayed the least, and goes to Session.SetClock, which makes
+// it the Session.RTT of the session. Both ends answer pings, either may
+// measure.
+type Sync struct {
+	Samples int // default 8
+	Clock   clock.Clock
+
+	mutex   sync.Mutex
+	samples map[uint64][]sample
+}
+
+type sample struct {
+	offset, rtt time.Duration
+}
+
+func New() *Sync {
+	return &Sync{
+		Samples: 8,
+		samples: make(map[uint64][]sample),
+	}
+}
+
+// Register adds the handlers of Ping and Pong to router.
+func (s *Sync) Register(router *link.Router) {
+	link.RegisterHandler(router, s.handlePing)
+	link.RegisterHandler(router, s.handlePong)
+}
+
+func (s *Sync) now() int64 {
+	return clock.Or(s.Clock).Now().UnixNano()
+}
+
+// Ping starts an exchange with the peer of session.
+func (s *Sync) Ping(session *link.Session) error {
+	return session.Send(&Ping{s.now()})
+}
+
+// Start pings session every interval until it closes.
+func (s *Sync) Start(session *link.Session, interval time.Duration) {
+	ticker := clock.Or(s.Clock).NewTicker(interval)
+	stop := make(chan struct{})
+	session.AddCloseCallback(s, stop, func() { close(stop) })
+	go func() {
+		defer ticker.Stop()
+		if s.Ping(session) != nil {
+			return
+		}
+		for {
+			select {
+			case <-ticker.C():
+				if s.Ping(session) != nil {
+					return
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+func (s *Sync) handlePing(session *link.Session, ping *Ping) {
+	receive := s.now()
+	session.Send(&Pong{ping.Origin, receive, s.now()})
+}
+
+func (s *Sync) handlePong(session *link.Session, pong *Pong) {
+	t0, t1, t2, t3 := pong.Origin, pong.Receive, pong.Transmit, s.now()
+	offset := time.Duration(((t1 - t0) + (t2 - t3)) / 2)
+	rtt := time.Duration((t3 - t0) - (t2 - t1))
+	if rtt < 0 {
+		rtt = 0
+	}
+
+	s.mutex.Lock()
+	samples, exists := s.samples[session.ID()]
+	if !exists {
+		session.AddCloseCallback(s, nil, func() {
+			s.mutex.Lock()
+			defer s.mutex.Unlock()
+			delete(s.samples, session.ID())
+		})
+	}
+	samples = append(samples, sample{offset, rtt})
+	if n := len(samples) - max(s.Samples, 1); n > 0 {
+		samples = samples[n:]
+	}
+	s.samples[session.ID()] = samples
+	best := samples[0]
+	for _, sample := range samples[1:] {
+		if sample.rtt < best.rtt {
+			best = sample
+		}
+	}
+	s.mutex.Unlock()
+	session.SetClock(best.offset, best.rtt)
+}
@@ -0,0 +1,77 @@
+package timesync
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/clock"
+	"github.com/funny/link/codec"
+	"github.com/funny/utest"
+)
+
+func Test_Sync(t *testing.T) {
+	json := codec.Json()
+	json.Register(Ping{})
+	json.Register(Pong{})
+	protocol := codec.FixLen(json, 4, binary.LittleEndian, 1024, 1024)
+	client, server, err := link.Pipe(protocol, 0)
+	utest.IsNilNow(t, err)
+	defer client.Close()
+
+	// the server clock is 5s ahead.
+	start := time.Unix(1000, 0)
+	clientSync, serverSync := New(), New()
+	clientSync.Clock = clock.NewFake(start)
+	serverSync.Clock = clock.NewFake(start.Add(5 * time.Second))
+	clientRouter, serverRouter := link.NewRouter(), link.NewRouter()
+	clientSync.Register(clientRouter)
+	serverSync.Register(serverRouter)
+	go clientRouter.HandleSession(client)
+	go serverRouter.HandleSession(server)
+
+	utest.IsNilNow(t, clientSync.Ping(client))
+	for i := 0; i < 100 && client.ClockOffset() == 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	utest.EqualNow(t, client.ClockOffset(), 5*time.Second)
+	utest.EqualNow(t, client.RTT(), time.Duration(0))
+	utest.EqualNow(t, client.PeerTime(start), start.Add(5*time.Second))
+}
+
+func Test_BestSample(t *testing.T) {
+	fake := clock.NewFake(time.Unix(0, 0))
+	s := New()
+	s.Samples = 2
+	s.Clock = fake
+	session := link.NewSession(nil, 0)
+	ms := int64(time.Millisecond)
+
+	// 100ms round trip, the reply was delayed on the way back.
+	fake.Advance(100 * time.Millisecond)
+	s.handlePong(session, &Pong{0, 10 * ms, 10 * ms})
+	utest.EqualNow(t, session.RTT(), 100*time.Millisecond)
+	utest.EqualNow(t, session.ClockOffset(), -40*time.Millisecond)
+
+	// a quicker exchange is more accurate.
+	s.handlePong(session, &Pong{90 * ms, 95 * ms, 95 * ms})
+	utest.EqualNow(t, session.RTT(), 10*time.Millisecond)
+	utest.EqualNow(t, session.ClockOffset(), time.Duration(0))
+
+	// the oldest sample goes out first.
+	s.handlePong(session, &Pong{50 * ms, 80 * ms, 80 * ms})
+	utest.EqualNow(t, session.RTT(), 10*time.Millisecond)
+	s.handlePong(session, &Pong{50 * ms, 80 * ms, 80 * ms})
+	utest.EqualNow(t, session.RTT(), 50*time.Millisecond)
+}
+
+func Test_NoSamples(t *testing.T) {
+	s := New()
+	s.Samples = 0
+	s.Clock = clock.NewFake(time.Unix(0, 0))
+	session := link.NewSession(nil, 0)
+	// at least the newest sample is kept.
+	s.handlePong(session, &Pong{0, 0, 0})
+	utest.EqualNow(t, session.RTT(), time.Duration(0))
+}
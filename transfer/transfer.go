@@ -0,0 +1,356 @@
+package transfer
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/clock"
+)
+
+var RejectedError = link.NewError(link.PolicyError, "Transfer Rejected")
+var ChecksumError = link.NewError(link.ProtocolError, "Transfer Checksum Mismatch")
+var UnknownTransferError = link.NewError(link.ProtocolError, "Unknown Transfer")
+var TimeoutError = link.NewError(link.TransportError, "Transfer Timeout")
+var DuplicateTransferError = link.NewError(link.ProtocolError, "Duplicate Transfer")
+
+// The messages of a transfer. They have to be registered with the codec of
+// the sessions, like application messages.
+type Offer struct {
+	ID     uint64
+	Name   string
+	Size   int64
+	SHA256 []byte
+}
+
+// Accept resumes the transfer from Offset, zero for a new file.
+type Accept struct {
+	ID     uint64
+	Offset int64
+}
+
+type Reject struct {
+	ID     uint64
+	Reason string
+}
+
+type Chunk struct {
+	ID     uint64
+	Offset int64
+	Data   []byte
+	CRC32  uint32
+}
+
+// Ack confirms the data up to Offset.
+type Ack struct {
+	ID     uint64
+	Offset int64
+}
+
+// Done ends a transfer after the receiver checked the whole file, Error is
+// empty on success.
+type Done struct {
+	ID    uint64
+	Error string
+}
+
+// File is where received data goes. It is read back for the checksum, so
+// resumed transfers are verified as a whole.
+type File interface {
+	io.ReaderAt
+	io.WriterAt
+}
+
+type key struct {
+	session uint64
+	id      uint64
+}
+
+// receiveKey is the close callback key of an incoming transfer, both peers
+// number their transfers from 1, so it must not be the one of the outgoing
+// transfer with the same ID.
+type receiveKey key
+
+// Transfers sends and receives files over sessions whose messages are
+// dispatched by a link.Router, see Register. Files are sent in chunks with a
+// CRC32 each, at most Window of them unacknowledged.
+type Transfers struct {
+	ChunkSize int           // default 64KB
+	Window    int           // default 8
+	Timeout   time.Duration // waiting for the peer, default 30s
+	Clock     clock.Clock   // of Timeout, nil is clock.Default()
+
+	// OnOffer accepts an offer by returning the file to write and the offset
+	// to resume from, or rejects it with an error. Offers are rejected when
+	// it is nil.
+	OnOffer func(session *link.Session, offer *Offer) (File, int64, error)
+
+	// OnProgress is called on both sides with the bytes transferred so far.
+	OnProgress func(session *link.Session, id uint64, done, size int64)
+
+	// OnReceived is called when an accepted transfer ends, err is nil when
+	// the checksum matched.
+	OnReceived func(session *link.Session, offer *Offer, err error)
+
+	mutex     sync.Mutex
+	sending   map[key]chan interface{}
+	receiving map[key]*incoming
+	nextID    atomic.Uint64
+}
+
+type incoming struct {
+	offer  *Offer
+	file   File
+	offset int64
+}
+
+func New() *Transfers {
+	return &Transfers{
+		ChunkSize: 64 * 1024,
+		Window:    8,
+		Timeout:   30 * time.Second,
+		sending:   make(map[key]chan interface{}),
+		receiving: make(map[key]*incoming),
+	}
+}
+
+// Register adds the handlers of the transfer messages to router.
+func (t *Transfers) Register(router *link.Router) {
+	link.RegisterHandler(router, t.handleOffer)
+	link.RegisterHandler(router, t.handleChunk)
+	link.RegisterHandler(router, func(session *link.Session, msg *Accept) {
+		t.notify(session, msg.ID, msg)
+	})
+	link.RegisterHandler(router, func(session *link.Session, msg *Reject) {
+		t.notify(session, msg.ID, msg)
+	})
+	link.RegisterHandler(router, func(session *link.Session, msg *Ack) {
+		t.notify(session, msg.ID, msg)
+	})
+	link.RegisterHandler(router, func(session *link.Session, msg *Done) {
+		t.notify(session, msg.ID, msg)
+	})
+}
+
+func (t *Transfers) notify(session *link.Session, id uint64, msg interface{}) {
+	t.mutex.Lock()
+	events, exists := t.sending[key{session.ID(), id}]
+	t.mutex.Unlock()
+	if !exists {
+		return
+	}
+	// the window bounds what a well behaved peer sends.
+	select {
+	case events <- msg:
+	default:
+	}
+}
+
+// Send offers size bytes of file as name and sends them once the peer
+// accepts. It returns when the peer confirmed the checksum, so it must not
+// be called by a handler of the same session.
+func (t *Transfers) Send(session *link.Session, name string, file io.ReaderAt, size int64) error {
+	sum := sha256.New()
+	if _, err := io.Copy(sum, io.NewSectionReader(file, 0, size)); err != nil {
+		return err
+	}
+	id := t.nextID.Add(1)
+	k := key{session.ID(), id}
+	events := make(chan interface{}, t.Window+2)
+	closed := make(chan struct{})
+	t.mutex.Lock()
+	t.sending[k] = events
+	t.mutex.Unlock()
+	session.AddCloseCallback(t, k, func() { close(closed) })
+	defer func() {
+		session.RemoveCloseCallback(t, k)
+		t.mutex.Lock()
+		delete(t.sending, k)
+		t.mutex.Unlock()
+	}()
+
+	wait := func() (interface{}, error) {
+		timer := clock.Or(t.Clock).NewTimer(t.Timeout)
+		defer timer.Stop()
+		select {
+		case msg := <-events:
+			if done, ok := msg.(*Done); ok && done.Error != "" {
+				return nil, fmt.Errorf("transfer: failed at the peer: %s", done.Error)
+			}
+			return msg, nil
+		case <-closed:
+			return nil, link.SessionClosedError
+		case <-timer.C():
+			return nil, TimeoutError
+		}
+	}
+
+	if err := session.Send(&Offer{id, name, size, sum.Sum(nil)}); err != nil {
+		return err
+	}
+	msg, err := wait()
+	if err != nil {
+		return err
+	}
+	var offset int64
+	switch m := msg.(type) {
+	case *Accept:
+		offset = m.Offset
+	case *Reject:
+		return fmt.Errorf("%w: %s", RejectedError, m.Reason)
+	default:
+		return UnknownTransferError
+	}
+	if offset < 0 || offset > size {
+		return UnknownTransferError
+	}
+
+	acked, inflight := offset, 0
+	for offset < size || inflight > 0 {
+		if offset < size && inflight < t.Window {
+			data := make([]byte, min(int64(t.ChunkSize), size-offset))
+			if _, err := file.ReadAt(data, offset); err != nil && err != io.EOF {
+				return err
+			}
+			if err := session.Send(&Chunk{id, offset, data, crc32.ChecksumIEEE(data)}); err != nil {
+				return err
+			}
+			offset += int64(len(data))
+			inflight++
+			continue
+		}
+		msg, err := wait()
+		if err != nil {
+			return err
+		}
+		switch m := msg.(type) {
+		case *Ack:
+			if m.Offset > acked {
+				acked = m.Offset
+				inflight--
+				if t.OnProgress != nil {
+					t.OnProgress(session, id, acked, size)
+				}
+			}
+		case *Done:
+			return UnknownTransferError
+		}
+	}
+	msg, err = wait()
+	if err != nil {
+		return err
+	}
+	if _, ok := msg.(*Done); !ok {
+		return UnknownTransferError
+	}
+	return nil
+}
+
+func (t *Transfers) handleOffer(session *link.Session, offer *Offer) {
+	if t.OnOffer == nil {
+		session.Send(&Reject{offer.ID, "transfers not accepted"})
+		return
+	}
+	k := key{session.ID(), offer.ID}
+	t.mutex.Lock()
+	_, exists := t.receiving[k]
+	t.mutex.Unlock()
+	if exists {
+		session.Send(&Reject{offer.ID, DuplicateTransferError.Error()})
+		return
+	}
+	file, offset, err := t.OnOffer(session, offer)
+	if err != nil {
+		session.Send(&Reject{offer.ID, err.Error()})
+		return
+	}
+	offset = min(max(offset, 0), offer.Size)
+	in := &incoming{offer, file, offset}
+	t.mutex.Lock()
+	if _, exists := t.receiving[k]; exists {
+		t.mutex.Unlock()
+		session.Send(&Reject{offer.ID, DuplicateTransferError.Error()})
+		return
+	}
+	t.receiving[k] = in
+	t.mutex.Unlock()
+	session.AddCloseCallback(t, receiveKey(k), func() {
+		t.mutex.Lock()
+		delete(t.receiving, k)
+		t.mutex.Unlock()
+	})
+	session.Send(&Accept{offer.ID, offset})
+	if offset == offer.Size {
+		t.finish(session, k, in)
+	}
+}
+
+func (t *Transfers) handleChunk(session *link.Session, chunk *Chunk) {
+	k := key{session.ID(), chunk.ID}
+	t.mutex.Lock()
+	in, exists := t.receiving[k]
+	t.mutex.Unlock()
+	if !exists {
+		session.Send(&Done{chunk.ID, UnknownTransferError.Error()})
+		return
+	}
+	var err error
+	switch {
+	case chunk.Offset != in.offset || in.offset+int64(len(chunk.Data)) > in.offer.Size:
+		err = UnknownTransferError
+	case crc32.ChecksumIEEE(chunk.Data) != chunk.CRC32:
+		err = ChecksumError
+	default:
+		_, err = in.file.WriteAt(chunk.Data, chunk.Offset)
+	}
+	if err != nil {
+		t.fail(session, k, in, err)
+		return
+	}
+	in.offset += int64(len(chunk.Data))
+	session.Send(&Ack{chunk.ID, in.offset})
+	if t.OnProgress != nil {
+		t.OnProgress(session, chunk.ID, in.offset, in.offer.Size)
+	}
+	if in.offset == in.offer.Size {
+		t.finish(session, k, in)
+	}
+}
+
+func (t *Transfers) finish(session *link.Session, k key, in *incoming) {
+	sum := sha256.New()
+	_, err := io.Copy(sum, io.NewSectionReader(in.file, 0, in.offer.Size))
+	if err == nil && !bytes.Equal(sum.Sum(nil), in.offer.SHA256) {
+		err = ChecksumError
+	}
+	if err != nil {
+		t.fail(session, k, in, err)
+		return
+	}
+	t.end(session, k)
+	session.Send(&Done{ID: in.offer.ID})
+	if t.OnReceived != nil {
+		t.OnReceived(session, in.offer, nil)
+	}
+}
+
+func (t *Transfers) fail(session *link.Session, k key, in *incoming, err error) {
+	t.end(session, k)
+	session.Send(&Done{in.offer.ID, err.Error()})
+	if t.OnReceived != nil {
+		t.OnReceived(session, in.offer, err)
+	}
+}
+
+func (t *Transfers) end(session *link.Session, k key) {
+	session.RemoveCloseCallback(t, receiveKey(k))
+	t.mutex.Lock()
+	delete(t.receiving, k)
+	t.mutex.Unlock()
+}
@@ -0,0 +1,140 @@
+package transfer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/codec"
+	"github.com/funny/utest"
+)
+
+func testProtocol() link.Protocol {
+	json := codec.Json()
+	for _, msg := range []interface{}{Offer{}, Accept{}, Reject{}, Chunk{}, Ack{}, Done{}} {
+		json.Register(msg)
+	}
+	return codec.FixLen(json, 4, binary.LittleEndian, 1<<20, 1<<20)
+}
+
+func Test_Transfer(t *testing.T) {
+	data := make([]byte, 300*1024+17)
+	rand.Read(data)
+	source := bytes.NewReader(data)
+	dir := t.TempDir()
+
+	sender, receiver := New(), New()
+	sender.ChunkSize = 16 * 1024
+	// called by Send, on the test goroutine.
+	var progress []int64
+	sender.OnProgress = func(_ *link.Session, _ uint64, done, size int64) {
+		progress = append(progress, done)
+	}
+	received := make(chan error, 1)
+	receiver.OnOffer = func(_ *link.Session, offer *Offer) (File, int64, error) {
+		if offer.Name == "secret" {
+			return nil, 0, errors.New("no thanks")
+		}
+		file, err := os.OpenFile(filepath.Join(dir, offer.Name), os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, 0, err
+		}
+		info, _ := file.Stat()
+		return file, info.Size(), nil
+	}
+	receiver.OnReceived = func(_ *link.Session, _ *Offer, err error) {
+		received <- err
+	}
+
+	client, server, err := link.Pipe(testProtocol(), 64)
+	utest.IsNilNow(t, err)
+	defer client.Close()
+	for _, pair := range []struct {
+		transfers *Transfers
+		session   *link.Session
+	}{{sender, client}, {receiver, server}} {
+		router := link.NewRouter()
+		pair.transfers.Register(router)
+		go router.HandleSession(pair.session)
+	}
+
+	utest.IsNilNow(t, sender.Send(client, "asset", source, int64(len(data))))
+	utest.IsNilNow(t, <-received)
+	got, _ := os.ReadFile(filepath.Join(dir, "asset"))
+	utest.Assert(t, bytes.Equal(got, data))
+	utest.EqualNow(t, progress[len(progress)-1], int64(len(data)))
+
+	// the receiver keeps the first half, only the rest is sent.
+	half := data[:len(data)/2]
+	utest.IsNilNow(t, os.WriteFile(filepath.Join(dir, "resumed"), half, 0644))
+	progress = nil
+	utest.IsNilNow(t, sender.Send(client, "resumed", source, int64(len(data))))
+	utest.IsNilNow(t, <-received)
+	got, _ = os.ReadFile(filepath.Join(dir, "resumed"))
+	utest.Assert(t, bytes.Equal(got, data))
+	utest.Assert(t, progress[0] > int64(len(half)))
+
+	// a corrupt partial file fails the checksum.
+	utest.IsNilNow(t, os.WriteFile(filepath.Join(dir, "corrupt"), make([]byte, 1000), 0644))
+	utest.NotNilNow(t, sender.Send(client, "corrupt", source, int64(len(data))))
+	utest.EqualNow(t, <-received, ChecksumError)
+
+	err = sender.Send(client, "secret", source, int64(len(data)))
+	utest.Assert(t, errors.Is(err, RejectedError))
+}
+
+func Test_TransferKeys(t *testing.T) {
+	client, server, err := link.Pipe(testProtocol(), 64)
+	utest.IsNilNow(t, err)
+	defer client.Close()
+	transfers := New()
+	transfers.OnOffer = func(_ *link.Session, offer *Offer) (File, int64, error) {
+		return &bytesFile{}, 0, nil
+	}
+
+	// a second offer with the ID of a running transfer is rejected.
+	transfers.handleOffer(server, &Offer{ID: 1, Name: "a", Size: 10})
+	transfers.handleOffer(server, &Offer{ID: 1, Name: "b", Size: 10})
+	msg, err := client.Receive()
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, msg.(*Accept).ID, uint64(1))
+	msg, err = client.Receive()
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, msg.(*Reject).Reason, DuplicateTransferError.Error())
+
+	// the outgoing transfer 1 of the same session doesn't touch the close
+	// callback of the incoming one.
+	server.AddCloseCallback(transfers, key{server.ID(), 1}, func() {})
+	server.RemoveCloseCallback(transfers, key{server.ID(), 1})
+	server.Close()
+	for {
+		transfers.mutex.Lock()
+		n := len(transfers.receiving)
+		transfers.mutex.Unlock()
+		if n == 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+type bytesFile struct {
+	data []byte
+}
+
+func (f *bytesFile) ReadAt(p []byte, off int64) (int, error) {
+	return copy(p, f.data[off:]), nil
+}
+
+func (f *bytesFile) WriteAt(p []byte, off int64) (int, error) {
+	if end := int(off) + len(p); end > len(f.data) {
+		f.data = append(f.data, make([]byte, end-len(f.data))...)
+	}
+	return copy(f.data[off:], p), nil
+}
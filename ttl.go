@@ -0,0 +1,32 @@
+package link
+
+import (
+	"time"
+
+	"github.com/funny/link/clock"
+)
+
+// Expiring is a message with a deadline, see WithTTL.
+type Expiring struct {
+	Msg      interface{}
+	Deadline time.Time
+}
+
+// WithTTL wraps msg for Session.Send so it is dropped instead of sent when it
+// is still queued after ttl, like position updates which are stale after a
+// lag spike. The codec gets msg itself.
+func WithTTL(msg interface{}, ttl time.Duration) *Expiring {
+	return &Expiring{msg, clock.Default().Now().Add(ttl)}
+}
+
+// unwrapExpiring returns the message to send, false when it expired.
+func unwrapExpiring(msg interface{}) (interface{}, bool) {
+	e, ok := msg.(*Expiring)
+	if !ok {
+		return msg, true
+	}
+	if !clock.Default().Now().Before(e.Deadline) {
+		return nil, false
+	}
+	return e.Msg, true
+}
@@ -0,0 +1,287 @@
+package udp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/clock"
+	"github.com/funny/link/codec"
+)
+
+var ErrRetransmitLimit = link.NewError(link.TransportError, "Retransmit Limit Exceeded")
+
+const (
+	kindUnreliable byte = iota
+	kindReliable
+	kindAck
+)
+
+const arqHeadSize = 5
+
+type Reliable struct {
+	Msg interface{}
+}
+
+type ARQConfig struct {
+	MaxPacket  int
+	RTO        time.Duration
+	MaxRetries int
+	RecvWindow uint32
+	Interval   time.Duration
+	Clock      clock.Clock // nil is clock.Default()
+}
+
+var DefaultARQConfig = ARQConfig{
+	MaxPacket:  1400,
+	RTO:        200 * time.Millisecond,
+	MaxRetries: 10,
+	RecvWindow: 1024,
+	Interval:   50 * time.Millisecond,
+}
+
+type arqProtocol struct {
+	base   link.Protocol
+	config ARQConfig
+}
+
+func ARQ(base link.Protocol, config ARQConfig) link.Protocol {
+	return &arqProtocol{base, config}
+}
+
+func (p *arqProtocol) NewCodec(rw io.ReadWriter) (cc link.Codec, err error) {
+	codec := &arqCodec{
+		rw:         rw,
+		config:     p.config,
+		recvPacket: make([]byte, p.config.MaxPacket+1),
+		pending:    make(map[uint32]*arqPending),
+		seen:       make(map[uint32]struct{}),
+		closeChan:  make(chan struct{}),
+	}
+	codec.base, err = p.base.NewCodec(&codec.buffer)
+	if err != nil {
+		return
+	}
+	go codec.retransmitLoop()
+	cc = codec
+	return
+}
+
+type arqBuffer struct {
+	recvBuf bytes.Reader
+	sendBuf bytes.Buffer
+}
+
+func (b *arqBuffer) Read(p []byte) (int, error) {
+	return b.recvBuf.Read(p)
+}
+
+func (b *arqBuffer) Write(p []byte) (int, error) {
+	return b.sendBuf.Write(p)
+}
+
+type arqPending struct {
+	packet  []byte
+	sentAt  time.Time
+	retries int
+}
+
+type arqCodec struct {
+	base       link.Codec
+	rw         io.ReadWriter
+	config     ARQConfig
+	buffer     arqBuffer
+	recvPacket []byte
+	writeMutex sync.Mutex
+
+	sendSeq      uint32
+	pendingMutex sync.Mutex
+	pending      map[uint32]*arqPending
+
+	recvHighest uint32
+	seen        map[uint32]struct{}
+
+	closeOnce sync.Once
+	closeChan chan struct{}
+	err       error
+}
+
+func (c *arqCodec) write(packet []byte) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	_, err := c.rw.Write(packet)
+	return err
+}
+
+func (c *arqCodec) Send(msg interface{}) error {
+	if encoded, ok := msg.(link.Encoded); ok {
+		return c.write(encoded)
+	}
+	kind := kindUnreliable
+	if r, ok := msg.(Reliable); ok {
+		kind = kindReliable
+		msg = r.Msg
+	}
+
+	c.buffer.sendBuf.Reset()
+	c.buffer.sendBuf.Write(make([]byte, arqHeadSize))
+	if err := c.base.Send(msg); err != nil {
+		return err
+	}
+	if c.buffer.sendBuf.Len() > c.config.MaxPacket {
+		return codec.ErrTooLargePacket
+	}
+
+	packet := c.buffer.sendBuf.Bytes()
+	packet[0] = kind
+	if kind == kindReliable {
+		packet = append([]byte(nil), packet...)
+		c.pendingMutex.Lock()
+		c.sendSeq++
+		binary.LittleEndian.PutUint32(packet[1:], c.sendSeq)
+		c.pending[c.sendSeq] = &arqPending{packet: packet, sentAt: clock.Or(c.config.Clock).Now()}
+		c.pendingMutex.Unlock()
+	}
+	return c.write(packet)
+}
+
+func (c *arqCodec) Receive() (interface{}, error) {
+	for {
+		n, err := c.rw.Read(c.recvPacket)
+		if err != nil {
+			return nil, c.closeErr(err)
+		}
+		if n > c.config.MaxPacket {
+			return nil, codec.ErrTooLargePacket
+		}
+		if n < arqHeadSize {
+			continue
+		}
+		packet := c.recvPacket[:n]
+		seq := binary.LittleEndian.Uint32(packet[1:])
+
+		switch packet[0] {
+		case kindAck:
+			c.pendingMutex.Lock()
+			delete(c.pending, seq)
+			c.pendingMutex.Unlock()
+			continue
+		case kindReliable:
+			inWindow, fresh := c.check(seq)
+			if !inWindow {
+				continue
+			}
+			var ack [arqHeadSize]byte
+			ack[0] = kindAck
+			binary.LittleEndian.PutUint32(ack[1:], seq)
+			if err := c.write(ack[:]); err != nil {
+				return nil, err
+			}
+			if !fresh {
+				continue
+			}
+		case kindUnreliable:
+		default:
+			continue
+		}
+
+		c.buffer.recvBuf.Reset(packet[arqHeadSize:])
+		return c.base.Receive()
+	}
+}
+
+// check classifies a reliable seq. Seqs are serial numbers which wrap
+// around, a seq more than RecvWindow ahead of the highest one received is
+// dropped without an ack so the peer sends it again later, anything else is
+// acked and fresh the first time it is seen.
+func (c *arqCodec) check(seq uint32) (inWindow, fresh bool) {
+	window := int32(c.config.RecvWindow)
+	diff := int32(seq - c.recvHighest)
+	if diff > window {
+		return false, false
+	}
+	if diff <= -window {
+		return true, false
+	}
+	if _, exists := c.seen[seq]; exists {
+		return true, false
+	}
+	c.seen[seq] = struct{}{}
+	if diff > 0 {
+		c.recvHighest = seq
+		for s := range c.seen {
+			if int32(c.recvHighest-s) >= window {
+				delete(c.seen, s)
+			}
+		}
+	}
+	return true, true
+}
+
+func (c *arqCodec) retransmitLoop() {
+	ticker := clock.Or(c.config.Clock).NewTicker(c.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C():
+			var resend [][]byte
+			failed := false
+			c.pendingMutex.Lock()
+			for _, p := range c.pending {
+				if now.Sub(p.sentAt) < c.config.RTO {
+					continue
+				}
+				if p.retries >= c.config.MaxRetries {
+					failed = true
+					break
+				}
+				p.retries++
+				p.sentAt = now
+				resend = append(resend, p.packet)
+			}
+			c.pendingMutex.Unlock()
+
+			if failed {
+				c.fail(ErrRetransmitLimit)
+				return
+			}
+			for _, packet := range resend {
+				if c.write(packet) != nil {
+					return
+				}
+			}
+		case <-c.closeChan:
+			return
+		}
+	}
+}
+
+func (c *arqCodec) fail(err error) {
+	c.pendingMutex.Lock()
+	c.err = err
+	c.pendingMutex.Unlock()
+	c.Close()
+}
+
+func (c *arqCodec) closeErr(err error) error {
+	c.pendingMutex.Lock()
+	defer c.pendingMutex.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	return err
+}
+
+func (c *arqCodec) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.closeChan)
+		if closer, ok := c.rw.(io.Closer); ok {
+			err = closer.Close()
+		}
+	})
+	return err
+}
@@ -0,0 +1,90 @@
+package udp
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+
+	"github.com/funny/link"
+	"github.com/funny/link/codec"
+	"github.com/funny/utest"
+)
+
+type lossyConn struct {
+	net.Conn
+	n int32
+}
+
+func (c *lossyConn) Write(p []byte) (int, error) {
+	if atomic.AddInt32(&c.n, 1)%3 == 0 {
+		return len(p), nil
+	}
+	return c.Conn.Write(p)
+}
+
+func Test_ARQ(t *testing.T) {
+	json := codec.Json()
+	json.Register(EchoMsg{})
+	protocol := ARQ(json, DefaultARQConfig)
+
+	c1, c2 := net.Pipe()
+
+	codec1, err := protocol.NewCodec(&lossyConn{Conn: c1})
+	utest.IsNilNow(t, err)
+	codec2, err := protocol.NewCodec(&lossyConn{Conn: c2})
+	utest.IsNilNow(t, err)
+
+	sender := link.NewSession(codec1, 0)
+	receiver := link.NewSession(codec2, 0)
+	defer sender.Close()
+	defer receiver.Close()
+
+	go func() {
+		for {
+			if _, err := sender.Receive(); err != nil {
+				return
+			}
+		}
+	}()
+
+	const N = 50
+	go func() {
+		for i := 0; i < N; i++ {
+			sender.Send(Reliable{&EchoMsg{i}})
+		}
+	}()
+
+	got := make(map[int]bool)
+	for len(got) < N {
+		msg, err := receiver.Receive()
+		utest.IsNilNow(t, err)
+		seq := msg.(*EchoMsg).Seq
+		utest.Assert(t, !got[seq], "duplicate message", seq)
+		got[seq] = true
+	}
+}
+
+func Test_ARQWindow(t *testing.T) {
+	c := &arqCodec{config: ARQConfig{RecvWindow: 4}, seen: make(map[uint32]struct{})}
+	check := func(seq uint32, inWindow, fresh bool) {
+		i, f := c.check(seq)
+		utest.EqualNow(t, [2]bool{i, f}, [2]bool{inWindow, fresh})
+	}
+	check(1, true, true)
+	check(1, true, false)
+	// too far ahead, the peer sends it again later.
+	check(1000, false, false)
+	check(3, true, true)
+	check(2, true, true)
+	check(7, true, true)
+	// out of the window behind, acked as seen.
+	check(3, true, false)
+	utest.Assert(t, len(c.seen) <= 4)
+
+	// seqs wrap around.
+	c.recvHighest = 1<<32 - 2
+	check(1<<32-1, true, true)
+	check(1, true, true)
+	check(1<<32-1, true, false)
+	utest.EqualNow(t, c.recvHighest, uint32(1))
+}
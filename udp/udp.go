@@ -0,0 +1,307 @@
+package udp
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/clock"
+)
+
+var ConnClosedError = link.NewError(link.TransportError, "Conn Closed")
+
+type Config struct {
+	MaxPacket     int
+	AcceptBacklog int
+	RecvQueueSize int
+	IdleTimeout   time.Duration
+	Clock         clock.Clock // nil is clock.Default()
+}
+
+var DefaultConfig = Config{
+	MaxPacket:     64 * 1024,
+	AcceptBacklog: 128,
+	RecvQueueSize: 128,
+	IdleTimeout:   time.Minute,
+}
+
+type Listener struct {
+	conn       net.PacketConn
+	config     Config
+	mutex      sync.Mutex
+	conns      map[string]*Conn
+	acceptChan chan *Conn
+	closeOnce  sync.Once
+	closeChan  chan struct{}
+	err        error
+}
+
+// NewListener serves the datagrams of conn, zero sizes of config take the
+// values of DefaultConfig and a zero IdleTimeout keeps idle conns open.
+func NewListener(conn net.PacketConn, config Config) *Listener {
+	if config.MaxPacket <= 0 {
+		config.MaxPacket = DefaultConfig.MaxPacket
+	}
+	if config.AcceptBacklog <= 0 {
+		config.AcceptBacklog = DefaultConfig.AcceptBacklog
+	}
+	if config.RecvQueueSize <= 0 {
+		config.RecvQueueSize = DefaultConfig.RecvQueueSize
+	}
+	listener := &Listener{
+		conn:       conn,
+		config:     config,
+		conns:      make(map[string]*Conn),
+		acceptChan: make(chan *Conn, config.AcceptBacklog),
+		closeChan:  make(chan struct{}),
+	}
+	go listener.readLoop()
+	return listener
+}
+
+func ListenPacket(network, address string, config Config) (*Listener, error) {
+	conn, err := net.ListenPacket(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return NewListener(conn, config), nil
+}
+
+func (listener *Listener) readLoop() {
+	defer listener.Close()
+	buf := make([]byte, listener.config.MaxPacket+1)
+	for {
+		n, addr, err := listener.conn.ReadFrom(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			listener.mutex.Lock()
+			listener.err = err
+			listener.mutex.Unlock()
+			return
+		}
+		// the buffer is one byte larger, so an oversized datagram shows.
+		if n > listener.config.MaxPacket {
+			continue
+		}
+		packet := make([]byte, n)
+		copy(packet, buf[:n])
+		listener.dispatch(addr, packet)
+	}
+}
+
+func (listener *Listener) dispatch(addr net.Addr, packet []byte) {
+	key := addr.String()
+
+	listener.mutex.Lock()
+	conn, exists := listener.conns[key]
+	if !exists {
+		select {
+		case <-listener.closeChan:
+			listener.mutex.Unlock()
+			return
+		default:
+		}
+		conn = newConn(listener, addr)
+		select {
+		case listener.acceptChan <- conn:
+			listener.conns[key] = conn
+		default:
+			// accept backlog is full, drop the datagram like the kernel would.
+			listener.mutex.Unlock()
+			return
+		}
+	}
+	listener.mutex.Unlock()
+
+	conn.input(packet)
+}
+
+func (listener *Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-listener.acceptChan:
+		return conn, nil
+	case <-listener.closeChan:
+		return nil, io.EOF
+	}
+}
+
+func (listener *Listener) Close() error {
+	var err error
+	listener.closeOnce.Do(func() {
+		close(listener.closeChan)
+		err = listener.conn.Close()
+
+		listener.mutex.Lock()
+		conns := listener.conns
+		listener.conns = make(map[string]*Conn)
+		listener.mutex.Unlock()
+
+		for _, conn := range conns {
+			conn.Close()
+		}
+	})
+	return err
+}
+
+func (listener *Listener) Addr() net.Addr {
+	return listener.conn.LocalAddr()
+}
+
+func (listener *Listener) Err() error {
+	listener.mutex.Lock()
+	defer listener.mutex.Unlock()
+	return listener.err
+}
+
+func (listener *Listener) delConn(conn *Conn) {
+	listener.mutex.Lock()
+	defer listener.mutex.Unlock()
+	key := conn.raddr.String()
+	if listener.conns[key] == conn {
+		delete(listener.conns, key)
+	}
+}
+
+type Conn struct {
+	listener  *Listener
+	raddr     net.Addr
+	recvChan  chan []byte
+	closeOnce sync.Once
+	closeChan chan struct{}
+
+	deadlineMutex sync.Mutex
+	readDeadline  time.Time
+	writeDeadline time.Time
+}
+
+func newConn(listener *Listener, raddr net.Addr) *Conn {
+	return &Conn{
+		listener:  listener,
+		raddr:     raddr,
+		recvChan:  make(chan []byte, listener.config.RecvQueueSize),
+		closeChan: make(chan struct{}),
+	}
+}
+
+func (conn *Conn) input(packet []byte) {
+	select {
+	case conn.recvChan <- packet:
+	case <-conn.closeChan:
+	default:
+		// receive queue is full, the datagram is dropped.
+	}
+}
+
+func (conn *Conn) Read(p []byte) (int, error) {
+	var timeout <-chan time.Time
+
+	conn.deadlineMutex.Lock()
+	deadline := conn.readDeadline
+	conn.deadlineMutex.Unlock()
+
+	clk := clock.Or(conn.listener.config.Clock)
+	if idle := conn.listener.config.IdleTimeout; idle > 0 {
+		if idleDeadline := clk.Now().Add(idle); deadline.IsZero() || idleDeadline.Before(deadline) {
+			deadline = idleDeadline
+		}
+	}
+	if !deadline.IsZero() {
+		timer := clk.NewTimer(deadline.Sub(clk.Now()))
+		defer timer.Stop()
+		timeout = timer.C()
+	}
+
+	select {
+	case packet := <-conn.recvChan:
+		n := copy(p, packet)
+		if n < len(packet) {
+			return n, io.ErrShortBuffer
+		}
+		return n, nil
+	case <-conn.closeChan:
+		return 0, io.EOF
+	case <-timeout:
+		return 0, timeoutError{}
+	}
+}
+
+func (conn *Conn) Write(p []byte) (int, error) {
+	select {
+	case <-conn.closeChan:
+		return 0, ConnClosedError
+	default:
+	}
+	if len(p) > conn.listener.config.MaxPacket {
+		return 0, io.ErrShortWrite
+	}
+	return conn.listener.conn.WriteTo(p, conn.raddr)
+}
+
+func (conn *Conn) Close() error {
+	err := ConnClosedError
+	conn.closeOnce.Do(func() {
+		close(conn.closeChan)
+		conn.listener.delConn(conn)
+		err = nil
+	})
+	return err
+}
+
+func (conn *Conn) LocalAddr() net.Addr {
+	return conn.listener.Addr()
+}
+
+func (conn *Conn) RemoteAddr() net.Addr {
+	return conn.raddr
+}
+
+func (conn *Conn) SetDeadline(t time.Time) error {
+	conn.SetReadDeadline(t)
+	conn.SetWriteDeadline(t)
+	return nil
+}
+
+func (conn *Conn) SetReadDeadline(t time.Time) error {
+	conn.deadlineMutex.Lock()
+	defer conn.deadlineMutex.Unlock()
+	conn.readDeadline = t
+	return nil
+}
+
+func (conn *Conn) SetWriteDeadline(t time.Time) error {
+	conn.deadlineMutex.Lock()
+	defer conn.deadlineMutex.Unlock()
+	conn.writeDeadline = t
+	return nil
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func Listen(network, address string, config Config, protocol link.Protocol, sendChanSize int, handler link.Handler) (*link.Server, error) {
+	listener, err := ListenPacket(network, address, config)
+	if err != nil {
+		return nil, err
+	}
+	return link.NewServer(listener, protocol, sendChanSize, handler), nil
+}
+
+func Dial(network, address string, protocol link.Protocol, sendChanSize int) (*link.Session, error) {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	codec, err := protocol.NewCodec(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return link.NewSession(codec, sendChanSize), nil
+}
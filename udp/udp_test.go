@@ -0,0 +1,79 @@
+package udp
+
+import (
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/funny/link"
+	"github.com/funny/link/codec"
+	"github.com/funny/utest"
+)
+
+type EchoMsg struct {
+	Seq int
+}
+
+func Test_UDP(t *testing.T) {
+	json := codec.Json()
+	json.Register(EchoMsg{})
+	protocol := codec.Packet(json, 1024, 1024)
+
+	server, err := Listen("udp", "127.0.0.1:0", DefaultConfig, protocol, 0, link.HandlerFunc(func(session *link.Session) {
+		defer session.Close()
+		for {
+			msg, err := session.Receive()
+			if err != nil {
+				return
+			}
+			if session.Send(msg) != nil {
+				return
+			}
+		}
+	}))
+	utest.IsNilNow(t, err)
+	go server.Serve()
+	defer server.Stop()
+
+	addr := server.Listener().Addr().String()
+
+	wait := new(sync.WaitGroup)
+	for i := 0; i < 10; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			session, err := Dial("udp", addr, protocol, 0)
+			utest.IsNilNow(t, err)
+			defer session.Close()
+			for j := 0; j < 100; j++ {
+				utest.IsNilNow(t, session.Send(&EchoMsg{j}))
+				msg, err := session.Receive()
+				utest.IsNilNow(t, err)
+				utest.EqualNow(t, msg.(*EchoMsg).Seq, j)
+			}
+		}()
+	}
+	wait.Wait()
+}
+
+func Test_MaxPacket(t *testing.T) {
+	listener, err := ListenPacket("udp", "127.0.0.1:0", Config{MaxPacket: 8})
+	utest.IsNilNow(t, err)
+	defer listener.Close()
+
+	client, err := net.Dial("udp", listener.Addr().String())
+	utest.IsNilNow(t, err)
+	defer client.Close()
+	_, err = client.Write([]byte("123456789"))
+	utest.IsNilNow(t, err)
+	_, err = client.Write([]byte("12345678"))
+	utest.IsNilNow(t, err)
+
+	// the oversized datagram is dropped, not cut to fit.
+	conn, err := listener.Accept()
+	utest.IsNilNow(t, err)
+	buf := make([]byte, 16)
+	n, err := conn.Read(buf)
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, string(buf[:n]), "12345678")
+}
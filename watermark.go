@@ -0,0 +1,50 @@
+package link
+
+// Watermarks are thresholds of the send queue of a session. OnHigh is called
+// when the queue grows to High, OnLow when it drained to Low after that, so
+// game logic can send fewer updates to a congested client and speed up again
+// instead of having its messages dropped. They run on the goroutine which
+// crossed the mark and must not block.
+type Watermarks struct {
+	High, Low int
+	OnHigh    func(session *Session)
+	OnLow     func(session *Session)
+}
+
+// SetWatermarks watches the send queue of session, nil stops it. Sessions
+// without a send queue never cross them.
+func (session *Session) SetWatermarks(w *Watermarks) {
+	session.watermarks.Store(w)
+	if w == nil {
+		session.congested.Store(false)
+	}
+}
+
+// Congested reports whether the send queue crossed High and did not drain
+// to Low yet.
+func (session *Session) Congested() bool {
+	return session.congested.Load()
+}
+
+func (session *Session) checkHigh() {
+	w := session.watermarks.Load()
+	if w == nil || session.congested.Load() || session.sendQueue.len() < w.High {
+		return
+	}
+	if session.congested.CompareAndSwap(false, true) && w.OnHigh != nil {
+		w.OnHigh(session)
+	}
+}
+
+func (session *Session) checkLow() {
+	if !session.congested.Load() {
+		return
+	}
+	w := session.watermarks.Load()
+	if w == nil || session.sendQueue.len() > w.Low {
+		return
+	}
+	if session.congested.CompareAndSwap(true, false) && w.OnLow != nil {
+		w.OnLow(session)
+	}
+}
@@ -0,0 +1,290 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/clock"
+)
+
+var (
+	QueueFullError    = link.NewError(link.PolicyError, "Webhook Queue Full")
+	StoppedError      = link.NewError(link.TransportError, "Webhook Forwarder Stopped")
+	BadSignatureError = link.NewError(link.PolicyError, "Webhook Bad Signature")
+)
+
+// Rule forwards the messages it matches to URL, signed with Secret.
+type Rule struct {
+	Name   string
+	URL    string
+	Secret string
+	Match  func(session *link.Session, msg interface{}) bool
+}
+
+// OfType matches messages of type T, as *T or T.
+func OfType[T any]() func(session *link.Session, msg interface{}) bool {
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	return func(session *link.Session, msg interface{}) bool {
+		mt := reflect.TypeOf(msg)
+		return mt == t || mt == reflect.PointerTo(t)
+	}
+}
+
+// Delivery is the JSON body POSTed to a webhook. The ID stays the same
+// across retries, receivers use it to drop duplicates.
+type Delivery struct {
+	ID      string          `json:"id"`
+	Rule    string          `json:"rule"`
+	Type    string          `json:"type"`
+	Session uint64          `json:"session"`
+	Time    time.Time       `json:"time"`
+	Body    json.RawMessage `json:"body"`
+
+	url, secret string
+}
+
+// SignatureHeader carries "t=<unix time>,v1=<hex hmac>", the HMAC-SHA256 of
+// the time, a dot and the body, keyed with the secret of the rule.
+const SignatureHeader = "X-Webhook-Signature"
+
+func sign(secret string, t int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", t)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature of a received webhook, which must not be older
+// than tolerance, so captured requests can't be replayed later.
+func Verify(secret, header string, body []byte, tolerance time.Duration) error {
+	var t int64
+	var signature string
+	for _, part := range strings.Split(header, ",") {
+		k, v, _ := strings.Cut(part, "=")
+		switch k {
+		case "t":
+			t, _ = strconv.ParseInt(v, 10, 64)
+		case "v1":
+			signature = v
+		}
+	}
+	if t == 0 || signature == "" {
+		return BadSignatureError
+	}
+	if d := clock.Default().Since(time.Unix(t, 0)); d > tolerance || d < -tolerance {
+		return BadSignatureError
+	}
+	if !hmac.Equal([]byte(signature), []byte(sign(secret, t, body))) {
+		return BadSignatureError
+	}
+	return nil
+}
+
+// Forwarder POSTs the messages matched by its rules as webhooks. Deliveries
+// are queued and sent by a few workers, failed ones are retried with
+// backoff. A delivery which failed MaxAttempts times, was refused with a 4xx
+// status other than 408 and 429, or didn't fit the queue is a dead letter,
+// it goes to OnDeadLetter, by default it is logged.
+type Forwarder struct {
+	rules   []Rule
+	queue   chan *Delivery
+	dead    atomic.Uint64
+	workers sync.WaitGroup
+
+	Client      *http.Client
+	MaxAttempts int           // default 5
+	Backoff     time.Duration // before the first retry, doubled after each, default 1s
+	MaxBackoff  time.Duration // default 1m
+	Clock       clock.Clock   // of the backoff and timestamps, nil is clock.Default()
+
+	OnDeadLetter func(delivery *Delivery, err error)
+
+	ctx  context.Context
+	stop context.CancelFunc
+}
+
+func New(workers, queueSize int, rules ...Rule) *Forwarder {
+	f := &Forwarder{
+		rules:       rules,
+		queue:       make(chan *Delivery, queueSize),
+		Client:      &http.Client{Timeout: 10 * time.Second},
+		MaxAttempts: 5,
+		Backoff:     time.Second,
+		MaxBackoff:  time.Minute,
+	}
+	f.ctx, f.stop = context.WithCancel(context.Background())
+	for i := 0; i < workers; i++ {
+		f.workers.Add(1)
+		go f.worker()
+	}
+	return f
+}
+
+// Middleware forwards the messages of a Router before they are handled.
+func (f *Forwarder) Middleware() link.Middleware {
+	return func(next link.MessageHandler) link.MessageHandler {
+		return func(ctx context.Context, session *link.Session, msg interface{}) {
+			f.Forward(session, msg)
+			next(ctx, session, msg)
+		}
+	}
+}
+
+// Forward queues a delivery of msg for every rule it matches and returns
+// how many.
+func (f *Forwarder) Forward(session *link.Session, msg interface{}) int {
+	var matched []Rule
+	for _, rule := range f.rules {
+		if rule.Match(session, msg) {
+			matched = append(matched, rule)
+		}
+	}
+	if len(matched) == 0 {
+		return 0
+	}
+	body, err := json.Marshal(msg)
+	if err != nil {
+		link.GetLogger().Warn("webhook: marshal failed", "type", reflect.TypeOf(msg), "error", err)
+		return 0
+	}
+	var id uint64
+	if session != nil {
+		id = session.ID()
+	}
+	now := clock.Or(f.Clock).Now()
+	for _, rule := range matched {
+		delivery := &Delivery{
+			ID:      newID(),
+			Rule:    rule.Name,
+			Type:    reflect.Indirect(reflect.ValueOf(msg)).Type().Name(),
+			Session: id,
+			Time:    now,
+			Body:    body,
+			url:     rule.URL,
+			secret:  rule.Secret,
+		}
+		if f.ctx.Err() != nil {
+			f.deadLetter(delivery, StoppedError)
+			continue
+		}
+		select {
+		case f.queue <- delivery:
+		default:
+			f.deadLetter(delivery, QueueFullError)
+		}
+	}
+	return len(matched)
+}
+
+func newID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// DeadLetters returns the number of deliveries given up.
+func (f *Forwarder) DeadLetters() uint64 {
+	return f.dead.Load()
+}
+
+func (f *Forwarder) deadLetter(delivery *Delivery, err error) {
+	f.dead.Add(1)
+	if f.OnDeadLetter != nil {
+		f.OnDeadLetter(delivery, err)
+		return
+	}
+	link.GetLogger().Error("webhook: delivery failed", "rule", delivery.Rule, "id", delivery.ID, "error", err)
+}
+
+func (f *Forwarder) worker() {
+	defer f.workers.Done()
+	for {
+		select {
+		case delivery := <-f.queue:
+			f.deliver(delivery)
+		case <-f.ctx.Done():
+			return
+		}
+	}
+}
+
+func (f *Forwarder) deliver(delivery *Delivery) {
+	body, _ := json.Marshal(delivery)
+	backoff := f.Backoff
+	for attempt := 1; ; attempt++ {
+		retry, err := f.post(delivery, body)
+		if err == nil {
+			return
+		}
+		if !retry || attempt >= f.MaxAttempts {
+			f.deadLetter(delivery, err)
+			return
+		}
+		timer := clock.Or(f.Clock).NewTimer(backoff)
+		select {
+		case <-timer.C():
+		case <-f.ctx.Done():
+			timer.Stop()
+			f.deadLetter(delivery, StoppedError)
+			return
+		}
+		if backoff *= 2; backoff > f.MaxBackoff {
+			backoff = f.MaxBackoff
+		}
+	}
+}
+
+// post reports whether a failure is worth retrying.
+func (f *Forwarder) post(delivery *Delivery, body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, delivery.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	t := clock.Or(f.Clock).Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Id", delivery.ID)
+	req.Header.Set(SignatureHeader, fmt.Sprintf("t=%d,v1=%s", t, sign(delivery.secret, t, body)))
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode/100 == 2:
+		return false, nil
+	case resp.StatusCode/100 == 4 && resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests:
+		return false, fmt.Errorf("webhook: refused with status %s", resp.Status)
+	}
+	return true, fmt.Errorf("webhook: status %s", resp.Status)
+}
+
+// Stop waits for the posts in flight and ends the workers, deliveries which
+// are queued or waiting for a retry become dead letters.
+func (f *Forwarder) Stop() {
+	f.stop()
+	f.workers.Wait()
+	for {
+		select {
+		case delivery := <-f.queue:
+			f.deadLetter(delivery, StoppedError)
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/clock"
+	"github.com/funny/utest"
+)
+
+type Purchase struct {
+	Item  string
+	Price int
+}
+
+type Chat struct {
+	Text string
+}
+
+func Test_Forwarder(t *testing.T) {
+	var mutex sync.Mutex
+	attempts := make(map[string]int)
+	received := make(chan *Delivery, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if Verify("secret", r.Header.Get(SignatureHeader), body, time.Minute) != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var delivery Delivery
+		json.Unmarshal(body, &delivery)
+		mutex.Lock()
+		attempts[delivery.ID]++
+		n := attempts[delivery.ID]
+		mutex.Unlock()
+		// the first attempt fails.
+		if n == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		received <- &delivery
+	}))
+	defer server.Close()
+
+	dead := make(chan error, 10)
+	f := New(2, 16,
+		Rule{Name: "purchases", URL: server.URL, Secret: "secret", Match: OfType[Purchase]()},
+		Rule{Name: "wrong secret", URL: server.URL, Secret: "guess", Match: func(session *link.Session, msg interface{}) bool {
+			purchase, ok := msg.(*Purchase)
+			return ok && purchase.Price > 100
+		}},
+	)
+	clk := clock.NewFake(time.Now())
+	f.Clock = clk
+	f.Backoff = time.Second
+	f.OnDeadLetter = func(delivery *Delivery, err error) {
+		utest.EqualNow(t, delivery.Rule, "wrong secret")
+		dead <- err
+	}
+	defer f.Stop()
+
+	utest.EqualNow(t, f.Forward(nil, &Chat{"hi"}), 0)
+	utest.EqualNow(t, f.Forward(nil, &Purchase{"sword", 10}), 1)
+	clk.BlockUntil(1)
+	clk.Advance(time.Second)
+	delivery := <-received
+	utest.EqualNow(t, delivery.Rule, "purchases")
+	utest.EqualNow(t, delivery.Type, "Purchase")
+	var purchase Purchase
+	utest.IsNilNow(t, json.Unmarshal(delivery.Body, &purchase))
+	utest.EqualNow(t, purchase.Item, "sword")
+	mutex.Lock()
+	utest.EqualNow(t, attempts[delivery.ID], 2)
+	mutex.Unlock()
+
+	// refused deliveries are not retried.
+	utest.EqualNow(t, f.Forward(nil, &Purchase{"castle", 1000}), 2)
+	clk.BlockUntil(1)
+	clk.Advance(time.Second)
+	<-received
+	utest.NotNilNow(t, <-dead)
+	utest.EqualNow(t, f.DeadLetters(), uint64(1))
+}
+
+func Test_Verify(t *testing.T) {
+	body := []byte(`{}`)
+	now := time.Now().Unix()
+	header := "t=" + strconv.FormatInt(now, 10) + ",v1=" + sign("secret", now, body)
+	utest.IsNilNow(t, Verify("secret", header, body, time.Minute))
+	utest.EqualNow(t, Verify("other", header, body, time.Minute), BadSignatureError)
+	utest.EqualNow(t, Verify("secret", header, []byte(`{"a":1}`), time.Minute), BadSignatureError)
+	old := now - 3600
+	header = "t=" + strconv.FormatInt(old, 10) + ",v1=" + sign("secret", old, body)
+	utest.EqualNow(t, Verify("secret", header, body, time.Minute), BadSignatureError)
+	utest.EqualNow(t, Verify("secret", "", body, time.Minute), BadSignatureError)
+}